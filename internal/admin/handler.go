@@ -0,0 +1,86 @@
+// Package admin serves operator endpoints on the HTTP proxy which aren't part
+// of the gRPC API
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chorerewards/backend/internal/config"
+	"github.com/chorerewards/backend/internal/interceptors"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/status"
+)
+
+// Service reports the server's state to admins
+type Service interface {
+	GetEffectiveConfig(ctx context.Context) (config.Snapshot, error)
+}
+
+// Authenticator authenticates the bearer token of a request, returning a
+// context carrying its principal
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (context.Context, error)
+}
+
+// Handler serves the admin routes alongside the routes generated by
+// grpc-gateway
+type Handler struct {
+	service Service
+	auth    Authenticator
+}
+
+func NewHandler(service Service, auth Authenticator) *Handler {
+	return &Handler{
+		service: service,
+		auth:    auth,
+	}
+}
+
+// Register adds the admin routes to the gateway mux
+func (h *Handler) Register(mux *runtime.ServeMux) error {
+	return mux.HandlePath(http.MethodGet, "/v1alpha1/admin/config", h.config)
+}
+
+type configResponse struct {
+	YAML            string    `json:"yaml"`
+	LoadedAt        time.Time `json:"loadedAt"`
+	RestartRequired []string  `json:"restartRequired"`
+}
+
+func (h *Handler) config(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	ctx, err := h.auth.Authenticate(interceptors.HTTPContext(r), strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	snapshot, err := h.service.GetEffectiveConfig(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	restartRequired := snapshot.RestartRequired
+	if restartRequired == nil {
+		restartRequired = []string{}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(configResponse{
+		YAML:            snapshot.YAML,
+		LoadedAt:        snapshot.LoadedAt,
+		RestartRequired: restartRequired,
+	})
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+
+	http.Error(w, s.Message(), runtime.HTTPStatusFromCode(s.Code()))
+}
@@ -0,0 +1,75 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/config"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeAuth struct{}
+
+func (fakeAuth) Authenticate(ctx context.Context, token string) (context.Context, error) {
+	switch token {
+	case "admin":
+		return auth.ContextWithPrincipal(ctx, auth.Principal{UserID: 1, Roles: []string{auth.RoleAdmin}}), nil
+	case "parent":
+		return auth.ContextWithPrincipal(ctx, auth.Principal{UserID: 2}), nil
+	}
+
+	return nil, status.Error(codes.Unauthenticated, "Invalid token")
+}
+
+type fakeService struct{}
+
+func (fakeService) GetEffectiveConfig(ctx context.Context) (config.Snapshot, error) {
+	p, _ := auth.PrincipalFromContext(ctx)
+	if !p.HasRole(auth.RoleAdmin) {
+		return config.Snapshot{}, status.Error(codes.PermissionDenied, "Only admins can see the server config")
+	}
+
+	return config.Snapshot{YAML: "log:\n  level: info\n"}, nil
+}
+
+func TestHandler(t *testing.T) {
+	mux := runtime.NewServeMux()
+	assert.NoError(t, NewHandler(fakeService{}, fakeAuth{}).Register(mux))
+
+	get := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1alpha1/admin/config", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("it should show admins the config", func(t *testing.T) {
+		w := get("admin")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp configResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "log:\n  level: info\n", resp.YAML)
+		assert.Equal(t, []string{}, resp.RestartRequired)
+	})
+
+	t.Run("it should refuse other users", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, get("parent").Code)
+	})
+
+	t.Run("it should refuse requests without a token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, get("").Code)
+	})
+}
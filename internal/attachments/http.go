@@ -0,0 +1,178 @@
+package attachments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	variantOriginal  = "original"
+	variantThumbnail = "thumbnail"
+
+	// multipartOverhead allows for the multipart boundaries and headers
+	// surrounding the uploaded file
+	multipartOverhead = 64 << 10
+)
+
+// Service stores and retrieves attachments
+type Service interface {
+	UploadAttachment(ctx context.Context, username string, taskFeedID int32, content []byte) (db.Attachment, error)
+	OpenAttachment(ctx context.Context, id int32, householdID int32, thumbnail bool) (io.ReadCloser, db.Attachment, error)
+}
+
+// TokenValidator validates the bearer token of an upload
+type TokenValidator interface {
+	TokenUsername(token string) (string, error)
+}
+
+// Handler serves attachment uploads and signed downloads on the HTTP proxy,
+// alongside the routes generated by grpc-gateway
+type Handler struct {
+	service Service
+	tokens  TokenValidator
+	signer  *Signer
+	maxSize int64
+}
+
+func NewHandler(service Service, tokens TokenValidator, signer *Signer, maxSize int64) *Handler {
+	return &Handler{
+		service: service,
+		tokens:  tokens,
+		signer:  signer,
+		maxSize: maxSize,
+	}
+}
+
+// Register adds the attachment routes to the gateway mux
+func (h *Handler) Register(mux *runtime.ServeMux) error {
+	if err := mux.HandlePath(http.MethodPost, "/v1alpha1/tasks-feed/{id}/attachments", h.upload); err != nil {
+		return err
+	}
+
+	if err := mux.HandlePath(http.MethodGet, "/v1alpha1/attachments/{id}", h.download(variantOriginal)); err != nil {
+		return err
+	}
+
+	return mux.HandlePath(http.MethodGet, "/v1alpha1/attachments/{id}/thumbnail", h.download(variantThumbnail))
+}
+
+type uploadResponse struct {
+	ID           int32  `json:"id"`
+	TaskFeedID   int32  `json:"task_feed_id"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+func (h *Handler) upload(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	username, err := h.tokens.TokenUsername(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	taskFeedID, err := strconv.ParseInt(pathParams["id"], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid task feed id", http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxSize+multipartOverhead)
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Expected a multipart upload with a file field", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(file, h.maxSize+1))
+	if err != nil {
+		http.Error(w, "Unable to read upload", http.StatusBadRequest)
+		return
+	}
+
+	if int64(len(content)) > h.maxSize {
+		http.Error(w, fmt.Sprintf("Uploads are limited to %d bytes", h.maxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	a, err := h.service.UploadAttachment(r.Context(), username, int32(taskFeedID), content)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if err := json.NewEncoder(w).Encode(uploadResponse{
+		ID:           a.ID,
+		TaskFeedID:   a.TaskFeedID,
+		ContentType:  a.ContentType,
+		Size:         a.Size,
+		URL:          h.URL(a, false),
+		ThumbnailURL: h.URL(a, true),
+	}); err != nil {
+		log.WithError(err).Warn("Unable to write upload response")
+	}
+}
+
+func (h *Handler) download(variant string) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		id, err := strconv.ParseInt(pathParams["id"], 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid attachment id", http.StatusBadRequest)
+			return
+		}
+
+		householdID, err := h.signer.Verify(int32(id), variant, r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		content, a, err := h.service.OpenAttachment(r.Context(), int32(id), householdID, variant == variantThumbnail)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer content.Close()
+
+		w.Header().Set("Content-Type", a.ContentType)
+		w.Header().Set("Cache-Control", "private, max-age=300")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		if _, err := io.Copy(w, content); err != nil {
+			log.WithError(err).Warn("Unable to write attachment")
+		}
+	}
+}
+
+// URL returns a signed, expiring URL for an attachment or its thumbnail
+func (h *Handler) URL(a db.Attachment, thumbnail bool) string {
+	path, variant := fmt.Sprintf("/v1alpha1/attachments/%d", a.ID), variantOriginal
+	if thumbnail {
+		path, variant = path+"/thumbnail", variantThumbnail
+	}
+
+	return path + "?" + h.signer.Sign(a.ID, a.HouseholdID, variant).Encode()
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+
+	http.Error(w, s.Message(), runtime.HTTPStatusFromCode(s.Code()))
+}
@@ -0,0 +1,98 @@
+package attachments
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	_ "image/png" // register the PNG decoder for image.Decode
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// allowedContentTypes are the image formats accepted as attachments
+var allowedContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+// ThumbnailSize is the maximum width and height of generated thumbnails
+const ThumbnailSize = 256
+
+// MaxPixels is the largest image, in pixels, that will be decoded. A small
+// compressed upload can declare a huge size, and decoding it would allocate
+// memory for every pixel.
+const MaxPixels = 40 * 1000 * 1000
+
+// DetectContentType sniffs the type of an upload from its content rather than
+// trusting the type declared by the client, and rejects anything that isn't an
+// allowed image format
+func DetectContentType(content []byte) (string, error) {
+	contentType := http.DetectContentType(content)
+	if !allowedContentTypes[contentType] {
+		return "", errors.Errorf("unsupported content type %q", contentType)
+	}
+
+	return contentType, nil
+}
+
+// Thumbnail decodes an image and returns a JPEG no larger than ThumbnailSize in
+// either dimension, preserving the aspect ratio. Images larger than MaxPixels
+// are refused before they are decoded.
+func Thumbnail(content []byte) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode image")
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return nil, errors.Errorf("image of %dx%d pixels is too large", cfg.Width, cfg.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode image")
+	}
+
+	dst := scale(src, ThumbnailSize)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, errors.Wrap(err, "unable to encode thumbnail")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// scale shrinks src to fit within max x max using nearest-neighbour sampling.
+// Images which already fit are returned unchanged.
+func scale(src image.Image, max int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	if w <= max && h <= max {
+		return src
+	}
+
+	dw, dh := max, h*max/w
+	if h > w {
+		dw, dh = w*max/h, max
+	}
+
+	if dw < 1 {
+		dw = 1
+	}
+
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			dst.Set(x, y, src.At(b.Min.X+x*w/dw, b.Min.Y+y*h/dh))
+		}
+	}
+
+	return dst
+}
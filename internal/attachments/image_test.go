@@ -0,0 +1,62 @@
+package attachments
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	img.Set(0, 0, color.White)
+
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+
+	return buf.Bytes()
+}
+
+func TestDetectContentType(t *testing.T) {
+	t.Run("it should accept a PNG", func(t *testing.T) {
+		contentType, err := DetectContentType(testPNG(t, 1, 1))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "image/png", contentType)
+	})
+
+	t.Run("it should reject content that isn't an image", func(t *testing.T) {
+		_, err := DetectContentType([]byte("<html><body>not an image</body></html>"))
+
+		assert.EqualError(t, err, `unsupported content type "text/html; charset=utf-8"`)
+	})
+}
+
+func TestThumbnail(t *testing.T) {
+	thumbnail, err := Thumbnail(testPNG(t, 1024, 512))
+	assert.NoError(t, err)
+
+	img, format, err := image.Decode(bytes.NewReader(thumbnail))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, image.Rect(0, 0, ThumbnailSize, ThumbnailSize/2), img.Bounds())
+}
+
+func TestThumbnailTooLarge(t *testing.T) {
+	// Claim a huge size in the header of a tiny PNG, fixing up the checksum
+	content := testPNG(t, 1, 1)
+	ihdr := content[12:29]
+	binary.BigEndian.PutUint32(ihdr[4:], 100000)
+	binary.BigEndian.PutUint32(ihdr[8:], 100000)
+	binary.BigEndian.PutUint32(content[29:], crc32.ChecksumIEEE(ihdr))
+
+	_, err := Thumbnail(content)
+
+	assert.EqualError(t, err, "image of 100000x100000 pixels is too large")
+}
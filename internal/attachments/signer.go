@@ -0,0 +1,76 @@
+package attachments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/chorerewards/backend/internal/clock"
+	"github.com/pkg/errors"
+)
+
+// Signer creates and verifies expiring download URLs for attachments. The
+// signature covers the attachment, the household it belongs to and the
+// expiry, so a URL can't be reused for another household's attachment or
+// after it has expired.
+type Signer struct {
+	key   []byte
+	ttl   time.Duration
+	clock clock.Clock
+}
+
+func NewSigner(key string, ttl time.Duration) *Signer {
+	return &Signer{
+		key:   []byte(key),
+		ttl:   ttl,
+		clock: clock.Real{},
+	}
+}
+
+func (s *Signer) signature(id int32, householdID int32, variant string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%d:%d:%s:%d", id, householdID, variant, expires)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the query string granting access to a variant ("original" or
+// "thumbnail") of an attachment until the signer's TTL elapses
+func (s *Signer) Sign(id int32, householdID int32, variant string) url.Values {
+	expires := s.clock.Now().Add(s.ttl).Unix()
+
+	return url.Values{
+		"household": {strconv.Itoa(int(householdID))},
+		"expires":   {strconv.FormatInt(expires, 10)},
+		"signature": {s.signature(id, householdID, variant, expires)},
+	}
+}
+
+// Verify checks a signed query string, returning the household it grants
+// access to
+func (s *Signer) Verify(id int32, variant string, q url.Values) (int32, error) {
+	householdID, err := strconv.ParseInt(q.Get("household"), 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid household")
+	}
+
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid expiry")
+	}
+
+	expected := s.signature(id, int32(householdID), variant, expires)
+	if !hmac.Equal([]byte(expected), []byte(q.Get("signature"))) {
+		return 0, errors.New("invalid signature")
+	}
+
+	if s.clock.Now().Unix() > expires {
+		return 0, errors.New("URL has expired")
+	}
+
+	return int32(householdID), nil
+}
@@ -0,0 +1,58 @@
+package attachments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testClock struct {
+	time time.Time
+}
+
+func (t testClock) Now() time.Time {
+	return t.time
+}
+
+func TestSigner(t *testing.T) {
+	now := time.Date(2021, 6, 7, 9, 0, 0, 0, time.UTC)
+
+	s := NewSigner("test-key", time.Minute)
+	s.clock = testClock{time: now}
+
+	t.Run("it should verify a URL it signed", func(t *testing.T) {
+		householdID, err := s.Verify(1, variantOriginal, s.Sign(1, 7, variantOriginal))
+
+		assert.NoError(t, err)
+		assert.Equal(t, int32(7), householdID)
+	})
+
+	t.Run("it should reject a URL for another attachment or variant", func(t *testing.T) {
+		q := s.Sign(1, 7, variantOriginal)
+
+		_, err := s.Verify(2, variantOriginal, q)
+		assert.EqualError(t, err, "invalid signature")
+
+		_, err = s.Verify(1, variantThumbnail, q)
+		assert.EqualError(t, err, "invalid signature")
+	})
+
+	t.Run("it should reject a URL whose household was changed", func(t *testing.T) {
+		q := s.Sign(1, 7, variantOriginal)
+		q.Set("household", "8")
+
+		_, err := s.Verify(1, variantOriginal, q)
+		assert.EqualError(t, err, "invalid signature")
+	})
+
+	t.Run("it should reject an expired URL", func(t *testing.T) {
+		q := s.Sign(1, 7, variantOriginal)
+
+		later := NewSigner("test-key", time.Minute)
+		later.clock = testClock{time: now.Add(time.Minute * 2)}
+
+		_, err := later.Verify(1, variantOriginal, q)
+		assert.EqualError(t, err, "URL has expired")
+	})
+}
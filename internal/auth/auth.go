@@ -2,15 +2,20 @@ package auth
 
 import (
 	"context"
-	"path"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/chorerewards/backend/internal/clock"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
-	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 // HashPassword takes a password and returns the hash
@@ -25,101 +30,231 @@ func PasswordMatches(hash []byte, password []byte) bool {
 	return err == nil
 }
 
-// Clock interface to make testing easier
-type clock interface {
-	Now() time.Time
-}
-
-type realClock struct{}
-
-func (realClock) Now() time.Time {
-	return time.Now()
+// Claims are the claims of the tokens issued by TokenManager. Subject holds
+// the user id.
+type Claims struct {
+	jwt.StandardClaims
+	Username    string   `json:"username"`
+	HouseholdID int32    `json:"household_id"`
+	Roles       []string `json:"roles"`
+	SessionID   string   `json:"sid,omitempty"`
 }
 
 type TokenManager struct {
-	key   string
-	clock clock
+	keys     *KeySet
+	issuer   string
+	audience string
+	clock    clock.Clock
+	// ttl is the token lifetime in nanoseconds, shared by copies of the
+	// manager so that it can be changed while serving
+	ttl *int64
 }
 
-func NewTokenManager(key string) TokenManager {
+// NewTokenManager creates a token manager issuing tokens from issuer to
+// audience, and only accepting tokens with the same issuer and audience
+func NewTokenManager(keys *KeySet, issuer string, audience string) TokenManager {
+	ttl := int64(DefaultTokenTTL)
+
 	return TokenManager{
-		key:   key,
-		clock: realClock{},
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		clock:    clock.Real{},
+		ttl:      &ttl,
 	}
 }
 
-func (t TokenManager) CreateToken(username string) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
+// DefaultTokenTTL is how long the tokens issued by TokenManager are valid for
+// unless changed with SetTokenTTL
+const DefaultTokenTTL = time.Minute * 30
+
+// TokenTTL is how long new tokens are valid for
+func (t TokenManager) TokenTTL() time.Duration {
+	if t.ttl == nil {
+		return DefaultTokenTTL
+	}
+
+	return time.Duration(atomic.LoadInt64(t.ttl))
+}
+
+// SetTokenTTL changes how long new tokens are valid for. Tokens already issued
+// keep their expiry.
+func (t TokenManager) SetTokenTTL(ttl time.Duration) {
+	atomic.StoreInt64(t.ttl, int64(ttl))
+}
+
+func (t TokenManager) CreateToken(p Principal) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", errors.Wrap(err, "unable to generate token id")
+	}
 
 	now := t.clock.Now()
 
 	// See https://tools.ietf.org/html/rfc7519#section-4.1
-	claims["authorized"] = true
-	claims["username"] = username
-	claims["exp"] = now.Add(time.Minute * 30).Unix()
-	claims["iat"] = now.Unix()
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.FormatInt(int64(p.UserID), 10),
+			Issuer:    t.issuer,
+			Audience:  t.audience,
+			Id:        hex.EncodeToString(jti),
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(t.TokenTTL()).Unix(),
+		},
+		Username:    p.Username,
+		HouseholdID: p.HouseholdID,
+		Roles:       p.Roles,
+		SessionID:   p.SessionID,
+	}
 
-	return token.SignedString([]byte(t.key))
+	return t.keys.Sign(claims)
 }
 
-func (t TokenManager) ValidateToken(token string) error {
-	jwtToken, err := jwt.Parse(token, func(tkn *jwt.Token) (interface{}, error) {
-		if _, ok := tkn.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("Invalid Signing Method")
-		}
+// challengeTTL is how long a user has to complete the second step of signing
+// in
+const challengeTTL = time.Minute * 5
+
+// challengeAudience is the audience of challenge tokens for a purpose. It
+// differs from the API audience so that a challenge can't be used to call the
+// API.
+func (t TokenManager) challengeAudience(purpose string) string {
+	return t.audience + "/" + purpose
+}
 
-		return []byte(t.key), nil
+// CreateChallenge creates a short lived token for a user who has passed the
+// first step of signing in, such as entering their password, to present with
+// the second step
+func (t TokenManager) CreateChallenge(userID int32, purpose string) (string, error) {
+	now := t.clock.Now()
+
+	return t.keys.Sign(Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.FormatInt(int64(userID), 10),
+			Issuer:    t.issuer,
+			Audience:  t.challengeAudience(purpose),
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(challengeTTL).Unix(),
+		},
 	})
+}
+
+// VerifyChallenge validates a challenge token created for purpose and returns
+// the user id it was issued to
+func (t TokenManager) VerifyChallenge(token string, purpose string) (int32, error) {
+	claims := &Claims{}
+
+	if _, err := jwt.ParseWithClaims(token, claims, t.keys.keyFunc); err != nil {
+		return 0, errors.Wrap(err, "invalid challenge")
+	}
+
+	if !claims.VerifyIssuer(t.issuer, true) || !claims.VerifyAudience(t.challengeAudience(purpose), true) {
+		return 0, errors.New("invalid challenge")
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid challenge")
+	}
+
+	return int32(userID), nil
+}
+
+func (t TokenManager) ValidateToken(token string) error {
+	_, err := t.Authenticate(token)
+
+	return err
+}
+
+// TokenUsername validates a token and returns the username it was issued to
+func (t TokenManager) TokenUsername(token string) (string, error) {
+	p, err := t.Authenticate(token)
+	if err != nil {
+		return "", err
+	}
+
+	return p.Username, nil
+}
+
+// Authenticate validates a token and returns the principal it was issued to
+func (t TokenManager) Authenticate(token string) (Principal, error) {
+	claims, err := t.parseToken(token)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 32)
+	if err != nil || claims.Username == "" {
+		return Principal{}, errors.New("Invalid Claims")
+	}
+
+	return Principal{
+		UserID:      int32(userID),
+		Username:    claims.Username,
+		HouseholdID: claims.HouseholdID,
+		Roles:       claims.Roles,
+		TokenID:     claims.Id,
+		SessionID:   claims.SessionID,
+	}, nil
+}
+
+func (t TokenManager) parseToken(token string) (*Claims, error) {
+	claims := &Claims{}
+
+	_, err := jwt.ParseWithClaims(token, claims, t.keys.keyFunc)
 	if err != nil {
 		if ve, ok := err.(*jwt.ValidationError); ok {
 			switch ve.Errors {
 			case jwt.ValidationErrorMalformed:
-				return errors.New("Token is malformed")
+				return nil, errors.New("Token is malformed")
 			case jwt.ValidationErrorUnverifiable:
-				return errors.New("Token could not be verified because of signing problems")
+				return nil, errors.New("Token could not be verified because of signing problems")
 			case jwt.ValidationErrorSignatureInvalid:
-				return errors.New("Signature validation failed")
+				return nil, errors.New("Signature validation failed")
 			case jwt.ValidationErrorExpired:
-				return errors.New("Expired token")
+				return nil, errors.New("Expired token")
+			case jwt.ValidationErrorNotValidYet, jwt.ValidationErrorIssuedAt, jwt.ValidationErrorNotValidYet | jwt.ValidationErrorIssuedAt:
+				return nil, errors.New("Token is not valid yet")
 			case jwt.ValidationErrorClaimsInvalid:
-				return errors.New("Invalid Claims")
+				return nil, errors.New("Invalid Claims")
 			default:
-				return errors.Wrap(err, "Validation error")
+				return nil, errors.Wrap(err, "Validation error")
 			}
 		}
-		return errors.Wrap(err, "Error parsing token")
+		return nil, errors.Wrap(err, "Error parsing token")
 	}
 
-	if _, ok := jwtToken.Claims.(jwt.MapClaims); !ok {
-		return errors.New("Unable to map claims")
+	switch {
+	case claims.ExpiresAt == 0:
+		return nil, errors.New("Invalid Claims")
+	case !claims.VerifyIssuer(t.issuer, true):
+		return nil, errors.New("Invalid issuer")
+	case !claims.VerifyAudience(t.audience, true):
+		return nil, errors.New("Invalid audience")
 	}
 
-	return nil
+	return claims, nil
 }
 
-func (t TokenManager) ValidateAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	_, endpoint := path.Split(info.FullMethod)
-
-	// Login route is unprotected
-	if endpoint == "Login" {
-		return handler(ctx, req)
-	}
-
+// authenticateContext authenticates the caller from the Authorization
+// metadata, returning a context carrying their principal
+func (t TokenManager) authenticateContext(ctx context.Context) (context.Context, error) {
 	meta, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return nil, errors.New("Unable to get metadata from context")
+		return nil, status.Error(codes.Unauthenticated, "Unable to get metadata from context")
 	}
 
 	auth := meta.Get("Authorization")
 
 	if len(auth) != 1 {
-		return nil, errors.New("Authorization header is in the wrong format")
+		return nil, status.Error(codes.Unauthenticated, "Authorization header is in the wrong format")
 	}
 
-	if err := t.ValidateToken(strings.TrimPrefix(auth[0], "Bearer ")); err != nil {
-		return nil, errors.Wrap(err, "Invalid token")
+	p, err := t.Authenticate(strings.TrimPrefix(auth[0], "Bearer "))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, errors.Wrap(err, "Invalid token").Error())
 	}
 
-	return handler(ctx, req)
+	return ContextWithPrincipal(ctx, p), nil
 }
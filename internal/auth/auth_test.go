@@ -1,10 +1,27 @@
 package auth
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 type testClock struct {
@@ -15,6 +32,21 @@ func (t testClock) Now() time.Time {
 	return t.time
 }
 
+const (
+	testHMACKey  = "test-key-which-is-long-enough-for-hs256"
+	testIssuer   = "chorerewards"
+	testAudience = "chorerewards-api"
+)
+
+var testPrincipal = Principal{UserID: 7, Username: "test-user", HouseholdID: 3, Roles: []string{RoleChild}}
+
+func hmacKeys(t *testing.T) *KeySet {
+	keys := NewKeySet()
+	assert.NoError(t, keys.AddHMAC(testHMACKey))
+
+	return keys
+}
+
 func TestHashPassword(t *testing.T) {
 	password := []byte(`testPassword123`)
 
@@ -41,29 +73,567 @@ func TestPasswordMatches(t *testing.T) {
 
 func TestValidateToken(t *testing.T) {
 	t.Run("it should not return an error when the token is valid", func(t *testing.T) {
-		tm := NewTokenManager("test-key")
+		tm := NewTokenManager(hmacKeys(t), testIssuer, testAudience)
 
-		tkn, err := tm.CreateToken("test-user")
+		tkn, err := tm.CreateToken(testPrincipal)
 		assert.NoError(t, err)
 
 		assert.NoError(t, tm.ValidateToken(tkn))
 	})
 
 	t.Run("it should error when the token is malformed", func(t *testing.T) {
-		tm := NewTokenManager("test-key")
+		tm := NewTokenManager(hmacKeys(t), testIssuer, testAudience)
 
 		assert.EqualError(t, tm.ValidateToken("aaaaa"), "Token is malformed")
 	})
 
 	t.Run("it should error when the token has expired", func(t *testing.T) {
 		tm := TokenManager{
-			key:   "test-key",
-			clock: testClock{time: time.Now().Add(-time.Minute * 300)},
+			keys:     hmacKeys(t),
+			issuer:   testIssuer,
+			audience: testAudience,
+			clock:    testClock{time: time.Now().Add(-time.Minute * 300)},
 		}
 
-		tkn, err := tm.CreateToken("test-user")
+		tkn, err := tm.CreateToken(testPrincipal)
 		assert.NoError(t, err)
 
 		assert.EqualError(t, tm.ValidateToken(tkn), "Expired token")
 	})
 }
+
+func TestNewOneTimeToken(t *testing.T) {
+	token, hash, err := NewOneTimeToken()
+	assert.NoError(t, err)
+
+	t.Run("it should return the hash of the token", func(t *testing.T) {
+		assert.Equal(t, HashOneTimeToken(token), hash)
+	})
+
+	t.Run("it should not return the same token twice", func(t *testing.T) {
+		other, _, err := NewOneTimeToken()
+		assert.NoError(t, err)
+		assert.NotEqual(t, token, other)
+	})
+}
+
+func TestValidateHMACKey(t *testing.T) {
+	t.Run("it should refuse the old default key", func(t *testing.T) {
+		assert.Error(t, ValidateHMACKey("secretkey"))
+	})
+
+	t.Run("it should refuse a short key", func(t *testing.T) {
+		assert.Error(t, ValidateHMACKey("averylongsecretthatissecure"))
+	})
+
+	t.Run("it should accept a long key", func(t *testing.T) {
+		assert.NoError(t, ValidateHMACKey(testHMACKey))
+	})
+}
+
+func TestKeySet(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	oldKeys := NewKeySet()
+	assert.NoError(t, oldKeys.Add(&Key{ID: "old", Method: jwt.SigningMethodRS256, Private: rsaKey, Public: &rsaKey.PublicKey}))
+
+	newKeys := NewKeySet()
+	assert.NoError(t, newKeys.Add(&Key{ID: "new", Method: SigningMethodEdDSA, Private: edKey, Public: edKey.Public()}))
+	assert.NoError(t, newKeys.Add(&Key{ID: "old", Method: jwt.SigningMethodRS256, Public: &rsaKey.PublicKey}))
+	assert.NoError(t, newKeys.AddHMAC(testHMACKey))
+
+	oldToken, err := NewTokenManager(oldKeys, testIssuer, testAudience).CreateToken(testPrincipal)
+	assert.NoError(t, err)
+
+	tm := NewTokenManager(newKeys, testIssuer, testAudience)
+
+	t.Run("it should sign with the first key and set its kid", func(t *testing.T) {
+		tkn, err := tm.CreateToken(testPrincipal)
+		assert.NoError(t, err)
+
+		parsed, _, err := new(jwt.Parser).ParseUnverified(tkn, jwt.MapClaims{})
+		assert.NoError(t, err)
+		assert.Equal(t, "new", parsed.Header["kid"])
+		assert.Equal(t, "EdDSA", parsed.Header["alg"])
+
+		assert.NoError(t, tm.ValidateToken(tkn))
+	})
+
+	t.Run("it should accept tokens signed by a rotated key", func(t *testing.T) {
+		assert.NoError(t, tm.ValidateToken(oldToken))
+	})
+
+	t.Run("it should accept tokens signed by the HMAC key without a kid", func(t *testing.T) {
+		tkn, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub":      "7",
+			"username": "test-user",
+			"iss":      testIssuer,
+			"aud":      testAudience,
+			"exp":      time.Now().Add(time.Minute).Unix(),
+		}).SignedString([]byte(testHMACKey))
+		assert.NoError(t, err)
+
+		assert.NoError(t, tm.ValidateToken(tkn))
+	})
+
+	t.Run("it should reject tokens with an unknown kid", func(t *testing.T) {
+		other := NewKeySet()
+		assert.NoError(t, other.Add(&Key{ID: "unknown", Method: jwt.SigningMethodRS256, Private: rsaKey, Public: &rsaKey.PublicKey}))
+
+		tkn, err := NewTokenManager(other, testIssuer, testAudience).CreateToken(testPrincipal)
+		assert.NoError(t, err)
+
+		assert.Error(t, tm.ValidateToken(tkn))
+	})
+
+	t.Run("it should reject tokens using a different algorithm to their key", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"username": "test-user"})
+		token.Header["kid"] = "old"
+
+		tkn, err := token.SignedString(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))
+		assert.NoError(t, err)
+
+		assert.Error(t, tm.ValidateToken(tkn))
+	})
+
+	t.Run("it should only publish public keys", func(t *testing.T) {
+		jwks := newKeys.JWKS()
+
+		assert.Len(t, jwks, 2)
+		assert.Equal(t, "OKP", jwks[0].KeyType)
+		assert.Equal(t, base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)), jwks[0].X)
+		assert.Equal(t, "RSA", jwks[1].KeyType)
+		assert.Equal(t, "AQAB", jwks[1].E)
+	})
+}
+
+func TestParseKey(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	assert.NoError(t, err)
+
+	t.Run("it should load an Ed25519 private key", func(t *testing.T) {
+		key, err := parseKey("ed", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		assert.NoError(t, err)
+		assert.Equal(t, "EdDSA", key.Method.Alg())
+		assert.NotNil(t, key.Private)
+	})
+
+	t.Run("it should load a public key for verification only", func(t *testing.T) {
+		pubDer, err := x509.MarshalPKIXPublicKey(edKey.Public())
+		assert.NoError(t, err)
+
+		key, err := parseKey("ed", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer}))
+		assert.NoError(t, err)
+		assert.Nil(t, key.Private)
+	})
+
+	t.Run("it should reject data that isn't PEM", func(t *testing.T) {
+		_, err := parseKey("ed", []byte("not a key"))
+		assert.Error(t, err)
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	keys := hmacKeys(t)
+	tm := NewTokenManager(keys, testIssuer, testAudience)
+
+	tkn, err := tm.CreateToken(testPrincipal)
+	assert.NoError(t, err)
+
+	t.Run("it should return the principal the token was issued to", func(t *testing.T) {
+		p, err := tm.Authenticate(tkn)
+		assert.NoError(t, err)
+
+		assert.NotEmpty(t, p.TokenID)
+		p.TokenID = ""
+		assert.Equal(t, testPrincipal, p)
+	})
+
+	t.Run("it should reject tokens for another audience", func(t *testing.T) {
+		other := NewTokenManager(keys, testIssuer, "another-api")
+
+		_, err := other.Authenticate(tkn)
+		assert.EqualError(t, err, "Invalid audience")
+	})
+
+	t.Run("it should reject tokens from another issuer", func(t *testing.T) {
+		other := NewTokenManager(keys, "someone-else", testAudience)
+
+		_, err := other.Authenticate(tkn)
+		assert.EqualError(t, err, "Invalid issuer")
+	})
+
+	t.Run("it should reject tokens without a subject", func(t *testing.T) {
+		tkn, err := keys.Sign(jwt.MapClaims{
+			"username": "test-user",
+			"iss":      testIssuer,
+			"aud":      testAudience,
+			"exp":      time.Now().Add(time.Minute).Unix(),
+		})
+		assert.NoError(t, err)
+
+		_, err = tm.Authenticate(tkn)
+		assert.EqualError(t, err, "Invalid Claims")
+	})
+
+	t.Run("it should reject tokens which are not valid yet", func(t *testing.T) {
+		future := TokenManager{
+			keys:     keys,
+			issuer:   testIssuer,
+			audience: testAudience,
+			clock:    testClock{time: time.Now().Add(time.Minute * 10)},
+		}
+
+		tkn, err := future.CreateToken(testPrincipal)
+		assert.NoError(t, err)
+
+		_, err = tm.Authenticate(tkn)
+		assert.EqualError(t, err, "Token is not valid yet")
+	})
+}
+
+func TestPrincipalContext(t *testing.T) {
+	t.Run("it should return the principal added to the context", func(t *testing.T) {
+		p, ok := PrincipalFromContext(ContextWithPrincipal(context.Background(), testPrincipal))
+
+		assert.True(t, ok)
+		assert.Equal(t, testPrincipal, p)
+	})
+
+	t.Run("it should report when there is no principal", func(t *testing.T) {
+		_, ok := PrincipalFromContext(context.Background())
+
+		assert.False(t, ok)
+	})
+
+	t.Run("it should give parents the parent role", func(t *testing.T) {
+		p := Principal{Roles: Roles(true, true)}
+
+		assert.True(t, p.IsParent())
+		assert.True(t, p.HasRole(RoleAdmin))
+		assert.False(t, p.HasRole(RoleChild))
+	})
+}
+
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s testStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestAuthorizer(t *testing.T) {
+	tm := NewTokenManager(hmacKeys(t), testIssuer, testAudience)
+
+	childToken, err := tm.CreateToken(testPrincipal)
+	assert.NoError(t, err)
+
+	const (
+		login  = "/chorerewards.v1alpha1.ChoreRewardsService/Login"
+		list   = "/chorerewards.v1alpha1.ChoreRewardsService/ListTasks"
+		create = "/chorerewards.v1alpha1.ChoreRewardsService/CreateTask"
+		watch  = "/chorerewards.v1alpha1.ChoreRewardsService/Watch"
+	)
+
+	a := NewAuthorizer(tm, Policies{
+		login:  Public(),
+		list:   Authenticated(),
+		create: RequireRoles(RoleParent),
+		watch:  Authenticated(),
+	}, nil)
+
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("Authorization", "Bearer "+token))
+	}
+
+	call := func(ctx context.Context, method string) error {
+		_, err := a.Unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+
+		return err
+	}
+
+	t.Run("it should allow public methods without a token", func(t *testing.T) {
+		assert.NoError(t, call(metadata.NewIncomingContext(context.Background(), metadata.MD{}), login))
+	})
+
+	t.Run("it should reject authenticated methods without a token", func(t *testing.T) {
+		err := call(metadata.NewIncomingContext(context.Background(), metadata.MD{}), list)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("it should allow authenticated methods with a token", func(t *testing.T) {
+		assert.NoError(t, call(withToken(childToken), list))
+	})
+
+	t.Run("it should reject callers without the required role", func(t *testing.T) {
+		err := call(withToken(childToken), create)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("it should reject methods without a policy", func(t *testing.T) {
+		err := call(withToken(childToken), "/other.Service/Login")
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("it should add the principal to the stream context", func(t *testing.T) {
+		var p Principal
+		err := a.Stream(nil, testStream{ctx: withToken(childToken)}, &grpc.StreamServerInfo{FullMethod: watch}, func(srv interface{}, ss grpc.ServerStream) error {
+			p, _ = PrincipalFromContext(ss.Context())
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, testPrincipal.UserID, p.UserID)
+	})
+
+	t.Run("it should reject streams without a token", func(t *testing.T) {
+		err := a.Stream(nil, testStream{ctx: metadata.NewIncomingContext(context.Background(), metadata.MD{})}, &grpc.StreamServerInfo{FullMethod: watch}, func(srv interface{}, ss grpc.ServerStream) error {
+			return nil
+		})
+
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+}
+
+func TestPoliciesValidate(t *testing.T) {
+	services := map[string]grpc.ServiceInfo{
+		"test.Service": {Methods: []grpc.MethodInfo{{Name: "Login"}, {Name: "List"}}},
+	}
+
+	t.Run("it should accept policies covering every method", func(t *testing.T) {
+		p := Policies{"/test.Service/Login": Public(), "/test.Service/List": Authenticated()}
+
+		assert.NoError(t, p.Validate(services))
+	})
+
+	t.Run("it should reject methods without a policy", func(t *testing.T) {
+		p := Policies{"/test.Service/Login": Public()}
+
+		assert.EqualError(t, p.Validate(services), "no access policy for methods: /test.Service/List")
+	})
+
+	t.Run("it should reject policies for unregistered methods", func(t *testing.T) {
+		p := Policies{"/test.Service/Login": Public(), "/test.Service/List": Authenticated(), "/test.Service/Lsit": Public()}
+
+		assert.EqualError(t, p.Validate(services), "access policies for unregistered methods: /test.Service/Lsit")
+	})
+}
+
+func TestTOTP(t *testing.T) {
+	// The SHA1 secret from the test vectors in RFC 6238 appendix B
+	secret := base32NoPadding.EncodeToString([]byte("12345678901234567890"))
+
+	t.Run("it should match the RFC 6238 test vectors", func(t *testing.T) {
+		for ts, want := range map[int64]string{
+			59:         "287082",
+			1111111109: "081804",
+			1234567890: "005924",
+			2000000000: "279037",
+		} {
+			code, err := TOTPCode(secret, TOTPStep(time.Unix(ts, 0)))
+			assert.NoError(t, err)
+			assert.Equal(t, want, code)
+		}
+	})
+
+	now := time.Unix(1234567890, 0)
+	step := TOTPStep(now)
+
+	t.Run("it should accept codes from the neighbouring periods", func(t *testing.T) {
+		previous, _ := TOTPCode(secret, step-1)
+
+		matched, ok := ValidateTOTP(secret, previous, now, 0)
+		assert.True(t, ok)
+		assert.Equal(t, step-1, matched)
+
+		old, _ := TOTPCode(secret, step-2)
+
+		_, ok = ValidateTOTP(secret, old, now, 0)
+		assert.False(t, ok)
+	})
+
+	t.Run("it should not accept a code twice", func(t *testing.T) {
+		code, _ := TOTPCode(secret, step)
+
+		_, ok := ValidateTOTP(secret, code, now, step)
+		assert.False(t, ok)
+	})
+
+	t.Run("it should build a provisioning URI", func(t *testing.T) {
+		assert.Equal(
+			t,
+			"otpauth://totp/ChoreRewards:mum?algorithm=SHA1&digits=6&issuer=ChoreRewards&period=30&secret="+secret,
+			TOTPProvisioningURI("ChoreRewards", "mum", secret),
+		)
+	})
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes(10)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 10)
+
+	t.Run("it should match codes ignoring case and separators", func(t *testing.T) {
+		assert.Equal(t, hashes[0], HashRecoveryCode(strings.ToLower(strings.Replace(codes[0], "-", "", 1))))
+		assert.NotEqual(t, hashes[0], hashes[1])
+	})
+}
+
+func TestChallenge(t *testing.T) {
+	tm := NewTokenManager(hmacKeys(t), testIssuer, testAudience)
+
+	challenge, err := tm.CreateChallenge(7, ChallengeMFA)
+	assert.NoError(t, err)
+
+	t.Run("it should return the user it was issued to", func(t *testing.T) {
+		userID, err := tm.VerifyChallenge(challenge, ChallengeMFA)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(7), userID)
+	})
+
+	t.Run("it should not be accepted for another purpose", func(t *testing.T) {
+		_, err := tm.VerifyChallenge(challenge, ChallengeMFAEnrolment)
+		assert.Error(t, err)
+	})
+
+	t.Run("it should not be accepted as an access token", func(t *testing.T) {
+		_, err := tm.Authenticate(challenge)
+		assert.Error(t, err)
+	})
+
+	t.Run("it should be carried in an error", func(t *testing.T) {
+		reason, got, ok := MFAChallengeFromError(MFAChallengeError(ReasonMFARequired, challenge))
+		assert.True(t, ok)
+		assert.Equal(t, ReasonMFARequired, reason)
+		assert.Equal(t, challenge, got)
+	})
+}
+
+type fakeSessionStore struct {
+	active map[string]bool
+	checks int
+}
+
+func (f *fakeSessionStore) CheckSession(ctx context.Context, sessionID string, userID int32) (bool, error) {
+	f.checks++
+
+	return f.active[sessionID], nil
+}
+
+func TestSessions(t *testing.T) {
+	store := &fakeSessionStore{active: map[string]bool{"phone": true, "laptop": true}}
+	clk := &testClock{time: time.Now()}
+
+	cache := NewSessionCache(store, time.Second*30)
+	cache.clock = clk
+
+	tm := NewTokenManager(hmacKeys(t), testIssuer, testAudience)
+
+	const list = "/chorerewards.v1alpha1.ChoreRewardsService/ListTasks"
+	a := NewAuthorizer(tm, Policies{list: Authenticated()}, cache)
+
+	call := func(p Principal) error {
+		tkn, err := tm.CreateToken(p)
+		assert.NoError(t, err)
+
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("Authorization", "Bearer "+tkn))
+		_, err = a.Unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: list}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+
+		return err
+	}
+
+	phone := testPrincipal
+	phone.SessionID = "phone"
+
+	laptop := testPrincipal
+	laptop.SessionID = "laptop"
+
+	t.Run("it should accept tokens for active sessions", func(t *testing.T) {
+		assert.NoError(t, call(phone))
+	})
+
+	t.Run("it should reuse the result of recent checks", func(t *testing.T) {
+		checks := store.checks
+
+		assert.NoError(t, call(phone))
+		assert.Equal(t, checks, store.checks)
+	})
+
+	t.Run("it should reject tokens without a session", func(t *testing.T) {
+		assert.Equal(t, codes.Unauthenticated, status.Code(call(testPrincipal)))
+	})
+
+	t.Run("it should reject sessions revoked here straight away", func(t *testing.T) {
+		store.active["phone"] = false
+		cache.Invalidate("phone")
+
+		assert.Equal(t, codes.Unauthenticated, status.Code(call(phone)))
+	})
+
+	t.Run("it should reject sessions revoked elsewhere once the cache expires", func(t *testing.T) {
+		assert.NoError(t, call(laptop))
+
+		store.active["laptop"] = false
+		assert.NoError(t, call(laptop))
+
+		clk.time = clk.time.Add(time.Second * 30)
+		assert.Equal(t, codes.Unauthenticated, status.Code(call(laptop)))
+	})
+}
+
+func TestClientCertificates(t *testing.T) {
+	const (
+		list   = "/chorerewards.v1alpha1.ChoreRewardsService/ListTasks"
+		create = "/chorerewards.v1alpha1.ChoreRewardsService/CreateTask"
+	)
+
+	tm := NewTokenManager(hmacKeys(t), testIssuer, testAudience)
+
+	a := NewAuthorizer(tm, Policies{list: RequireRoles(RoleService), create: RequireRoles(RoleParent)}, nil)
+	a.TrustClientCertificates(ClientRoles{"backup-service": {RoleService}})
+
+	withCert := func(name string) context.Context {
+		state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: name}}}}
+
+		return peer.NewContext(
+			metadata.NewIncomingContext(context.Background(), metadata.MD{}),
+			&peer.Peer{AuthInfo: credentials.TLSInfo{State: state}},
+		)
+	}
+
+	var got Principal
+	call := func(ctx context.Context, method string) error {
+		_, err := a.Unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			got, _ = PrincipalFromContext(ctx)
+			return nil, nil
+		})
+
+		return err
+	}
+
+	t.Run("it should give known certificates their roles", func(t *testing.T) {
+		assert.NoError(t, call(withCert("backup-service"), list))
+		assert.Equal(t, "backup-service", got.Username)
+	})
+
+	t.Run("it should only allow the certificate's roles", func(t *testing.T) {
+		assert.Equal(t, codes.PermissionDenied, status.Code(call(withCert("backup-service"), create)))
+	})
+
+	t.Run("it should not trust unknown certificates", func(t *testing.T) {
+		assert.Equal(t, codes.Unauthenticated, status.Code(call(withCert("someone-else"), list)))
+	})
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientRoles maps the identity in a client certificate, its first URI SAN
+// such as a SPIFFE ID or otherwise its common name, to the roles it holds
+type ClientRoles map[string][]string
+
+// clientCertificateIdentity returns the identity of the client certificate
+// presented on the connection. The TLS config must have verified the
+// certificate, see certs.Reloader.
+func clientCertificateIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return ""
+	}
+
+	leaf := info.State.PeerCertificates[0]
+	if len(leaf.URIs) > 0 {
+		return leaf.URIs[0].String()
+	}
+
+	return leaf.Subject.CommonName
+}
+
+// clientPrincipal returns the principal of a caller who presented a client
+// certificate with a known identity and no token. A token takes precedence so
+// that users of a service holding a certificate act as themselves.
+func (a *Authorizer) clientPrincipal(ctx context.Context) (Principal, bool) {
+	if len(a.clientRoles) == 0 {
+		return Principal{}, false
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("Authorization")) > 0 {
+		return Principal{}, false
+	}
+
+	identity := clientCertificateIdentity(ctx)
+
+	roles, ok := a.clientRoles[identity]
+	if identity == "" || !ok {
+		return Principal{}, false
+	}
+
+	return Principal{Username: identity, Roles: roles}, true
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// hmacKeyID identifies tokens signed with the shared HMAC key. Tokens
+	// issued before key IDs were introduced have no kid and are treated the
+	// same.
+	hmacKeyID = "hmac"
+
+	// MinHMACKeyLength is the shortest HMAC key accepted, matching the 256 bit
+	// output of HS256
+	MinHMACKeyLength = 32
+
+	// defaultHMACKey was the default auth.key in older releases
+	defaultHMACKey = "secretkey"
+)
+
+// Key is a key used to sign or verify tokens
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Private is nil for keys that are only used to verify tokens, such as a
+	// retired key whose tokens have not yet expired
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+}
+
+// KeySet holds the key new tokens are signed with, along with every key which
+// tokens are still accepted from. Keys are rotated by adding the new key as
+// the signing key while keeping the previous key for verification until the
+// tokens it signed have expired.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+	// order keeps keys in the order they were added, for a stable JWKS
+	order []string
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{keys: make(map[string]*Key)}
+}
+
+// ValidateHMACKey refuses the old default key and keys too short to be secure
+func ValidateHMACKey(key string) error {
+	if key == defaultHMACKey {
+		return errors.New("auth.key is set to the insecure default, choose a new random key")
+	}
+
+	if len(key) < MinHMACKeyLength {
+		return errors.Errorf("auth.key must be at least %d characters", MinHMACKeyLength)
+	}
+
+	return nil
+}
+
+// AddHMAC adds a shared HS256 key
+func (k *KeySet) AddHMAC(key string) error {
+	if err := ValidateHMACKey(key); err != nil {
+		return err
+	}
+
+	return k.Add(&Key{ID: hmacKeyID, Method: jwt.SigningMethodHS256, Private: []byte(key), Public: []byte(key)})
+}
+
+// Add adds a key to the set. The first key with a private part becomes the
+// signing key.
+func (k *KeySet) Add(key *Key) error {
+	if _, ok := k.keys[key.ID]; ok {
+		return errors.Errorf("duplicate key id %q", key.ID)
+	}
+
+	k.keys[key.ID] = key
+	k.order = append(k.order, key.ID)
+
+	if k.signing == nil && key.Private != nil {
+		k.signing = key
+	}
+
+	return nil
+}
+
+// LoadKeyFile adds a PEM encoded key from a file. RSA keys sign with RS256 and
+// Ed25519 keys with EdDSA. A file holding only a public key adds a key that
+// is used for verification only.
+func (k *KeySet) LoadKeyFile(id string, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read key %q", id)
+	}
+
+	key, err := parseKey(id, data)
+	if err != nil {
+		return errors.Wrapf(err, "unable to load key %q", id)
+	}
+
+	return k.Add(key)
+}
+
+func parseKey(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, errors.Errorf("unsupported PEM type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, Private: key, Public: &key.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, Public: key}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: id, Method: SigningMethodEdDSA, Private: key, Public: key.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: id, Method: SigningMethodEdDSA, Public: key}, nil
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+}
+
+// Sign signs claims with the signing key, setting the kid header
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if k.signing == nil {
+		return "", errors.New("no signing key configured")
+	}
+
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	token.Header["kid"] = k.signing.ID
+
+	return token.SignedString(k.signing.Private)
+}
+
+// keyFunc finds the key a token was signed with, making sure the token uses
+// the algorithm of that key so that, for example, a public RSA key can't be
+// used as an HMAC secret
+func (k *KeySet) keyFunc(tkn *jwt.Token) (interface{}, error) {
+	kid, _ := tkn.Header["kid"].(string)
+	if kid == "" {
+		kid = hmacKeyID
+	}
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, errors.New("Unknown signing key")
+	}
+
+	if tkn.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("Invalid Signing Method")
+	}
+
+	return key.Public, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS returns the public keys of the set. Shared HMAC keys are never
+// published.
+func (k *KeySet) JWKS() []JWK {
+	jwks := make([]JWK, 0, len(k.keys))
+
+	for _, id := range k.order {
+		key := k.keys[id]
+
+		jwk := JWK{KeyID: key.ID, Algorithm: key.Method.Alg(), Use: "sig"}
+
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+
+		jwks = append(jwks, jwk)
+	}
+
+	return jwks
+}
+
+// JWKSHandler serves the public keys at /.well-known/jwks.json so that other
+// services can verify tokens
+func (k *KeySet) JWKSHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	_ = json.NewEncoder(w).Encode(struct {
+		Keys []JWK `json:"keys"`
+	}{Keys: k.JWKS()})
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Challenge purposes and the reasons given to clients when signing in needs a
+// second step
+const (
+	// ChallengeMFA is completed by entering a TOTP or recovery code
+	ChallengeMFA = "mfa"
+	// ChallengeMFAEnrolment is completed by enrolling an authenticator app,
+	// for users who must use two-factor authentication but haven't yet
+	ChallengeMFAEnrolment = "mfa-enrolment"
+
+	ReasonMFARequired          = "MFA_REQUIRED"
+	ReasonMFAEnrolmentRequired = "MFA_ENROLMENT_REQUIRED"
+
+	errorDomain = "chorerewards"
+)
+
+// MFAChallengeError is returned by sign in when a second step is needed. The
+// challenge token is passed to the client in the error details, as the Login
+// response has nowhere to carry it.
+func MFAChallengeError(reason string, challenge string) error {
+	st := status.New(codes.Unauthenticated, "Two-factor authentication is required")
+
+	withDetails, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   errorDomain,
+		Metadata: map[string]string{"challenge": challenge},
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return withDetails.Err()
+}
+
+// MFAChallengeFromError returns the reason and challenge token carried by an
+// error from MFAChallengeError
+func MFAChallengeFromError(err error) (reason string, challenge string, ok bool) {
+	st, isStatus := status.FromError(err)
+	if !isStatus {
+		return "", "", false
+	}
+
+	for _, d := range st.Details() {
+		if info, isInfo := d.(*errdetails.ErrorInfo); isInfo && info.Domain == errorDomain && info.Metadata["challenge"] != "" {
+			return info.Reason, info.Metadata["challenge"], true
+		}
+	}
+
+	return "", "", false
+}
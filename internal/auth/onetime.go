@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+)
+
+// NewOneTimeToken creates a random token for links sent by email, such as
+// email verification and password reset. Only the hash is stored so that a
+// leaked database can't be used to take over accounts.
+func NewOneTimeToken() (token string, hash []byte, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, errors.Wrap(err, "unable to generate token")
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+
+	return token, HashOneTimeToken(token), nil
+}
+
+// HashOneTimeToken returns the hash a one-time token is stored and looked up by
+func HashOneTimeToken(token string) []byte {
+	h := sha256.Sum256([]byte(token))
+
+	return h[:]
+}
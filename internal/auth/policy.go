@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/chorerewards/backend/internal/interceptors"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Policy decides who may call a method
+type Policy struct {
+	// Public methods can be called without a token
+	Public bool
+	// Roles, when set, restricts the method to callers holding at least one
+	// of the roles
+	Roles []string
+}
+
+// Public allows anyone to call a method
+func Public() Policy {
+	return Policy{Public: true}
+}
+
+// Authenticated allows any caller with a valid token
+func Authenticated() Policy {
+	return Policy{}
+}
+
+// RequireRoles allows callers holding any of the roles
+func RequireRoles(roles ...string) Policy {
+	return Policy{Roles: roles}
+}
+
+// allows reports whether a principal satisfies the policy
+func (p Policy) allows(principal Principal) bool {
+	if len(p.Roles) == 0 {
+		return true
+	}
+
+	for _, role := range p.Roles {
+		if principal.HasRole(role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Policies maps fully-qualified method names, such as
+// "/chorerewards.v1alpha1.ChoreRewardsService/Login", to their policy. A
+// method without a policy can't be called at all.
+type Policies map[string]Policy
+
+// Validate checks that every method registered on the server has a policy,
+// and that every policy is for a registered method so a typo can't leave a
+// method unlisted
+func (p Policies) Validate(services map[string]grpc.ServiceInfo) error {
+	registered := make(map[string]bool)
+	missing := make([]string, 0)
+
+	for service, info := range services {
+		for _, m := range info.Methods {
+			method := "/" + service + "/" + m.Name
+			registered[method] = true
+
+			if _, ok := p[method]; !ok {
+				missing = append(missing, method)
+			}
+		}
+	}
+
+	unknown := make([]string, 0)
+	for method := range p {
+		if !registered[method] {
+			unknown = append(unknown, method)
+		}
+	}
+
+	sort.Strings(missing)
+	sort.Strings(unknown)
+
+	switch {
+	case len(missing) > 0:
+		return errors.Errorf("no access policy for methods: %s", strings.Join(missing, ", "))
+	case len(unknown) > 0:
+		return errors.Errorf("access policies for unregistered methods: %s", strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+// Authorizer authenticates callers and enforces method policies, for both
+// unary and streaming calls
+type Authorizer struct {
+	tokens   TokenManager
+	policies Policies
+	sessions *SessionCache
+	// clientRoles are the roles of services authenticating with a client
+	// certificate
+	clientRoles ClientRoles
+}
+
+// NewAuthorizer creates an authorizer. When sessions is set, tokens are only
+// accepted while the session they were issued for is signed in.
+func NewAuthorizer(tokens TokenManager, policies Policies, sessions *SessionCache) *Authorizer {
+	return &Authorizer{
+		tokens:   tokens,
+		policies: policies,
+		sessions: sessions,
+	}
+}
+
+// TrustClientCertificates lets callers with a verified client certificate
+// whose identity is listed call methods allowed for its roles without a token
+func (a *Authorizer) TrustClientCertificates(roles ClientRoles) {
+	a.clientRoles = roles
+}
+
+// checkSession refuses principals whose session has been signed out
+func (a *Authorizer) checkSession(ctx context.Context, p Principal) error {
+	if a.sessions == nil {
+		return nil
+	}
+
+	if p.SessionID == "" {
+		return status.Error(codes.Unauthenticated, "Token has no session")
+	}
+
+	active, err := a.sessions.Active(ctx, p.SessionID, p.UserID)
+	if err != nil {
+		return status.Error(codes.Unavailable, "Unable to check session")
+	}
+
+	if !active {
+		return status.Error(codes.Unauthenticated, "Session has been signed out")
+	}
+
+	return nil
+}
+
+// TokenUsername authenticates a token presented outside of gRPC, such as to
+// the attachment upload handler, returning the username it was issued to
+func (a *Authorizer) TokenUsername(token string) (string, error) {
+	ctx, err := a.Authenticate(context.Background(), token)
+	if err != nil {
+		return "", err
+	}
+
+	p, _ := PrincipalFromContext(ctx)
+
+	return p.Username, nil
+}
+
+// Authenticate authenticates a token presented outside of gRPC, returning a
+// context carrying the principal it was issued to
+func (a *Authorizer) Authenticate(ctx context.Context, token string) (context.Context, error) {
+	p, err := a.tokens.Authenticate(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid token")
+	}
+
+	if err := a.checkSession(ctx, p); err != nil {
+		return nil, err
+	}
+
+	return ContextWithPrincipal(ctx, p), nil
+}
+
+// authorize checks the caller may call a method, returning a context carrying
+// their principal
+func (a *Authorizer) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	policy, ok := a.policies[fullMethod]
+	if !ok {
+		// Fail closed for anything not listed
+		return nil, status.Error(codes.PermissionDenied, "Method has no access policy")
+	}
+
+	if policy.Public {
+		return ctx, nil
+	}
+
+	if p, ok := a.clientPrincipal(ctx); ok {
+		if !policy.allows(p) {
+			return nil, status.Error(codes.PermissionDenied, "Not allowed to call this method")
+		}
+
+		return ContextWithPrincipal(ctx, p), nil
+	}
+
+	ctx, err := a.tokens.authenticateContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p, _ := PrincipalFromContext(ctx)
+
+	if err := a.checkSession(ctx, p); err != nil {
+		return nil, err
+	}
+
+	if !policy.allows(p) {
+		return nil, status.Error(codes.PermissionDenied, "Not allowed to call this method")
+	}
+
+	return ctx, nil
+}
+
+func (a *Authorizer) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (a *Authorizer) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, interceptors.WrappedStream{ServerStream: ss, WrappedContext: ctx})
+}
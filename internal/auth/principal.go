@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+)
+
+// Roles a principal may hold
+const (
+	RoleAdmin  = "admin"
+	RoleParent = "parent"
+	RoleChild  = "child"
+	// RoleService is held by services calling with a client certificate
+	// rather than as a user
+	RoleService = "service"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID      int32
+	Username    string
+	HouseholdID int32
+	Roles       []string
+	// TokenID is the jti of the token the caller authenticated with
+	TokenID string
+	// SessionID is the sign in the token was issued for
+	SessionID string
+}
+
+// HasRole reports whether the principal holds a role
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsParent reports whether the principal is a parent of their household
+func (p Principal) IsParent() bool {
+	return p.HasRole(RoleParent)
+}
+
+// Roles returns the roles of a user with the given flags
+func Roles(isAdmin bool, isParent bool) []string {
+	roles := make([]string, 0, 2)
+
+	if isAdmin {
+		roles = append(roles, RoleAdmin)
+	}
+
+	if isParent {
+		roles = append(roles, RoleParent)
+	} else {
+		roles = append(roles, RoleChild)
+	}
+
+	return roles
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx carrying the principal
+func ContextWithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal of an authenticated request
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+
+	return p, ok
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/chorerewards/backend/internal/clock"
+	"github.com/pkg/errors"
+)
+
+// SessionStore looks up whether a session is still signed in
+type SessionStore interface {
+	// CheckSession returns whether the user's session is active, recording
+	// that it has been seen
+	CheckSession(ctx context.Context, sessionID string, userID int32) (bool, error)
+}
+
+// NewSessionID generates the id of a new session
+func NewSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "unable to generate session id")
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+type sessionEntry struct {
+	userID    int32
+	active    bool
+	checkedAt time.Time
+}
+
+// SessionCache remembers the result of session checks for a short time so that
+// authenticating a call doesn't need a database query every time. Sessions
+// revoked through this instance are forgotten straight away; those revoked
+// elsewhere are refused once the cached result expires.
+type SessionCache struct {
+	store SessionStore
+	ttl   time.Duration
+	clock clock.Clock
+
+	mu        sync.Mutex
+	entries   map[string]sessionEntry
+	lastSweep time.Time
+}
+
+func NewSessionCache(store SessionStore, ttl time.Duration) *SessionCache {
+	return &SessionCache{
+		store:   store,
+		ttl:     ttl,
+		clock:   clock.Real{},
+		entries: make(map[string]sessionEntry),
+	}
+}
+
+// Active returns whether the user's session is active
+func (c *SessionCache) Active(ctx context.Context, sessionID string, userID int32) (bool, error) {
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	e, ok := c.entries[sessionID]
+	c.mu.Unlock()
+
+	if ok && e.userID == userID && now.Sub(e.checkedAt) < c.ttl {
+		return e.active, nil
+	}
+
+	active, err := c.store.CheckSession(ctx, sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[sessionID] = sessionEntry{userID: userID, active: active, checkedAt: now}
+
+	if now.Sub(c.lastSweep) >= c.ttl {
+		c.sweep(now)
+	}
+
+	return active, nil
+}
+
+// sweep drops expired entries so that the cache doesn't grow without bound
+func (c *SessionCache) sweep(now time.Time) {
+	for id, e := range c.entries {
+		if now.Sub(e.checkedAt) >= c.ttl {
+			delete(c.entries, id)
+		}
+	}
+
+	c.lastSweep = now
+}
+
+// Invalidate forgets a session so that its next use is checked with the store
+func (c *SessionCache) Invalidate(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, sessionID)
+}
+
+// InvalidateUser forgets all of a user's sessions
+func (c *SessionCache) InvalidateUser(userID int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, e := range c.entries {
+		if e.userID == userID {
+			delete(c.entries, id)
+		}
+	}
+}
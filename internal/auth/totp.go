@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TOTP parameters understood by all common authenticator apps, see
+// https://tools.ietf.org/html/rfc6238
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is how many periods either side of now a code is accepted for,
+	// to allow for clock drift and slow typing
+	totpSkew = 1
+
+	recoveryCodeLength = 10
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret generates a base32 encoded secret to enrol an authenticator with
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "unable to generate secret")
+	}
+
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// TOTPEnrolment is shown to a user enrolling an authenticator app. URI is
+// rendered as a QR code, and Secret can be typed in instead.
+type TOTPEnrolment struct {
+	Secret string
+	URI    string
+}
+
+// TOTPProvisioningURI returns the otpauth URI encoded in the QR code shown to
+// users when they enrol an authenticator app
+func TOTPProvisioningURI(issuer string, account string, secret string) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + account,
+		RawQuery: url.Values{
+			"secret":    {secret},
+			"issuer":    {issuer},
+			"algorithm": {"SHA1"},
+			"digits":    {fmt.Sprint(totpDigits)},
+			"period":    {fmt.Sprint(totpPeriod)},
+		}.Encode(),
+	}
+
+	return u.String()
+}
+
+// TOTPStep returns the time step a code is generated for at t
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// TOTPCode returns the code for a secret at a time step
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", errors.Wrap(err, "invalid secret")
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	m := hmac.New(sha1.New, key)
+	m.Write(counter)
+	sum := m.Sum(nil)
+
+	// Dynamic truncation, see https://tools.ietf.org/html/rfc4226#section-5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// ValidateTOTP checks a code against a secret at time t. It returns the time
+// step the code matched, which callers store so that a code can't be used
+// twice; codes for steps up to and including lastStep are refused.
+func ValidateTOTP(secret string, code string, t time.Time, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	now := TOTPStep(t)
+
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// NewRecoveryCodes generates codes that can be used once each in place of a
+// TOTP code, returning them formatted for the user along with their hashes for
+// storage
+func NewRecoveryCodes(n int) (codes []string, hashes [][]byte, err error) {
+	for i := 0; i < n; i++ {
+		b := make([]byte, recoveryCodeLength)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, errors.Wrap(err, "unable to generate recovery code")
+		}
+
+		// Crockford style alphabet without easily confused characters
+		const alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+		for j := range b {
+			b[j] = alphabet[int(b[j])%len(alphabet)]
+		}
+
+		code := string(b[:5]) + "-" + string(b[5:])
+
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the hash a recovery code is stored and looked up
+// by. Codes are compared ignoring case and separators.
+func HashRecoveryCode(code string) []byte {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	return HashOneTimeToken(code)
+}
@@ -0,0 +1,40 @@
+package blob
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+
+	t.Run("it should read back a blob that was put", func(t *testing.T) {
+		assert.NoError(t, store.Put(ctx, "1/photo.jpg", strings.NewReader("content")))
+
+		r, err := store.Get(ctx, "1/photo.jpg")
+		assert.NoError(t, err)
+		defer r.Close()
+
+		b, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "content", string(b))
+	})
+
+	t.Run("it should return ErrNotExist once a blob is deleted", func(t *testing.T) {
+		assert.NoError(t, store.Delete(ctx, "1/photo.jpg"))
+
+		_, err := store.Get(ctx, "1/photo.jpg")
+		assert.Equal(t, ErrNotExist, err)
+	})
+
+	t.Run("it should reject keys escaping the store directory", func(t *testing.T) {
+		assert.Error(t, store.Put(ctx, "../escape", strings.NewReader("content")))
+	})
+}
@@ -0,0 +1,219 @@
+// Package certs serves TLS certificates loaded from files, picking up renewed
+// certificates without a restart
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Client certificate modes
+const (
+	// ClientAuthNone doesn't ask clients for a certificate
+	ClientAuthNone = "none"
+	// ClientAuthOptional verifies a certificate when the client presents one,
+	// so that services can use one while apps sign in with tokens
+	ClientAuthOptional = "optional"
+	// ClientAuthRequire refuses clients without a valid certificate
+	ClientAuthRequire = "require"
+)
+
+// Reloader holds a certificate and key, and optionally the CA bundle client
+// certificates are verified with, reloading them when their files change
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+// NewReloader loads the certificate, key and client CA bundle. clientCAFile
+// may be empty if client certificates aren't used.
+func NewReloader(certFile string, keyFile string, clientCAFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+
+	return files
+}
+
+func (r *Reloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return errors.Wrap(err, "unable to read certificate file")
+		}
+
+		modTimes[f] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to load certificate")
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return errors.Wrap(err, "unable to read client CA file")
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return errors.Errorf("no certificates found in %s", r.clientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+
+	return nil
+}
+
+// changed reports whether any of the files have been modified since they were
+// loaded
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			// Files are briefly missing while being replaced; try again later
+			return false
+		}
+
+		if !info.ModTime().Equal(r.modTimes[f]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReloadIfChanged reloads the files if they have changed. The previous
+// certificate stays in use if the new files can't be loaded, such as when
+// only the certificate has been replaced so far.
+func (r *Reloader) ReloadIfChanged(ctx context.Context) error {
+	if !r.changed() {
+		return nil
+	}
+
+	if err := r.load(); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"certFile": r.certFile,
+	}).Info("TLS certificate reloaded")
+
+	return nil
+}
+
+func (r *Reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// verifyClient verifies a client certificate against the current CA bundle.
+// It is done here rather than by crypto/tls so that the bundle can be
+// reloaded.
+func (r *Reloader) verifyClient(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return nil
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		c, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return errors.Wrap(err, "invalid client certificate")
+		}
+
+		certs[i] = c
+	}
+
+	r.mu.RLock()
+	roots := r.clientCAs
+	r.mu.RUnlock()
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+
+	if _, err := certs[0].Verify(opts); err != nil {
+		return errors.Wrap(err, "client certificate not trusted")
+	}
+
+	return nil
+}
+
+// ServerConfig returns a TLS config serving the current certificate. With
+// ClientAuthOptional or ClientAuthRequire, client certificates are verified
+// against the client CA bundle.
+func (r *Reloader) ServerConfig(clientAuth string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+	}
+
+	switch clientAuth {
+	case ClientAuthNone, "":
+		return config, nil
+	case ClientAuthOptional:
+		config.ClientAuth = tls.RequestClientCert
+	case ClientAuthRequire:
+		config.ClientAuth = tls.RequireAnyClientCert
+	default:
+		return nil, errors.Errorf("unknown client auth mode %q", clientAuth)
+	}
+
+	if r.clientCAFile == "" {
+		return nil, errors.New("a client CA file is needed to verify client certificates")
+	}
+
+	config.VerifyPeerCertificate = r.verifyClient
+
+	return config, nil
+}
@@ -0,0 +1,139 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newCert creates a certificate signed by parent, or a self-signed CA when
+// parent is nil
+func newCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) write(t *testing.T, certFile string, keyFile string) {
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600))
+
+	if keyFile != "" {
+		der, err := x509.MarshalECPrivateKey(c.key)
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+
+	ca := newCert(t, "ca", nil, x509.ExtKeyUsageAny)
+	ca.write(t, caFile, "")
+
+	first := newCert(t, "localhost", ca, x509.ExtKeyUsageServerAuth)
+	first.write(t, certFile, keyFile)
+
+	r, err := NewReloader(certFile, keyFile, caFile)
+	assert.NoError(t, err)
+
+	t.Run("it should serve the certificate", func(t *testing.T) {
+		cert, err := r.getCertificate(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, first.der, cert.Certificate[0])
+	})
+
+	t.Run("it should reload the certificate when the files change", func(t *testing.T) {
+		second := newCert(t, "localhost", ca, x509.ExtKeyUsageServerAuth)
+		second.write(t, certFile, keyFile)
+
+		later := time.Now().Add(time.Minute)
+		assert.NoError(t, os.Chtimes(certFile, later, later))
+		assert.NoError(t, os.Chtimes(keyFile, later, later))
+
+		assert.NoError(t, r.ReloadIfChanged(context.Background()))
+
+		cert, _ := r.getCertificate(nil)
+		assert.Equal(t, second.der, cert.Certificate[0])
+	})
+
+	t.Run("it should keep the certificate when the new files are invalid", func(t *testing.T) {
+		current, _ := r.getCertificate(nil)
+
+		assert.NoError(t, ioutil.WriteFile(keyFile, []byte("not a key"), 0600))
+		later := time.Now().Add(time.Hour)
+		assert.NoError(t, os.Chtimes(keyFile, later, later))
+
+		assert.Error(t, r.ReloadIfChanged(context.Background()))
+
+		cert, _ := r.getCertificate(nil)
+		assert.Equal(t, current, cert)
+	})
+
+	t.Run("it should verify client certificates against the CA", func(t *testing.T) {
+		client := newCert(t, "spiffe-service", ca, x509.ExtKeyUsageClientAuth)
+		assert.NoError(t, r.verifyClient([][]byte{client.der}, nil))
+
+		other := newCert(t, "other-ca", nil, x509.ExtKeyUsageAny)
+		stranger := newCert(t, "stranger", other, x509.ExtKeyUsageClientAuth)
+		assert.Error(t, r.verifyClient([][]byte{stranger.der}, nil))
+	})
+
+	t.Run("it should refuse server certificates as client certificates", func(t *testing.T) {
+		server := newCert(t, "server", ca, x509.ExtKeyUsageServerAuth)
+		assert.Error(t, r.verifyClient([][]byte{server.der}, nil))
+	})
+
+	t.Run("it should need a client CA to verify clients", func(t *testing.T) {
+		noCA := &Reloader{certFile: certFile, keyFile: keyFile}
+
+		_, err := noCA.ServerConfig(ClientAuthRequire)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,728 @@
+// Package config loads the server configuration from config.yaml and the
+// environment, and checks it before anything is started
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/certs"
+	"github.com/chorerewards/backend/internal/cors"
+)
+
+// EnvPrefix starts the environment variables which override settings. A
+// setting's variable is its key in upper case with dots replaced by
+// underscores, such as CHOREREWARDS_DB_PASSWORD for db.password.
+const EnvPrefix = "CHOREREWARDS"
+
+// placeholderPrefix starts the example secrets in the documentation, which
+// must not be used as real ones
+const placeholderPrefix = "replace-with-"
+
+// Config is the server configuration. Fields tagged secret are redacted when
+// printed and can be read from a file named by the setting's environment
+// variable with _FILE appended, such as CHOREREWARDS_DB_PASSWORD_FILE. Fields
+// tagged reload take effect when the config file changes, see Manager.
+type Config struct {
+	Log           Log           `mapstructure:"log"`
+	Server        Server        `mapstructure:"server"`
+	DB            DB            `mapstructure:"db"`
+	Auth          Auth          `mapstructure:"auth"`
+	Attachments   Attachments   `mapstructure:"attachments"`
+	Notifications Notifications `mapstructure:"notifications"`
+	Mail          Mail          `mapstructure:"mail"`
+	OIDC          OIDC          `mapstructure:"oidc"`
+	CORS          CORS          `mapstructure:"cors" reload:"true"`
+	Webhooks      Webhooks      `mapstructure:"webhooks"`
+	Tasks         Tasks         `mapstructure:"tasks"`
+	Jobs          Jobs          `mapstructure:"jobs"`
+	// Features turns features on and off by name
+	Features map[string]bool `mapstructure:"features" reload:"true"`
+}
+
+type Log struct {
+	Level string `mapstructure:"level" reload:"true"`
+}
+
+type Server struct {
+	Port      int       `mapstructure:"port"`
+	HTTPProxy HTTPProxy `mapstructure:"httpProxy"`
+	RateLimit RateLimit `mapstructure:"rateLimit" reload:"true"`
+	TLS       TLS       `mapstructure:"tls"`
+}
+
+type HTTPProxy struct {
+	Enabled bool `mapstructure:"enabled"`
+	Docs    bool `mapstructure:"docs"`
+}
+
+type RateLimit struct {
+	RequestsPerSecond float64 `mapstructure:"requestsPerSecond"`
+	Burst             int     `mapstructure:"burst"`
+}
+
+type TLS struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	CertFile       string        `mapstructure:"certFile"`
+	KeyFile        string        `mapstructure:"keyFile"`
+	ClientCAFile   string        `mapstructure:"clientCAFile"`
+	ClientAuth     string        `mapstructure:"clientAuth"`
+	ReloadInterval time.Duration `mapstructure:"reloadInterval"`
+	Clients        []TLSClient   `mapstructure:"clients"`
+}
+
+// TLSClient gives roles to the client certificate with the identity
+type TLSClient struct {
+	Identity string   `mapstructure:"identity"`
+	Roles    []string `mapstructure:"roles"`
+}
+
+type DB struct {
+	// DSN replaces the host, port, username, name and SSL settings
+	DSN              string        `mapstructure:"dsn" secret:"true"`
+	Host             string        `mapstructure:"host"`
+	Port             int           `mapstructure:"port"`
+	Username         string        `mapstructure:"username"`
+	Password         string        `mapstructure:"password" secret:"true"`
+	Name             string        `mapstructure:"name"`
+	SSLMode          string        `mapstructure:"sslMode"`
+	SSLRootCert      string        `mapstructure:"sslRootCert"`
+	SSLCert          string        `mapstructure:"sslCert"`
+	SSLKey           string        `mapstructure:"sslKey"`
+	ApplicationName  string        `mapstructure:"applicationName"`
+	StatementTimeout time.Duration `mapstructure:"statementTimeout"`
+	ConnectTimeout   time.Duration `mapstructure:"connectTimeout"`
+	StartupTimeout   time.Duration `mapstructure:"startupTimeout"`
+	Pool             DBPool        `mapstructure:"pool"`
+	Replica          DBReplica     `mapstructure:"replica"`
+}
+
+type DBPool struct {
+	MinConns          int32         `mapstructure:"minConns"`
+	MaxConns          int32         `mapstructure:"maxConns"`
+	MaxConnLifetime   time.Duration `mapstructure:"maxConnLifetime"`
+	MaxConnIdleTime   time.Duration `mapstructure:"maxConnIdleTime"`
+	HealthCheckPeriod time.Duration `mapstructure:"healthCheckPeriod"`
+}
+
+// DBReplica is a read replica for list queries, used when dsn or host is set.
+// Settings left empty are taken from db.
+type DBReplica struct {
+	DSN         string `mapstructure:"dsn" secret:"true"`
+	Host        string `mapstructure:"host"`
+	Port        int    `mapstructure:"port"`
+	Username    string `mapstructure:"username"`
+	Password    string `mapstructure:"password" secret:"true"`
+	Name        string `mapstructure:"name"`
+	SSLMode     string `mapstructure:"sslMode"`
+	SSLRootCert string `mapstructure:"sslRootCert"`
+	// ReadYourWritesWindow is how long a user's reads go to the primary
+	// after they write, so that replication lag doesn't hide their changes
+	ReadYourWritesWindow time.Duration `mapstructure:"readYourWritesWindow"`
+}
+
+// Enabled reports whether a replica is configured
+func (r DBReplica) Enabled() bool {
+	return r.DSN != "" || r.Host != ""
+}
+
+type Auth struct {
+	Key             string        `mapstructure:"key" secret:"true"`
+	Keys            []KeyFile     `mapstructure:"keys"`
+	Issuer          string        `mapstructure:"issuer"`
+	Audience        string        `mapstructure:"audience"`
+	TokenTTL        time.Duration `mapstructure:"tokenTTL" reload:"true"`
+	SessionCacheTTL time.Duration `mapstructure:"sessionCacheTTL"`
+}
+
+// KeyFile is a PEM encoded signing key, or the public key of a retired one
+type KeyFile struct {
+	ID   string `mapstructure:"id"`
+	File string `mapstructure:"file"`
+}
+
+type Attachments struct {
+	Dir     string        `mapstructure:"dir"`
+	MaxSize int64         `mapstructure:"maxSize"`
+	URLTTL  time.Duration `mapstructure:"urlTTL"`
+	// URLKey signs attachment URLs. It must differ from auth.key, so that a
+	// leaked URL key can't be used to sign tokens.
+	URLKey string `mapstructure:"urlKey" secret:"true"`
+}
+
+type Notifications struct {
+	SMTP    SMTP          `mapstructure:"smtp"`
+	Webhook Webhook       `mapstructure:"webhook"`
+	WebPush WebPush       `mapstructure:"webPush"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+type SMTP struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password" secret:"true"`
+	From     string `mapstructure:"from"`
+}
+
+// Webhook enables notifications to user supplied URLs. It is off by default,
+// and deliveries to internal addresses are refused when it is on.
+type Webhook struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+type WebPush struct {
+	VAPIDPrivateKey string `mapstructure:"vapidPrivateKey" secret:"true"`
+	Subscriber      string `mapstructure:"subscriber"`
+}
+
+type Mail struct {
+	Driver string `mapstructure:"driver"`
+	Dir    string `mapstructure:"dir"`
+	AppURL string `mapstructure:"appURL"`
+}
+
+type OIDC struct {
+	BaseURL    string        `mapstructure:"baseURL"`
+	SuccessURL string        `mapstructure:"successURL"`
+	StateKey   string        `mapstructure:"stateKey" secret:"true"`
+	Timeout    time.Duration `mapstructure:"timeout"`
+	// Providers are keyed by their name, which appears in the sign in URLs
+	Providers map[string]OIDCProvider `mapstructure:"providers"`
+}
+
+type OIDCProvider struct {
+	Issuer       string   `mapstructure:"issuer"`
+	ClientID     string   `mapstructure:"clientID"`
+	ClientSecret string   `mapstructure:"clientSecret" secret:"true"`
+	Scopes       []string `mapstructure:"scopes"`
+}
+
+type CORS struct {
+	Profile  string                 `mapstructure:"profile"`
+	Profiles map[string]cors.Policy `mapstructure:"profiles"`
+}
+
+type Webhooks struct {
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+type Tasks struct {
+	// MissedAfter is how long after its deadline an incomplete feed entry is
+	// treated as missed, and its missed penalty applied
+	MissedAfter time.Duration `mapstructure:"missedAfter"`
+}
+
+type Jobs struct {
+	ReleaseExpiredClaimsInterval  time.Duration `mapstructure:"releaseExpiredClaimsInterval"`
+	RunDueRotationsInterval       time.Duration `mapstructure:"runDueRotationsInterval"`
+	MarkOverdueInterval           time.Duration `mapstructure:"markOverdueInterval"`
+	DispatchNotificationsInterval time.Duration `mapstructure:"dispatchNotificationsInterval"`
+	DispatchWebhooksInterval      time.Duration `mapstructure:"dispatchWebhooksInterval"`
+	DeleteExpiredSessionsInterval time.Duration `mapstructure:"deleteExpiredSessionsInterval"`
+}
+
+// SetDefaults sets the default of every setting. Every setting needs a
+// default for its environment variable to be read.
+func SetDefaults(v *viper.Viper) {
+	// Log defaults. level is one of the logrus levels, such as debug or warn.
+	v.SetDefault("log.level", "info")
+
+	// Server defaults
+	v.SetDefault("server.port", 8080)
+	// The HTTP proxy serves REST and gRPC-Web on server.port alongside gRPC
+	v.SetDefault("server.httpProxy.enabled", false)
+	// Serve the OpenAPI document at /openapi.json, a page to browse it at
+	// /docs, and the descriptors for grpcurl at /descriptors.protoset
+	v.SetDefault("server.httpProxy.docs", true)
+	// Calls allowed per client address. A rate of 0 disables rate limiting.
+	v.SetDefault("server.rateLimit.requestsPerSecond", 20)
+	v.SetDefault("server.rateLimit.burst", 40)
+	// TLS for server.port. clientAuth is one of none, optional or require;
+	// verified client certificates listed in server.tls.clients can call
+	// without a token.
+	v.SetDefault("server.tls.enabled", false)
+	v.SetDefault("server.tls.certFile", "")
+	v.SetDefault("server.tls.keyFile", "")
+	v.SetDefault("server.tls.clientCAFile", "")
+	v.SetDefault("server.tls.clientAuth", certs.ClientAuthNone)
+	v.SetDefault("server.tls.reloadInterval", time.Minute)
+
+	// DB defaults. sslMode is one of the libpq modes, and statementTimeout 0
+	// uses the database's setting. startupTimeout is how long to keep trying
+	// to connect at startup.
+	v.SetDefault("db.dsn", "")
+	v.SetDefault("db.host", "localhost")
+	v.SetDefault("db.port", 5432)
+	v.SetDefault("db.username", "chorerewards")
+	v.SetDefault("db.password", "")
+	v.SetDefault("db.name", "chorerewards")
+	v.SetDefault("db.sslMode", "prefer")
+	v.SetDefault("db.sslRootCert", "")
+	v.SetDefault("db.sslCert", "")
+	v.SetDefault("db.sslKey", "")
+	v.SetDefault("db.applicationName", "chorerewards")
+	v.SetDefault("db.statementTimeout", 0)
+	v.SetDefault("db.connectTimeout", time.Second*5)
+	v.SetDefault("db.startupTimeout", time.Minute)
+	v.SetDefault("db.pool.minConns", 0)
+	v.SetDefault("db.pool.maxConns", 10)
+	v.SetDefault("db.pool.maxConnLifetime", time.Hour)
+	v.SetDefault("db.pool.maxConnIdleTime", time.Minute*30)
+	v.SetDefault("db.pool.healthCheckPeriod", time.Minute)
+	v.SetDefault("db.replica.dsn", "")
+	v.SetDefault("db.replica.host", "")
+	v.SetDefault("db.replica.port", 0)
+	v.SetDefault("db.replica.username", "")
+	v.SetDefault("db.replica.password", "")
+	v.SetDefault("db.replica.name", "")
+	v.SetDefault("db.replica.sslMode", "")
+	v.SetDefault("db.replica.sslRootCert", "")
+	v.SetDefault("db.replica.readYourWritesWindow", time.Second*5)
+
+	// Auth defaults. Tokens are signed with the first of auth.keys, or with the
+	// auth.key HMAC secret when no keys are configured.
+	v.SetDefault("auth.key", "")
+	v.SetDefault("auth.issuer", "chorerewards")
+	v.SetDefault("auth.audience", "chorerewards-api")
+	v.SetDefault("auth.tokenTTL", auth.DefaultTokenTTL)
+	// sessionCacheTTL bounds how long a session signed out on another instance
+	// can still be used on this one
+	v.SetDefault("auth.sessionCacheTTL", time.Second*30)
+
+	// Attachment defaults
+	v.SetDefault("attachments.dir", "./data/attachments")
+	v.SetDefault("attachments.maxSize", 10<<20)
+	v.SetDefault("attachments.urlTTL", time.Minute*15)
+	v.SetDefault("attachments.urlKey", "")
+
+	// Notification defaults
+	v.SetDefault("notifications.smtp.host", "")
+	v.SetDefault("notifications.smtp.port", 587)
+	v.SetDefault("notifications.smtp.username", "")
+	v.SetDefault("notifications.smtp.password", "")
+	v.SetDefault("notifications.smtp.from", "")
+	v.SetDefault("notifications.webhook.enabled", false)
+	v.SetDefault("notifications.webPush.vapidPrivateKey", "")
+	v.SetDefault("notifications.webPush.subscriber", "")
+	v.SetDefault("notifications.timeout", time.Second*10)
+
+	// Account email defaults. The driver is one of smtp, which uses the
+	// notifications.smtp settings, or file.
+	v.SetDefault("mail.driver", "file")
+	v.SetDefault("mail.dir", "./data/mail")
+	v.SetDefault("mail.appURL", "http://localhost:3000")
+
+	// OpenID Connect defaults. baseURL is the public URL of the HTTP proxy,
+	// which providers redirect back to.
+	v.SetDefault("oidc.baseURL", "http://localhost:8080")
+	v.SetDefault("oidc.successURL", "http://localhost:3000/login/callback")
+	v.SetDefault("oidc.stateKey", "")
+	v.SetDefault("oidc.timeout", time.Second*10)
+
+	// CORS defaults. profile picks one of cors.profiles, which may override
+	// the built in development and production profiles or add new ones.
+	v.SetDefault("cors.profile", "development")
+
+	// Webhook defaults
+	v.SetDefault("webhooks.timeout", time.Second*10)
+
+	// Task defaults
+	v.SetDefault("tasks.missedAfter", time.Hour*24)
+
+	// Feature flags, off unless listed here or in the config. Each is set on
+	// its own so that the config file adds to them rather than replacing them.
+	v.SetDefault("features", map[string]bool{})
+	v.SetDefault("features.attachmentUploads", true)
+
+	// Background job defaults
+	v.SetDefault("jobs.releaseExpiredClaimsInterval", time.Minute)
+	v.SetDefault("jobs.runDueRotationsInterval", time.Minute)
+	v.SetDefault("jobs.markOverdueInterval", time.Minute)
+	v.SetDefault("jobs.dispatchNotificationsInterval", time.Second*10)
+	v.SetDefault("jobs.dispatchWebhooksInterval", time.Second*10)
+	v.SetDefault("jobs.deleteExpiredSessionsInterval", time.Hour)
+}
+
+// Load reads the configuration from the config file v is set up to read, if
+// there is one, and the environment. It doesn't validate it.
+func Load(v *viper.Viper) (*Config, error) {
+	SetDefaults(v)
+
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	if err := v.ReadInConfig(); err != nil {
+		// Everything can be configured with environment variables instead
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return nil, errors.Wrap(err, "unable to read config file")
+		}
+	}
+
+	if err := readSecretFiles(v); err != nil {
+		return nil, err
+	}
+
+	return decode(v)
+}
+
+// decode converts the settings read by v into a Config
+func decode(v *viper.Viper) (*Config, error) {
+	c := &Config{}
+	if err := v.Unmarshal(c); err != nil {
+		return nil, errors.Wrap(err, "unable to decode config")
+	}
+
+	return c, nil
+}
+
+// EnvVar returns the environment variable overriding a setting
+func EnvVar(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// readSecretFiles sets secrets from the files named by their _FILE
+// environment variables, as used for Docker and Kubernetes secrets. Every
+// problem is returned in a *ValidationError.
+func readSecretFiles(v *viper.Viper) error {
+	var p problems
+
+	mapKeys := func(key string) []string {
+		var names []string
+		for name := range v.GetStringMap(key) {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return names
+	}
+
+	for _, key := range secretKeys(reflect.TypeOf(Config{}), "", mapKeys) {
+		env := EnvVar(key) + "_FILE"
+
+		file, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+
+		if _, ok := os.LookupEnv(EnvVar(key)); ok {
+			p.add("only one of %s and %s can be set", EnvVar(key), env)
+			continue
+		}
+
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			p.add("unable to read %s: %v", env, err)
+			continue
+		}
+
+		// Files usually end with a newline which isn't part of the secret
+		v.Set(key, strings.TrimRight(string(b), "\r\n"))
+	}
+
+	if len(p) > 0 {
+		return &ValidationError{Problems: p}
+	}
+
+	return nil
+}
+
+// secretKeys returns the keys of the secret settings. Secrets in maps of
+// structs, such as oidc.providers, are returned for each entry mapKeys lists.
+func secretKeys(t reflect.Type, prefix string, mapKeys func(key string) []string) []string {
+	var keys []string
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := prefix + f.Tag.Get("mapstructure")
+
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			keys = append(keys, secretKeys(f.Type, key+".", mapKeys)...)
+		case f.Type.Kind() == reflect.Map && f.Type.Elem().Kind() == reflect.Struct:
+			for _, name := range mapKeys(key) {
+				keys = append(keys, secretKeys(f.Type.Elem(), key+"."+name+".", mapKeys)...)
+			}
+		case f.Tag.Get("secret") == "true":
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// ValidationError lists every problem found with a config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+type problems []string
+
+func (p *problems) add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+func (p *problems) port(key string, port int) {
+	if port < 1 || port > 65535 {
+		p.add("%s must be between 1 and 65535", key)
+	}
+}
+
+func (p *problems) positive(key string, d time.Duration) {
+	if d <= 0 {
+		p.add("%s must be positive", key)
+	}
+}
+
+func (p *problems) hmacKey(key string, value string) {
+	switch {
+	case value == "":
+	case strings.HasPrefix(value, placeholderPrefix):
+		p.add("%s is still set to the example value", key)
+	case len(value) < auth.MinHMACKeyLength:
+		p.add("%s must be at least %d characters", key, auth.MinHMACKeyLength)
+	}
+}
+
+// Validate checks the config, returning a *ValidationError listing every
+// problem found
+func (c *Config) Validate() error {
+	var p problems
+
+	if _, err := log.ParseLevel(c.Log.Level); err != nil {
+		p.add("log.level %q is not a log level", c.Log.Level)
+	}
+
+	p.port("server.port", c.Server.Port)
+
+	if c.Server.RateLimit.RequestsPerSecond < 0 {
+		p.add("server.rateLimit.requestsPerSecond can't be negative")
+	} else if c.Server.RateLimit.RequestsPerSecond > 0 && c.Server.RateLimit.Burst < 1 {
+		p.add("server.rateLimit.burst must be at least 1")
+	}
+
+	c.Server.TLS.validate(&p)
+
+	c.DB.validate(&p)
+
+	if c.Auth.Key == "" && len(c.Auth.Keys) == 0 {
+		p.add("either auth.keys or auth.key is required")
+	}
+	p.hmacKey("auth.key", c.Auth.Key)
+	for i, k := range c.Auth.Keys {
+		if k.ID == "" || k.File == "" {
+			p.add("auth.keys[%d] needs an id and a file", i)
+		}
+	}
+	if c.Auth.Issuer == "" {
+		p.add("auth.issuer is required")
+	}
+	if c.Auth.Audience == "" {
+		p.add("auth.audience is required")
+	}
+	p.positive("auth.tokenTTL", c.Auth.TokenTTL)
+	p.positive("auth.sessionCacheTTL", c.Auth.SessionCacheTTL)
+
+	if c.Attachments.URLKey == "" {
+		p.add("attachments.urlKey is required")
+	} else if c.Attachments.URLKey == c.Auth.Key {
+		p.add("attachments.urlKey must differ from auth.key")
+	}
+	p.hmacKey("attachments.urlKey", c.Attachments.URLKey)
+	if c.Attachments.MaxSize <= 0 {
+		p.add("attachments.maxSize must be positive")
+	}
+	p.positive("attachments.urlTTL", c.Attachments.URLTTL)
+
+	if c.Notifications.SMTP.Host != "" {
+		p.port("notifications.smtp.port", c.Notifications.SMTP.Port)
+	}
+	p.positive("notifications.timeout", c.Notifications.Timeout)
+
+	switch c.Mail.Driver {
+	case "smtp":
+		if c.Notifications.SMTP.Host == "" {
+			p.add("mail.driver smtp requires notifications.smtp.host")
+		}
+	case "file":
+	default:
+		p.add("mail.driver must be smtp or file")
+	}
+
+	c.OIDC.validate(&p)
+
+	if _, err := c.CORS.Policy(); err != nil {
+		p.add("%s", err)
+	}
+
+	p.positive("webhooks.timeout", c.Webhooks.Timeout)
+
+	p.positive("tasks.missedAfter", c.Tasks.MissedAfter)
+
+	p.positive("jobs.releaseExpiredClaimsInterval", c.Jobs.ReleaseExpiredClaimsInterval)
+	p.positive("jobs.runDueRotationsInterval", c.Jobs.RunDueRotationsInterval)
+	p.positive("jobs.markOverdueInterval", c.Jobs.MarkOverdueInterval)
+	p.positive("jobs.dispatchNotificationsInterval", c.Jobs.DispatchNotificationsInterval)
+	p.positive("jobs.dispatchWebhooksInterval", c.Jobs.DispatchWebhooksInterval)
+	p.positive("jobs.deleteExpiredSessionsInterval", c.Jobs.DeleteExpiredSessionsInterval)
+
+	if len(p) > 0 {
+		return &ValidationError{Problems: p}
+	}
+
+	return nil
+}
+
+func (d DB) validate(p *problems) {
+	if d.DSN == "" {
+		if d.Host == "" {
+			p.add("db.host is required")
+		}
+		p.port("db.port", d.Port)
+		if d.Username == "" {
+			p.add("db.username is required")
+		}
+		if d.Password == "" {
+			p.add("db.password is required")
+		}
+		if d.Name == "" {
+			p.add("db.name is required")
+		}
+
+		if !validSSLMode(d.SSLMode) {
+			p.add("db.sslMode must be disable, allow, prefer, require, verify-ca or verify-full")
+		}
+
+		if (d.SSLCert == "") != (d.SSLKey == "") {
+			p.add("db.sslCert and db.sslKey must be set together")
+		}
+	}
+
+	if d.StatementTimeout < 0 {
+		p.add("db.statementTimeout can't be negative")
+	}
+	p.positive("db.connectTimeout", d.ConnectTimeout)
+	if d.StartupTimeout < 0 {
+		p.add("db.startupTimeout can't be negative")
+	}
+
+	if d.Pool.MaxConns < 1 {
+		p.add("db.pool.maxConns must be at least 1")
+	}
+	if d.Pool.MinConns < 0 || d.Pool.MinConns > d.Pool.MaxConns {
+		p.add("db.pool.minConns must be between 0 and db.pool.maxConns")
+	}
+	p.positive("db.pool.maxConnLifetime", d.Pool.MaxConnLifetime)
+	p.positive("db.pool.maxConnIdleTime", d.Pool.MaxConnIdleTime)
+	p.positive("db.pool.healthCheckPeriod", d.Pool.HealthCheckPeriod)
+
+	if d.Replica.Enabled() && d.Replica.DSN == "" {
+		// A port of 0 uses db.port
+		if d.Replica.Port != 0 {
+			p.port("db.replica.port", d.Replica.Port)
+		}
+		if d.Replica.SSLMode != "" && !validSSLMode(d.Replica.SSLMode) {
+			p.add("db.replica.sslMode must be disable, allow, prefer, require, verify-ca or verify-full")
+		}
+	}
+	if d.Replica.ReadYourWritesWindow < 0 {
+		p.add("db.replica.readYourWritesWindow can't be negative")
+	}
+}
+
+// validSSLMode reports whether mode is one of the libpq SSL modes
+func validSSLMode(mode string) bool {
+	switch mode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+		return true
+	}
+
+	return false
+}
+
+func (t TLS) validate(p *problems) {
+	switch t.ClientAuth {
+	case certs.ClientAuthNone, certs.ClientAuthOptional, certs.ClientAuthRequire:
+	default:
+		p.add("server.tls.clientAuth must be none, optional or require")
+	}
+
+	if !t.Enabled {
+		return
+	}
+
+	if t.CertFile == "" || t.KeyFile == "" {
+		p.add("server.tls.certFile and server.tls.keyFile are required when TLS is enabled")
+	}
+
+	if t.ClientAuth != certs.ClientAuthNone && t.ClientCAFile == "" {
+		p.add("server.tls.clientCAFile is required when server.tls.clientAuth is %s", t.ClientAuth)
+	}
+
+	if len(t.Clients) > 0 && t.ClientAuth == certs.ClientAuthNone {
+		p.add("server.tls.clients needs server.tls.clientAuth to be optional or require")
+	}
+
+	for i, c := range t.Clients {
+		if c.Identity == "" || len(c.Roles) == 0 {
+			p.add("server.tls.clients[%d] needs an identity and roles", i)
+		}
+	}
+
+	p.positive("server.tls.reloadInterval", t.ReloadInterval)
+}
+
+func (o OIDC) validate(p *problems) {
+	if len(o.Providers) == 0 {
+		return
+	}
+
+	if len(o.StateKey) < auth.MinHMACKeyLength {
+		p.add("oidc.stateKey must be at least %d characters", auth.MinHMACKeyLength)
+	}
+
+	if o.BaseURL == "" || o.SuccessURL == "" {
+		p.add("oidc.baseURL and oidc.successURL are required")
+	}
+
+	p.positive("oidc.timeout", o.Timeout)
+
+	for name, provider := range o.Providers {
+		if provider.Issuer == "" || provider.ClientID == "" {
+			p.add("oidc.providers.%s needs an issuer and clientID", name)
+		}
+	}
+}
+
+// Policy returns the CORS policy of the configured profile. A profile in the
+// config replaces the built in profile of the same name.
+func (c CORS) Policy() (cors.Policy, error) {
+	// Viper lower cases map keys
+	policy, ok := c.Profiles[strings.ToLower(c.Profile)]
+	if !ok {
+		if policy, ok = cors.Profiles[c.Profile]; !ok {
+			return cors.Policy{}, errors.Errorf("cors.profile %q does not exist", c.Profile)
+		}
+	}
+
+	if err := policy.Validate(); err != nil {
+		return cors.Policy{}, errors.Wrapf(err, "cors.profiles.%s is invalid", c.Profile)
+	}
+
+	return policy, nil
+}
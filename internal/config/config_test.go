@@ -0,0 +1,303 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	secret      = "a-secret-which-is-long-enough-to-use"
+	otherSecret = "another-secret-which-is-long-enough"
+)
+
+func load(t *testing.T, yaml string, env map[string]string) (*Config, error) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(yaml), 0600))
+
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	v := viper.New()
+	v.SetConfigFile(file)
+
+	return Load(v)
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("it should use the defaults", func(t *testing.T) {
+		c, err := load(t, "", nil)
+		assert.NoError(t, err)
+
+		assert.Equal(t, 8080, c.Server.Port)
+		assert.Equal(t, "development", c.CORS.Profile)
+		assert.Equal(t, time.Minute, c.Jobs.MarkOverdueInterval)
+	})
+
+	t.Run("it should let the environment override the file", func(t *testing.T) {
+		c, err := load(t, "db:\n  host: db.internal\n  port: 5433\n", map[string]string{
+			"CHOREREWARDS_DB_PORT":                  "6432",
+			"CHOREREWARDS_SERVER_HTTPPROXY_ENABLED": "true",
+			"CHOREREWARDS_AUTH_SESSIONCACHETTL":     "1m",
+			"CHOREREWARDS_JOBS_MARKOVERDUEINTERVAL": "5s",
+			"CHOREREWARDS_SERVER_RATELIMIT_BURST":   "7",
+		})
+		assert.NoError(t, err)
+
+		assert.Equal(t, "db.internal", c.DB.Host)
+		assert.Equal(t, 6432, c.DB.Port)
+		assert.True(t, c.Server.HTTPProxy.Enabled)
+		assert.Equal(t, time.Minute, c.Auth.SessionCacheTTL)
+		assert.Equal(t, time.Second*5, c.Jobs.MarkOverdueInterval)
+		assert.Equal(t, 7, c.Server.RateLimit.Burst)
+	})
+
+	t.Run("it should read secrets from files", func(t *testing.T) {
+		f, err := ioutil.TempFile("", "secret")
+		assert.NoError(t, err)
+		defer os.Remove(f.Name())
+
+		_, _ = f.WriteString("p@ss/word\n")
+		f.Close()
+
+		c, err := load(t, "", map[string]string{"CHOREREWARDS_DB_PASSWORD_FILE": f.Name()})
+		assert.NoError(t, err)
+
+		assert.Equal(t, "p@ss/word", c.DB.Password)
+	})
+
+	t.Run("it should refuse a secret set twice", func(t *testing.T) {
+		_, err := load(t, "", map[string]string{
+			"CHOREREWARDS_DB_PASSWORD_FILE": "/run/secrets/db",
+			"CHOREREWARDS_DB_PASSWORD":      "password",
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("it should list every problem with secret files", func(t *testing.T) {
+		_, err := load(t, "", map[string]string{
+			"CHOREREWARDS_DB_PASSWORD_FILE": "/run/secrets/db",
+			"CHOREREWARDS_DB_PASSWORD":      "password",
+			"CHOREREWARDS_AUTH_KEY_FILE":    "/does/not/exist",
+		})
+
+		if assert.IsType(t, &ValidationError{}, err) {
+			problems := err.(*ValidationError).Problems
+			assert.Len(t, problems, 2)
+			assert.Equal(t, "only one of CHOREREWARDS_DB_PASSWORD and CHOREREWARDS_DB_PASSWORD_FILE can be set", problems[0])
+			assert.Contains(t, problems[1], "unable to read CHOREREWARDS_AUTH_KEY_FILE")
+		}
+	})
+
+	t.Run("it should read OIDC client secrets from files", func(t *testing.T) {
+		f, err := ioutil.TempFile("", "secret")
+		assert.NoError(t, err)
+		defer os.Remove(f.Name())
+
+		_, _ = f.WriteString("client-secret\n")
+		f.Close()
+
+		c, err := load(t, `
+oidc:
+  providers:
+    google:
+      issuer: https://accounts.google.com
+      clientID: chorerewards
+`, map[string]string{"CHOREREWARDS_OIDC_PROVIDERS_GOOGLE_CLIENTSECRET_FILE": f.Name()})
+		assert.NoError(t, err)
+
+		if assert.Contains(t, c.OIDC.Providers, "google") {
+			assert.Equal(t, "client-secret", c.OIDC.Providers["google"].ClientSecret)
+			assert.Equal(t, "chorerewards", c.OIDC.Providers["google"].ClientID)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("it should accept a complete config", func(t *testing.T) {
+		c, err := load(t, "db:\n  password: password\nauth:\n  key: "+secret+"\nattachments:\n  urlKey: "+otherSecret+"\n", nil)
+		assert.NoError(t, err)
+
+		assert.NoError(t, c.Validate())
+	})
+
+	t.Run("it should refuse to sign attachment URLs with the auth key", func(t *testing.T) {
+		c, err := load(t, "db:\n  password: password\nauth:\n  key: "+secret+"\nattachments:\n  urlKey: "+secret+"\n", nil)
+		assert.NoError(t, err)
+
+		err = c.Validate()
+		if assert.IsType(t, &ValidationError{}, err) {
+			assert.Equal(t, []string{"attachments.urlKey must differ from auth.key"}, err.(*ValidationError).Problems)
+		}
+	})
+
+	t.Run("it should refuse the example auth key", func(t *testing.T) {
+		c, err := load(t, "db:\n  password: password\nauth:\n  key: replace-with-a-long-random-secret-of-32-chars-or-more\nattachments:\n  urlKey: "+otherSecret+"\n", nil)
+		assert.NoError(t, err)
+
+		err = c.Validate()
+		if assert.IsType(t, &ValidationError{}, err) {
+			assert.Equal(t, []string{"auth.key is still set to the example value"}, err.(*ValidationError).Problems)
+		}
+	})
+
+	t.Run("it should list every problem", func(t *testing.T) {
+		c, err := load(t, `
+server:
+  port: 70000
+auth:
+  key: short
+mail:
+  driver: carrier-pigeon
+cors:
+  profile: staging
+`, nil)
+		assert.NoError(t, err)
+
+		err = c.Validate()
+		if assert.IsType(t, &ValidationError{}, err) {
+			assert.ElementsMatch(t, []string{
+				"server.port must be between 1 and 65535",
+				"db.password is required",
+				"auth.key must be at least 32 characters",
+				"attachments.urlKey is required",
+				"mail.driver must be smtp or file",
+				`cors.profile "staging" does not exist`,
+			}, err.(*ValidationError).Problems)
+		}
+	})
+
+	t.Run("it should check the read replica", func(t *testing.T) {
+		c, err := load(t, `
+db:
+  password: password
+  replica:
+    host: replica.internal
+    sslMode: sometimes
+auth:
+  key: `+secret+`
+attachments:
+  urlKey: `+otherSecret+"\n", nil)
+		assert.NoError(t, err)
+
+		err = c.Validate()
+		if assert.IsType(t, &ValidationError{}, err) {
+			assert.Equal(t, []string{
+				"db.replica.sslMode must be disable, allow, prefer, require, verify-ca or verify-full",
+			}, err.(*ValidationError).Problems)
+		}
+	})
+}
+
+func TestPrint(t *testing.T) {
+	c, err := load(t, "db:\n  password: password\n", nil)
+	assert.NoError(t, err)
+
+	t.Run("it should redact secrets", func(t *testing.T) {
+		var b bytes.Buffer
+		assert.NoError(t, c.Print(&b, true))
+
+		assert.Contains(t, b.String(), "password: REDACTED")
+		assert.Contains(t, b.String(), "markOverdueInterval: 1m0s")
+		// Secrets which aren't set are left empty, so it's clear they're missing
+		assert.Contains(t, b.String(), `stateKey: ""`)
+	})
+
+	t.Run("it should print secrets when asked", func(t *testing.T) {
+		var b bytes.Buffer
+		assert.NoError(t, c.Print(&b, false))
+
+		assert.Contains(t, b.String(), "password: password")
+	})
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yaml")
+	write := func(yaml string) {
+		assert.NoError(t, ioutil.WriteFile(file, []byte("db:\n  password: password\nauth:\n  key: "+secret+"\nattachments:\n  urlKey: "+otherSecret+"\n"+yaml), 0600))
+	}
+
+	write("server:\n  port: 8080\n")
+
+	v := viper.New()
+	v.SetConfigFile(file)
+
+	c, err := Load(v)
+	assert.NoError(t, err)
+
+	m := NewManager(v, c)
+
+	var reloaded *Config
+	m.OnReload(func(c *Config) { reloaded = c })
+
+	t.Run("it should apply reloadable settings", func(t *testing.T) {
+		write("log:\n  level: debug\nserver:\n  port: 8080\n  rateLimit:\n    burst: 5\nfeatures:\n  newFeed: true\n")
+		assert.NoError(t, m.Reload())
+
+		assert.Equal(t, "debug", m.Current().Log.Level)
+		assert.Equal(t, 5, m.Current().Server.RateLimit.Burst)
+		assert.True(t, m.FeatureEnabled("newFeed"))
+		assert.True(t, m.FeatureEnabled("attachmentUploads"), "defaults should be kept alongside listed features")
+		assert.Same(t, m.Current(), reloaded)
+		assert.Empty(t, m.RestartRequired())
+	})
+
+	t.Run("it should keep settings which need a restart", func(t *testing.T) {
+		write("log:\n  level: warn\nserver:\n  port: 9090\nmail:\n  dir: ./elsewhere\n")
+		assert.NoError(t, m.Reload())
+
+		assert.Equal(t, "warn", m.Current().Log.Level)
+		assert.Equal(t, 8080, m.Current().Server.Port)
+		assert.Equal(t, "./data/mail", m.Current().Mail.Dir)
+		assert.ElementsMatch(t, []string{"server.port", "mail.dir"}, m.RestartRequired())
+	})
+
+	t.Run("it should turn off features on by default", func(t *testing.T) {
+		write("log:\n  level: warn\nserver:\n  port: 9090\nmail:\n  dir: ./elsewhere\nfeatures:\n  attachmentUploads: false\n")
+		assert.NoError(t, m.Reload())
+
+		assert.False(t, m.FeatureEnabled("attachmentUploads"))
+	})
+
+	t.Run("it should report the config in effect without secrets", func(t *testing.T) {
+		snapshot, err := m.Snapshot()
+		assert.NoError(t, err)
+
+		assert.Contains(t, snapshot.YAML, "password: REDACTED")
+		assert.NotContains(t, snapshot.YAML, secret)
+		assert.ElementsMatch(t, []string{"server.port", "mail.dir"}, snapshot.RestartRequired)
+	})
+
+	t.Run("it should keep the current config when the new one is invalid", func(t *testing.T) {
+		write("log:\n  level: loud\n")
+		assert.Error(t, m.Reload())
+
+		assert.Equal(t, "warn", m.Current().Log.Level)
+	})
+
+	t.Run("it should not be affected by changes to the previous config", func(t *testing.T) {
+		previous := m.Current()
+
+		write("log:\n  level: error\n")
+		assert.NoError(t, m.Reload())
+
+		assert.Equal(t, "warn", previous.Log.Level)
+		assert.Equal(t, "error", m.Current().Log.Level)
+	})
+}
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Manager holds the config in effect. When the config file changes, the
+// settings tagged reload are swapped in, while changes to any other setting
+// are only logged, as they need a restart.
+type Manager struct {
+	v       *viper.Viper
+	current atomic.Value
+
+	// mu serialises reloads, and guards the fields below
+	mu              sync.Mutex
+	listeners       []func(*Config)
+	loadedAt        time.Time
+	restartRequired []string
+}
+
+// NewManager creates a manager for the config loaded from v
+func NewManager(v *viper.Viper, c *Config) *Manager {
+	m := &Manager{v: v, loadedAt: time.Now()}
+	m.current.Store(c)
+
+	return m
+}
+
+// Current returns the config in effect. It must not be modified.
+func (m *Manager) Current() *Config {
+	return m.current.Load().(*Config)
+}
+
+// FeatureEnabled reports whether the feature flag is on
+func (m *Manager) FeatureEnabled(name string) bool {
+	// Viper lower cases map keys
+	return m.Current().Features[strings.ToLower(name)]
+}
+
+// LoadedAt returns when the config in effect was loaded
+func (m *Manager) LoadedAt() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.loadedAt
+}
+
+// Snapshot is the config in effect, as reported to admins
+type Snapshot struct {
+	// YAML is the config in the layout of config.yaml, with secrets redacted
+	YAML string
+	// LoadedAt is when the config was last reloaded
+	LoadedAt time.Time
+	// RestartRequired lists settings changed in the config file which won't
+	// take effect until the server restarts
+	RestartRequired []string
+}
+
+// Snapshot returns the config in effect with its secrets redacted
+func (m *Manager) Snapshot() (Snapshot, error) {
+	var b bytes.Buffer
+	if err := m.Current().Print(&b, true); err != nil {
+		return Snapshot{}, err
+	}
+
+	return Snapshot{
+		YAML:            b.String(),
+		LoadedAt:        m.LoadedAt(),
+		RestartRequired: m.RestartRequired(),
+	}, nil
+}
+
+// RestartRequired lists the settings which have changed in the config file
+// but won't take effect until the server restarts
+func (m *Manager) RestartRequired() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]string(nil), m.restartRequired...)
+}
+
+// OnReload calls fn with the new config each time reloadable settings change
+func (m *Manager) OnReload(fn func(c *Config)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.listeners = append(m.listeners, fn)
+}
+
+// Watch reloads the config whenever the config file changes
+func (m *Manager) Watch() {
+	m.v.OnConfigChange(func(fsnotify.Event) {
+		if err := m.Reload(); err != nil {
+			log.WithError(err).Warn("Config not reloaded, keeping the current config")
+		}
+	})
+
+	m.v.WatchConfig()
+}
+
+// Reload reads the config file again and applies the settings which can be
+// changed while running. The current config is kept if the new one is
+// invalid.
+func (m *Manager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.v.ReadInConfig(); err != nil {
+		return errors.Wrap(err, "unable to read config file")
+	}
+
+	next, err := decode(m.v)
+	if err != nil {
+		return err
+	}
+
+	current := m.Current()
+
+	effective := *current
+	var changed, restartRequired []string
+	merge(reflect.ValueOf(&effective).Elem(), reflect.ValueOf(next).Elem(), "", &changed, &restartRequired)
+
+	if err := effective.Validate(); err != nil {
+		return err
+	}
+
+	m.restartRequired = restartRequired
+
+	if len(restartRequired) > 0 {
+		log.WithField("settings", restartRequired).Warn("Config changes need a restart to take effect")
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	m.current.Store(&effective)
+	m.loadedAt = time.Now()
+
+	for _, fn := range m.listeners {
+		fn(&effective)
+	}
+
+	log.WithField("settings", changed).Info("Config reloaded")
+
+	return nil
+}
+
+// merge copies the reloadable settings from next into effective, recording
+// the keys of those which changed, and the keys of other settings which
+// differ and so need a restart
+func merge(effective reflect.Value, next reflect.Value, prefix string, changed *[]string, restartRequired *[]string) {
+	t := effective.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := prefix + f.Tag.Get("mapstructure")
+
+		if reflect.DeepEqual(effective.Field(i).Interface(), next.Field(i).Interface()) {
+			continue
+		}
+
+		switch {
+		case f.Tag.Get("reload") == "true":
+			effective.Field(i).Set(next.Field(i))
+			*changed = append(*changed, key)
+		case f.Type.Kind() == reflect.Struct:
+			merge(effective.Field(i), next.Field(i), key+".", changed, restartRequired)
+		default:
+			*restartRequired = append(*restartRequired, key)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// redacted replaces secrets when printing a redacted config
+const redacted = "REDACTED"
+
+// Print writes the config as YAML in the layout of config.yaml. Secrets are
+// replaced when redact is set.
+func (c *Config) Print(w io.Writer, redact bool) error {
+	b, err := yaml.Marshal(printable(reflect.ValueOf(*c), redact, false))
+	if err != nil {
+		return errors.Wrap(err, "unable to encode config")
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+// printable converts a config value to values which marshal to YAML the way
+// they are written in config.yaml, keeping the order of fields
+func printable(v reflect.Value, redact bool, secret bool) interface{} {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		out := yaml.MapSlice{}
+		t := v.Type()
+
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+
+			out = append(out, yaml.MapItem{
+				Key:   f.Tag.Get("mapstructure"),
+				Value: printable(v.Field(i), redact, f.Tag.Get("secret") == "true"),
+			})
+		}
+
+		return out
+	case reflect.Slice:
+		out := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out = append(out, printable(v.Index(i), redact, secret))
+		}
+
+		return out
+	case reflect.Map:
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, fmt.Sprint(k.Interface()))
+		}
+		sort.Strings(keys)
+
+		out := yaml.MapSlice{}
+		for _, k := range keys {
+			out = append(out, yaml.MapItem{Key: k, Value: printable(v.MapIndex(reflect.ValueOf(k)), redact, secret)})
+		}
+
+		return out
+	}
+
+	if secret && redact && !v.IsZero() {
+		return redacted
+	}
+
+	return v.Interface()
+}
@@ -0,0 +1,241 @@
+// Package cors decides which web origins may call the HTTP proxy from a
+// browser
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	rscors "github.com/rs/cors"
+)
+
+// Policy configures the CORS headers of the HTTP proxy
+type Policy struct {
+	// AllowedOrigins are origins such as https://app.example.com. The
+	// leftmost label of the host may be * to allow any subdomain, the port may
+	// be * to allow any port, and a lone * allows every origin.
+	AllowedOrigins []string `mapstructure:"allowedOrigins"`
+	AllowedMethods []string `mapstructure:"allowedMethods"`
+	AllowedHeaders []string `mapstructure:"allowedHeaders"`
+	ExposedHeaders []string `mapstructure:"exposedHeaders"`
+	// AllowCredentials lets browsers send cookies and client certificates.
+	// The API authenticates with bearer tokens, so it is rarely needed.
+	AllowCredentials bool `mapstructure:"allowCredentials"`
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration `mapstructure:"maxAge"`
+}
+
+// Profiles are the built in policies, chosen with the cors.profile setting.
+// Development allows the web app's dev server on any local port, while
+// production allows no origins until they are configured.
+var Profiles = map[string]Policy{
+	"development": {
+		AllowedOrigins: []string{"http://localhost:*", "http://127.0.0.1:*"},
+		MaxAge:         time.Minute * 10,
+	},
+	"production": {
+		MaxAge: time.Hour * 24,
+	},
+}
+
+// Defaults fills in the methods and headers the API uses where the policy
+// doesn't list its own
+func (p Policy) Defaults() Policy {
+	if len(p.AllowedMethods) == 0 {
+		p.AllowedMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+
+	if len(p.AllowedHeaders) == 0 {
+		p.AllowedHeaders = []string{
+			"Authorization", "Content-Type", "X-Request-Id", "X-Device-Name",
+			// Sent by gRPC-Web clients
+			"X-Grpc-Web", "X-User-Agent", "Grpc-Timeout",
+		}
+	}
+
+	if len(p.ExposedHeaders) == 0 {
+		p.ExposedHeaders = []string{"X-Request-Id", "Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"}
+	}
+
+	return p
+}
+
+type originPattern struct {
+	scheme string
+	// host is the host, or with a leading "*." the domain whose subdomains
+	// are allowed
+	host string
+	// port is the port, empty for the scheme's default port, or * for any
+	port string
+}
+
+// parseOrigin splits an origin, or an allowed origin pattern, into its parts.
+// url.Parse isn't used as it refuses * as a port.
+func parseOrigin(origin string) (originPattern, error) {
+	i := strings.Index(origin, "://")
+	if i <= 0 {
+		return originPattern{}, errors.Errorf("invalid origin %q", origin)
+	}
+
+	scheme, host, port := strings.ToLower(origin[:i]), strings.ToLower(strings.TrimSuffix(origin[i+3:], "/")), ""
+
+	if j := strings.LastIndex(host, ":"); j > strings.LastIndex(host, "]") {
+		host, port = host[:j], host[j+1:]
+
+		if _, err := strconv.Atoi(port); err != nil && port != "*" {
+			return originPattern{}, errors.Errorf("invalid port in origin %q", origin)
+		}
+	}
+
+	if host == "" || strings.ContainsAny(host, "/?#@") {
+		return originPattern{}, errors.Errorf("invalid origin %q", origin)
+	}
+
+	return originPattern{scheme: scheme, host: host, port: port}, nil
+}
+
+func (p originPattern) matches(o originPattern) bool {
+	if p.scheme != o.scheme || (p.port != "*" && p.port != o.port) {
+		return false
+	}
+
+	if strings.HasPrefix(p.host, "*.") {
+		// Only subdomains, not the domain itself
+		return strings.HasSuffix(o.host, p.host[1:]) && len(o.host) > len(p.host)-1
+	}
+
+	return p.host == o.host
+}
+
+// Checker decides whether an origin is allowed
+type Checker struct {
+	allowAll bool
+	patterns []originPattern
+}
+
+// NewChecker parses allowed origins
+func NewChecker(origins []string) (*Checker, error) {
+	c := &Checker{}
+
+	for _, o := range origins {
+		if o == "*" {
+			c.allowAll = true
+			continue
+		}
+
+		p, err := parseOrigin(o)
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.Contains(strings.TrimPrefix(p.host, "*."), "*") {
+			return nil, errors.Errorf("origin %q may only use * as the leftmost label", o)
+		}
+
+		c.patterns = append(c.patterns, p)
+	}
+
+	return c, nil
+}
+
+// Allowed reports whether a request from origin is allowed
+func (c *Checker) Allowed(origin string) bool {
+	if c.allowAll {
+		return true
+	}
+
+	o, err := parseOrigin(origin)
+	if err != nil || o.port == "*" || strings.Contains(o.host, "*") {
+		return false
+	}
+
+	for _, p := range c.patterns {
+		if p.matches(o) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p Policy) checker() (*Checker, error) {
+	checker, err := NewChecker(p.AllowedOrigins)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.AllowCredentials && checker.allowAll {
+		return nil, errors.New("credentials can't be allowed for every origin")
+	}
+
+	return checker, nil
+}
+
+// Validate checks the allowed origins can be used
+func (p Policy) Validate() error {
+	if p.MaxAge < 0 {
+		return errors.New("max age can't be negative")
+	}
+
+	_, err := p.checker()
+	return err
+}
+
+// Handler wraps h with the CORS headers of the policy, answering preflight
+// requests itself
+func (p Policy) Handler(h http.Handler) (http.Handler, error) {
+	p = p.Defaults()
+
+	checker, err := p.checker()
+	if err != nil {
+		return nil, err
+	}
+
+	c := rscors.New(rscors.Options{
+		AllowOriginFunc:  checker.Allowed,
+		AllowedMethods:   p.AllowedMethods,
+		AllowedHeaders:   p.AllowedHeaders,
+		ExposedHeaders:   p.ExposedHeaders,
+		AllowCredentials: p.AllowCredentials,
+		// In seconds, see https://fetch.spec.whatwg.org/#http-access-control-max-age
+		MaxAge: int(p.MaxAge / time.Second),
+	})
+
+	return c.Handler(h), nil
+}
+
+// Reloadable applies a policy which can be replaced while serving
+type Reloadable struct {
+	next    http.Handler
+	handler atomic.Value
+}
+
+// NewReloadable wraps next with the CORS headers of the policy
+func NewReloadable(p Policy, next http.Handler) (*Reloadable, error) {
+	r := &Reloadable{next: next}
+	if err := r.Update(p); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Update replaces the policy, keeping the current one if p is invalid
+func (r *Reloadable) Update(p Policy) error {
+	h, err := p.Handler(r.next)
+	if err != nil {
+		return err
+	}
+
+	r.handler.Store(h)
+
+	return nil
+}
+
+func (r *Reloadable) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.handler.Load().(http.Handler).ServeHTTP(w, req)
+}
@@ -0,0 +1,135 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func preflight(h http.Handler, origin string, method string, headers string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodOptions, "/v1alpha1/chores", nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestPreflight(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	policy := Policy{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org", "http://localhost:*"},
+		MaxAge:         time.Hour * 24,
+	}
+
+	h, err := policy.Handler(ok)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		origin  string
+		method  string
+		headers string
+		allowed bool
+	}{
+		{"an exact origin", "https://app.example.com", http.MethodPost, "authorization,content-type", true},
+		{"an origin with another scheme", "http://app.example.com", http.MethodPost, "", false},
+		{"an origin with another port", "https://app.example.com:8443", http.MethodPost, "", false},
+		{"an unlisted origin", "https://evil.example", http.MethodGet, "", false},
+		{"an origin which only ends with an allowed one", "https://notapp.example.com", http.MethodGet, "", false},
+		{"a subdomain of a wildcard", "https://staging.example.org", http.MethodGet, "", true},
+		{"a nested subdomain of a wildcard", "https://a.b.example.org", http.MethodGet, "", true},
+		{"the domain of a wildcard itself", "https://example.org", http.MethodGet, "", false},
+		{"any port of a port wildcard", "http://localhost:3000", http.MethodDelete, "", true},
+		{"a null origin", "null", http.MethodGet, "", false},
+		{"a method which isn't allowed", "https://app.example.com", "PROPFIND", "", false},
+		{"a header which isn't allowed", "https://app.example.com", http.MethodPost, "x-secret", false},
+		{"the gRPC-Web headers", "https://app.example.com", http.MethodPost, "x-grpc-web,x-user-agent,content-type", true},
+	}
+
+	for _, tt := range tests {
+		w := preflight(h, tt.origin, tt.method, tt.headers)
+
+		if tt.allowed {
+			t.Run("it should allow "+tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.origin, w.Header().Get("Access-Control-Allow-Origin"))
+				assert.Equal(t, "86400", w.Header().Get("Access-Control-Max-Age"))
+				assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+			})
+		} else {
+			t.Run("it should refuse "+tt.name, func(t *testing.T) {
+				assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+			})
+		}
+	}
+
+	t.Run("it should allow credentials when configured", func(t *testing.T) {
+		policy.AllowCredentials = true
+
+		h, err := policy.Handler(ok)
+		assert.NoError(t, err)
+
+		w := preflight(h, "https://app.example.com", http.MethodPost, "")
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("it should expose the gRPC status headers", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "Grpc-Status")
+	})
+}
+
+func TestPolicy(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("it should refuse credentials for every origin", func(t *testing.T) {
+		_, err := Policy{AllowedOrigins: []string{"*"}, AllowCredentials: true}.Handler(ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("it should refuse a wildcard which isn't the leftmost label", func(t *testing.T) {
+		_, err := Policy{AllowedOrigins: []string{"https://app.*.example.com"}}.Handler(ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("it should refuse an origin without a scheme", func(t *testing.T) {
+		_, err := Policy{AllowedOrigins: []string{"app.example.com"}}.Handler(ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("it should allow no origins in the production profile", func(t *testing.T) {
+		h, err := Profiles["production"].Handler(ok)
+		assert.NoError(t, err)
+
+		w := preflight(h, "http://localhost:3000", http.MethodGet, "")
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("it should apply a replaced policy", func(t *testing.T) {
+		r, err := NewReloadable(Policy{AllowedOrigins: []string{"https://app.example.com"}}, ok)
+		assert.NoError(t, err)
+
+		assert.NoError(t, r.Update(Policy{AllowedOrigins: []string{"https://next.example.com"}}))
+		assert.Error(t, r.Update(Policy{AllowedOrigins: []string{"next.example.com"}}))
+
+		w := preflight(r, "https://next.example.com", http.MethodGet, "")
+		assert.Equal(t, "https://next.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+		w = preflight(r, "https://app.example.com", http.MethodGet, "")
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Purposes of the one-time tokens sent to users by email
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+)
+
+// CreateUserToken stores the hash of a one-time token for a user. Any earlier
+// unused token for the same purpose stops working, so only the most recent
+// email sent is valid.
+func (d *Manager) CreateUserToken(ctx context.Context, userID int32, purpose string, hash []byte, expiresAt time.Time) error {
+	return d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "UPDATE user_tokens SET used_at=now() WHERE user_id=$1 AND purpose=$2 AND used_at IS NULL", userID, purpose)
+		if err != nil {
+			return errors.Wrap(err, "unable to invalidate user tokens")
+		}
+
+		// The email is recorded so that a verification link stops working
+		// if the address is changed before it is followed
+		_, err = tx.Exec(
+			ctx,
+			"INSERT INTO user_tokens(user_id, purpose, token_hash, email, expires_at) SELECT id, $2, $3, email, $4 FROM users WHERE id=$1",
+			userID, purpose, hash, expiresAt,
+		)
+		if err != nil {
+			return errors.Wrap(err, "unable to add user token")
+		}
+
+		return nil
+	})
+}
+
+// consumeUserToken marks a token as used, returning the user it was issued to
+// and the email address it was sent to
+func consumeUserToken(ctx context.Context, tx pgx.Tx, purpose string, hash []byte) (int32, string, error) {
+	var (
+		userID int32
+		email  string
+	)
+
+	err := tx.QueryRow(
+		ctx,
+		"UPDATE user_tokens SET used_at=now() WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > now() RETURNING user_id, email",
+		hash, purpose,
+	).Scan(&userID, &email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, "", &ErrFailedPrecondition{message: "token is invalid, expired or has already been used"}
+		}
+		return 0, "", errors.Wrap(err, "unable to use token")
+	}
+
+	return userID, email, nil
+}
+
+// VerifyEmail uses an email verification token, marking the address it was
+// sent to as verified
+func (d *Manager) VerifyEmail(ctx context.Context, hash []byte) (int32, error) {
+	var userID int32
+
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		id, email, err := consumeUserToken(ctx, tx, TokenPurposeVerifyEmail, hash)
+		if err != nil {
+			return err
+		}
+
+		tag, err := tx.Exec(ctx, "UPDATE users SET email_verified_at=now() WHERE id=$1 AND email=$2", id, email)
+		if err != nil {
+			return errors.Wrap(err, "unable to verify email")
+		}
+
+		if tag.RowsAffected() != 1 {
+			return &ErrFailedPrecondition{message: "email address has changed since the token was sent"}
+		}
+
+		userID = id
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": userID,
+	}).Info("Email verified successfully")
+
+	return userID, nil
+}
+
+// ResetPassword uses a password reset token to set a new password
+func (d *Manager) ResetPassword(ctx context.Context, hash []byte, passwordHash string) (int32, error) {
+	var userID int32
+
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		id, _, err := consumeUserToken(ctx, tx, TokenPurposeResetPassword, hash)
+		if err != nil {
+			return err
+		}
+
+		userID = id
+
+		return setPassword(ctx, tx, id, passwordHash)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": userID,
+	}).Info("Password reset successfully")
+
+	return userID, nil
+}
+
+// SetPassword changes a user's password
+func (d *Manager) SetPassword(ctx context.Context, userID int32, passwordHash string) error {
+	return d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		return setPassword(ctx, tx, userID, passwordHash)
+	})
+}
+
+// setPassword updates the password and records the time of the change in
+// sessions_revoked_at. Sessions created before it no longer match
+// activeSession, so the auth interceptor refuses their tokens and anyone who
+// knew the old password is signed out. Outstanding password reset tokens are
+// also invalidated.
+func setPassword(ctx context.Context, tx pgx.Tx, userID int32, passwordHash string) error {
+	tag, err := tx.Exec(ctx, "UPDATE users SET password=$1, sessions_revoked_at=now() WHERE id=$2", passwordHash, userID)
+	if err != nil {
+		return errors.Wrap(err, "unable to update password")
+	}
+
+	if tag.RowsAffected() != 1 {
+		return &ErrNotFound{message: "record not found"}
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE user_tokens SET used_at=now() WHERE user_id=$1 AND purpose=$2 AND used_at IS NULL", userID, TokenPurposeResetPassword)
+	if err != nil {
+		return errors.Wrap(err, "unable to invalidate user tokens")
+	}
+
+	return nil
+}
+
+// ListUsersByEmail returns every user with an email address, as members of a
+// household may share one
+func (d *Manager) ListUsersByEmail(ctx context.Context, email string) ([]User, error) {
+	users := make([]User, 0)
+
+	rows, err := d.conn.Query(ctx, "SELECT id, username, email, household_id, is_admin, is_parent, avatar, points, reserved_points, email_verified_at IS NOT NULL, password, pin, is_active FROM users WHERE lower(email)=lower($1) AND email <> '' ORDER BY id", email)
+	if err != nil {
+		return users, errors.Wrap(err, "unable to get users")
+	}
+
+	for rows.Next() {
+		u := User{}
+
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.HouseholdID, &u.IsAdmin, &u.IsParent, &u.Avatar, &u.Points, &u.ReservedPoints, &u.EmailVerified, &u.Password, &u.Pin, &u.IsActive); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		users = append(users, u)
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	return users, nil
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Attachment is a file, such as a photo, attached to a feed entry as proof the
+// task was completed. The content itself is kept in a blob store.
+type Attachment struct {
+	ID           int32
+	TaskFeedID   int32
+	HouseholdID  int32
+	UploaderID   int32
+	ContentType  string
+	Size         int64
+	BlobKey      string
+	ThumbnailKey string
+	CreatedAt    time.Time
+}
+
+func scanAttachment(row pgx.Row, a *Attachment) error {
+	return row.Scan(&a.ID, &a.TaskFeedID, &a.HouseholdID, &a.UploaderID, &a.ContentType, &a.Size, &a.BlobKey, &a.ThumbnailKey, &a.CreatedAt)
+}
+
+func (d *Manager) CreateAttachment(ctx context.Context, attachment Attachment) (Attachment, error) {
+	a := Attachment{}
+
+	err := scanAttachment(d.conn.QueryRow(
+		ctx,
+		"INSERT INTO attachments(tasks_feed_id, household_id, uploader_id, content_type, size, blob_key, thumbnail_key) VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id, tasks_feed_id, household_id, uploader_id, content_type, size, blob_key, thumbnail_key, created_at",
+		attachment.TaskFeedID, attachment.HouseholdID, attachment.UploaderID, attachment.ContentType, attachment.Size, attachment.BlobKey, attachment.ThumbnailKey,
+	), &a)
+	if err != nil {
+		return a, errors.Wrap(err, "unable to add attachment")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": a.ID,
+	}).Info("Attachment inserted successfully")
+
+	return a, nil
+}
+
+func (d *Manager) GetAttachment(ctx context.Context, id int32) (Attachment, error) {
+	a := Attachment{}
+
+	err := scanAttachment(d.conn.QueryRow(ctx, "SELECT id, tasks_feed_id, household_id, uploader_id, content_type, size, blob_key, thumbnail_key, created_at FROM attachments WHERE id=$1", id), &a)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return a, &ErrNotFound{message: "record not found"}
+		}
+		return a, errors.Wrap(err, "unable to get attachment")
+	}
+
+	return a, nil
+}
+
+func (d *Manager) ListAttachments(ctx context.Context, taskFeedID int32) ([]Attachment, error) {
+	attachments := make([]Attachment, 0)
+
+	rows, err := d.read(ctx).Query(ctx, "SELECT id, tasks_feed_id, household_id, uploader_id, content_type, size, blob_key, thumbnail_key, created_at FROM attachments WHERE tasks_feed_id=$1 ORDER BY created_at", taskFeedID)
+	if err != nil {
+		return attachments, errors.Wrap(err, "unable to get attachments")
+	}
+
+	rowCount := 0
+	for rows.Next() {
+		a := Attachment{}
+
+		if err := scanAttachment(rows, &a); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		attachments = append(attachments, a)
+
+		rowCount++
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	logrus.WithFields(logrus.Fields{"rowCount": rowCount}).Info("Attachments queried successfully")
+
+	return attachments, nil
+}
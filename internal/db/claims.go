@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ClaimTask assigns an open task to the child claiming it and adds it to their
+// feed, including any first-come bonus. The claim is a conditional update so
+// that when several children claim the same task at once only the first
+// succeeds; the others receive an ErrFailedPrecondition.
+func (d *Manager) ClaimTask(ctx context.Context, taskID int32, userID int32) (Task, error) {
+	t := Task{}
+
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		err := scanTask(tx.QueryRow(
+			ctx,
+			`UPDATE tasks SET claimed_by_id=$2, claim_expires_at=CASE WHEN claim_duration_seconds > 0 THEN now() + make_interval(secs => claim_duration_seconds) END
+			WHERE id=$1 AND is_open AND claimed_by_id IS NULL
+			AND household_id = (SELECT household_id FROM users WHERE id=$2 AND is_active AND NOT is_parent)
+			RETURNING `+taskColumns,
+			taskID, userID,
+		), &t)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return &ErrFailedPrecondition{message: "task is not open or has already been claimed"}
+			}
+			return errors.Wrap(err, "unable to claim task")
+		}
+
+		// The bonus is only paid for claims that are completed, as the feed
+		// entry of an expired claim is removed when the task is released
+		points := t.Points + t.ClaimBonusPoints
+
+		// The claim remembers its feed entry, so that releasing it only
+		// removes this entry and not those of earlier claims
+		_, err = tx.Exec(
+			ctx,
+			`WITH entry AS (
+				INSERT INTO tasks_feed(assignee_id, task_id, is_complete, is_approved, points, due_at)
+				VALUES($1, $2, false, false, $3, CASE WHEN $4 > 0 THEN now() + make_interval(secs => $4) END)
+				RETURNING id
+			)
+			UPDATE tasks SET claim_feed_id = entry.id FROM entry WHERE tasks.id=$2`,
+			userID, t.ID, points, int32(t.DueIn/time.Second),
+		)
+		if err != nil {
+			return errors.Wrap(err, "unable to add task feed")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Task{}, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":     t.ID,
+		"userID": userID,
+	}).Info("Task claimed successfully")
+
+	return t, nil
+}
+
+// SetTaskClaims opens a task in a household for any child to claim, or closes
+// it again. Closing a task drops any claim on it. Tasks with an assignee
+// can't be opened.
+func (d *Manager) SetTaskClaims(ctx context.Context, taskID int32, householdID int32, open bool, claimDuration time.Duration, claimBonusPoints int32) (Task, error) {
+	t := Task{}
+
+	err := scanTask(d.conn.QueryRow(
+		ctx,
+		`UPDATE tasks SET is_open=$3, claim_duration_seconds=$4, claim_bonus_points=$5,
+		claimed_by_id=CASE WHEN $3 THEN claimed_by_id END, claim_feed_id=CASE WHEN $3 THEN claim_feed_id END,
+		claim_expires_at=CASE WHEN $3 THEN claim_expires_at END
+		WHERE id=$1 AND household_id=$2 AND (NOT $3 OR assignee_id IS NULL)
+		RETURNING `+taskColumns,
+		taskID, householdID, open, int32(claimDuration/time.Second), claimBonusPoints,
+	), &t)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return t, &ErrFailedPrecondition{message: "task not found or has an assignee"}
+		}
+		return t, errors.Wrap(err, "unable to update task")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":     t.ID,
+		"isOpen": t.IsOpen,
+	}).Info("Task claims updated successfully")
+
+	return t, nil
+}
+
+// ReleaseExpiredClaims reopens claimed tasks whose feed entry was not
+// completed before the claim expired, removing that entry from the
+// claimant's feed. Entries which were missed are kept, as their penalty
+// refers to them. Only the current claim's entry is looked at, so entries
+// from earlier claims of a repeatable task don't affect it.
+// It is a single statement, with the expired tasks locked, so that a task
+// can't be claimed again between the claim being released and its feed entry
+// being removed.
+func (d *Manager) ReleaseExpiredClaims(ctx context.Context) (int64, error) {
+	var released int64
+
+	err := d.conn.QueryRow(
+		ctx,
+		`WITH expired AS (
+			SELECT t.id, t.claim_feed_id FROM tasks t
+			LEFT JOIN tasks_feed tf ON tf.id = t.claim_feed_id
+			WHERE t.is_open AND t.claim_expires_at < now() AND NOT COALESCE(tf.is_complete, false)
+			FOR UPDATE OF t
+		), released AS (
+			UPDATE tasks t SET claimed_by_id = NULL, claim_feed_id = NULL, claim_expires_at = NULL
+			FROM expired e WHERE t.id = e.id
+			RETURNING e.id, e.claim_feed_id
+		), removed AS (
+			DELETE FROM tasks_feed tf USING released r
+			WHERE tf.id = r.claim_feed_id AND NOT tf.is_complete AND NOT tf.is_missed
+		)
+		SELECT count(*) FROM released`,
+	).Scan(&released)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to release claims")
+	}
+
+	if released > 0 {
+		logrus.WithFields(logrus.Fields{"rowCount": released}).Info("Expired task claims released")
+	}
+
+	return released, nil
+}
@@ -0,0 +1,377 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Comment is a message left on a task or on a feed entry. Replies reference
+// the comment they respond to through ParentID.
+type Comment struct {
+	ID          int32
+	HouseholdID int32
+	// Exactly one of TaskID and TaskFeedID is set
+	TaskID     int32
+	TaskFeedID int32
+	ParentID   int32
+	AuthorID   int32
+	Body       string
+	CreatedAt  time.Time
+	EditedAt   *time.Time
+	// DeletedAt is set when a comment is deleted. The comment is kept, with
+	// an empty body, so that replies to it remain threaded.
+	DeletedAt *time.Time
+}
+
+// CommentRevision is a previous body of an edited or deleted comment
+type CommentRevision struct {
+	ID        int32
+	CommentID int32
+	Body      string
+	CreatedAt time.Time
+}
+
+// ReactionCount is the number of users who reacted to a comment with an emoji
+type ReactionCount struct {
+	CommentID int32
+	Emoji     string
+	Count     int32
+}
+
+// Mention records that a user was mentioned in a comment
+type Mention struct {
+	CommentID int32
+	UserID    int32
+	CreatedAt time.Time
+}
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@(\w+)`)
+
+// Mentions returns the unique usernames mentioned with @username in a comment
+func Mentions(body string) []string {
+	usernames := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			usernames = append(usernames, m[1])
+		}
+	}
+
+	return usernames
+}
+
+const commentColumns = "id, household_id, COALESCE(task_id, 0), COALESCE(tasks_feed_id, 0), COALESCE(parent_id, 0), author_id, body, created_at, edited_at, deleted_at"
+
+func scanComment(row pgx.Row, c *Comment) error {
+	return row.Scan(&c.ID, &c.HouseholdID, &c.TaskID, &c.TaskFeedID, &c.ParentID, &c.AuthorID, &c.Body, &c.CreatedAt, &c.EditedAt, &c.DeletedAt)
+}
+
+// CreateComment adds a comment and records a mention for every user in the
+// household mentioned in its body
+func (d *Manager) CreateComment(ctx context.Context, comment Comment) (Comment, error) {
+	c := Comment{}
+
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		err := scanComment(tx.QueryRow(
+			ctx,
+			"INSERT INTO comments(household_id, task_id, tasks_feed_id, parent_id, author_id, body) VALUES($1, NULLIF($2, 0), NULLIF($3, 0), NULLIF($4, 0), $5, $6) RETURNING "+commentColumns,
+			comment.HouseholdID, comment.TaskID, comment.TaskFeedID, comment.ParentID, comment.AuthorID, comment.Body,
+		), &c)
+		if err != nil {
+			return errors.Wrap(err, "unable to add comment")
+		}
+
+		return updateMentions(ctx, tx, c)
+	})
+	if err != nil {
+		return Comment{}, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": c.ID,
+	}).Info("Comment inserted successfully")
+
+	return c, nil
+}
+
+// updateMentions records a mention for every user in the household mentioned
+// in the body of a comment, and removes those no longer mentioned. Only users
+// who weren't mentioned before are notified, so that editing a comment doesn't
+// notify everyone again.
+func updateMentions(ctx context.Context, tx pgx.Tx, c Comment) error {
+	usernames := Mentions(c.Body)
+
+	_, err := tx.Exec(
+		ctx,
+		"DELETE FROM comment_mentions cm USING users u WHERE cm.comment_id=$1 AND u.id = cm.user_id AND NOT u.username = ANY($2)",
+		c.ID, usernames,
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to remove mentions")
+	}
+
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	rows, err := tx.Query(
+		ctx,
+		`INSERT INTO comment_mentions(comment_id, user_id) SELECT $1, u.id FROM users u
+		WHERE u.household_id=$2 AND u.username = ANY($3) AND u.id <> $4
+		AND NOT EXISTS (SELECT 1 FROM comment_mentions cm WHERE cm.comment_id=$1 AND cm.user_id = u.id)
+		RETURNING user_id`,
+		c.ID, c.HouseholdID, usernames, c.AuthorID,
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to add mentions")
+	}
+
+	mentioned := make([]int32, 0, len(usernames))
+	for rows.Next() {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "unable to scan row")
+		}
+		mentioned = append(mentioned, id)
+	}
+	rows.Close()
+
+	if rows.Err() != nil {
+		return errors.Wrap(rows.Err(), "unable to add mentions")
+	}
+
+	if len(mentioned) == 0 {
+		return nil
+	}
+
+	var author string
+	if err := tx.QueryRow(ctx, "SELECT username FROM users WHERE id=$1", c.AuthorID).Scan(&author); err != nil {
+		return errors.Wrap(err, "unable to get author")
+	}
+
+	return notifyUsers(ctx, tx, mentioned, EventCommentMentioned, fmt.Sprintf("%s mentioned you", author), c.Body)
+}
+
+func (d *Manager) GetComment(ctx context.Context, id int32) (Comment, error) {
+	c := Comment{}
+
+	err := scanComment(d.conn.QueryRow(ctx, "SELECT "+commentColumns+" FROM comments WHERE id=$1", id), &c)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c, &ErrNotFound{message: "record not found"}
+		}
+		return c, errors.Wrap(err, "unable to get comment")
+	}
+
+	return c, nil
+}
+
+// ListComments returns up to limit comments on a task or feed entry, oldest
+// first, starting after the comment with ID afterID
+func (d *Manager) ListComments(ctx context.Context, taskID int32, taskFeedID int32, afterID int32, limit int) ([]Comment, error) {
+	comments := make([]Comment, 0)
+
+	rows, err := d.read(ctx).Query(
+		ctx,
+		"SELECT "+commentColumns+" FROM comments WHERE task_id IS NOT DISTINCT FROM NULLIF($1, 0) AND tasks_feed_id IS NOT DISTINCT FROM NULLIF($2, 0) AND id > $3 ORDER BY id LIMIT $4",
+		taskID, taskFeedID, afterID, limit,
+	)
+	if err != nil {
+		return comments, errors.Wrap(err, "unable to get comments")
+	}
+
+	rowCount := 0
+	for rows.Next() {
+		c := Comment{}
+
+		if err := scanComment(rows, &c); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		comments = append(comments, c)
+
+		rowCount++
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	logrus.WithFields(logrus.Fields{"rowCount": rowCount}).Info("Comments queried successfully")
+
+	return comments, nil
+}
+
+// UpdateComment replaces the body of a comment, keeping the previous body as a
+// revision, and updates who it mentions. An empty body deletes the comment.
+func (d *Manager) UpdateComment(ctx context.Context, id int32, body string) (Comment, error) {
+	c := Comment{}
+
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(
+			ctx,
+			"INSERT INTO comment_revisions(comment_id, body, created_at) SELECT id, body, COALESCE(edited_at, created_at) FROM comments WHERE id=$1 AND deleted_at IS NULL",
+			id,
+		)
+		if err != nil {
+			return errors.Wrap(err, "unable to add comment revision")
+		}
+
+		err = scanComment(tx.QueryRow(
+			ctx,
+			`UPDATE comments SET body=$1, edited_at=now(), deleted_at=CASE WHEN $1 = '' THEN now() END
+			WHERE id=$2 AND deleted_at IS NULL RETURNING `+commentColumns,
+			body, id,
+		), &c)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return &ErrNotFound{message: "record not found"}
+			}
+			return errors.Wrap(err, "unable to update comment")
+		}
+
+		return updateMentions(ctx, tx, c)
+	})
+	if err != nil {
+		return Comment{}, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": c.ID,
+	}).Info("Comment updated successfully")
+
+	return c, nil
+}
+
+func (d *Manager) ListCommentRevisions(ctx context.Context, commentID int32) ([]CommentRevision, error) {
+	revisions := make([]CommentRevision, 0)
+
+	rows, err := d.read(ctx).Query(ctx, "SELECT id, comment_id, body, created_at FROM comment_revisions WHERE comment_id=$1 ORDER BY id", commentID)
+	if err != nil {
+		return revisions, errors.Wrap(err, "unable to get comment revisions")
+	}
+
+	rowCount := 0
+	for rows.Next() {
+		r := CommentRevision{}
+
+		if err := rows.Scan(&r.ID, &r.CommentID, &r.Body, &r.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		revisions = append(revisions, r)
+
+		rowCount++
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	logrus.WithFields(logrus.Fields{"rowCount": rowCount}).Info("Comment revisions queried successfully")
+
+	return revisions, nil
+}
+
+// AddReaction reacts to a comment with an emoji. Reacting twice with the same
+// emoji has no further effect.
+func (d *Manager) AddReaction(ctx context.Context, commentID int32, userID int32, emoji string) error {
+	_, err := d.conn.Exec(
+		ctx,
+		"INSERT INTO comment_reactions(comment_id, user_id, emoji) VALUES($1, $2, $3) ON CONFLICT DO NOTHING",
+		commentID, userID, emoji,
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to add reaction")
+	}
+
+	return nil
+}
+
+func (d *Manager) RemoveReaction(ctx context.Context, commentID int32, userID int32, emoji string) error {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM comment_reactions WHERE comment_id=$1 AND user_id=$2 AND emoji=$3", commentID, userID, emoji)
+	if err != nil {
+		return errors.Wrap(err, "unable to remove reaction")
+	}
+
+	if tag.RowsAffected() != 1 {
+		return &ErrNotFound{message: "record not found"}
+	}
+
+	return nil
+}
+
+// ListReactions returns the reaction counts for a set of comments
+func (d *Manager) ListReactions(ctx context.Context, commentIDs []int32) ([]ReactionCount, error) {
+	reactions := make([]ReactionCount, 0)
+
+	rows, err := d.read(ctx).Query(
+		ctx,
+		"SELECT comment_id, emoji, count(*) FROM comment_reactions WHERE comment_id = ANY($1) GROUP BY comment_id, emoji ORDER BY comment_id, min(created_at)",
+		commentIDs,
+	)
+	if err != nil {
+		return reactions, errors.Wrap(err, "unable to get reactions")
+	}
+
+	rowCount := 0
+	for rows.Next() {
+		r := ReactionCount{}
+
+		if err := rows.Scan(&r.CommentID, &r.Emoji, &r.Count); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		reactions = append(reactions, r)
+
+		rowCount++
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	logrus.WithFields(logrus.Fields{"rowCount": rowCount}).Info("Reactions queried successfully")
+
+	return reactions, nil
+}
+
+// ListMentions returns the most recent mentions of a user
+func (d *Manager) ListMentions(ctx context.Context, userID int32, limit int) ([]Mention, error) {
+	mentions := make([]Mention, 0)
+
+	rows, err := d.read(ctx).Query(ctx, "SELECT comment_id, user_id, created_at FROM comment_mentions WHERE user_id=$1 ORDER BY created_at DESC LIMIT $2", userID, limit)
+	if err != nil {
+		return mentions, errors.Wrap(err, "unable to get mentions")
+	}
+
+	rowCount := 0
+	for rows.Next() {
+		m := Mention{}
+
+		if err := rows.Scan(&m.CommentID, &m.UserID, &m.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		mentions = append(mentions, m)
+
+		rowCount++
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	logrus.WithFields(logrus.Fields{"rowCount": rowCount}).Info("Mentions queried successfully")
+
+	return mentions, nil
+}
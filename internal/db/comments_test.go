@@ -0,0 +1,26 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{name: "no mentions", body: "Looks great!", want: []string{}},
+		{name: "single mention", body: "@sam you missed a spot", want: []string{"sam"}},
+		{name: "duplicate mentions", body: "@sam and @alex, thanks @sam", want: []string{"sam", "alex"}},
+		{name: "email addresses", body: "email dad@example.com", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Mentions(tt.body))
+		})
+	}
+}
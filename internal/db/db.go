@@ -3,8 +3,14 @@ package db
 import (
 	"context"
 	"fmt"
-
-	"github.com/golang/protobuf/ptypes/timestamp"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chorerewards/backend/internal/clock"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/pkg/errors"
@@ -12,19 +18,87 @@ import (
 )
 
 type Config struct {
+	// DSN is a postgres:// URL or key=value connection string. When set, it
+	// is used instead of the host, port, username, database and SSL settings
+	// below. Password still applies if the DSN doesn't include one.
+	DSN string
+
 	Host     string
 	Port     int
 	Username string
 	Password string
 	Database string
+
+	// SSLMode is one of the libpq modes: disable, allow, prefer, require,
+	// verify-ca or verify-full
+	SSLMode string
+	// SSLRootCert is the CA bundle the server certificate is verified against
+	// with verify-ca and verify-full
+	SSLRootCert string
+	// SSLCert and SSLKey are the client certificate, if the server asks for one
+	SSLCert string
+	SSLKey  string
+
+	// ApplicationName is shown in pg_stat_activity
+	ApplicationName string
+	// StatementTimeout cancels statements running for longer. Zero uses the
+	// server's setting.
+	StatementTimeout time.Duration
+	// ConnectTimeout limits each attempt to connect
+	ConnectTimeout time.Duration
+
+	MinConns          int32
+	MaxConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+
+	// StartupTimeout is how long New keeps retrying while the database is
+	// unavailable, so that the server can start alongside it
+	StartupTimeout time.Duration
+
+	// Replica is an optional read replica which list queries are sent to.
+	// Settings it leaves empty are taken from the primary, apart from the DSN.
+	Replica *Config
+	// ReadYourWritesWindow is how long a caller's reads go to the primary
+	// after they write, so that replication lag doesn't hide their changes
+	ReadYourWritesWindow time.Duration
+	// CallerKey identifies the caller for read-your-writes, such as by user.
+	// Callers with an empty key are never pinned to the primary.
+	CallerKey func(ctx context.Context) string
 }
 
 type Manager struct {
-	pool *pgxpool.Pool
+	// conn runs the statements. It's the primary pool, or the transaction for
+	// a Manager passed to a WithTx callback.
+	conn conn
+	// inTx is set when conn is a transaction
+	inTx bool
+	pool *primaryPool
+	// replica is nil when there is no read replica, and inside a transaction
+	replica *replicaPool
+	clock   clock.Clock
+}
+
+// querier is satisfied by both the pool and transactions, so helpers can run
+// either inside or outside of a transaction
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// conn is satisfied by both the pool and transactions. Within a transaction,
+// BeginFunc starts a savepoint, so methods which need a transaction of their
+// own can be used inside a larger one.
+type conn interface {
+	querier
+	BeginFunc(ctx context.Context, f func(pgx.Tx) error) error
 }
 
 type Category struct {
 	ID          int32
+	HouseholdID int32
 	Color       string
 	Name        string
 	Description string
@@ -34,10 +108,30 @@ type Task struct {
 	ID           int32
 	CategoryID   int32
 	AssigneeID   int32
+	HouseholdID  int32
 	Name         string
 	Description  string
 	Points       int32
 	IsRepeatable bool
+	// IsOpen tasks have no assignee and can be claimed by any child in the household
+	IsOpen bool
+	// ClaimDuration is how long a claimant has to complete an open task before
+	// the claim expires and the task is open to others again. Zero means the
+	// claim never expires.
+	ClaimDuration time.Duration
+	// ClaimBonusPoints are awarded on top of Points to whoever claims the task first
+	ClaimBonusPoints int32
+	ClaimedByID      int32
+	ClaimExpiresAt   *time.Time
+	// DueIn is how long after a feed entry of this task is added it is due.
+	// Zero means entries have no deadline.
+	DueIn time.Duration
+	// LatePenaltyPercent is the percentage of points lost when a feed entry
+	// is not completed by its deadline
+	LatePenaltyPercent int32
+	// MissedPenaltyPoints are deducted from the assignee when a feed entry is
+	// never completed, see Manager.MarkOverdue
+	MissedPenaltyPoints int32
 }
 
 type TaskFeed struct {
@@ -46,21 +140,34 @@ type TaskFeed struct {
 	TaskID      int32
 	IsComplete  bool
 	IsApproved  bool
-	CompletedAt timestamp.Timestamp
+	CompletedAt *time.Time
 	Points      int32
+	DueAt       *time.Time
+	// IsOverdue is set by the background overdue job once DueAt has passed
+	// without the task being completed
+	IsOverdue bool
+	// IsMissed is set once an overdue entry has gone uncompleted for so long
+	// that it can no longer be completed
+	IsMissed bool
 }
 
 type User struct {
 	ID       int32
 	Username string
 	Email    string
-	IsAdmin  bool
-	IsParent bool
-	Avatar   string
-	Points   int32
-	Password string
-	Pin      string
-	IsActive bool
+	// EmailVerified is set once the user has followed a verification link
+	// sent to Email
+	EmailVerified bool
+	HouseholdID   int32
+	IsAdmin       bool
+	IsParent      bool
+	Avatar        string
+	Points        int32
+	// ReservedPoints is the portion of Points allocated to savings goals
+	ReservedPoints int32
+	Password       string
+	Pin            string
+	IsActive       bool
 }
 
 var _ error = (*ErrNotFound)(nil) // ensure CustomError implements error
@@ -73,45 +180,299 @@ func (c *ErrNotFound) Error() string {
 	return c.message
 }
 
-func New(c Config) (*Manager, error) {
+var _ error = (*ErrFailedPrecondition)(nil)
+
+// ErrFailedPrecondition is returned when an operation is rejected because
+// the current state of a record does not allow it
+type ErrFailedPrecondition struct {
+	message string
+}
+
+func (c *ErrFailedPrecondition) Error() string {
+	return c.message
+}
+
+const (
+	// initialBackoff and maxBackoff bound the wait between attempts to connect
+	// at startup
+	initialBackoff = time.Millisecond * 500
+	maxBackoff     = time.Second * 10
+)
+
+// connString returns the libpq connection string for the config, escaping
+// each part so that passwords and names can contain any character
+func (c Config) connString() (string, error) {
+	if c.DSN != "" {
+		return c.DSN, nil
+	}
+
 	if c.Host == "" {
-		return nil, errors.New("host not defined")
+		return "", errors.New("host not defined")
 	}
 
 	if c.Port == 0 {
-		return nil, errors.New("port not defined")
+		return "", errors.New("port not defined")
 	}
 
 	if c.Username == "" {
-		return nil, errors.New("user not defined")
+		return "", errors.New("user not defined")
 	}
 
 	if c.Password == "" {
-		return nil, errors.New("password not defined")
+		return "", errors.New("password not defined")
 	}
 
 	if c.Database == "" {
-		return nil, errors.New("database not defined")
+		return "", errors.New("database not defined")
+	}
+
+	q := url.Values{}
+	for param, value := range map[string]string{
+		"sslmode":     c.SSLMode,
+		"sslrootcert": c.SSLRootCert,
+		"sslcert":     c.SSLCert,
+		"sslkey":      c.SSLKey,
+	} {
+		if value != "" {
+			q.Set(param, value)
+		}
 	}
 
-	dbURL := fmt.Sprintf("postgres://%s:%s@%s:%d/%s", c.Username, c.Password, c.Host, c.Port, c.Database)
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.Username, c.Password),
+		Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:     "/" + c.Database,
+		RawQuery: q.Encode(),
+	}
 
-	pool, err := pgxpool.Connect(context.Background(), dbURL)
+	return u.String(), nil
+}
+
+// poolConfig parses the connection settings and applies the pool settings
+func (c Config) poolConfig() (*pgxpool.Config, error) {
+	connString, err := c.connString()
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		// The error can include the connection string, and so the password
+		return nil, errors.New("invalid database connection settings")
+	}
+
+	if config.ConnConfig.Password == "" {
+		config.ConnConfig.Password = c.Password
+	}
+
+	if c.ApplicationName != "" {
+		config.ConnConfig.RuntimeParams["application_name"] = c.ApplicationName
+	}
+
+	if c.StatementTimeout > 0 {
+		config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+	}
+
+	if c.ConnectTimeout > 0 {
+		config.ConnConfig.ConnectTimeout = c.ConnectTimeout
+	}
+
+	// Zero values keep the pgxpool defaults
+	if c.MaxConns > 0 {
+		config.MaxConns = c.MaxConns
+	}
+
+	if c.MinConns > 0 {
+		config.MinConns = c.MinConns
+	}
+
+	if c.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = c.MaxConnLifetime
+	}
+
+	if c.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = c.MaxConnIdleTime
+	}
+
+	if c.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = c.HealthCheckPeriod
+	}
+
+	if config.MinConns > config.MaxConns {
+		return nil, errors.Errorf("min conns %d is more than max conns %d", config.MinConns, config.MaxConns)
+	}
+
+	return config, nil
+}
+
+// replicaConfig returns the settings for the read replica, taking any it
+// leaves empty from the primary
+func (c Config) replicaConfig() Config {
+	r := *c.Replica
+
+	if r.DSN == "" {
+		if r.Port == 0 {
+			r.Port = c.Port
+		}
+		if r.Username == "" {
+			r.Username = c.Username
+		}
+		if r.Database == "" {
+			r.Database = c.Database
+		}
+		if r.SSLMode == "" {
+			r.SSLMode = c.SSLMode
+		}
+		if r.SSLRootCert == "" {
+			r.SSLRootCert = c.SSLRootCert
+		}
+		if r.SSLCert == "" && r.SSLKey == "" {
+			r.SSLCert, r.SSLKey = c.SSLCert, c.SSLKey
+		}
+	}
+
+	if r.Password == "" {
+		r.Password = c.Password
+	}
+	if r.ApplicationName == "" {
+		r.ApplicationName = c.ApplicationName
+	}
+	if r.StatementTimeout == 0 {
+		r.StatementTimeout = c.StatementTimeout
+	}
+	if r.ConnectTimeout == 0 {
+		r.ConnectTimeout = c.ConnectTimeout
+	}
+	if r.MinConns == 0 {
+		r.MinConns = c.MinConns
+	}
+	if r.MaxConns == 0 {
+		r.MaxConns = c.MaxConns
+	}
+	if r.MaxConnLifetime == 0 {
+		r.MaxConnLifetime = c.MaxConnLifetime
+	}
+	if r.MaxConnIdleTime == 0 {
+		r.MaxConnIdleTime = c.MaxConnIdleTime
+	}
+	if r.HealthCheckPeriod == 0 {
+		r.HealthCheckPeriod = c.HealthCheckPeriod
+	}
+
+	return r
+}
+
+// retryable reports whether connecting may succeed if tried again. Bad
+// credentials or a missing database won't fix themselves.
+func retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Classes 28, invalid authorization, and 3D, invalid catalog name
+		return !strings.HasPrefix(pgErr.Code, "28") && !strings.HasPrefix(pgErr.Code, "3D")
+	}
+
+	return true
+}
+
+// retry calls connect until it succeeds, it fails with an error which isn't
+// retryable, or ctx is done, waiting longer between each attempt
+func retry(ctx context.Context, connect func(ctx context.Context) error) error {
+	backoff := initialBackoff
+
+	for {
+		err := connect(ctx)
+		if err == nil || !retryable(err) {
+			return err
+		}
+
+		logrus.WithError(err).WithField("retryIn", backoff.String()).Warn("Unable to connect to the database")
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func New(c Config) (*Manager, error) {
+	config, err := c.poolConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if c.StartupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.StartupTimeout)
+		defer cancel()
+	}
+
+	var pool *pgxpool.Pool
+
+	err = retry(ctx, func(ctx context.Context) error {
+		pool, err = pgxpool.ConnectConfig(ctx, config)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error creating connection pool: %w", err)
 	}
 
-	return &Manager{pool: pool}, nil
+	logrus.WithFields(logrus.Fields{
+		"host":     config.ConnConfig.Host,
+		"port":     config.ConnConfig.Port,
+		"database": config.ConnConfig.Database,
+		"user":     config.ConnConfig.User,
+		"tls":      config.ConnConfig.TLSConfig != nil,
+		"maxConns": config.MaxConns,
+	}).Info("Connected to database")
+
+	primary := &primaryPool{Pool: pool}
+	m := &Manager{conn: primary, pool: primary, clock: clock.Real{}}
+
+	if c.Replica != nil {
+		replicaConfig, err := c.replicaConfig().poolConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid read replica settings")
+		}
+
+		// Reads fall back to the primary, so the server can start without the
+		// replica
+		replicaConfig.LazyConnect = true
+
+		replica, err := pgxpool.ConnectConfig(context.Background(), replicaConfig)
+		if err != nil {
+			return nil, fmt.Errorf("error creating read replica connection pool: %w", err)
+		}
+
+		m.pool.pins = newPins(c.ReadYourWritesWindow, c.CallerKey)
+		m.replica = &replicaPool{replica: replica, primary: m.pool, clock: clock.Real{}}
+
+		logrus.WithFields(logrus.Fields{
+			"host":     replicaConfig.ConnConfig.Host,
+			"port":     replicaConfig.ConnConfig.Port,
+			"database": replicaConfig.ConnConfig.Database,
+		}).Info("Using read replica")
+	}
+
+	return m, nil
 }
 
+const categoryColumns = "id, household_id, color, name, description"
+
 func (d *Manager) CreateCategory(ctx context.Context, category Category) (Category, error) {
 	c := Category{}
 
-	err := d.pool.QueryRow(
+	err := d.conn.QueryRow(
 		ctx,
-		"INSERT INTO categories(color, name, description) VALUES($1, $2, $3) RETURNING id, color, name, description",
-		category.Color, category.Name, category.Description,
-	).Scan(&c.ID, &c.Color, &c.Name, &c.Description)
+		"INSERT INTO categories(household_id, color, name, description) VALUES($1, $2, $3, $4) RETURNING "+categoryColumns,
+		category.HouseholdID, category.Color, category.Name, category.Description,
+	).Scan(&c.ID, &c.HouseholdID, &c.Color, &c.Name, &c.Description)
 	if err != nil {
 		return c, errors.Wrap(err, "unable to add category")
 	}
@@ -126,8 +487,8 @@ func (d *Manager) CreateCategory(ctx context.Context, category Category) (Catego
 func (d *Manager) GetCategory(ctx context.Context, name string) (Category, error) {
 	c := Category{}
 
-	err := d.pool.QueryRow(ctx, "SELECT id, color, name, description FROM categories WHERE name=$1", name).
-		Scan(&c.ID, &c.Color, &c.Name, &c.Description)
+	err := d.conn.QueryRow(ctx, "SELECT "+categoryColumns+" FROM categories WHERE name=$1", name).
+		Scan(&c.ID, &c.HouseholdID, &c.Color, &c.Name, &c.Description)
 	if err != nil {
 		return c, errors.Wrap(err, "unable to get category")
 	}
@@ -135,10 +496,26 @@ func (d *Manager) GetCategory(ctx context.Context, name string) (Category, error
 	return c, nil
 }
 
-func (d *Manager) ListCategories(ctx context.Context) ([]Category, error) {
+func (d *Manager) GetCategoryByID(ctx context.Context, id int32) (Category, error) {
+	c := Category{}
+
+	err := d.conn.QueryRow(ctx, "SELECT "+categoryColumns+" FROM categories WHERE id=$1", id).
+		Scan(&c.ID, &c.HouseholdID, &c.Color, &c.Name, &c.Description)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c, &ErrNotFound{message: "record not found"}
+		}
+		return c, errors.Wrap(err, "unable to get category")
+	}
+
+	return c, nil
+}
+
+// ListCategories lists the categories of a household
+func (d *Manager) ListCategories(ctx context.Context, householdID int32) ([]Category, error) {
 	categories := make([]Category, 0)
 
-	rows, err := d.pool.Query(ctx, "SELECT id, color, name, description FROM categories")
+	rows, err := d.read(ctx).Query(ctx, "SELECT "+categoryColumns+" FROM categories WHERE household_id=$1", householdID)
 	if err != nil {
 		return categories, errors.Wrap(err, "unable to get users")
 	}
@@ -147,7 +524,7 @@ func (d *Manager) ListCategories(ctx context.Context) ([]Category, error) {
 	for rows.Next() {
 		c := Category{}
 
-		if err := rows.Scan(&c.ID, &c.Color, &c.Name, &c.Description); err != nil {
+		if err := rows.Scan(&c.ID, &c.HouseholdID, &c.Color, &c.Name, &c.Description); err != nil {
 			return nil, errors.Wrap(err, "unable to scan row")
 		}
 
@@ -165,16 +542,50 @@ func (d *Manager) ListCategories(ctx context.Context) ([]Category, error) {
 	return categories, nil
 }
 
+const taskColumns = "id, category_id, COALESCE(assignee_id, 0), household_id, name, description, points, is_repeatable, is_open, claim_duration_seconds, claim_bonus_points, COALESCE(claimed_by_id, 0), claim_expires_at, due_in_seconds, late_penalty_percent, missed_penalty_points"
+
+func scanTask(row pgx.Row, t *Task) error {
+	var claimDurationSeconds, dueInSeconds int32
+
+	if err := row.Scan(
+		&t.ID, &t.CategoryID, &t.AssigneeID, &t.HouseholdID, &t.Name, &t.Description, &t.Points, &t.IsRepeatable,
+		&t.IsOpen, &claimDurationSeconds, &t.ClaimBonusPoints, &t.ClaimedByID, &t.ClaimExpiresAt,
+		&dueInSeconds, &t.LatePenaltyPercent, &t.MissedPenaltyPoints,
+	); err != nil {
+		return err
+	}
+
+	t.ClaimDuration = time.Duration(claimDurationSeconds) * time.Second
+	t.DueIn = time.Duration(dueInSeconds) * time.Second
+
+	return nil
+}
+
 func (d *Manager) CreateTask(ctx context.Context, task Task) (Task, error) {
 	t := Task{}
 
-	err := d.pool.QueryRow(
-		ctx,
-		"INSERT INTO tasks(category_id, assignee_id, name, description, points, is_repeatable) VALUES($1, $2, $3, $4, $5, $6) RETURNING id, category_id, assignee_id, name, description, points, is_repeatable",
-		task.CategoryID, task.AssigneeID, task.Name, task.Description, task.Points, task.IsRepeatable,
-	).Scan(&t.ID, &t.CategoryID, &t.AssigneeID, &t.Name, &t.Description, &t.Points, &t.IsRepeatable)
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		err := scanTask(tx.QueryRow(
+			ctx,
+			"INSERT INTO tasks(category_id, assignee_id, household_id, name, description, points, is_repeatable, is_open, claim_duration_seconds, claim_bonus_points, due_in_seconds, late_penalty_percent, missed_penalty_points) VALUES($1, NULLIF($2, 0), $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING "+taskColumns,
+			task.CategoryID, task.AssigneeID, task.HouseholdID, task.Name, task.Description, task.Points, task.IsRepeatable,
+			task.IsOpen, int32(task.ClaimDuration/time.Second), task.ClaimBonusPoints, int32(task.DueIn/time.Second), task.LatePenaltyPercent, task.MissedPenaltyPoints,
+		), &t)
+		if err != nil {
+			return errors.Wrap(err, "unable to add task")
+		}
+
+		return emitWebhook(ctx, tx, t.HouseholdID, EventTaskCreated, taskCreated{
+			ID:          t.ID,
+			Name:        t.Name,
+			Description: t.Description,
+			Points:      t.Points,
+			AssigneeID:  t.AssigneeID,
+			IsOpen:      t.IsOpen,
+		})
+	})
 	if err != nil {
-		return t, errors.Wrap(err, "unable to add task")
+		return Task{}, err
 	}
 
 	logrus.WithFields(logrus.Fields{
@@ -187,19 +598,46 @@ func (d *Manager) CreateTask(ctx context.Context, task Task) (Task, error) {
 func (d *Manager) GetTask(ctx context.Context, name string) (Task, error) {
 	t := Task{}
 
-	err := d.pool.QueryRow(ctx, "SELECT category_id, assignee_id, name, description, points, is_repeatable FROM tasks WHERE name=$1", name).
-		Scan(&t.ID, &t.CategoryID, &t.AssigneeID, &t.Name, &t.Description, &t.Points, &t.IsRepeatable)
+	err := scanTask(d.conn.QueryRow(ctx, "SELECT "+taskColumns+" FROM tasks WHERE name=$1", name), &t)
+	if err != nil {
+		return t, errors.Wrap(err, "unable to get task")
+	}
+
+	return t, nil
+}
+
+func (d *Manager) GetTaskByID(ctx context.Context, id int32) (Task, error) {
+	t := Task{}
+
+	err := scanTask(d.conn.QueryRow(ctx, "SELECT "+taskColumns+" FROM tasks WHERE id=$1", id), &t)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return t, &ErrNotFound{message: "record not found"}
+		}
 		return t, errors.Wrap(err, "unable to get task")
 	}
 
 	return t, nil
 }
 
-func (d *Manager) ListTasks(ctx context.Context) ([]Task, error) {
+// ListTasks lists the tasks of a household
+func (d *Manager) ListTasks(ctx context.Context, householdID int32) ([]Task, error) {
+	return d.listTasks(ctx, "SELECT "+taskColumns+" FROM tasks WHERE household_id=$1", householdID)
+}
+
+// ListOpenTasks lists the open tasks of a household that are not currently claimed
+func (d *Manager) ListOpenTasks(ctx context.Context, householdID int32) ([]Task, error) {
+	return d.listTasks(
+		ctx,
+		"SELECT "+taskColumns+" FROM tasks WHERE household_id=$1 AND is_open AND claimed_by_id IS NULL",
+		householdID,
+	)
+}
+
+func (d *Manager) listTasks(ctx context.Context, query string, args ...interface{}) ([]Task, error) {
 	tasks := make([]Task, 0)
 
-	rows, err := d.pool.Query(ctx, "SELECT category_id, assignee_id, name, description, points, is_repeatable FROM tasks ")
+	rows, err := d.read(ctx).Query(ctx, query, args...)
 	if err != nil {
 		return tasks, errors.Wrap(err, "unable to get tasks")
 	}
@@ -208,7 +646,7 @@ func (d *Manager) ListTasks(ctx context.Context) ([]Task, error) {
 	for rows.Next() {
 		t := Task{}
 
-		if err := rows.Scan(&t.ID, &t.CategoryID, &t.AssigneeID, &t.Name, &t.Description, &t.Points, &t.IsRepeatable); err != nil {
+		if err := scanTask(rows, &t); err != nil {
 			return nil, errors.Wrap(err, "unable to scan row")
 		}
 
@@ -226,28 +664,22 @@ func (d *Manager) ListTasks(ctx context.Context) ([]Task, error) {
 	return tasks, nil
 }
 
-/*
+const taskFeedColumns = "id, assignee_id, task_id, is_complete, is_approved, completed_at, points, due_at, is_overdue, is_missed"
 
-type TaskFeed struct {
-	ID          int32
-	AssigneeID  int32
-	TaskID      int32
-	IsComplete  bool
-	IsApproved  bool
-	CompletedAt timestamp.Timestamp
-	Points      int32
+func scanTaskFeed(row pgx.Row, tf *TaskFeed) error {
+	return row.Scan(&tf.ID, &tf.AssigneeID, &tf.TaskID, &tf.IsComplete, &tf.IsApproved, &tf.CompletedAt, &tf.Points, &tf.DueAt, &tf.IsOverdue, &tf.IsMissed)
 }
 
-*/
-
+// CreateTaskFeed adds a task to the feed. If taskFeed.DueAt isn't set the
+// entry is due the task's DueIn from now.
 func (d *Manager) CreateTaskFeed(ctx context.Context, taskFeed TaskFeed) (TaskFeed, error) {
 	tf := TaskFeed{}
 
-	err := d.pool.QueryRow(
+	err := scanTaskFeed(d.conn.QueryRow(
 		ctx,
-		"INSERT INTO tasks_feed(assignee_id, task_id, is_complete, is_approved, completed_at, points) VALUES($1, $2, $3, $4, $5, $6) RETURNING id, assignee_id, task_id, is_complete, is_approved, completed_at, points",
-		taskFeed.AssigneeID, taskFeed.TaskID, taskFeed.IsComplete, taskFeed.IsApproved, taskFeed.CompletedAt, taskFeed.Points,
-	).Scan(&tf.ID, &tf.AssigneeID, &tf.TaskID, &tf.IsComplete, &tf.IsApproved, &tf.CompletedAt, &tf.Points)
+		"INSERT INTO tasks_feed(assignee_id, task_id, is_complete, is_approved, completed_at, points, due_at) VALUES($1, $2, $3, $4, $5, $6, COALESCE($7, (SELECT now() + make_interval(secs => due_in_seconds) FROM tasks WHERE id=$2 AND due_in_seconds > 0))) RETURNING "+taskFeedColumns,
+		taskFeed.AssigneeID, taskFeed.TaskID, taskFeed.IsComplete, taskFeed.IsApproved, taskFeed.CompletedAt, taskFeed.Points, taskFeed.DueAt,
+	), &tf)
 	if err != nil {
 		return tf, errors.Wrap(err, "unable to add task feed")
 	}
@@ -259,10 +691,33 @@ func (d *Manager) CreateTaskFeed(ctx context.Context, taskFeed TaskFeed) (TaskFe
 	return tf, nil
 }
 
-func (d *Manager) ListTasksFeed(ctx context.Context) ([]TaskFeed, error) {
+func (d *Manager) GetTaskFeed(ctx context.Context, id int32) (TaskFeed, error) {
+	tf := TaskFeed{}
+
+	err := scanTaskFeed(d.conn.QueryRow(ctx, "SELECT "+taskFeedColumns+" FROM tasks_feed WHERE id=$1", id), &tf)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return tf, &ErrNotFound{message: "record not found"}
+		}
+		return tf, errors.Wrap(err, "unable to get task feed")
+	}
+
+	return tf, nil
+}
+
+// ListTasksFeed lists the feed entries for the tasks of a household
+func (d *Manager) ListTasksFeed(ctx context.Context, householdID int32) ([]TaskFeed, error) {
+	return d.listTasksFeed(
+		ctx,
+		"SELECT "+taskFeedColumns+" FROM tasks_feed WHERE task_id IN (SELECT id FROM tasks WHERE household_id=$1)",
+		householdID,
+	)
+}
+
+func (d *Manager) listTasksFeed(ctx context.Context, query string, args ...interface{}) ([]TaskFeed, error) {
 	tasksFeed := make([]TaskFeed, 0)
 
-	rows, err := d.pool.Query(ctx, "SELECT id, assignee_id, task_id, is_complete, is_approved, completed_at, points FROM tasks_feed")
+	rows, err := d.read(ctx).Query(ctx, query, args...)
 	if err != nil {
 		return tasksFeed, errors.Wrap(err, "unable to get tasks feed")
 	}
@@ -271,7 +726,7 @@ func (d *Manager) ListTasksFeed(ctx context.Context) ([]TaskFeed, error) {
 	for rows.Next() {
 		tf := TaskFeed{}
 
-		if err := rows.Scan(&tf.ID, &tf.AssigneeID, &tf.TaskID, &tf.IsComplete, &tf.IsApproved, &tf.CompletedAt, &tf.Points); err != nil {
+		if err := scanTaskFeed(rows, &tf); err != nil {
 			return nil, errors.Wrap(err, "unable to scan row")
 		}
 
@@ -289,14 +744,142 @@ func (d *Manager) ListTasksFeed(ctx context.Context) ([]TaskFeed, error) {
 	return tasksFeed, nil
 }
 
+// CompleteTaskFeed marks a feed entry as completed by its assignee and lets
+// the parents of the household know it is waiting for approval
+func (d *Manager) CompleteTaskFeed(ctx context.Context, id int32, assigneeID int32) (TaskFeed, error) {
+	tf := TaskFeed{}
+
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		err := scanTaskFeed(tx.QueryRow(
+			ctx,
+			"UPDATE tasks_feed SET is_complete = true, completed_at = now() WHERE id=$1 AND assignee_id=$2 AND NOT is_complete AND NOT is_missed RETURNING "+taskFeedColumns,
+			id, assigneeID,
+		), &tf)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return &ErrFailedPrecondition{message: "task feed not found, already complete or missed"}
+			}
+			return errors.Wrap(err, "unable to complete task feed")
+		}
+
+		// Completing a claimed repeatable task reopens it for the next claim
+		_, err = tx.Exec(
+			ctx,
+			"UPDATE tasks SET claimed_by_id = NULL, claim_feed_id = NULL, claim_expires_at = NULL WHERE claim_feed_id=$1 AND is_open AND is_repeatable",
+			tf.ID,
+		)
+		if err != nil {
+			return errors.Wrap(err, "unable to reopen task")
+		}
+
+		var username, taskName string
+		var householdID int32
+
+		err = tx.QueryRow(
+			ctx,
+			"SELECT u.username, u.household_id, t.name FROM users u, tasks t WHERE u.id=$1 AND t.id=$2",
+			tf.AssigneeID, tf.TaskID,
+		).Scan(&username, &householdID, &taskName)
+		if err != nil {
+			return errors.Wrap(err, "unable to get task details")
+		}
+
+		err = emitWebhook(ctx, tx, householdID, EventFeedCompleted, feedEvent{
+			ID:         tf.ID,
+			TaskID:     tf.TaskID,
+			TaskName:   taskName,
+			AssigneeID: tf.AssigneeID,
+			Points:     tf.Points,
+		})
+		if err != nil {
+			return err
+		}
+
+		return notifyParents(
+			ctx, tx, householdID, EventFeedCompleted,
+			fmt.Sprintf("%s completed %s", username, taskName),
+			fmt.Sprintf("%s has completed %q and it is waiting for your approval.", username, taskName),
+		)
+	})
+	if err != nil {
+		return TaskFeed{}, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": tf.ID,
+	}).Info("Task Feed completed successfully")
+
+	return tf, nil
+}
+
+// ApproveTaskFeed approves a completed feed entry, crediting its points to the
+// assignee
+func (d *Manager) ApproveTaskFeed(ctx context.Context, id int32) (TaskFeed, error) {
+	tf := TaskFeed{}
+
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		err := scanTaskFeed(tx.QueryRow(
+			ctx,
+			"UPDATE tasks_feed SET is_approved = true WHERE id=$1 AND is_complete AND NOT is_approved RETURNING "+taskFeedColumns,
+			id,
+		), &tf)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return &ErrFailedPrecondition{message: "task feed not found, not complete or already approved"}
+			}
+			return errors.Wrap(err, "unable to approve task feed")
+		}
+
+		if _, err := tx.Exec(ctx, "UPDATE users SET points = points + $1 WHERE id=$2", tf.Points, tf.AssigneeID); err != nil {
+			return errors.Wrap(err, "unable to credit points")
+		}
+
+		var taskName string
+		var householdID int32
+		if err := tx.QueryRow(ctx, "SELECT name, household_id FROM tasks WHERE id=$1", tf.TaskID).Scan(&taskName, &householdID); err != nil {
+			return errors.Wrap(err, "unable to get task")
+		}
+
+		err = emitWebhook(ctx, tx, householdID, EventFeedApproved, feedEvent{
+			ID:         tf.ID,
+			TaskID:     tf.TaskID,
+			TaskName:   taskName,
+			AssigneeID: tf.AssigneeID,
+			Points:     tf.Points,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := emitPointsChanged(ctx, tx, tf.AssigneeID, tf.Points, fmt.Sprintf("%q was approved", taskName)); err != nil {
+			return err
+		}
+
+		return notifyUsers(
+			ctx, tx, []int32{tf.AssigneeID}, EventFeedApproved,
+			fmt.Sprintf("%s was approved", taskName),
+			fmt.Sprintf("Well done! %q was approved and you earned %d points.", taskName, tf.Points),
+		)
+	})
+	if err != nil {
+		return TaskFeed{}, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": tf.ID,
+	}).Info("Task Feed approved successfully")
+
+	return tf, nil
+}
+
 func (d *Manager) CreateUser(ctx context.Context, user User) (User, error) {
 	u := User{}
 
-	err := d.pool.QueryRow(
+	err := d.conn.QueryRow(
 		ctx,
-		"INSERT INTO users(username, email, is_admin, is_parent, avatar, password, pin, points, is_active) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, username, email, is_admin, is_parent, avatar, points, is_active",
-		user.Username, user.Email, user.IsAdmin, user.IsParent, user.Avatar, user.Password, user.Pin, 0, true,
-	).Scan(&u.ID, &u.Username, &u.Email, &u.IsAdmin, &u.IsParent, &u.Avatar, &u.Points, &u.IsActive)
+		"INSERT INTO users(username, email, household_id, is_admin, is_parent, avatar, password, pin, points, is_active) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id, username, email, household_id, is_admin, is_parent, avatar, points, reserved_points, email_verified_at IS NOT NULL, is_active",
+		user.Username, user.Email, user.HouseholdID, user.IsAdmin, user.IsParent, user.Avatar, user.Password, user.Pin, 0, true,
+	).Scan(&u.ID, &u.Username, &u.Email, &u.HouseholdID, &u.IsAdmin, &u.IsParent, &u.Avatar, &u.Points, &u.ReservedPoints, &u.EmailVerified, &u.IsActive)
 	if err != nil {
 		return u, errors.Wrap(err, "unable to add user")
 	}
@@ -311,8 +894,23 @@ func (d *Manager) CreateUser(ctx context.Context, user User) (User, error) {
 func (d *Manager) GetUser(ctx context.Context, username string) (User, error) {
 	u := User{}
 
-	err := d.pool.QueryRow(ctx, "SELECT id, username, email, is_admin, is_parent, avatar, points, password, pin, is_active FROM users WHERE username=$1", username).
-		Scan(&u.ID, &u.Username, &u.Email, &u.IsAdmin, &u.IsParent, &u.Avatar, &u.Points, &u.Password, &u.Pin, &u.IsActive)
+	err := d.conn.QueryRow(ctx, "SELECT id, username, email, household_id, is_admin, is_parent, avatar, points, reserved_points, email_verified_at IS NOT NULL, password, pin, is_active FROM users WHERE username=$1", username).
+		Scan(&u.ID, &u.Username, &u.Email, &u.HouseholdID, &u.IsAdmin, &u.IsParent, &u.Avatar, &u.Points, &u.ReservedPoints, &u.EmailVerified, &u.Password, &u.Pin, &u.IsActive)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return u, &ErrNotFound{message: "record not found"}
+		}
+		return u, errors.Wrap(err, "unable to get user")
+	}
+
+	return u, nil
+}
+
+func (d *Manager) GetUserByID(ctx context.Context, id int32) (User, error) {
+	u := User{}
+
+	err := d.conn.QueryRow(ctx, "SELECT id, username, email, household_id, is_admin, is_parent, avatar, points, reserved_points, email_verified_at IS NOT NULL, password, pin, is_active FROM users WHERE id=$1", id).
+		Scan(&u.ID, &u.Username, &u.Email, &u.HouseholdID, &u.IsAdmin, &u.IsParent, &u.Avatar, &u.Points, &u.ReservedPoints, &u.EmailVerified, &u.Password, &u.Pin, &u.IsActive)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return u, &ErrNotFound{message: "record not found"}
@@ -323,10 +921,11 @@ func (d *Manager) GetUser(ctx context.Context, username string) (User, error) {
 	return u, nil
 }
 
-func (d *Manager) ListUsers(ctx context.Context) ([]User, error) {
+// ListUsers lists the users of a household
+func (d *Manager) ListUsers(ctx context.Context, householdID int32) ([]User, error) {
 	users := make([]User, 0)
 
-	rows, err := d.pool.Query(ctx, "SELECT id, username, email, is_admin, is_parent, avatar, points, is_active FROM users")
+	rows, err := d.read(ctx).Query(ctx, "SELECT id, username, email, household_id, is_admin, is_parent, avatar, points, reserved_points, email_verified_at IS NOT NULL, is_active FROM users WHERE household_id=$1", householdID)
 	if err != nil {
 		return users, errors.Wrap(err, "unable to get users")
 	}
@@ -335,7 +934,7 @@ func (d *Manager) ListUsers(ctx context.Context) ([]User, error) {
 	for rows.Next() {
 		u := User{}
 
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.IsAdmin, &u.IsParent, &u.Avatar, &u.Points, &u.IsActive); err != nil {
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.HouseholdID, &u.IsAdmin, &u.IsParent, &u.Avatar, &u.Points, &u.ReservedPoints, &u.EmailVerified, &u.IsActive); err != nil {
 			return nil, errors.Wrap(err, "unable to scan row")
 		}
 
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolConfig(t *testing.T) {
+	c := Config{
+		Host:     "db.internal",
+		Port:     5433,
+		Username: "chore rewards",
+		Password: "p@ss/w:rd?#",
+		Database: "chorerewards",
+		SSLMode:  "disable",
+	}
+
+	t.Run("it should escape the credentials", func(t *testing.T) {
+		config, err := c.poolConfig()
+		if assert.NoError(t, err) {
+			assert.Equal(t, "db.internal", config.ConnConfig.Host)
+			assert.Equal(t, uint16(5433), config.ConnConfig.Port)
+			assert.Equal(t, "chore rewards", config.ConnConfig.User)
+			assert.Equal(t, "p@ss/w:rd?#", config.ConnConfig.Password)
+			assert.Equal(t, "chorerewards", config.ConnConfig.Database)
+			assert.Nil(t, config.ConnConfig.TLSConfig)
+		}
+	})
+
+	t.Run("it should verify the server certificate", func(t *testing.T) {
+		c := c
+		c.SSLMode = "verify-full"
+
+		config, err := c.poolConfig()
+		if assert.NoError(t, err) && assert.NotNil(t, config.ConnConfig.TLSConfig) {
+			assert.Equal(t, "db.internal", config.ConnConfig.TLSConfig.ServerName)
+			assert.False(t, config.ConnConfig.TLSConfig.InsecureSkipVerify)
+		}
+	})
+
+	t.Run("it should apply the pool and session settings", func(t *testing.T) {
+		c := c
+		c.MinConns = 2
+		c.MaxConns = 20
+		c.MaxConnLifetime = time.Hour
+		c.StatementTimeout = time.Second * 30
+		c.ApplicationName = "chorerewards-test"
+
+		config, err := c.poolConfig()
+		if assert.NoError(t, err) {
+			assert.Equal(t, int32(2), config.MinConns)
+			assert.Equal(t, int32(20), config.MaxConns)
+			assert.Equal(t, time.Hour, config.MaxConnLifetime)
+			assert.Equal(t, "30000", config.ConnConfig.RuntimeParams["statement_timeout"])
+			assert.Equal(t, "chorerewards-test", config.ConnConfig.RuntimeParams["application_name"])
+		}
+	})
+
+	t.Run("it should refuse a min above the max", func(t *testing.T) {
+		c := c
+		c.MinConns = 5
+		c.MaxConns = 2
+
+		_, err := c.poolConfig()
+		assert.Error(t, err)
+	})
+
+	t.Run("it should use the password with a DSN which has none", func(t *testing.T) {
+		config, err := Config{DSN: "host=replica.internal user=app dbname=app sslmode=disable", Password: "secret"}.poolConfig()
+		if assert.NoError(t, err) {
+			assert.Equal(t, "replica.internal", config.ConnConfig.Host)
+			assert.Equal(t, "secret", config.ConnConfig.Password)
+		}
+	})
+
+	t.Run("it should not leak the DSN in errors", func(t *testing.T) {
+		_, err := Config{DSN: "postgres://app:secret@db:notaport/app"}.poolConfig()
+		if assert.Error(t, err) {
+			assert.NotContains(t, err.Error(), "secret")
+		}
+	})
+}
+
+func TestRetry(t *testing.T) {
+	t.Run("it should retry until connected", func(t *testing.T) {
+		attempts := 0
+
+		err := retry(context.Background(), func(ctx context.Context) error {
+			if attempts++; attempts < 2 {
+				return errors.New("connection refused")
+			}
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("it should not retry bad credentials", func(t *testing.T) {
+		attempts := 0
+
+		err := retry(context.Background(), func(ctx context.Context) error {
+			attempts++
+			return &pgconn.PgError{Code: "28P01"}
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("it should give up when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+
+		err := retry(ctx, func(ctx context.Context) error {
+			return errors.New("connection refused")
+		})
+
+		assert.EqualError(t, err, "connection refused")
+	})
+}
@@ -0,0 +1,288 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Goal is a savings goal a child allocates points towards
+type Goal struct {
+	ID              int32
+	UserID          int32
+	RewardID        int32
+	Name            string
+	TargetPoints    int32
+	AllocatedPoints int32
+	IsRedeemed      bool
+}
+
+// Progress returns how far through the goal the allocated points are, as a
+// percentage capped at 100
+func (g Goal) Progress() int32 {
+	if g.TargetPoints <= 0 || g.AllocatedPoints >= g.TargetPoints {
+		return 100
+	}
+
+	return g.AllocatedPoints * 100 / g.TargetPoints
+}
+
+// Remaining returns how many more points need to be allocated before the goal
+// can be redeemed
+func (g Goal) Remaining() int32 {
+	if g.AllocatedPoints >= g.TargetPoints {
+		return 0
+	}
+
+	return g.TargetPoints - g.AllocatedPoints
+}
+
+// AvailablePoints returns the points a user can spend or allocate, which
+// excludes those already reserved for goals
+func (u User) AvailablePoints() int32 {
+	return u.Points - u.ReservedPoints
+}
+
+func scanGoal(row pgx.Row, g *Goal) error {
+	return row.Scan(&g.ID, &g.UserID, &g.RewardID, &g.Name, &g.TargetPoints, &g.AllocatedPoints, &g.IsRedeemed)
+}
+
+func (d *Manager) CreateGoal(ctx context.Context, goal Goal) (Goal, error) {
+	g := Goal{}
+
+	err := scanGoal(d.conn.QueryRow(
+		ctx,
+		"INSERT INTO goals(user_id, reward_id, name, target_points, allocated_points, is_redeemed) VALUES($1, NULLIF($2, 0), $3, $4, 0, false) RETURNING id, user_id, COALESCE(reward_id, 0), name, target_points, allocated_points, is_redeemed",
+		goal.UserID, goal.RewardID, goal.Name, goal.TargetPoints,
+	), &g)
+	if err != nil {
+		return g, errors.Wrap(err, "unable to add goal")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": g.ID,
+	}).Info("Goal inserted successfully")
+
+	return g, nil
+}
+
+func (d *Manager) GetGoal(ctx context.Context, id int32) (Goal, error) {
+	g := Goal{}
+
+	err := scanGoal(d.conn.QueryRow(ctx, "SELECT id, user_id, COALESCE(reward_id, 0), name, target_points, allocated_points, is_redeemed FROM goals WHERE id=$1", id), &g)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return g, &ErrNotFound{message: "record not found"}
+		}
+		return g, errors.Wrap(err, "unable to get goal")
+	}
+
+	return g, nil
+}
+
+func (d *Manager) ListGoals(ctx context.Context, userID int32) ([]Goal, error) {
+	goals := make([]Goal, 0)
+
+	rows, err := d.read(ctx).Query(ctx, "SELECT id, user_id, COALESCE(reward_id, 0), name, target_points, allocated_points, is_redeemed FROM goals WHERE user_id=$1 ORDER BY id", userID)
+	if err != nil {
+		return goals, errors.Wrap(err, "unable to get goals")
+	}
+
+	rowCount := 0
+	for rows.Next() {
+		g := Goal{}
+
+		if err := scanGoal(rows, &g); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		goals = append(goals, g)
+
+		rowCount++
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	logrus.WithFields(logrus.Fields{"rowCount": rowCount}).Info("Goals queried successfully")
+
+	return goals, nil
+}
+
+// AllocateToGoal moves points from the user's available balance into the goal.
+// A negative amount releases previously allocated points back to the user.
+func (d *Manager) AllocateToGoal(ctx context.Context, goalID int32, userID int32, points int32) (Goal, error) {
+	g := Goal{}
+
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		err := scanGoal(tx.QueryRow(
+			ctx,
+			"UPDATE goals SET allocated_points = allocated_points + $1 WHERE id=$2 AND user_id=$3 AND NOT is_redeemed AND allocated_points + $1 >= 0 RETURNING id, user_id, COALESCE(reward_id, 0), name, target_points, allocated_points, is_redeemed",
+			points, goalID, userID,
+		), &g)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return &ErrFailedPrecondition{message: "goal not found, already redeemed or has insufficient allocated points"}
+			}
+			return errors.Wrap(err, "unable to update goal")
+		}
+
+		tag, err := tx.Exec(
+			ctx,
+			"UPDATE users SET reserved_points = reserved_points + $1 WHERE id=$2 AND points - reserved_points >= $1",
+			points, userID,
+		)
+		if err != nil {
+			return errors.Wrap(err, "unable to reserve points")
+		}
+
+		if tag.RowsAffected() != 1 {
+			return &ErrFailedPrecondition{message: "insufficient available points"}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Goal{}, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":     g.ID,
+		"points": points,
+	}).Info("Goal allocation updated successfully")
+
+	return g, nil
+}
+
+// ContributeToGoal adds points on behalf of someone other than the goal owner,
+// such as a parent matching a child's savings. The contribution is credited to
+// the owner and reserved for the goal in one step so their available balance
+// is unchanged.
+func (d *Manager) ContributeToGoal(ctx context.Context, goalID int32, points int32) (Goal, error) {
+	g := Goal{}
+
+	if points <= 0 {
+		return g, &ErrFailedPrecondition{message: "contribution must be positive"}
+	}
+
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		err := scanGoal(tx.QueryRow(
+			ctx,
+			"UPDATE goals SET allocated_points = allocated_points + $1 WHERE id=$2 AND NOT is_redeemed RETURNING id, user_id, COALESCE(reward_id, 0), name, target_points, allocated_points, is_redeemed",
+			points, goalID,
+		), &g)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return &ErrFailedPrecondition{message: "goal not found or already redeemed"}
+			}
+			return errors.Wrap(err, "unable to update goal")
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			"UPDATE users SET points = points + $1, reserved_points = reserved_points + $1 WHERE id=$2",
+			points, g.UserID,
+		)
+		if err != nil {
+			return errors.Wrap(err, "unable to credit points")
+		}
+
+		return emitPointsChanged(ctx, tx, g.UserID, points, fmt.Sprintf("contribution to %q", g.Name))
+	})
+	if err != nil {
+		return Goal{}, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":     g.ID,
+		"points": points,
+	}).Info("Goal contribution added successfully")
+
+	return g, nil
+}
+
+// RedeemGoal spends the target points of a goal that has been reached. Any
+// points allocated above the target are released back to the user.
+func (d *Manager) RedeemGoal(ctx context.Context, goalID int32, userID int32) (Goal, error) {
+	g := Goal{}
+
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		err := scanGoal(tx.QueryRow(
+			ctx,
+			"SELECT id, user_id, COALESCE(reward_id, 0), name, target_points, allocated_points, is_redeemed FROM goals WHERE id=$1 AND user_id=$2 FOR UPDATE",
+			goalID, userID,
+		), &g)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return &ErrNotFound{message: "record not found"}
+			}
+			return errors.Wrap(err, "unable to get goal")
+		}
+
+		if g.IsRedeemed {
+			return &ErrFailedPrecondition{message: "goal already redeemed"}
+		}
+
+		if g.Remaining() > 0 {
+			return &ErrFailedPrecondition{message: "goal target not reached"}
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			"UPDATE users SET points = points - $1, reserved_points = reserved_points - $2 WHERE id=$3",
+			g.TargetPoints, g.AllocatedPoints, g.UserID,
+		)
+		if err != nil {
+			return errors.Wrap(err, "unable to spend points")
+		}
+
+		if _, err := tx.Exec(ctx, "UPDATE goals SET is_redeemed = true WHERE id=$1", g.ID); err != nil {
+			return errors.Wrap(err, "unable to redeem goal")
+		}
+
+		g.IsRedeemed = true
+
+		if err := emitPointsChanged(ctx, tx, g.UserID, -g.TargetPoints, fmt.Sprintf("redeemed %q", g.Name)); err != nil {
+			return err
+		}
+
+		var username string
+		var householdID int32
+
+		err = tx.QueryRow(ctx, "SELECT username, household_id FROM users WHERE id=$1", g.UserID).Scan(&username, &householdID)
+		if err != nil {
+			return errors.Wrap(err, "unable to get user")
+		}
+
+		// Parents hand over the reward the goal was saving for, if there is one
+		err = emitWebhook(ctx, tx, householdID, EventGoalRedeemed, goalRedeemed{
+			ID:       g.ID,
+			UserID:   g.UserID,
+			Name:     g.Name,
+			Points:   g.TargetPoints,
+			RewardID: g.RewardID,
+		})
+		if err != nil {
+			return err
+		}
+
+		return notifyParents(
+			ctx, tx, householdID, EventRewardRedeemed,
+			fmt.Sprintf("%s reached their goal", username),
+			fmt.Sprintf("%s has redeemed %d points for %q.", username, g.TargetPoints, g.Name),
+		)
+	})
+	if err != nil {
+		return Goal{}, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": g.ID,
+	}).Info("Goal redeemed successfully")
+
+	return g, nil
+}
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoalProgress(t *testing.T) {
+	t.Run("it should report the percentage of the target allocated", func(t *testing.T) {
+		g := Goal{TargetPoints: 200, AllocatedPoints: 50}
+
+		assert.Equal(t, int32(25), g.Progress())
+		assert.Equal(t, int32(150), g.Remaining())
+	})
+
+	t.Run("it should cap progress when allocations exceed the target", func(t *testing.T) {
+		g := Goal{TargetPoints: 100, AllocatedPoints: 150}
+
+		assert.Equal(t, int32(100), g.Progress())
+		assert.Equal(t, int32(0), g.Remaining())
+	})
+}
+
+func TestAvailablePoints(t *testing.T) {
+	u := User{Points: 120, ReservedPoints: 45}
+
+	assert.Equal(t, int32(75), u.AvailablePoints())
+}
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Household is a family sharing tasks, categories and rewards
+type Household struct {
+	ID   int32
+	Name string
+}
+
+func (d *Manager) CreateHousehold(ctx context.Context, name string) (Household, error) {
+	h := Household{}
+
+	err := d.conn.QueryRow(ctx, "INSERT INTO households(name) VALUES($1) RETURNING id, name", name).Scan(&h.ID, &h.Name)
+	if err != nil {
+		return h, errors.Wrap(err, "unable to add household")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": h.ID,
+	}).Info("Household inserted successfully")
+
+	return h, nil
+}
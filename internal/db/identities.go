@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// UserIdentity links a user to their account with an external identity
+// provider
+type UserIdentity struct {
+	UserID   int32
+	Provider string
+	// Subject is the provider's identifier for the user
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+}
+
+// GetUserByIdentity returns the user linked to a provider's subject
+func (d *Manager) GetUserByIdentity(ctx context.Context, provider string, subject string) (User, error) {
+	u := User{}
+
+	err := d.conn.QueryRow(
+		ctx,
+		`SELECT u.id, u.username, u.email, u.household_id, u.is_admin, u.is_parent, u.avatar, u.points, u.reserved_points, u.email_verified_at IS NOT NULL, u.password, u.pin, u.is_active
+		FROM users u JOIN user_identities i ON i.user_id = u.id WHERE i.provider=$1 AND i.subject=$2`,
+		provider, subject,
+	).Scan(&u.ID, &u.Username, &u.Email, &u.HouseholdID, &u.IsAdmin, &u.IsParent, &u.Avatar, &u.Points, &u.ReservedPoints, &u.EmailVerified, &u.Password, &u.Pin, &u.IsActive)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return u, &ErrNotFound{message: "record not found"}
+		}
+		return u, errors.Wrap(err, "unable to get user")
+	}
+
+	return u, nil
+}
+
+func (d *Manager) LinkIdentity(ctx context.Context, identity UserIdentity) (UserIdentity, error) {
+	i := UserIdentity{}
+
+	err := d.conn.QueryRow(
+		ctx,
+		"INSERT INTO user_identities(user_id, provider, subject, email) VALUES($1, $2, $3, $4) RETURNING user_id, provider, subject, email, created_at",
+		identity.UserID, identity.Provider, identity.Subject, identity.Email,
+	).Scan(&i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return i, &ErrFailedPrecondition{message: "identity is already linked"}
+		}
+		return i, errors.Wrap(err, "unable to link identity")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"userID":   i.UserID,
+		"provider": i.Provider,
+	}).Info("Identity linked successfully")
+
+	return i, nil
+}
+
+func (d *Manager) ListIdentities(ctx context.Context, userID int32) ([]UserIdentity, error) {
+	identities := make([]UserIdentity, 0)
+
+	rows, err := d.read(ctx).Query(ctx, "SELECT user_id, provider, subject, email, created_at FROM user_identities WHERE user_id=$1 ORDER BY provider", userID)
+	if err != nil {
+		return identities, errors.Wrap(err, "unable to get identities")
+	}
+
+	for rows.Next() {
+		i := UserIdentity{}
+
+		if err := rows.Scan(&i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		identities = append(identities, i)
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	return identities, nil
+}
+
+func (d *Manager) UnlinkIdentity(ctx context.Context, userID int32, provider string) error {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM user_identities WHERE user_id=$1 AND provider=$2", userID, provider)
+	if err != nil {
+		return errors.Wrap(err, "unable to unlink identity")
+	}
+
+	if tag.RowsAffected() == 0 {
+		return &ErrNotFound{message: "record not found"}
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// MFA is a user's TOTP two-factor authentication enrolment. An enrolment is
+// pending until the user has confirmed it with a code from their app.
+type MFA struct {
+	UserID  int32
+	Secret  string
+	Enabled bool
+	// LastStep is the TOTP time step of the last code used, so that no code is
+	// accepted twice
+	LastStep               int64
+	LockedUntil            *time.Time
+	RecoveryCodesRemaining int32
+}
+
+// Locked returns whether too many wrong codes have been entered recently
+func (m MFA) Locked(now time.Time) bool {
+	return m.LockedUntil != nil && now.Before(*m.LockedUntil)
+}
+
+// HouseholdSettings are the policies a household's admins have chosen
+type HouseholdSettings struct {
+	HouseholdID      int32
+	RequireParentMFA bool
+}
+
+func (d *Manager) GetMFA(ctx context.Context, userID int32) (MFA, error) {
+	m := MFA{}
+
+	err := d.conn.QueryRow(
+		ctx,
+		`SELECT user_id, secret, enabled_at IS NOT NULL, last_step, locked_until,
+			(SELECT count(*) FROM user_recovery_codes r WHERE r.user_id = m.user_id AND r.used_at IS NULL)
+		FROM user_mfa m WHERE user_id=$1`,
+		userID,
+	).Scan(&m.UserID, &m.Secret, &m.Enabled, &m.LastStep, &m.LockedUntil, &m.RecoveryCodesRemaining)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return m, &ErrNotFound{message: "record not found"}
+		}
+		return m, errors.Wrap(err, "unable to get mfa")
+	}
+
+	return m, nil
+}
+
+// StartMFAEnrolment stores a new secret for the user to confirm, replacing any
+// pending enrolment
+func (d *Manager) StartMFAEnrolment(ctx context.Context, userID int32, secret string) error {
+	tag, err := d.conn.Exec(
+		ctx,
+		`INSERT INTO user_mfa(user_id, secret, last_step, failed_attempts) VALUES($1, $2, 0, 0)
+		ON CONFLICT (user_id) DO UPDATE SET secret=EXCLUDED.secret, last_step=0, failed_attempts=0, locked_until=NULL
+		WHERE user_mfa.enabled_at IS NULL`,
+		userID, secret,
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to start mfa enrolment")
+	}
+
+	if tag.RowsAffected() != 1 {
+		return &ErrFailedPrecondition{message: "two-factor authentication is already enabled"}
+	}
+
+	return nil
+}
+
+// EnableMFA confirms a pending enrolment with the time step of the code the
+// user entered, replacing their recovery codes
+func (d *Manager) EnableMFA(ctx context.Context, userID int32, step int64, recoveryCodeHashes [][]byte) error {
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(
+			ctx,
+			"UPDATE user_mfa SET enabled_at=now(), last_step=$2, failed_attempts=0 WHERE user_id=$1 AND enabled_at IS NULL AND last_step < $2",
+			userID, step,
+		)
+		if err != nil {
+			return errors.Wrap(err, "unable to enable mfa")
+		}
+
+		if tag.RowsAffected() != 1 {
+			return &ErrFailedPrecondition{message: "no pending two-factor enrolment"}
+		}
+
+		return replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"userID": userID,
+	}).Info("MFA enabled successfully")
+
+	return nil
+}
+
+// UseTOTPStep records that the code for a time step has been used. It fails if
+// a code for the same or a later step was used first.
+func (d *Manager) UseTOTPStep(ctx context.Context, userID int32, step int64) error {
+	tag, err := d.conn.Exec(
+		ctx,
+		"UPDATE user_mfa SET last_step=$2, failed_attempts=0 WHERE user_id=$1 AND enabled_at IS NOT NULL AND last_step < $2",
+		userID, step,
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to use code")
+	}
+
+	if tag.RowsAffected() != 1 {
+		return &ErrFailedPrecondition{message: "code has already been used"}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode spends one of the user's recovery codes
+func (d *Manager) UseRecoveryCode(ctx context.Context, userID int32, hash []byte) error {
+	return d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(
+			ctx,
+			"UPDATE user_recovery_codes SET used_at=now() WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL",
+			userID, hash,
+		)
+		if err != nil {
+			return errors.Wrap(err, "unable to use recovery code")
+		}
+
+		if tag.RowsAffected() != 1 {
+			return &ErrFailedPrecondition{message: "invalid recovery code"}
+		}
+
+		if _, err := tx.Exec(ctx, "UPDATE user_mfa SET failed_attempts=0 WHERE user_id=$1", userID); err != nil {
+			return errors.Wrap(err, "unable to reset failed attempts")
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"userID": userID,
+		}).Info("Recovery code used")
+
+		return nil
+	})
+}
+
+// RecordMFAFailure counts a wrong code, locking the user out of the second
+// step for lockout once maxAttempts wrong codes have been entered in a row
+func (d *Manager) RecordMFAFailure(ctx context.Context, userID int32, maxAttempts int32, lockout time.Duration) error {
+	_, err := d.conn.Exec(
+		ctx,
+		`UPDATE user_mfa SET
+			locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN now() + $3::interval ELSE locked_until END,
+			failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END
+		WHERE user_id=$1`,
+		userID, maxAttempts, lockout,
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to record failed attempt")
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes invalidates the user's recovery codes and stores new ones
+func (d *Manager) ReplaceRecoveryCodes(ctx context.Context, userID int32, hashes [][]byte) error {
+	return d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		return replaceRecoveryCodes(ctx, tx, userID, hashes)
+	})
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int32, hashes [][]byte) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM user_recovery_codes WHERE user_id=$1", userID); err != nil {
+		return errors.Wrap(err, "unable to delete recovery codes")
+	}
+
+	_, err := tx.Exec(
+		ctx,
+		"INSERT INTO user_recovery_codes(user_id, code_hash) SELECT $1, unnest($2::bytea[])",
+		userID, hashes,
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to add recovery codes")
+	}
+
+	return nil
+}
+
+// DisableMFA removes the user's enrolment and recovery codes
+func (d *Manager) DisableMFA(ctx context.Context, userID int32) error {
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM user_recovery_codes WHERE user_id=$1", userID); err != nil {
+			return errors.Wrap(err, "unable to delete recovery codes")
+		}
+
+		tag, err := tx.Exec(ctx, "DELETE FROM user_mfa WHERE user_id=$1", userID)
+		if err != nil {
+			return errors.Wrap(err, "unable to disable mfa")
+		}
+
+		if tag.RowsAffected() != 1 {
+			return &ErrNotFound{message: "record not found"}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"userID": userID,
+	}).Info("MFA disabled successfully")
+
+	return nil
+}
+
+// GetHouseholdSettings returns a household's settings, which are the defaults
+// until an admin changes them
+func (d *Manager) GetHouseholdSettings(ctx context.Context, householdID int32) (HouseholdSettings, error) {
+	s := HouseholdSettings{HouseholdID: householdID}
+
+	err := d.conn.QueryRow(ctx, "SELECT require_parent_mfa FROM household_settings WHERE household_id=$1", householdID).Scan(&s.RequireParentMFA)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return s, errors.Wrap(err, "unable to get household settings")
+	}
+
+	return s, nil
+}
+
+func (d *Manager) SetRequireParentMFA(ctx context.Context, householdID int32, required bool) (HouseholdSettings, error) {
+	s := HouseholdSettings{}
+
+	err := d.conn.QueryRow(
+		ctx,
+		`INSERT INTO household_settings(household_id, require_parent_mfa) VALUES($1, $2)
+		ON CONFLICT (household_id) DO UPDATE SET require_parent_mfa=EXCLUDED.require_parent_mfa
+		RETURNING household_id, require_parent_mfa`,
+		householdID, required,
+	).Scan(&s.HouseholdID, &s.RequireParentMFA)
+	if err != nil {
+		return s, errors.Wrap(err, "unable to update household settings")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"householdID":      householdID,
+		"requireParentMFA": required,
+	}).Info("Household settings updated successfully")
+
+	return s, nil
+}
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Events which notifications are sent for
+const (
+	EventFeedCompleted    = "feed.completed"
+	EventFeedApproved     = "feed.approved"
+	EventFeedOverdue      = "feed.overdue"
+	EventRewardRedeemed   = "reward.redeemed"
+	EventCommentMentioned = "comment.mentioned"
+)
+
+// NotificationPreference enables delivery of a user's notifications over a channel
+type NotificationPreference struct {
+	UserID  int32
+	Channel string
+	// Address is where to deliver to, see notify.Message
+	Address string
+	Enabled bool
+}
+
+// PendingNotification is a notification claimed from the outbox, along with the
+// address and quiet hours it is to be delivered with
+type PendingNotification struct {
+	ID        int32
+	UserID    int32
+	Channel   string
+	EventType string
+	Subject   string
+	Body      string
+	Address   string
+	Attempts  int32
+	// QuietHoursStart and QuietHoursEnd are minutes after midnight in Location
+	QuietHoursStart int32
+	QuietHoursEnd   int32
+	Location        *time.Location
+}
+
+// NotificationSettings are a user's settings that apply to every channel
+type NotificationSettings struct {
+	UserID int32
+	// QuietHoursStart and QuietHoursEnd are minutes after midnight in TimeZone
+	QuietHoursStart int32
+	QuietHoursEnd   int32
+	TimeZone        string
+}
+
+// notifyUsers queues a notification for each of the users on every channel they
+// have enabled. It is called with the transaction of the event being notified
+// so that notifications are only sent for events which were committed.
+func notifyUsers(ctx context.Context, q querier, userIDs []int32, event string, subject string, body string) error {
+	_, err := q.Exec(
+		ctx,
+		`INSERT INTO notification_outbox(user_id, channel, event_type, subject, body)
+		SELECT user_id, channel, $2, $3, $4 FROM notification_preferences WHERE user_id = ANY($1) AND enabled`,
+		userIDs, event, subject, body,
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to queue notification")
+	}
+
+	return nil
+}
+
+// notifyParents queues a notification for the active parents of a household
+func notifyParents(ctx context.Context, q querier, householdID int32, event string, subject string, body string) error {
+	_, err := q.Exec(
+		ctx,
+		`INSERT INTO notification_outbox(user_id, channel, event_type, subject, body)
+		SELECT p.user_id, p.channel, $2, $3, $4 FROM notification_preferences p JOIN users u ON u.id = p.user_id
+		WHERE u.household_id=$1 AND u.is_parent AND u.is_active AND p.enabled`,
+		householdID, event, subject, body,
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to queue notification")
+	}
+
+	return nil
+}
+
+func (d *Manager) SetNotificationPreference(ctx context.Context, preference NotificationPreference) (NotificationPreference, error) {
+	p := NotificationPreference{}
+
+	err := d.conn.QueryRow(
+		ctx,
+		`INSERT INTO notification_preferences(user_id, channel, address, enabled) VALUES($1, $2, $3, $4)
+		ON CONFLICT (user_id, channel) DO UPDATE SET address=EXCLUDED.address, enabled=EXCLUDED.enabled
+		RETURNING user_id, channel, address, enabled`,
+		preference.UserID, preference.Channel, preference.Address, preference.Enabled,
+	).Scan(&p.UserID, &p.Channel, &p.Address, &p.Enabled)
+	if err != nil {
+		return p, errors.Wrap(err, "unable to set notification preference")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"userID":  p.UserID,
+		"channel": p.Channel,
+	}).Info("Notification preference set successfully")
+
+	return p, nil
+}
+
+func (d *Manager) ListNotificationPreferences(ctx context.Context, userID int32) ([]NotificationPreference, error) {
+	preferences := make([]NotificationPreference, 0)
+
+	rows, err := d.read(ctx).Query(ctx, "SELECT user_id, channel, address, enabled FROM notification_preferences WHERE user_id=$1 ORDER BY channel", userID)
+	if err != nil {
+		return preferences, errors.Wrap(err, "unable to get notification preferences")
+	}
+
+	for rows.Next() {
+		p := NotificationPreference{}
+
+		if err := rows.Scan(&p.UserID, &p.Channel, &p.Address, &p.Enabled); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		preferences = append(preferences, p)
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	return preferences, nil
+}
+
+func (d *Manager) SetNotificationSettings(ctx context.Context, settings NotificationSettings) (NotificationSettings, error) {
+	s := NotificationSettings{}
+
+	err := d.conn.QueryRow(
+		ctx,
+		`INSERT INTO notification_settings(user_id, quiet_hours_start, quiet_hours_end, time_zone) VALUES($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET quiet_hours_start=EXCLUDED.quiet_hours_start, quiet_hours_end=EXCLUDED.quiet_hours_end, time_zone=EXCLUDED.time_zone
+		RETURNING user_id, quiet_hours_start, quiet_hours_end, time_zone`,
+		settings.UserID, settings.QuietHoursStart, settings.QuietHoursEnd, settings.TimeZone,
+	).Scan(&s.UserID, &s.QuietHoursStart, &s.QuietHoursEnd, &s.TimeZone)
+	if err != nil {
+		return s, errors.Wrap(err, "unable to set notification settings")
+	}
+
+	return s, nil
+}
+
+// ClaimNotifications returns up to limit due notifications. Claimed
+// notifications have their next attempt pushed back by the lease, so a
+// dispatcher that crashes mid-batch doesn't lose them and concurrent
+// dispatchers don't send them twice.
+func (d *Manager) ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]PendingNotification, error) {
+	pending := make([]PendingNotification, 0)
+
+	rows, err := d.conn.Query(
+		ctx,
+		`WITH claimed AS (
+			UPDATE notification_outbox SET next_attempt_at = now() + make_interval(secs => $2)
+			WHERE id IN (
+				SELECT id FROM notification_outbox WHERE delivered_at IS NULL AND NOT is_dead AND next_attempt_at <= now()
+				ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, user_id, channel, event_type, subject, body, attempts
+		)
+		SELECT c.id, c.user_id, c.channel, c.event_type, c.subject, c.body, c.attempts, COALESCE(p.address, ''),
+			COALESCE(s.quiet_hours_start, 0), COALESCE(s.quiet_hours_end, 0), COALESCE(s.time_zone, 'UTC')
+		FROM claimed c
+		LEFT JOIN notification_preferences p ON p.user_id = c.user_id AND p.channel = c.channel
+		LEFT JOIN notification_settings s ON s.user_id = c.user_id`,
+		limit, lease.Seconds(),
+	)
+	if err != nil {
+		return pending, errors.Wrap(err, "unable to claim notifications")
+	}
+
+	for rows.Next() {
+		var (
+			p        PendingNotification
+			timeZone string
+		)
+
+		if err := rows.Scan(
+			&p.ID, &p.UserID, &p.Channel, &p.EventType, &p.Subject, &p.Body, &p.Attempts, &p.Address,
+			&p.QuietHoursStart, &p.QuietHoursEnd, &timeZone,
+		); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		if p.Location, err = time.LoadLocation(timeZone); err != nil {
+			p.Location = time.UTC
+		}
+
+		pending = append(pending, p)
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	return pending, nil
+}
+
+func (d *Manager) MarkNotificationDelivered(ctx context.Context, id int32) error {
+	_, err := d.conn.Exec(ctx, "UPDATE notification_outbox SET delivered_at=now(), attempts=attempts + 1, last_error=NULL WHERE id=$1", id)
+	if err != nil {
+		return errors.Wrap(err, "unable to mark notification delivered")
+	}
+
+	return nil
+}
+
+func (d *Manager) RetryNotification(ctx context.Context, id int32, lastError string, next time.Time) error {
+	_, err := d.conn.Exec(
+		ctx,
+		"UPDATE notification_outbox SET attempts=attempts + 1, last_error=$2, is_dead=$3, next_attempt_at=COALESCE($4, next_attempt_at) WHERE id=$1",
+		id, lastError, next.IsZero(), nullTime(next),
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to update notification")
+	}
+
+	return nil
+}
+
+func (d *Manager) DeferNotification(ctx context.Context, id int32, until time.Time) error {
+	_, err := d.conn.Exec(ctx, "UPDATE notification_outbox SET next_attempt_at=$2 WHERE id=$1", id, until)
+	if err != nil {
+		return errors.Wrap(err, "unable to defer notification")
+	}
+
+	return nil
+}
+
+// nullTime converts the zero time to NULL
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
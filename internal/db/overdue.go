@@ -0,0 +1,252 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// PointsAdjustment records a change to a user's points made outside of the
+// normal task approval flow, along with the reason for it
+type PointsAdjustment struct {
+	ID         int32
+	UserID     int32
+	TaskFeedID int32
+	Points     int32
+	Reason     string
+	CreatedAt  time.Time
+}
+
+// LatePoints returns the points awarded for a feed entry completed after its
+// deadline, given the percentage lost to the late penalty
+func LatePoints(points int32, latePenaltyPercent int32) int32 {
+	if latePenaltyPercent <= 0 {
+		return points
+	}
+
+	if latePenaltyPercent >= 100 {
+		return 0
+	}
+
+	return points - points*latePenaltyPercent/100
+}
+
+// SetTaskDeadline sets how long after being added the feed entries of a task
+// in a household are due, and the penalties for completing them late or not
+// at all. Entries already in the feed keep their deadline.
+func (d *Manager) SetTaskDeadline(ctx context.Context, taskID int32, householdID int32, dueIn time.Duration, latePenaltyPercent int32, missedPenaltyPoints int32) (Task, error) {
+	t := Task{}
+
+	err := scanTask(d.conn.QueryRow(
+		ctx,
+		"UPDATE tasks SET due_in_seconds=$3, late_penalty_percent=$4, missed_penalty_points=$5 WHERE id=$1 AND household_id=$2 RETURNING "+taskColumns,
+		taskID, householdID, int32(dueIn/time.Second), latePenaltyPercent, missedPenaltyPoints,
+	), &t)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return t, &ErrNotFound{message: "record not found"}
+		}
+		return t, errors.Wrap(err, "unable to update task")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": t.ID,
+	}).Info("Task deadline updated successfully")
+
+	return t, nil
+}
+
+// MarkOverdue flags incomplete feed entries whose deadline has passed, and
+// those which are still incomplete missedAfter after their deadline.
+//
+// An overdue entry can still be completed, for the points left after the late
+// penalty of its task. A missed entry can't be completed any more, and the
+// missed penalty of its task is deducted from the assignee and recorded as a
+// points adjustment. A deduction never takes a user below the points they
+// have reserved for goals.
+func (d *Manager) MarkOverdue(ctx context.Context, missedAfter time.Duration) (int, error) {
+	count := 0
+
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		overdue, err := overdueEntries(
+			ctx, tx,
+			`SELECT tf.id, tf.assignee_id, tf.points, t.name, t.late_penalty_percent, t.missed_penalty_points
+			FROM tasks_feed tf JOIN tasks t ON t.id = tf.task_id
+			WHERE NOT tf.is_complete AND NOT tf.is_overdue AND tf.due_at < now()
+			FOR UPDATE OF tf SKIP LOCKED`,
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, o := range overdue {
+			_, err := tx.Exec(
+				ctx,
+				"UPDATE tasks_feed SET is_overdue = true, points=$1 WHERE id=$2",
+				LatePoints(o.points, o.latePenaltyPercent), o.id,
+			)
+			if err != nil {
+				return errors.Wrap(err, "unable to update task feed")
+			}
+
+			reason := fmt.Sprintf("%q was not completed by its deadline", o.name)
+
+			if err := notifyUsers(ctx, tx, []int32{o.assigneeID}, EventFeedOverdue, fmt.Sprintf("%s is overdue", o.name), reason+"."); err != nil {
+				return err
+			}
+
+			count++
+		}
+
+		missed, err := overdueEntries(
+			ctx, tx,
+			`SELECT tf.id, tf.assignee_id, tf.points, t.name, t.late_penalty_percent, t.missed_penalty_points
+			FROM tasks_feed tf JOIN tasks t ON t.id = tf.task_id
+			WHERE NOT tf.is_complete AND tf.is_overdue AND NOT tf.is_missed AND tf.due_at < now() - make_interval(secs => $1)
+			FOR UPDATE OF tf SKIP LOCKED`,
+			missedAfter.Seconds(),
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, o := range missed {
+			if _, err := tx.Exec(ctx, "UPDATE tasks_feed SET is_missed = true WHERE id=$1", o.id); err != nil {
+				return errors.Wrap(err, "unable to update task feed")
+			}
+
+			reason := fmt.Sprintf("%q was never completed", o.name)
+
+			if o.missedPenaltyPoints > 0 {
+				if err := deductPoints(ctx, tx, o.assigneeID, o.id, o.missedPenaltyPoints, reason); err != nil {
+					return err
+				}
+			}
+
+			count++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if count > 0 {
+		logrus.WithFields(logrus.Fields{"rowCount": count}).Info("Tasks feed marked overdue")
+	}
+
+	return count, nil
+}
+
+type overdueEntry struct {
+	id, assigneeID, points, latePenaltyPercent, missedPenaltyPoints int32
+	name                                                            string
+}
+
+// overdueEntries reads the feed entries selected by query along with the
+// penalties of their tasks
+func overdueEntries(ctx context.Context, tx pgx.Tx, query string, args ...interface{}) ([]overdueEntry, error) {
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get overdue tasks feed")
+	}
+	defer rows.Close()
+
+	entries := make([]overdueEntry, 0)
+	for rows.Next() {
+		o := overdueEntry{}
+
+		if err := rows.Scan(&o.id, &o.assigneeID, &o.points, &o.name, &o.latePenaltyPercent, &o.missedPenaltyPoints); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		entries = append(entries, o)
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	return entries, nil
+}
+
+func deductPoints(ctx context.Context, tx pgx.Tx, userID int32, taskFeedID int32, points int32, reason string) error {
+	var available int32
+
+	err := tx.QueryRow(ctx, "SELECT points - reserved_points FROM users WHERE id=$1 FOR UPDATE", userID).Scan(&available)
+	if err != nil {
+		return errors.Wrap(err, "unable to get user")
+	}
+
+	deducted := points
+	if deducted > available {
+		deducted = available
+	}
+
+	if deducted <= 0 {
+		return nil
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE users SET points = points - $1 WHERE id=$2", deducted, userID); err != nil {
+		return errors.Wrap(err, "unable to deduct points")
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		"INSERT INTO points_adjustments(user_id, tasks_feed_id, points, reason) VALUES($1, $2, $3, $4)",
+		userID, taskFeedID, -deducted, reason,
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to add points adjustment")
+	}
+
+	return emitPointsChanged(ctx, tx, userID, -deducted, reason)
+}
+
+// ListOverdue lists the overdue and missed feed entries of everyone in a
+// household
+func (d *Manager) ListOverdue(ctx context.Context, householdID int32) ([]TaskFeed, error) {
+	return d.listTasksFeed(
+		ctx,
+		`SELECT tf.id, tf.assignee_id, tf.task_id, tf.is_complete, tf.is_approved, tf.completed_at, tf.points, tf.due_at, tf.is_overdue, tf.is_missed
+		FROM tasks_feed tf JOIN users u ON u.id = tf.assignee_id
+		WHERE u.household_id=$1 AND tf.is_overdue AND NOT tf.is_complete
+		ORDER BY tf.due_at`,
+		householdID,
+	)
+}
+
+func (d *Manager) ListPointsAdjustments(ctx context.Context, userID int32) ([]PointsAdjustment, error) {
+	adjustments := make([]PointsAdjustment, 0)
+
+	rows, err := d.read(ctx).Query(ctx, "SELECT id, user_id, COALESCE(tasks_feed_id, 0), points, reason, created_at FROM points_adjustments WHERE user_id=$1 ORDER BY created_at DESC", userID)
+	if err != nil {
+		return adjustments, errors.Wrap(err, "unable to get points adjustments")
+	}
+
+	rowCount := 0
+	for rows.Next() {
+		a := PointsAdjustment{}
+
+		if err := rows.Scan(&a.ID, &a.UserID, &a.TaskFeedID, &a.Points, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		adjustments = append(adjustments, a)
+
+		rowCount++
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	logrus.WithFields(logrus.Fields{"rowCount": rowCount}).Info("Points adjustments queried successfully")
+
+	return adjustments, nil
+}
@@ -0,0 +1,28 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatePoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		points  int32
+		percent int32
+		want    int32
+	}{
+		{name: "no penalty", points: 50, percent: 0, want: 50},
+		{name: "half penalty", points: 50, percent: 50, want: 25},
+		{name: "rounds in the child's favour", points: 15, percent: 10, want: 14},
+		{name: "full penalty", points: 50, percent: 100, want: 0},
+		{name: "penalty above 100 percent", points: 50, percent: 150, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, LatePoints(tt.points, tt.percent))
+		})
+	}
+}
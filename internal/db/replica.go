@@ -0,0 +1,253 @@
+package db
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chorerewards/backend/internal/clock"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// replicaRetryAfter is how long reads skip a replica which has failed
+const replicaRetryAfter = time.Second * 10
+
+// pins remembers the callers who have written recently, so that their reads
+// go to the primary until the replica has caught up with their writes
+type pins struct {
+	window    time.Duration
+	callerKey func(ctx context.Context) string
+	clock     clock.Clock
+
+	mu    sync.Mutex
+	until map[string]time.Time
+	swept time.Time
+}
+
+func newPins(window time.Duration, callerKey func(ctx context.Context) string) *pins {
+	return &pins{
+		window:    window,
+		callerKey: callerKey,
+		clock:     clock.Real{},
+		until:     make(map[string]time.Time),
+	}
+}
+
+// unpinnedKey marks a context whose writes don't pin the caller
+type unpinnedKey struct{}
+
+// unpinned returns a context whose writes don't pin the caller, for
+// bookkeeping writes the caller never reads back, such as when their session
+// was last seen
+func unpinned(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unpinnedKey{}, true)
+}
+
+func (p *pins) key(ctx context.Context) string {
+	if p == nil || p.callerKey == nil {
+		return ""
+	}
+
+	if skip, _ := ctx.Value(unpinnedKey{}).(bool); skip {
+		return ""
+	}
+
+	return p.callerKey(ctx)
+}
+
+// pin sends the caller's reads to the primary for the window
+func (p *pins) pin(ctx context.Context) {
+	key := p.key(ctx)
+	if key == "" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock.Now()
+	p.until[key] = now.Add(p.window)
+
+	// Forget expired pins so that the map doesn't grow with every caller
+	if now.Sub(p.swept) > p.window {
+		p.swept = now
+
+		for k, until := range p.until {
+			if now.After(until) {
+				delete(p.until, k)
+			}
+		}
+	}
+}
+
+// pinned reports whether the caller has written within the window
+func (p *pins) pinned(ctx context.Context) bool {
+	key := p.key(ctx)
+	if key == "" {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	until, ok := p.until[key]
+
+	return ok && p.clock.Now().Before(until)
+}
+
+// isWrite reports whether a statement may write. Only plain SELECTs are
+// treated as reads, as a WITH query can contain an UPDATE.
+func isWrite(sql string) bool {
+	return !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(sql)), "SELECT")
+}
+
+// primaryPool is the pool of the primary database. Writes through it pin the
+// caller to the primary.
+type primaryPool struct {
+	*pgxpool.Pool
+	pins *pins
+}
+
+func (p *primaryPool) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	p.pins.pin(ctx)
+	return p.Pool.Exec(ctx, sql, args...)
+}
+
+func (p *primaryPool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if isWrite(sql) {
+		p.pins.pin(ctx)
+	}
+	return p.Pool.Query(ctx, sql, args...)
+}
+
+func (p *primaryPool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	if isWrite(sql) {
+		p.pins.pin(ctx)
+	}
+	return p.Pool.QueryRow(ctx, sql, args...)
+}
+
+func (p *primaryPool) BeginFunc(ctx context.Context, f func(pgx.Tx) error) error {
+	p.pins.pin(ctx)
+	return p.Pool.BeginFunc(ctx, f)
+}
+
+func (p *primaryPool) BeginTxFunc(ctx context.Context, txOptions pgx.TxOptions, f func(pgx.Tx) error) error {
+	p.pins.pin(ctx)
+	return p.Pool.BeginTxFunc(ctx, txOptions, f)
+}
+
+// replicaPool runs reads on a read replica, falling back to the primary when
+// the replica can't be reached. Errors reading rows after a query has started
+// are returned rather than retried.
+type replicaPool struct {
+	replica querier
+	primary querier
+	clock   clock.Clock
+	// downUntil is when to try the replica again after it failed, in Unix
+	// nanoseconds
+	downUntil int64
+}
+
+// available reports whether the replica should be tried
+func (r *replicaPool) available() bool {
+	return r.clock.Now().UnixNano() >= atomic.LoadInt64(&r.downUntil)
+}
+
+// failed reports whether err means the replica couldn't run the statement,
+// rather than the statement failing, and if so skips the replica for a while
+func (r *replicaPool) failed(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || !unavailable(err) {
+		return false
+	}
+
+	atomic.StoreInt64(&r.downUntil, r.clock.Now().Add(replicaRetryAfter).UnixNano())
+	logrus.WithError(err).Warn("Read replica failed, reading from the primary")
+
+	return true
+}
+
+// unavailable reports whether err came from the replica being unreachable or
+// unable to serve queries. Other errors, such as a row failing to scan, would
+// fail on the primary too.
+func unavailable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		// Connection exceptions, insufficient resources, and operator
+		// intervention such as a shutdown
+		case strings.HasPrefix(pgErr.Code, "08"), strings.HasPrefix(pgErr.Code, "53"), strings.HasPrefix(pgErr.Code, "57"):
+			return true
+		// Queries cancelled by a conflict with replaying the primary's changes
+		case pgErr.Code == "40001":
+			return true
+		}
+
+		return false
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		pgconn.SafeToRetry(err)
+}
+
+// Exec runs on the primary, as statements run with Exec write
+func (r *replicaPool) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return r.primary.Exec(ctx, sql, args...)
+}
+
+func (r *replicaPool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if r.available() {
+		rows, err := r.replica.Query(ctx, sql, args...)
+		if !r.failed(ctx, err) {
+			return rows, err
+		}
+	}
+
+	return r.primary.Query(ctx, sql, args...)
+}
+
+func (r *replicaPool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return &replicaRow{r: r, ctx: ctx, sql: sql, args: args}
+}
+
+// replicaRow runs the query when scanned, so that it can fall back to the
+// primary if the replica fails
+type replicaRow struct {
+	r    *replicaPool
+	ctx  context.Context
+	sql  string
+	args []interface{}
+}
+
+func (row *replicaRow) Scan(dest ...interface{}) error {
+	if row.r.available() {
+		err := row.r.replica.QueryRow(row.ctx, row.sql, row.args...).Scan(dest...)
+		if !row.r.failed(row.ctx, err) {
+			return err
+		}
+	}
+
+	return row.r.primary.QueryRow(row.ctx, row.sql, row.args...).Scan(dest...)
+}
+
+// read returns where to run a read-only query: the replica if there is one,
+// unless the caller has written recently or is in a transaction
+func (d *Manager) read(ctx context.Context) querier {
+	if d.replica == nil || d.pool.pins.pinned(ctx) {
+		return d.conn
+	}
+
+	return d.replica
+}
@@ -0,0 +1,226 @@
+package db
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type testClock struct {
+	time time.Time
+}
+
+func (t *testClock) Now() time.Time {
+	return t.time
+}
+
+// testQuerier records the queries it runs and fails them with err
+type testQuerier struct {
+	err     error
+	queries int
+}
+
+func (q *testQuerier) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	q.queries++
+	return nil, q.err
+}
+
+func (q *testQuerier) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	q.queries++
+	return nil, q.err
+}
+
+func (q *testQuerier) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	q.queries++
+	return testRow{err: q.err}
+}
+
+type testRow struct {
+	err error
+}
+
+func (r testRow) Scan(dest ...interface{}) error {
+	return r.err
+}
+
+type callerKey struct{}
+
+func withCaller(key string) context.Context {
+	return context.WithValue(context.Background(), callerKey{}, key)
+}
+
+func TestPins(t *testing.T) {
+	clock := &testClock{time: time.Date(2021, 6, 7, 12, 0, 0, 0, time.UTC)}
+
+	p := newPins(time.Second*5, func(ctx context.Context) string {
+		key, _ := ctx.Value(callerKey{}).(string)
+		return key
+	})
+	p.clock = clock
+
+	p.pin(withCaller("a"))
+
+	t.Run("it should pin a caller after they write", func(t *testing.T) {
+		assert.True(t, p.pinned(withCaller("a")))
+	})
+
+	t.Run("it should not pin other callers", func(t *testing.T) {
+		assert.False(t, p.pinned(withCaller("b")))
+	})
+
+	t.Run("it should not pin callers for unpinned writes", func(t *testing.T) {
+		p.pin(unpinned(withCaller("d")))
+
+		assert.False(t, p.pinned(withCaller("d")))
+	})
+
+	t.Run("it should not pin anonymous callers", func(t *testing.T) {
+		p.pin(context.Background())
+
+		assert.False(t, p.pinned(context.Background()))
+	})
+
+	t.Run("it should unpin a caller after the window", func(t *testing.T) {
+		clock.time = clock.time.Add(time.Second * 5)
+
+		assert.False(t, p.pinned(withCaller("a")))
+	})
+
+	t.Run("it should forget expired pins", func(t *testing.T) {
+		clock.time = clock.time.Add(time.Second * 10)
+		p.pin(withCaller("c"))
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		assert.NotContains(t, p.until, "a")
+	})
+}
+
+func TestIsWrite(t *testing.T) {
+	t.Run("it should treat selects as reads", func(t *testing.T) {
+		assert.False(t, isWrite("  select id FROM users"))
+	})
+
+	t.Run("it should treat other statements as writes", func(t *testing.T) {
+		assert.True(t, isWrite("INSERT INTO users (username) VALUES ($1) RETURNING id"))
+		assert.True(t, isWrite("WITH deleted AS (DELETE FROM sessions RETURNING id) SELECT count(*) FROM deleted"))
+	})
+}
+
+func TestReplicaPool(t *testing.T) {
+	clock := &testClock{time: time.Date(2021, 6, 7, 12, 0, 0, 0, time.UTC)}
+	ctx := context.Background()
+
+	newReplicaPool := func(err error) (*replicaPool, *testQuerier, *testQuerier) {
+		replica, primary := &testQuerier{err: err}, &testQuerier{}
+		return &replicaPool{replica: replica, primary: primary, clock: clock}, replica, primary
+	}
+
+	t.Run("it should read from the replica", func(t *testing.T) {
+		r, replica, primary := newReplicaPool(nil)
+
+		_, err := r.Query(ctx, "SELECT 1")
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, replica.queries)
+		assert.Equal(t, 0, primary.queries)
+	})
+
+	t.Run("it should fall back to the primary when the replica can't be reached", func(t *testing.T) {
+		r, replica, primary := newReplicaPool(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED})
+
+		_, err := r.Query(ctx, "SELECT 1")
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, replica.queries)
+		assert.Equal(t, 1, primary.queries)
+
+		t.Run("it should skip the replica for a while", func(t *testing.T) {
+			assert.NoError(t, r.QueryRow(ctx, "SELECT 1").Scan())
+			assert.Equal(t, 1, replica.queries)
+
+			clock.time = clock.time.Add(replicaRetryAfter)
+
+			assert.NoError(t, r.QueryRow(ctx, "SELECT 1").Scan())
+			assert.Equal(t, 2, replica.queries)
+		})
+	})
+
+	t.Run("it should fall back to the primary on a recovery conflict", func(t *testing.T) {
+		r, _, primary := newReplicaPool(&pgconn.PgError{Code: "40001"})
+
+		assert.NoError(t, r.QueryRow(ctx, "SELECT 1").Scan())
+		assert.Equal(t, 1, primary.queries)
+	})
+
+	t.Run("it should return errors from the query", func(t *testing.T) {
+		r, _, primary := newReplicaPool(&pgconn.PgError{Code: "42P01"})
+
+		_, err := r.Query(ctx, "SELECT 1 FROM missing")
+
+		assert.Error(t, err)
+		assert.Equal(t, 0, primary.queries)
+	})
+
+	t.Run("it should return scan errors without skipping the replica", func(t *testing.T) {
+		r, replica, primary := newReplicaPool(errors.New("can't scan into dest[0]"))
+
+		assert.Error(t, r.QueryRow(ctx, "SELECT 1").Scan())
+		assert.Equal(t, 0, primary.queries)
+
+		replica.err = nil
+		assert.NoError(t, r.QueryRow(ctx, "SELECT 1").Scan())
+		assert.Equal(t, 2, replica.queries)
+	})
+
+	t.Run("it should return no rows without asking the primary", func(t *testing.T) {
+		r, _, primary := newReplicaPool(pgx.ErrNoRows)
+
+		assert.Equal(t, pgx.ErrNoRows, r.QueryRow(ctx, "SELECT 1").Scan())
+		assert.Equal(t, 0, primary.queries)
+	})
+}
+
+func TestReplicaConfig(t *testing.T) {
+	c := Config{
+		Host:            "db.internal",
+		Port:            5433,
+		Username:        "chorerewards",
+		Password:        "secret",
+		Database:        "chorerewards",
+		SSLMode:         "verify-full",
+		ApplicationName: "chorerewards",
+		MaxConns:        20,
+		Replica:         &Config{Host: "replica.internal"},
+	}
+
+	t.Run("it should take settings from the primary", func(t *testing.T) {
+		r := c.replicaConfig()
+
+		assert.Equal(t, "replica.internal", r.Host)
+		assert.Equal(t, 5433, r.Port)
+		assert.Equal(t, "chorerewards", r.Username)
+		assert.Equal(t, "secret", r.Password)
+		assert.Equal(t, "verify-full", r.SSLMode)
+		assert.Equal(t, int32(20), r.MaxConns)
+	})
+
+	t.Run("it should only take the password and pool settings for a DSN", func(t *testing.T) {
+		c := c
+		c.Replica = &Config{DSN: "postgres://reader@replica.internal/chorerewards"}
+
+		r := c.replicaConfig()
+
+		assert.Equal(t, 0, r.Port)
+		assert.Equal(t, "", r.SSLMode)
+		assert.Equal(t, "secret", r.Password)
+		assert.Equal(t, int32(20), r.MaxConns)
+	})
+}
@@ -0,0 +1,288 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Rotation shares a repeating task between several users, assigning each
+// occurrence to the next participant in turn
+type Rotation struct {
+	ID     int32
+	TaskID int32
+	// ParticipantIDs are the users taking part, in rotation order
+	ParticipantIDs []int32
+	// Cadence is how often a new occurrence of the task is added to the feed
+	Cadence time.Duration
+	// Position is the index into ParticipantIDs of whoever is due next
+	Position  int32
+	NextRunAt time.Time
+}
+
+// RotationOccurrence is an upcoming occurrence of a rotation
+type RotationOccurrence struct {
+	UserID int32
+	At     time.Time
+}
+
+// Next returns the next active participant, starting at the rotation's
+// current position, along with the position following them. Inactive users
+// are skipped without losing their place in the order. ok is false when
+// nobody in the rotation is active.
+func (r Rotation) Next(active map[int32]bool) (userID int32, nextPosition int32, ok bool) {
+	count := int32(len(r.ParticipantIDs))
+
+	for i := int32(0); i < count; i++ {
+		pos := (r.Position + i) % count
+
+		if id := r.ParticipantIDs[pos]; active[id] {
+			return id, (pos + 1) % count, true
+		}
+	}
+
+	return 0, r.Position, false
+}
+
+// Upcoming returns the next n occurrences of the rotation
+func (r Rotation) Upcoming(n int, active map[int32]bool) []RotationOccurrence {
+	occurrences := make([]RotationOccurrence, 0, n)
+
+	at := r.NextRunAt
+	for i := 0; i < n; i++ {
+		userID, pos, ok := r.Next(active)
+		if !ok {
+			break
+		}
+
+		occurrences = append(occurrences, RotationOccurrence{UserID: userID, At: at})
+
+		r.Position = pos
+		at = at.Add(r.Cadence)
+	}
+
+	return occurrences
+}
+
+// Due returns the latest occurrence of the rotation at or before now, and when
+// the one after it is due. Occurrences missed while the job wasn't running are
+// skipped rather than backfilled.
+func (r Rotation) Due(now time.Time) (at time.Time, nextRunAt time.Time) {
+	at = r.NextRunAt
+	if r.Cadence > 0 && now.After(at) {
+		at = at.Add(now.Sub(at) / r.Cadence * r.Cadence)
+	}
+
+	return at, at.Add(r.Cadence)
+}
+
+func scanRotation(row pgx.Row, r *Rotation) error {
+	var cadenceSeconds int32
+
+	if err := row.Scan(&r.ID, &r.TaskID, &r.ParticipantIDs, &cadenceSeconds, &r.Position, &r.NextRunAt); err != nil {
+		return err
+	}
+
+	r.Cadence = time.Duration(cadenceSeconds) * time.Second
+
+	return nil
+}
+
+func (d *Manager) CreateRotation(ctx context.Context, rotation Rotation) (Rotation, error) {
+	r := Rotation{}
+
+	err := scanRotation(d.conn.QueryRow(
+		ctx,
+		"INSERT INTO rotations(task_id, participant_ids, cadence_seconds, position, next_run_at) VALUES($1, $2, $3, 0, $4) RETURNING id, task_id, participant_ids, cadence_seconds, position, next_run_at",
+		rotation.TaskID, rotation.ParticipantIDs, int32(rotation.Cadence/time.Second), rotation.NextRunAt,
+	), &r)
+	if err != nil {
+		return r, errors.Wrap(err, "unable to add rotation")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": r.ID,
+	}).Info("Rotation inserted successfully")
+
+	return r, nil
+}
+
+func (d *Manager) GetRotation(ctx context.Context, id int32) (Rotation, error) {
+	r := Rotation{}
+
+	err := scanRotation(d.conn.QueryRow(ctx, "SELECT id, task_id, participant_ids, cadence_seconds, position, next_run_at FROM rotations WHERE id=$1", id), &r)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return r, &ErrNotFound{message: "record not found"}
+		}
+		return r, errors.Wrap(err, "unable to get rotation")
+	}
+
+	return r, nil
+}
+
+// ActiveUsers returns which of the given users are active
+func (d *Manager) ActiveUsers(ctx context.Context, ids []int32) (map[int32]bool, error) {
+	return activeUsers(ctx, d.conn, ids)
+}
+
+func activeUsers(ctx context.Context, q querier, ids []int32) (map[int32]bool, error) {
+	active := make(map[int32]bool, len(ids))
+
+	rows, err := q.Query(ctx, "SELECT id FROM users WHERE id = ANY($1) AND is_active", ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get users")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int32
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		active[id] = true
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	return active, nil
+}
+
+// SwapRotationParticipants exchanges the places of two users in a rotation,
+// for example when siblings agree to trade turns
+func (d *Manager) SwapRotationParticipants(ctx context.Context, rotationID int32, firstUserID int32, secondUserID int32) (Rotation, error) {
+	r := Rotation{}
+
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		err := scanRotation(tx.QueryRow(ctx, "SELECT id, task_id, participant_ids, cadence_seconds, position, next_run_at FROM rotations WHERE id=$1 FOR UPDATE", rotationID), &r)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return &ErrNotFound{message: "record not found"}
+			}
+			return errors.Wrap(err, "unable to get rotation")
+		}
+
+		first, second := -1, -1
+		for i, id := range r.ParticipantIDs {
+			switch id {
+			case firstUserID:
+				first = i
+			case secondUserID:
+				second = i
+			}
+		}
+
+		if first == -1 || second == -1 {
+			return &ErrFailedPrecondition{message: "both users must be participants of the rotation"}
+		}
+
+		r.ParticipantIDs[first], r.ParticipantIDs[second] = r.ParticipantIDs[second], r.ParticipantIDs[first]
+
+		if _, err := tx.Exec(ctx, "UPDATE rotations SET participant_ids=$1 WHERE id=$2", r.ParticipantIDs, r.ID); err != nil {
+			return errors.Wrap(err, "unable to update rotation")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Rotation{}, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": r.ID,
+	}).Info("Rotation participants swapped successfully")
+
+	return r, nil
+}
+
+// RunDueRotations adds the latest due occurrence of every rotation to the
+// feed, assigned to the next active participant and due by the time the
+// following occurrence is added. A rotation that fell behind, for example
+// after downtime, only gets its latest occurrence and moves on to the next
+// one in the future. Rotations are locked with SKIP LOCKED so that several
+// instances can run the job concurrently.
+func (d *Manager) RunDueRotations(ctx context.Context) (int, error) {
+	count := 0
+	now := d.clock.Now()
+
+	err := d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, "SELECT id, task_id, participant_ids, cadence_seconds, position, next_run_at FROM rotations WHERE next_run_at <= $1 FOR UPDATE SKIP LOCKED", now)
+		if err != nil {
+			return errors.Wrap(err, "unable to get rotations")
+		}
+
+		rotations := make([]Rotation, 0)
+		for rows.Next() {
+			r := Rotation{}
+
+			if err := scanRotation(rows, &r); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "unable to scan row")
+			}
+
+			rotations = append(rotations, r)
+		}
+		rows.Close()
+
+		if rows.Err() != nil {
+			return errors.Wrap(rows.Err(), "erroring reading rows")
+		}
+
+		for _, r := range rotations {
+			active, err := activeUsers(ctx, tx, r.ParticipantIDs)
+			if err != nil {
+				return err
+			}
+
+			position := r.Position
+			at, nextRunAt := r.Due(now)
+			if at.After(r.NextRunAt) {
+				logrus.WithFields(logrus.Fields{"id": r.ID, "from": r.NextRunAt, "to": at}).Warn("Rotation skipped missed occurrences")
+			}
+
+			userID, next, ok := r.Next(active)
+			if ok {
+				_, err := tx.Exec(
+					ctx,
+					"INSERT INTO tasks_feed(assignee_id, task_id, is_complete, is_approved, points, due_at) SELECT $1, id, false, false, points, $3 FROM tasks WHERE id=$2",
+					userID, r.TaskID, nextRunAt,
+				)
+				if err != nil {
+					return errors.Wrap(err, "unable to add task feed")
+				}
+
+				position = next
+				count++
+			} else {
+				logrus.WithFields(logrus.Fields{"id": r.ID}).Warn("Rotation has no active participants")
+			}
+
+			_, err = tx.Exec(
+				ctx,
+				"UPDATE rotations SET position=$1, next_run_at=$2 WHERE id=$3",
+				position, nextRunAt, r.ID,
+			)
+			if err != nil {
+				return errors.Wrap(err, "unable to update rotation")
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if count > 0 {
+		logrus.WithFields(logrus.Fields{"rowCount": count}).Info("Rotation occurrences added to feed")
+	}
+
+	return count, nil
+}
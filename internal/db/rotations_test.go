@@ -0,0 +1,69 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotationNext(t *testing.T) {
+	r := Rotation{ParticipantIDs: []int32{1, 2, 3}, Position: 1}
+
+	t.Run("it should return the participant at the current position", func(t *testing.T) {
+		userID, next, ok := r.Next(map[int32]bool{1: true, 2: true, 3: true})
+
+		assert.True(t, ok)
+		assert.Equal(t, int32(2), userID)
+		assert.Equal(t, int32(2), next)
+	})
+
+	t.Run("it should skip inactive participants and wrap around", func(t *testing.T) {
+		userID, next, ok := r.Next(map[int32]bool{1: true})
+
+		assert.True(t, ok)
+		assert.Equal(t, int32(1), userID)
+		assert.Equal(t, int32(1), next)
+	})
+
+	t.Run("it should not return anyone when no participant is active", func(t *testing.T) {
+		_, next, ok := r.Next(map[int32]bool{})
+
+		assert.False(t, ok)
+		assert.Equal(t, int32(1), next)
+	})
+}
+
+func TestRotationUpcoming(t *testing.T) {
+	start := time.Date(2021, 6, 7, 9, 0, 0, 0, time.UTC)
+	week := time.Hour * 24 * 7
+
+	r := Rotation{ParticipantIDs: []int32{1, 2, 3}, Cadence: week, NextRunAt: start}
+
+	assert.Equal(t, []RotationOccurrence{
+		{UserID: 1, At: start},
+		{UserID: 3, At: start.Add(week)},
+		{UserID: 1, At: start.Add(week * 2)},
+	}, r.Upcoming(3, map[int32]bool{1: true, 3: true}))
+}
+
+func TestRotationDue(t *testing.T) {
+	start := time.Date(2021, 6, 7, 9, 0, 0, 0, time.UTC)
+	day := time.Hour * 24
+
+	r := Rotation{Cadence: day, NextRunAt: start}
+
+	t.Run("it should run the occurrence that is due", func(t *testing.T) {
+		at, next := r.Due(start.Add(time.Minute))
+
+		assert.Equal(t, start, at)
+		assert.Equal(t, start.Add(day), next)
+	})
+
+	t.Run("it should skip to the latest occurrence after downtime", func(t *testing.T) {
+		at, next := r.Due(start.Add(day*3 + time.Hour))
+
+		assert.Equal(t, start.Add(day*3), at)
+		assert.Equal(t, start.Add(day*4), next)
+	})
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Session is a sign in on one of a user's devices
+type Session struct {
+	ID         string
+	UserID     int32
+	DeviceName string
+	UserAgent  string
+	IPAddress  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	// ExpiresAt is when the token issued for the session expires
+	ExpiresAt time.Time
+}
+
+const sessionColumns = "s.id, s.user_id, s.device_name, s.user_agent, s.ip_address, s.created_at, s.last_seen_at, s.expires_at"
+
+// activeSession matches sessions that haven't been signed out, either one at
+// a time or by everything before the user's sessions_revoked_at, and whose
+// user is still active
+const activeSession = "s.revoked_at IS NULL AND u.is_active AND (u.sessions_revoked_at IS NULL OR s.created_at > u.sessions_revoked_at)"
+
+func scanSession(row pgx.Row, s *Session) error {
+	return row.Scan(&s.ID, &s.UserID, &s.DeviceName, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+}
+
+func (d *Manager) CreateSession(ctx context.Context, session Session) (Session, error) {
+	s := Session{}
+
+	err := scanSession(d.conn.QueryRow(
+		ctx,
+		"INSERT INTO sessions AS s(id, user_id, device_name, user_agent, ip_address, expires_at) VALUES($1, $2, $3, $4, $5, $6) RETURNING "+sessionColumns,
+		session.ID, session.UserID, session.DeviceName, session.UserAgent, session.IPAddress, session.ExpiresAt,
+	), &s)
+	if err != nil {
+		return s, errors.Wrap(err, "unable to add session")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"userID": s.UserID,
+	}).Info("Session created successfully")
+
+	return s, nil
+}
+
+// CheckSession returns whether a user's session is active, updating when it
+// was last seen if it is
+func (d *Manager) CheckSession(ctx context.Context, sessionID string, userID int32) (bool, error) {
+	// Checked on every call, so it mustn't pin the caller's reads to the
+	// primary
+	tag, err := d.conn.Exec(
+		unpinned(ctx),
+		"UPDATE sessions s SET last_seen_at=now() FROM users u WHERE u.id = s.user_id AND s.id=$1 AND s.user_id=$2 AND "+activeSession,
+		sessionID, userID,
+	)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to check session")
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// ListSessions lists the user's active sessions which haven't expired, most
+// recently seen first
+func (d *Manager) ListSessions(ctx context.Context, userID int32) ([]Session, error) {
+	sessions := make([]Session, 0)
+
+	rows, err := d.read(ctx).Query(
+		ctx,
+		"SELECT "+sessionColumns+" FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.user_id=$1 AND s.expires_at > now() AND "+activeSession+" ORDER BY s.last_seen_at DESC",
+		userID,
+	)
+	if err != nil {
+		return sessions, errors.Wrap(err, "unable to get sessions")
+	}
+
+	rowCount := 0
+	for rows.Next() {
+		s := Session{}
+
+		if err := scanSession(rows, &s); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		sessions = append(sessions, s)
+
+		rowCount++
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	logrus.WithFields(logrus.Fields{"rowCount": rowCount}).Info("Sessions queried successfully")
+
+	return sessions, nil
+}
+
+// RevokeSession signs out one of the user's sessions
+func (d *Manager) RevokeSession(ctx context.Context, userID int32, sessionID string) error {
+	tag, err := d.conn.Exec(ctx, "UPDATE sessions SET revoked_at=now() WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL", sessionID, userID)
+	if err != nil {
+		return errors.Wrap(err, "unable to revoke session")
+	}
+
+	if tag.RowsAffected() != 1 {
+		return &ErrNotFound{message: "record not found"}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"userID": userID,
+	}).Info("Session revoked successfully")
+
+	return nil
+}
+
+// RevokeOtherSessions signs out all of the user's sessions except one,
+// returning the ids of those signed out
+func (d *Manager) RevokeOtherSessions(ctx context.Context, userID int32, keepSessionID string) ([]string, error) {
+	ids := make([]string, 0)
+
+	rows, err := d.conn.Query(ctx, "UPDATE sessions SET revoked_at=now() WHERE user_id=$1 AND id<>$2 AND revoked_at IS NULL RETURNING id", userID, keepSessionID)
+	if err != nil {
+		return ids, errors.Wrap(err, "unable to revoke sessions")
+	}
+
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		ids = append(ids, id)
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"userID":   userID,
+		"rowCount": len(ids),
+	}).Info("Sessions revoked successfully")
+
+	return ids, nil
+}
+
+// DeleteExpiredSessions removes sessions whose tokens have expired, returning
+// how many were removed
+func (d *Manager) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM sessions WHERE expires_at < now()")
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to delete sessions")
+	}
+
+	logrus.WithFields(logrus.Fields{"rowCount": tag.RowsAffected()}).Info("Expired sessions deleted successfully")
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,255 @@
+package db
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Isolation levels for TxOptions
+const (
+	ReadCommitted  = pgx.ReadCommitted
+	RepeatableRead = pgx.RepeatableRead
+	Serializable   = pgx.Serializable
+)
+
+const (
+	// defaultTxAttempts is how many times a transaction is tried when it
+	// keeps failing with serialization failures or deadlocks
+	defaultTxAttempts = 5
+	// txBackoff is the wait before the first retry, doubling for each retry
+	// after that
+	txBackoff = time.Millisecond * 20
+)
+
+// Store is the data layer. Manager implements it both outside of a
+// transaction and inside one, where it's passed to WithTx callbacks.
+type Store interface {
+	// WithTx runs fn in a transaction, see Manager.WithTx
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+	// WithTxOptions runs fn in a transaction, see Manager.WithTxOptions
+	WithTxOptions(ctx context.Context, opts TxOptions, fn func(tx Store) error) error
+
+	// Households
+	CreateHousehold(ctx context.Context, name string) (Household, error)
+
+	// Categories, tasks, the feed and users
+	CreateCategory(ctx context.Context, category Category) (Category, error)
+	GetCategory(ctx context.Context, name string) (Category, error)
+	GetCategoryByID(ctx context.Context, id int32) (Category, error)
+	ListCategories(ctx context.Context, householdID int32) ([]Category, error)
+	CreateTask(ctx context.Context, task Task) (Task, error)
+	GetTask(ctx context.Context, name string) (Task, error)
+	GetTaskByID(ctx context.Context, id int32) (Task, error)
+	ListTasks(ctx context.Context, householdID int32) ([]Task, error)
+	ListOpenTasks(ctx context.Context, householdID int32) ([]Task, error)
+	CreateTaskFeed(ctx context.Context, taskFeed TaskFeed) (TaskFeed, error)
+	GetTaskFeed(ctx context.Context, id int32) (TaskFeed, error)
+	ListTasksFeed(ctx context.Context, householdID int32) ([]TaskFeed, error)
+	CompleteTaskFeed(ctx context.Context, id int32, assigneeID int32) (TaskFeed, error)
+	ApproveTaskFeed(ctx context.Context, id int32) (TaskFeed, error)
+	CreateUser(ctx context.Context, user User) (User, error)
+	GetUser(ctx context.Context, username string) (User, error)
+	GetUserByID(ctx context.Context, id int32) (User, error)
+	ListUsers(ctx context.Context, householdID int32) ([]User, error)
+
+	// Account tokens and passwords
+	CreateUserToken(ctx context.Context, userID int32, purpose string, hash []byte, expiresAt time.Time) error
+	VerifyEmail(ctx context.Context, hash []byte) (int32, error)
+	ResetPassword(ctx context.Context, hash []byte, passwordHash string) (int32, error)
+	SetPassword(ctx context.Context, userID int32, passwordHash string) error
+	ListUsersByEmail(ctx context.Context, email string) ([]User, error)
+
+	// Attachments
+	CreateAttachment(ctx context.Context, attachment Attachment) (Attachment, error)
+	GetAttachment(ctx context.Context, id int32) (Attachment, error)
+	ListAttachments(ctx context.Context, taskFeedID int32) ([]Attachment, error)
+
+	// Open task claims
+	SetTaskClaims(ctx context.Context, taskID int32, householdID int32, open bool, claimDuration time.Duration, claimBonusPoints int32) (Task, error)
+	ClaimTask(ctx context.Context, taskID int32, userID int32) (Task, error)
+	ReleaseExpiredClaims(ctx context.Context) (int64, error)
+
+	// Comments, reactions and mentions
+	CreateComment(ctx context.Context, comment Comment) (Comment, error)
+	GetComment(ctx context.Context, id int32) (Comment, error)
+	ListComments(ctx context.Context, taskID int32, taskFeedID int32, afterID int32, limit int) ([]Comment, error)
+	UpdateComment(ctx context.Context, id int32, body string) (Comment, error)
+	ListCommentRevisions(ctx context.Context, commentID int32) ([]CommentRevision, error)
+	AddReaction(ctx context.Context, commentID int32, userID int32, emoji string) error
+	RemoveReaction(ctx context.Context, commentID int32, userID int32, emoji string) error
+	ListReactions(ctx context.Context, commentIDs []int32) ([]ReactionCount, error)
+	ListMentions(ctx context.Context, userID int32, limit int) ([]Mention, error)
+
+	// Savings goals
+	CreateGoal(ctx context.Context, goal Goal) (Goal, error)
+	GetGoal(ctx context.Context, id int32) (Goal, error)
+	ListGoals(ctx context.Context, userID int32) ([]Goal, error)
+	AllocateToGoal(ctx context.Context, goalID int32, userID int32, points int32) (Goal, error)
+	ContributeToGoal(ctx context.Context, goalID int32, points int32) (Goal, error)
+	RedeemGoal(ctx context.Context, goalID int32, userID int32) (Goal, error)
+
+	// Linked sign in identities
+	GetUserByIdentity(ctx context.Context, provider string, subject string) (User, error)
+	LinkIdentity(ctx context.Context, identity UserIdentity) (UserIdentity, error)
+	ListIdentities(ctx context.Context, userID int32) ([]UserIdentity, error)
+	UnlinkIdentity(ctx context.Context, userID int32, provider string) error
+
+	// Multi-factor authentication
+	GetMFA(ctx context.Context, userID int32) (MFA, error)
+	StartMFAEnrolment(ctx context.Context, userID int32, secret string) error
+	EnableMFA(ctx context.Context, userID int32, step int64, recoveryCodeHashes [][]byte) error
+	UseTOTPStep(ctx context.Context, userID int32, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int32, hash []byte) error
+	RecordMFAFailure(ctx context.Context, userID int32, maxAttempts int32, lockout time.Duration) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int32, hashes [][]byte) error
+	DisableMFA(ctx context.Context, userID int32) error
+	GetHouseholdSettings(ctx context.Context, householdID int32) (HouseholdSettings, error)
+	SetRequireParentMFA(ctx context.Context, householdID int32, required bool) (HouseholdSettings, error)
+
+	// Notifications
+	SetNotificationPreference(ctx context.Context, preference NotificationPreference) (NotificationPreference, error)
+	ListNotificationPreferences(ctx context.Context, userID int32) ([]NotificationPreference, error)
+	SetNotificationSettings(ctx context.Context, settings NotificationSettings) (NotificationSettings, error)
+	ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]PendingNotification, error)
+	MarkNotificationDelivered(ctx context.Context, id int32) error
+	RetryNotification(ctx context.Context, id int32, lastError string, next time.Time) error
+	DeferNotification(ctx context.Context, id int32, until time.Time) error
+
+	// Overdue tasks and points adjustments
+	SetTaskDeadline(ctx context.Context, taskID int32, householdID int32, dueIn time.Duration, latePenaltyPercent int32, missedPenaltyPoints int32) (Task, error)
+	MarkOverdue(ctx context.Context, missedAfter time.Duration) (int, error)
+	ListOverdue(ctx context.Context, householdID int32) ([]TaskFeed, error)
+	ListPointsAdjustments(ctx context.Context, userID int32) ([]PointsAdjustment, error)
+
+	// Rotations
+	CreateRotation(ctx context.Context, rotation Rotation) (Rotation, error)
+	GetRotation(ctx context.Context, id int32) (Rotation, error)
+	ActiveUsers(ctx context.Context, ids []int32) (map[int32]bool, error)
+	SwapRotationParticipants(ctx context.Context, rotationID int32, firstUserID int32, secondUserID int32) (Rotation, error)
+	RunDueRotations(ctx context.Context) (int, error)
+
+	// Sessions
+	CreateSession(ctx context.Context, session Session) (Session, error)
+	CheckSession(ctx context.Context, sessionID string, userID int32) (bool, error)
+	ListSessions(ctx context.Context, userID int32) ([]Session, error)
+	RevokeSession(ctx context.Context, userID int32, sessionID string) error
+	RevokeOtherSessions(ctx context.Context, userID int32, keepSessionID string) ([]string, error)
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+
+	// Webhooks
+	CreateWebhookSubscription(ctx context.Context, subscription WebhookSubscription) (WebhookSubscription, error)
+	GetWebhookSubscription(ctx context.Context, id int32) (WebhookSubscription, error)
+	ListWebhookSubscriptions(ctx context.Context, householdID int32) ([]WebhookSubscription, error)
+	DeleteWebhookSubscription(ctx context.Context, id int32) error
+	GetWebhookDelivery(ctx context.Context, id int32) (WebhookDelivery, error)
+	ListWebhookDeliveries(ctx context.Context, subscriptionID int32, limit int) ([]WebhookDelivery, error)
+	ListDeadWebhookDeliveries(ctx context.Context, householdID int32) ([]WebhookDelivery, error)
+	ReplayWebhookDelivery(ctx context.Context, id int32) (WebhookDelivery, error)
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]PendingWebhookDelivery, error)
+	MarkWebhookDelivered(ctx context.Context, id int32, responseStatus int) error
+	RetryWebhookDelivery(ctx context.Context, id int32, lastError string, responseStatus int, next time.Time) error
+}
+
+var _ Store = (*Manager)(nil)
+
+// TxOptions configure a transaction started with WithTxOptions
+type TxOptions struct {
+	// Isolation is the isolation level. The server's default, normally
+	// ReadCommitted, is used if it's empty.
+	Isolation pgx.TxIsoLevel
+	ReadOnly  bool
+	// MaxAttempts limits how many times the transaction is tried. Zero uses
+	// the default of 5.
+	MaxAttempts int
+}
+
+// WithTx runs fn in a transaction with the default options, committing it if
+// fn returns nil and rolling it back otherwise. See WithTxOptions.
+func (d *Manager) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	return d.WithTxOptions(ctx, TxOptions{}, fn)
+}
+
+// WithTxOptions runs fn in a transaction, committing it if fn returns nil and
+// rolling it back otherwise. Every Store method called on tx runs in the
+// transaction, and tx must not be used once fn returns, nor by more than one
+// goroutine at a time.
+//
+// The transaction is retried from the start when it fails with a
+// serialization failure or deadlock, so fn may be called more than once and
+// shouldn't have side effects outside of the database.
+//
+// Called on a Store which is already in a transaction, fn runs in a savepoint
+// instead. A failure rolls back to the savepoint and is returned without
+// retrying, and opts are ignored as they can only be set for the outermost
+// transaction.
+func (d *Manager) WithTxOptions(ctx context.Context, opts TxOptions, fn func(tx Store) error) error {
+	if d.inTx {
+		return d.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+			return fn(&Manager{conn: tx, inTx: true, pool: d.pool, clock: d.clock})
+		})
+	}
+
+	txOptions := pgx.TxOptions{IsoLevel: opts.Isolation}
+	if opts.ReadOnly {
+		txOptions.AccessMode = pgx.ReadOnly
+	}
+
+	attempts := opts.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultTxAttempts
+	}
+
+	return retryTx(ctx, attempts, func() error {
+		return d.pool.BeginTxFunc(ctx, txOptions, func(tx pgx.Tx) error {
+			return fn(&Manager{conn: tx, inTx: true, pool: d.pool, clock: d.clock})
+		})
+	})
+}
+
+// conflict reports whether a transaction failed because of concurrent
+// transactions, and so may succeed if tried again
+func conflict(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// serialization_failure and deadlock_detected
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	}
+
+	return false
+}
+
+// retryTx calls tx until it succeeds, fails with an error other than a
+// conflict, has been tried attempts times or ctx is done. It waits longer
+// between each attempt, with jitter so that the conflicting transactions
+// don't collide again.
+func retryTx(ctx context.Context, attempts int, tx func() error) error {
+	backoff := txBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := tx()
+		if err == nil || !conflict(err) || attempt >= attempts {
+			return err
+		}
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"attempt": attempt,
+			"retryIn": wait.String(),
+		}).Debug("Transaction conflicted, retrying")
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+	}
+}
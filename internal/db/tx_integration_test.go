@@ -0,0 +1,148 @@
+//go:build integration
+// +build integration
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// newIntegrationManager connects to the database named by
+// CHOREREWARDS_TEST_DB_DSN, which must have the ChoreRewards schema
+func newIntegrationManager(t *testing.T) *Manager {
+	dsn := os.Getenv("CHOREREWARDS_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("CHOREREWARDS_TEST_DB_DSN is not set")
+	}
+
+	d, err := New(Config{DSN: dsn, StartupTimeout: time.Second * 10})
+	if err != nil {
+		t.Fatalf("unable to connect to the test database: %+v", err)
+	}
+
+	t.Cleanup(d.pool.Close)
+
+	return d
+}
+
+func TestWithTxIntegration(t *testing.T) {
+	d := newIntegrationManager(t)
+	ctx := context.Background()
+
+	prefix := fmt.Sprintf("tx-test-%d-", time.Now().UnixNano())
+
+	// Categories belong to a household
+	var householdID int32
+	if err := d.conn.QueryRow(ctx, "INSERT INTO households(name) VALUES($1) RETURNING id", prefix).Scan(&householdID); err != nil {
+		t.Fatalf("unable to add household: %+v", err)
+	}
+
+	defer func() {
+		_, _ = d.conn.Exec(ctx, "DELETE FROM categories WHERE name LIKE $1", prefix+"%")
+		_, _ = d.conn.Exec(ctx, "DELETE FROM households WHERE id=$1", householdID)
+	}()
+
+	exists := func(name string) bool {
+		_, err := d.GetCategory(ctx, name)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false
+		}
+		assert.NoError(t, err)
+
+		return true
+	}
+
+	t.Run("it should commit when the callback succeeds", func(t *testing.T) {
+		err := d.WithTx(ctx, func(tx Store) error {
+			_, err := tx.CreateCategory(ctx, Category{HouseholdID: householdID, Name: prefix + "committed"})
+			return err
+		})
+
+		assert.NoError(t, err)
+		assert.True(t, exists(prefix+"committed"))
+	})
+
+	t.Run("it should roll back when the callback fails", func(t *testing.T) {
+		failure := errors.New("failed")
+
+		err := d.WithTx(ctx, func(tx Store) error {
+			if _, err := tx.CreateCategory(ctx, Category{HouseholdID: householdID, Name: prefix + "rolled-back"}); err != nil {
+				return err
+			}
+
+			// Visible inside the transaction before it's rolled back
+			_, err := tx.GetCategory(ctx, prefix+"rolled-back")
+			assert.NoError(t, err)
+
+			return failure
+		})
+
+		assert.Equal(t, failure, err)
+		assert.False(t, exists(prefix+"rolled-back"))
+	})
+
+	t.Run("it should only roll back a failed savepoint", func(t *testing.T) {
+		err := d.WithTx(ctx, func(tx Store) error {
+			if _, err := tx.CreateCategory(ctx, Category{HouseholdID: householdID, Name: prefix + "outer"}); err != nil {
+				return err
+			}
+
+			err := tx.WithTx(ctx, func(tx Store) error {
+				if _, err := tx.CreateCategory(ctx, Category{HouseholdID: householdID, Name: prefix + "inner"}); err != nil {
+					return err
+				}
+
+				return errors.New("failed")
+			})
+			assert.Error(t, err)
+
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.True(t, exists(prefix+"outer"))
+		assert.False(t, exists(prefix+"inner"))
+	})
+
+	t.Run("it should retry a serialization failure", func(t *testing.T) {
+		name := prefix + "serializable"
+		_, err := d.CreateCategory(ctx, Category{HouseholdID: householdID, Name: name, Description: "0"})
+		assert.NoError(t, err)
+
+		attempts := 0
+		err = d.WithTxOptions(ctx, TxOptions{Isolation: Serializable}, func(tx Store) error {
+			attempts++
+
+			if _, err := tx.GetCategory(ctx, name); err != nil {
+				return err
+			}
+
+			// A concurrent write to the row read above makes this
+			// transaction fail to serialize the first time
+			if attempts == 1 {
+				if _, err := d.pool.Exec(ctx, "UPDATE categories SET description='1' WHERE name=$1", name); err != nil {
+					return err
+				}
+			}
+
+			_, err := tx.(*Manager).conn.Exec(ctx, "UPDATE categories SET description='2' WHERE name=$1", name)
+
+			return err
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+
+		c, err := d.GetCategory(ctx, name)
+		assert.NoError(t, err)
+		assert.Equal(t, "2", c.Description)
+	})
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// testConn records the savepoints started on it
+type testConn struct {
+	testQuerier
+	savepoints int
+}
+
+func (c *testConn) BeginFunc(ctx context.Context, f func(pgx.Tx) error) error {
+	c.savepoints++
+	return f(nil)
+}
+
+func TestConflict(t *testing.T) {
+	t.Run("it should retry serialization failures and deadlocks", func(t *testing.T) {
+		assert.True(t, conflict(errors.Wrap(&pgconn.PgError{Code: "40001"}, "unable to approve task feed")))
+		assert.True(t, conflict(&pgconn.PgError{Code: "40P01"}))
+	})
+
+	t.Run("it should not retry other errors", func(t *testing.T) {
+		assert.False(t, conflict(&pgconn.PgError{Code: "23505"}))
+		assert.False(t, conflict(pgx.ErrNoRows))
+	})
+}
+
+func TestRetryTx(t *testing.T) {
+	ctx := context.Background()
+	serializationFailure := &pgconn.PgError{Code: "40001"}
+
+	t.Run("it should retry until the transaction succeeds", func(t *testing.T) {
+		attempts := 0
+		err := retryTx(ctx, 5, func() error {
+			if attempts++; attempts < 3 {
+				return serializationFailure
+			}
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("it should give up after the maximum attempts", func(t *testing.T) {
+		attempts := 0
+		err := retryTx(ctx, 2, func() error {
+			attempts++
+			return serializationFailure
+		})
+
+		assert.Equal(t, serializationFailure, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("it should not retry other errors", func(t *testing.T) {
+		attempts := 0
+		err := retryTx(ctx, 5, func() error {
+			attempts++
+			return &ErrNotFound{message: "user not found"}
+		})
+
+		assert.IsType(t, &ErrNotFound{}, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("it should stop when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		attempts := 0
+		err := retryTx(ctx, 5, func() error {
+			attempts++
+			return serializationFailure
+		})
+
+		assert.Equal(t, serializationFailure, err)
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func TestWithTx(t *testing.T) {
+	conn := &testConn{}
+	d := &Manager{conn: conn, inTx: true}
+
+	t.Run("it should use a savepoint inside a transaction", func(t *testing.T) {
+		var nested Store
+		err := d.WithTx(context.Background(), func(tx Store) error {
+			nested = tx
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, conn.savepoints)
+		assert.True(t, nested.(*Manager).inTx)
+	})
+
+	t.Run("it should not retry a savepoint", func(t *testing.T) {
+		calls := 0
+		err := d.WithTx(context.Background(), func(tx Store) error {
+			calls++
+			return &pgconn.PgError{Code: "40P01"}
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("it should read from the transaction", func(t *testing.T) {
+		assert.Equal(t, conn, d.read(context.Background()))
+	})
+}
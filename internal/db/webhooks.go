@@ -0,0 +1,398 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Events which webhooks are sent for, along with EventFeedCompleted and
+// EventFeedApproved
+const (
+	EventTaskCreated   = "task.created"
+	EventPointsChanged = "points.changed"
+	EventGoalRedeemed  = "goal.redeemed"
+)
+
+// WebhookEventTypes are the event types a subscription may filter on
+var WebhookEventTypes = []string{EventTaskCreated, EventFeedCompleted, EventFeedApproved, EventPointsChanged, EventGoalRedeemed}
+
+// WebhookSubscription sends a household's events to an external URL
+type WebhookSubscription struct {
+	ID          int32
+	HouseholdID int32
+	URL         string
+	// Secret is the key deliveries are signed with, see webhooks.Sign
+	Secret string
+	// EventTypes limits the events delivered. An empty list means every event.
+	EventTypes []string
+	IsActive   bool
+	CreatedAt  time.Time
+}
+
+// WebhookDelivery is an event queued for, or sent to, a subscription
+type WebhookDelivery struct {
+	ID             int32
+	SubscriptionID int32
+	EventType      string
+	Payload        json.RawMessage
+	Attempts       int32
+	NextAttemptAt  time.Time
+	DeliveredAt    *time.Time
+	// IsDead is set once a delivery has failed too many times to retry
+	IsDead         bool
+	LastError      string
+	ResponseStatus int32
+	// ReplayOf is the delivery this one was replayed from
+	ReplayOf  int32
+	CreatedAt time.Time
+}
+
+// PendingWebhookDelivery is a delivery claimed for sending, along with the
+// subscription's URL and secret
+type PendingWebhookDelivery struct {
+	ID             int32
+	SubscriptionID int32
+	EventType      string
+	Payload        json.RawMessage
+	Attempts       int32
+	CreatedAt      time.Time
+	URL            string
+	Secret         string
+}
+
+type taskCreated struct {
+	ID          int32  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Points      int32  `json:"points"`
+	AssigneeID  int32  `json:"assignee_id,omitempty"`
+	IsOpen      bool   `json:"is_open"`
+}
+
+type feedEvent struct {
+	ID         int32  `json:"id"`
+	TaskID     int32  `json:"task_id"`
+	TaskName   string `json:"task_name"`
+	AssigneeID int32  `json:"assignee_id"`
+	Points     int32  `json:"points"`
+}
+
+type goalRedeemed struct {
+	ID       int32  `json:"id"`
+	UserID   int32  `json:"user_id"`
+	Name     string `json:"name"`
+	Points   int32  `json:"points"`
+	RewardID int32  `json:"reward_id,omitempty"`
+}
+
+type pointsChange struct {
+	UserID int32  `json:"user_id"`
+	Points int32  `json:"points"`
+	Delta  int32  `json:"delta"`
+	Reason string `json:"reason"`
+}
+
+// emitWebhook queues a delivery of an event to each active subscription of a
+// household which includes the event type. Like notifyUsers, it is called with
+// the transaction of the event so only committed events are delivered.
+func emitWebhook(ctx context.Context, q querier, householdID int32, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode webhook payload")
+	}
+
+	_, err = q.Exec(
+		ctx,
+		`INSERT INTO webhook_deliveries(subscription_id, event_type, payload)
+		SELECT id, $2, $3 FROM webhook_subscriptions
+		WHERE household_id=$1 AND is_active AND (cardinality(event_types) = 0 OR $2 = ANY(event_types))`,
+		householdID, eventType, payload,
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to queue webhook")
+	}
+
+	return nil
+}
+
+// emitPointsChanged queues a points.changed event with the user's new balance
+func emitPointsChanged(ctx context.Context, q querier, userID int32, delta int32, reason string) error {
+	var householdID, points int32
+
+	if err := q.QueryRow(ctx, "SELECT household_id, points FROM users WHERE id=$1", userID).Scan(&householdID, &points); err != nil {
+		return errors.Wrap(err, "unable to get user")
+	}
+
+	return emitWebhook(ctx, q, householdID, EventPointsChanged, pointsChange{
+		UserID: userID,
+		Points: points,
+		Delta:  delta,
+		Reason: reason,
+	})
+}
+
+const webhookSubscriptionColumns = "id, household_id, url, secret, event_types, is_active, created_at"
+
+func scanWebhookSubscription(row pgx.Row, s *WebhookSubscription) error {
+	return row.Scan(&s.ID, &s.HouseholdID, &s.URL, &s.Secret, &s.EventTypes, &s.IsActive, &s.CreatedAt)
+}
+
+func (d *Manager) CreateWebhookSubscription(ctx context.Context, subscription WebhookSubscription) (WebhookSubscription, error) {
+	s := WebhookSubscription{}
+
+	eventTypes := subscription.EventTypes
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+
+	err := scanWebhookSubscription(d.conn.QueryRow(
+		ctx,
+		"INSERT INTO webhook_subscriptions(household_id, url, secret, event_types, is_active) VALUES($1, $2, $3, $4, true) RETURNING "+webhookSubscriptionColumns,
+		subscription.HouseholdID, subscription.URL, subscription.Secret, eventTypes,
+	), &s)
+	if err != nil {
+		return s, errors.Wrap(err, "unable to add webhook subscription")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id": s.ID,
+	}).Info("Webhook subscription inserted successfully")
+
+	return s, nil
+}
+
+func (d *Manager) GetWebhookSubscription(ctx context.Context, id int32) (WebhookSubscription, error) {
+	s := WebhookSubscription{}
+
+	err := scanWebhookSubscription(d.conn.QueryRow(ctx, "SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions WHERE id=$1", id), &s)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return s, &ErrNotFound{message: "record not found"}
+		}
+		return s, errors.Wrap(err, "unable to get webhook subscription")
+	}
+
+	return s, nil
+}
+
+func (d *Manager) ListWebhookSubscriptions(ctx context.Context, householdID int32) ([]WebhookSubscription, error) {
+	subscriptions := make([]WebhookSubscription, 0)
+
+	rows, err := d.read(ctx).Query(ctx, "SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions WHERE household_id=$1 ORDER BY id", householdID)
+	if err != nil {
+		return subscriptions, errors.Wrap(err, "unable to get webhook subscriptions")
+	}
+
+	rowCount := 0
+	for rows.Next() {
+		s := WebhookSubscription{}
+
+		if err := scanWebhookSubscription(rows, &s); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		subscriptions = append(subscriptions, s)
+
+		rowCount++
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	logrus.WithFields(logrus.Fields{"rowCount": rowCount}).Info("Webhook subscriptions queried successfully")
+
+	return subscriptions, nil
+}
+
+// DeleteWebhookSubscription deactivates a subscription. It is kept so that its
+// delivery history remains available.
+func (d *Manager) DeleteWebhookSubscription(ctx context.Context, id int32) error {
+	tag, err := d.conn.Exec(ctx, "UPDATE webhook_subscriptions SET is_active = false WHERE id=$1", id)
+	if err != nil {
+		return errors.Wrap(err, "unable to delete webhook subscription")
+	}
+
+	if tag.RowsAffected() != 1 {
+		return &ErrNotFound{message: "record not found"}
+	}
+
+	return nil
+}
+
+const webhookDeliveryColumns = "id, subscription_id, event_type, payload, attempts, next_attempt_at, delivered_at, is_dead, COALESCE(last_error, ''), COALESCE(response_status, 0), COALESCE(replay_of, 0), created_at"
+
+func scanWebhookDelivery(row pgx.Row, wd *WebhookDelivery) error {
+	return row.Scan(
+		&wd.ID, &wd.SubscriptionID, &wd.EventType, &wd.Payload, &wd.Attempts, &wd.NextAttemptAt, &wd.DeliveredAt,
+		&wd.IsDead, &wd.LastError, &wd.ResponseStatus, &wd.ReplayOf, &wd.CreatedAt,
+	)
+}
+
+func (d *Manager) GetWebhookDelivery(ctx context.Context, id int32) (WebhookDelivery, error) {
+	wd := WebhookDelivery{}
+
+	err := scanWebhookDelivery(d.conn.QueryRow(ctx, "SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE id=$1", id), &wd)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return wd, &ErrNotFound{message: "record not found"}
+		}
+		return wd, errors.Wrap(err, "unable to get webhook delivery")
+	}
+
+	return wd, nil
+}
+
+// ListWebhookDeliveries returns the most recent deliveries to a subscription
+func (d *Manager) ListWebhookDeliveries(ctx context.Context, subscriptionID int32, limit int) ([]WebhookDelivery, error) {
+	return d.listWebhookDeliveries(
+		ctx,
+		"SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE subscription_id=$1 ORDER BY id DESC LIMIT $2",
+		subscriptionID, limit,
+	)
+}
+
+// ListDeadWebhookDeliveries returns the dead-letter list of a household: the
+// deliveries which were given up on after failing too many times
+func (d *Manager) ListDeadWebhookDeliveries(ctx context.Context, householdID int32) ([]WebhookDelivery, error) {
+	return d.listWebhookDeliveries(
+		ctx,
+		`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE is_dead AND subscription_id IN (SELECT id FROM webhook_subscriptions WHERE household_id=$1)
+		ORDER BY id DESC`,
+		householdID,
+	)
+}
+
+func (d *Manager) listWebhookDeliveries(ctx context.Context, query string, args ...interface{}) ([]WebhookDelivery, error) {
+	deliveries := make([]WebhookDelivery, 0)
+
+	rows, err := d.read(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return deliveries, errors.Wrap(err, "unable to get webhook deliveries")
+	}
+
+	rowCount := 0
+	for rows.Next() {
+		wd := WebhookDelivery{}
+
+		if err := scanWebhookDelivery(rows, &wd); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		deliveries = append(deliveries, wd)
+
+		rowCount++
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	logrus.WithFields(logrus.Fields{"rowCount": rowCount}).Info("Webhook deliveries queried successfully")
+
+	return deliveries, nil
+}
+
+// ReplayWebhookDelivery queues a past delivery to be sent again. The original
+// is left untouched and the replay is recorded as a new delivery.
+func (d *Manager) ReplayWebhookDelivery(ctx context.Context, id int32) (WebhookDelivery, error) {
+	wd := WebhookDelivery{}
+
+	err := scanWebhookDelivery(d.conn.QueryRow(
+		ctx,
+		`INSERT INTO webhook_deliveries(subscription_id, event_type, payload, replay_of)
+		SELECT wd.subscription_id, wd.event_type, wd.payload, wd.id FROM webhook_deliveries wd
+		JOIN webhook_subscriptions s ON s.id = wd.subscription_id
+		WHERE wd.id=$1 AND s.is_active
+		RETURNING `+webhookDeliveryColumns,
+		id,
+	), &wd)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return wd, &ErrFailedPrecondition{message: "delivery not found or its subscription was deleted"}
+		}
+		return wd, errors.Wrap(err, "unable to replay webhook delivery")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":       wd.ID,
+		"replayOf": id,
+	}).Info("Webhook delivery replayed successfully")
+
+	return wd, nil
+}
+
+// ClaimWebhookDeliveries leases due deliveries to the caller by pushing back
+// their next attempt, so that a dispatcher which crashes mid-batch has its
+// deliveries retried once the lease runs out
+func (d *Manager) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]PendingWebhookDelivery, error) {
+	pending := make([]PendingWebhookDelivery, 0)
+
+	rows, err := d.conn.Query(
+		ctx,
+		`WITH claimed AS (
+			UPDATE webhook_deliveries SET next_attempt_at = now() + make_interval(secs => $2)
+			WHERE id IN (
+				SELECT wd.id FROM webhook_deliveries wd JOIN webhook_subscriptions s ON s.id = wd.subscription_id
+				WHERE wd.delivered_at IS NULL AND NOT wd.is_dead AND s.is_active AND wd.next_attempt_at <= now()
+				ORDER BY wd.next_attempt_at LIMIT $1 FOR UPDATE OF wd SKIP LOCKED
+			)
+			RETURNING id, subscription_id, event_type, payload, attempts, created_at
+		)
+		SELECT c.id, c.subscription_id, c.event_type, c.payload, c.attempts, c.created_at, s.url, s.secret
+		FROM claimed c JOIN webhook_subscriptions s ON s.id = c.subscription_id`,
+		limit, lease.Seconds(),
+	)
+	if err != nil {
+		return pending, errors.Wrap(err, "unable to claim webhook deliveries")
+	}
+
+	for rows.Next() {
+		p := PendingWebhookDelivery{}
+
+		if err := rows.Scan(&p.ID, &p.SubscriptionID, &p.EventType, &p.Payload, &p.Attempts, &p.CreatedAt, &p.URL, &p.Secret); err != nil {
+			return nil, errors.Wrap(err, "unable to scan row")
+		}
+
+		pending = append(pending, p)
+	}
+
+	if rows.Err() != nil {
+		return nil, errors.Wrap(rows.Err(), "erroring reading rows")
+	}
+
+	return pending, nil
+}
+
+func (d *Manager) MarkWebhookDelivered(ctx context.Context, id int32, responseStatus int) error {
+	_, err := d.conn.Exec(
+		ctx,
+		"UPDATE webhook_deliveries SET delivered_at=now(), attempts=attempts + 1, last_error=NULL, response_status=$2 WHERE id=$1",
+		id, responseStatus,
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to mark webhook delivered")
+	}
+
+	return nil
+}
+
+func (d *Manager) RetryWebhookDelivery(ctx context.Context, id int32, lastError string, responseStatus int, next time.Time) error {
+	_, err := d.conn.Exec(
+		ctx,
+		`UPDATE webhook_deliveries SET attempts=attempts + 1, last_error=$2, response_status=NULLIF($3, 0), is_dead=$4,
+		next_attempt_at=COALESCE($5, next_attempt_at) WHERE id=$1`,
+		id, lastError, responseStatus, next.IsZero(), nullTime(next),
+	)
+	if err != nil {
+		return errors.Wrap(err, "unable to update webhook delivery")
+	}
+
+	return nil
+}
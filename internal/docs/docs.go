@@ -0,0 +1,220 @@
+// Package docs serves the API description on the HTTP proxy: the OpenAPI
+// document of the REST routes, a page listing them, and the protobuf
+// descriptors for gRPC clients such as grpcurl
+package docs
+
+//go:generate sh -c "cp \"$(go list -m -f '{{.Dir}}' github.com/chorerewards/proto)/chorerewards/v1alpha1/chorerewards.swagger.json\" openapi.json && chmod 644 openapi.json"
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// openAPI is the document generated for the gateway by chorerewards/proto,
+// updated with go generate when the proto version changes
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// page lists the REST routes from the OpenAPI document. It is rendered here
+// rather than with a browser UI so that the page loads nothing from other
+// origins.
+var page = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  <style>
+    body { font-family: sans-serif; margin: 2em; }
+    code { font-size: 1.1em; }
+    .method { display: inline-block; width: 5em; font-weight: bold; }
+  </style>
+</head>
+<body>
+  <h1>{{.Title}} <small>{{.Version}}</small></h1>
+  <p>
+    The full description is in the <a href="/openapi.json">OpenAPI document</a>.
+    gRPC clients such as grpcurl can load the <a href="/descriptors.protoset">protobuf descriptors</a>.
+  </p>
+  {{range .Operations}}
+  <h2><span class="method">{{.Method}}</span> <code>{{.Path}}</code></h2>
+  <p><strong>{{.Summary}}</strong>{{with .Description}} &mdash; {{.}}{{end}}</p>
+  {{end}}
+</body>
+</html>
+`))
+
+type operation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+}
+
+type pageData struct {
+	Title      string
+	Version    string
+	Operations []operation
+}
+
+// renderPage renders the docs page for an OpenAPI document
+func renderPage(document []byte) ([]byte, error) {
+	var doc struct {
+		Info struct {
+			Title   string `json:"title"`
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths map[string]map[string]struct {
+			Summary     string `json:"summary"`
+			Description string `json:"description"`
+		} `json:"paths"`
+	}
+
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, errors.Wrap(err, "unable to parse OpenAPI document")
+	}
+
+	data := pageData{Title: doc.Info.Title, Version: doc.Info.Version}
+
+	for path, methods := range doc.Paths {
+		for method, op := range methods {
+			data.Operations = append(data.Operations, operation{
+				Method:      strings.ToUpper(method),
+				Path:        path,
+				Summary:     op.Summary,
+				Description: strings.TrimSpace(op.Description),
+			})
+		}
+	}
+
+	sort.Slice(data.Operations, func(i, j int) bool {
+		a, b := data.Operations[i], data.Operations[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Method < b.Method
+	})
+
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, data); err != nil {
+		return nil, errors.Wrap(err, "unable to render docs page")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Handler serves the API description
+type Handler struct {
+	protoset []byte
+	page     []byte
+}
+
+// NewHandler creates a handler describing the named gRPC services
+func NewHandler(services []string) (*Handler, error) {
+	set, err := Protoset(services)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := proto.Marshal(set)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode descriptors")
+	}
+
+	p, err := renderPage(openAPI)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Handler{protoset: b, page: p}, nil
+}
+
+// Register adds the documentation routes to the gateway mux
+func (h *Handler) Register(mux *runtime.ServeMux) error {
+	if err := mux.HandlePath(http.MethodGet, "/openapi.json", h.serveOpenAPI); err != nil {
+		return err
+	}
+
+	if err := mux.HandlePath(http.MethodGet, "/docs", h.servePage); err != nil {
+		return err
+	}
+
+	return mux.HandlePath(http.MethodGet, "/descriptors.protoset", h.descriptors)
+}
+
+func (h *Handler) serveOpenAPI(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_, _ = w.Write(openAPI)
+}
+
+func (h *Handler) servePage(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// The page has no scripts and only its own styles
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	_, _ = w.Write(h.page)
+}
+
+// descriptors serves the descriptors as a FileDescriptorSet, the format
+// grpcurl reads with -protoset
+func (h *Handler) descriptors(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Disposition", `attachment; filename="chorerewards.protoset"`)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_, _ = w.Write(h.protoset)
+}
+
+// Protoset returns the files defining the services and everything they
+// import, with every file after its imports
+func Protoset(services []string) (*descriptorpb.FileDescriptorSet, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+
+		file := protodesc.ToFileDescriptorProto(fd)
+
+		// Files registered by golang/protobuf v1 packages, such as
+		// protoc-gen-validate's, don't record their syntax. They are proto2,
+		// which is written by leaving the syntax out.
+		if !fd.Syntax().IsValid() {
+			file.Syntax = nil
+		}
+
+		set.File = append(set.File, file)
+	}
+
+	for _, name := range services {
+		d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to find service %s", name)
+		}
+
+		add(d.ParentFile())
+	}
+
+	return set, nil
+}
@@ -0,0 +1,77 @@
+package docs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	_ "github.com/chorerewards/proto/chorerewards/v1alpha1"
+)
+
+const service = "chorerewards.v1alpha1.ChoreRewardsService"
+
+func TestHandler(t *testing.T) {
+	h, err := NewHandler([]string{service})
+	assert.NoError(t, err)
+
+	mux := runtime.NewServeMux()
+	assert.NoError(t, h.Register(mux))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	t.Run("it should serve the OpenAPI document", func(t *testing.T) {
+		w := get("/openapi.json")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var doc struct {
+			Paths map[string]interface{} `json:"paths"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+		assert.Contains(t, doc.Paths, "/v1alpha1/categories")
+	})
+
+	t.Run("it should serve the docs page", func(t *testing.T) {
+		w := get("/docs")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "/openapi.json")
+		assert.Contains(t, w.Body.String(), "<code>/v1alpha1/login</code>")
+	})
+
+	t.Run("it should not load anything from other origins", func(t *testing.T) {
+		w := get("/docs")
+		assert.NotContains(t, w.Body.String(), "<script")
+		assert.NotContains(t, w.Body.String(), "https://")
+		assert.Contains(t, w.Header().Get("Content-Security-Policy"), "default-src 'none'")
+	})
+
+	t.Run("it should serve descriptors grpcurl can load", func(t *testing.T) {
+		w := get("/descriptors.protoset")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		set := &descriptorpb.FileDescriptorSet{}
+		assert.NoError(t, proto.Unmarshal(w.Body.Bytes(), set))
+
+		// Building the files fails unless every import is included
+		files, err := protodesc.NewFiles(set)
+		if assert.NoError(t, err) {
+			_, err := files.FindDescriptorByName(service)
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("it should refuse an unknown service", func(t *testing.T) {
+		_, err := NewHandler([]string{"chorerewards.v1alpha1.Missing"})
+		assert.Error(t, err)
+	})
+}
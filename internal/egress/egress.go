@@ -0,0 +1,99 @@
+// Package egress makes HTTP requests to URLs supplied by users, such as
+// notification webhooks, without letting them reach the server's own network
+package egress
+
+import (
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrForbiddenAddress is returned when a request would connect to an internal address
+var ErrForbiddenAddress = errors.New("address is not allowed")
+
+var forbiddenNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		networks[i] = n
+	}
+
+	return networks
+}
+
+// Allowed reports whether requests may be sent to ip. Loopback, private,
+// link-local, unspecified and multicast addresses are refused.
+func Allowed(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, n := range forbiddenNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// control runs after the host has been resolved and before connecting, so it
+// checks the address actually dialled and can't be bypassed by DNS rebinding
+func control(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrap(err, "invalid address")
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !Allowed(ip) {
+		return errors.Wrap(ErrForbiddenAddress, host)
+	}
+
+	return nil
+}
+
+// NewClient creates a client that refuses to connect to internal addresses
+// and doesn't follow redirects, returning the redirect response instead.
+// Proxies from the environment are ignored because they would connect on the
+// client's behalf.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: control,
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     time.Second * 90,
+			TLSHandshakeTimeout: time.Second * 10,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,55 @@
+package egress
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowed(t *testing.T) {
+	t.Run("it should allow public addresses", func(t *testing.T) {
+		for _, ip := range []string{"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"} {
+			assert.True(t, Allowed(net.ParseIP(ip)), ip)
+		}
+	})
+
+	t.Run("it should refuse internal addresses", func(t *testing.T) {
+		for _, ip := range []string{
+			"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+			"100.64.0.1", "0.0.0.0", "224.0.0.1", "::1", "fd00::1", "fe80::1", "::ffff:127.0.0.1",
+		} {
+			assert.False(t, Allowed(net.ParseIP(ip)), ip)
+		}
+	})
+}
+
+func TestNewClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	client := NewClient(time.Second)
+
+	t.Run("it should refuse to connect to internal addresses", func(t *testing.T) {
+		_, err := client.Get(srv.URL)
+		assert.True(t, errors.Is(err, ErrForbiddenAddress), err)
+	})
+
+	t.Run("it should not follow redirects", func(t *testing.T) {
+		// Use the plain transport so the loopback test server can be reached
+		c := *client
+		c.Transport = http.DefaultTransport
+
+		resp, err := c.Get(srv.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+	})
+}
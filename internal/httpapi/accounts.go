@@ -0,0 +1,104 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+)
+
+// AccountService verifies email addresses and changes passwords
+type AccountService interface {
+	SendEmailVerification(ctx context.Context) error
+	VerifyEmail(ctx context.Context, token string) error
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token string, password string) error
+	ChangePassword(ctx context.Context, currentPassword string, password string) error
+}
+
+func (h *Handler) accountRoutes() []route {
+	return []route{
+		{http.MethodPost, "/v1alpha1/account/email-verification", h.sendEmailVerification},
+		{http.MethodPost, "/v1alpha1/account/password", h.changePassword},
+	}
+}
+
+// publicAccountRoutes are followed from emails, or used by those who can't
+// sign in, so they have no token
+func (h *Handler) publicAccountRoutes() []route {
+	return []route{
+		{http.MethodPost, "/v1alpha1/account/verify-email", h.verifyEmail},
+		{http.MethodPost, "/v1alpha1/account/password-reset", h.requestPasswordReset},
+		{http.MethodPost, "/v1alpha1/account/password-reset/complete", h.resetPassword},
+	}
+}
+
+type accountRequest struct {
+	Token           string `json:"token"`
+	Email           string `json:"email"`
+	CurrentPassword string `json:"currentPassword"`
+	Password        string `json:"password"`
+}
+
+func (h *Handler) sendEmailVerification(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	if err := h.service.SendEmailVerification(ctx); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeEmpty(w)
+}
+
+func (h *Handler) changePassword(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var req accountRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if err := h.service.ChangePassword(ctx, req.CurrentPassword, req.Password); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeEmpty(w)
+}
+
+func (h *Handler) verifyEmail(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var req accountRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if err := h.service.VerifyEmail(ctx, req.Token); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeEmpty(w)
+}
+
+func (h *Handler) requestPasswordReset(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var req accountRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if err := h.service.RequestPasswordReset(ctx, req.Email); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeEmpty(w)
+}
+
+func (h *Handler) resetPassword(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var req accountRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if err := h.service.ResetPassword(ctx, req.Token, req.Password); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeEmpty(w)
+}
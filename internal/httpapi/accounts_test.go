@@ -0,0 +1,83 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (fakeService) SendEmailVerification(ctx context.Context) error {
+	if _, ok := auth.PrincipalFromContext(ctx); !ok {
+		return status.Error(codes.Unauthenticated, "Not signed in")
+	}
+
+	return nil
+}
+
+func (fakeService) VerifyEmail(ctx context.Context, token string) error {
+	if token != "token" {
+		return status.Error(codes.NotFound, "record not found")
+	}
+
+	return nil
+}
+
+func (fakeService) RequestPasswordReset(ctx context.Context, email string) error {
+	return nil
+}
+
+func (fakeService) ResetPassword(ctx context.Context, token string, password string) error {
+	if len(password) < 8 {
+		return status.Error(codes.InvalidArgument, "Password must be at least 8 characters")
+	}
+
+	return nil
+}
+
+func (fakeService) ChangePassword(ctx context.Context, currentPassword string, password string) error {
+	if currentPassword != "password" {
+		return status.Error(codes.PermissionDenied, "incorrect password")
+	}
+
+	return nil
+}
+
+func TestAccounts(t *testing.T) {
+	mux := newMux(t, NewHandler(fakeService{}, fakeAuth{}, nil))
+
+	t.Run("it should send the caller a verification email", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, call(mux, http.MethodPost, "/v1alpha1/account/email-verification", "child", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, call(mux, http.MethodPost, "/v1alpha1/account/email-verification", "", "").Code)
+	})
+
+	t.Run("it should verify an email address without a token", func(t *testing.T) {
+		w := call(mux, http.MethodPost, "/v1alpha1/account/verify-email", "", `{"token":"token"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{}`, w.Body.String())
+
+		assert.Equal(t, http.StatusNotFound, call(mux, http.MethodPost, "/v1alpha1/account/verify-email", "", `{"token":"other"}`).Code)
+	})
+
+	t.Run("it should request and complete a password reset without a token", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, call(mux, http.MethodPost, "/v1alpha1/account/password-reset", "", `{"email":"user@example.com"}`).Code)
+		assert.Equal(t, http.StatusOK, call(mux, http.MethodPost, "/v1alpha1/account/password-reset/complete", "", `{"token":"token","password":"new password"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, call(mux, http.MethodPost, "/v1alpha1/account/password-reset/complete", "", `{"token":"token","password":"short"}`).Code)
+	})
+
+	t.Run("it should change the caller's password", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, call(mux, http.MethodPost, "/v1alpha1/account/password", "child", `{"currentPassword":"password","password":"new password"}`).Code)
+		assert.Equal(t, http.StatusForbidden, call(mux, http.MethodPost, "/v1alpha1/account/password", "child", `{"currentPassword":"wrong","password":"new password"}`).Code)
+	})
+
+	t.Run("it should limit public routes", func(t *testing.T) {
+		limited := newMux(t, NewHandler(fakeService{}, fakeAuth{}, denyLimiter{}))
+
+		assert.Equal(t, http.StatusTooManyRequests, call(limited, http.MethodPost, "/v1alpha1/account/password-reset", "", `{"email":"user@example.com"}`).Code)
+	})
+}
@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+)
+
+// ClaimService lets children claim open tasks
+type ClaimService interface {
+	ListOpenTasks(ctx context.Context) ([]db.Task, error)
+	SetTaskOpen(ctx context.Context, taskID int32, open bool, claimDuration time.Duration, claimBonusPoints int32) (db.Task, error)
+	ClaimTask(ctx context.Context, taskID int32) (db.Task, error)
+}
+
+func (h *Handler) claimRoutes() []route {
+	return []route{
+		{http.MethodGet, "/v1alpha1/tasks/open", h.listOpenTasks},
+		{http.MethodPut, "/v1alpha1/tasks/{id}/claims", h.setTaskOpen},
+		{http.MethodPost, "/v1alpha1/tasks/{id}/claim", h.claimTask},
+	}
+}
+
+type taskClaimsRequest struct {
+	IsOpen               bool  `json:"isOpen"`
+	ClaimDurationSeconds int32 `json:"claimDurationSeconds"`
+	ClaimBonusPoints     int32 `json:"claimBonusPoints"`
+}
+
+func (h *Handler) listOpenTasks(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	tasks, err := h.service.ListOpenTasks(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, struct {
+		Tasks []task `json:"tasks"`
+	}{Tasks: tasksFromDB(tasks)})
+}
+
+func (h *Handler) setTaskOpen(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	var req taskClaimsRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	t, err := h.service.SetTaskOpen(ctx, id, req.IsOpen, time.Duration(req.ClaimDurationSeconds)*time.Second, req.ClaimBonusPoints)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, taskFromDB(t))
+}
+
+func (h *Handler) claimTask(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	t, err := h.service.ClaimTask(ctx, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, taskFromDB(t))
+}
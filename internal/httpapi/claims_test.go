@@ -0,0 +1,48 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (fakeService) ListOpenTasks(ctx context.Context) ([]db.Task, error) {
+	return []db.Task{{ID: 1, Name: "Dishes", Points: 10, IsOpen: true, ClaimDuration: time.Hour}}, nil
+}
+
+func (fakeService) SetTaskOpen(ctx context.Context, taskID int32, open bool, claimDuration time.Duration, claimBonusPoints int32) (db.Task, error) {
+	return db.Task{ID: taskID, IsOpen: open, ClaimDuration: claimDuration, ClaimBonusPoints: claimBonusPoints}, nil
+}
+
+func (fakeService) ClaimTask(ctx context.Context, taskID int32) (db.Task, error) {
+	return db.Task{}, status.Error(codes.FailedPrecondition, "task is not open or has already been claimed")
+}
+
+func TestClaims(t *testing.T) {
+	mux := newMux(t, NewHandler(fakeService{}, fakeAuth{}, nil))
+
+	t.Run("it should list open tasks", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/tasks/open", "child", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"isOpen":true,"claimDurationSeconds":3600`)
+	})
+
+	t.Run("it should open a task with its claim settings", func(t *testing.T) {
+		w := call(mux, http.MethodPut, "/v1alpha1/tasks/4/claims", "parent", `{"isOpen":true,"claimDurationSeconds":1800,"claimBonusPoints":5}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"id":4`)
+		assert.Contains(t, w.Body.String(), `"isOpen":true,"claimDurationSeconds":1800,"claimBonusPoints":5`)
+	})
+
+	t.Run("it should report a task which was already claimed", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, call(mux, http.MethodPost, "/v1alpha1/tasks/4/claim", "child", "").Code)
+	})
+}
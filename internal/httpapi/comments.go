@@ -0,0 +1,292 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/server"
+)
+
+// CommentService manages comments on tasks and feed entries
+type CommentService interface {
+	CreateComment(ctx context.Context, comment db.Comment) (db.Comment, error)
+	ListComments(ctx context.Context, taskID int32, taskFeedID int32, pageToken int32, pageSize int) (server.CommentPage, error)
+	EditComment(ctx context.Context, commentID int32, body string) (db.Comment, error)
+	DeleteComment(ctx context.Context, commentID int32) error
+	ListCommentRevisions(ctx context.Context, commentID int32) ([]db.CommentRevision, error)
+	AddReaction(ctx context.Context, commentID int32, emoji string) error
+	RemoveReaction(ctx context.Context, commentID int32, emoji string) error
+	ListMentions(ctx context.Context) ([]db.Mention, error)
+}
+
+func (h *Handler) commentRoutes() []route {
+	return []route{
+		{http.MethodPost, "/v1alpha1/comments", h.createComment},
+		{http.MethodGet, "/v1alpha1/tasks/{id}/comments", h.listTaskComments},
+		{http.MethodGet, "/v1alpha1/tasks-feed/{id}/comments", h.listTaskFeedComments},
+		{http.MethodPatch, "/v1alpha1/comments/{id}", h.editComment},
+		{http.MethodDelete, "/v1alpha1/comments/{id}", h.deleteComment},
+		{http.MethodGet, "/v1alpha1/comments/{id}/revisions", h.listCommentRevisions},
+		{http.MethodPost, "/v1alpha1/comments/{id}/reactions", h.addReaction},
+		{http.MethodDelete, "/v1alpha1/comments/{id}/reactions", h.removeReaction},
+		{http.MethodGet, "/v1alpha1/mentions", h.listMentions},
+	}
+}
+
+type comment struct {
+	ID         int32      `json:"id"`
+	TaskID     int32      `json:"taskId"`
+	TaskFeedID int32      `json:"taskFeedId"`
+	ParentID   int32      `json:"parentId"`
+	AuthorID   int32      `json:"authorId"`
+	Body       string     `json:"body"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	EditedAt   *time.Time `json:"editedAt"`
+	DeletedAt  *time.Time `json:"deletedAt"`
+}
+
+func commentFromDB(c db.Comment) comment {
+	return comment{
+		ID:         c.ID,
+		TaskID:     c.TaskID,
+		TaskFeedID: c.TaskFeedID,
+		ParentID:   c.ParentID,
+		AuthorID:   c.AuthorID,
+		Body:       c.Body,
+		CreatedAt:  c.CreatedAt,
+		EditedAt:   c.EditedAt,
+		DeletedAt:  c.DeletedAt,
+	}
+}
+
+type reactionCount struct {
+	CommentID int32  `json:"commentId"`
+	Emoji     string `json:"emoji"`
+	Count     int32  `json:"count"`
+}
+
+type commentPage struct {
+	Comments  []comment       `json:"comments"`
+	Reactions []reactionCount `json:"reactions"`
+	// NextPageToken is passed as pageToken to fetch the following page. It
+	// is zero when there are no more comments.
+	NextPageToken int32 `json:"nextPageToken"`
+}
+
+type commentRevision struct {
+	ID        int32     `json:"id"`
+	CommentID int32     `json:"commentId"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type mention struct {
+	CommentID int32     `json:"commentId"`
+	UserID    int32     `json:"userId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type commentRequest struct {
+	Body string `json:"body"`
+}
+
+type reactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+func (h *Handler) createComment(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var req comment
+	if !decode(w, r, &req) {
+		return
+	}
+
+	c, err := h.service.CreateComment(ctx, db.Comment{
+		TaskID:     req.TaskID,
+		TaskFeedID: req.TaskFeedID,
+		ParentID:   req.ParentID,
+		Body:       req.Body,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, commentFromDB(c))
+}
+
+func (h *Handler) listTaskComments(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	h.listComments(ctx, w, r, id, 0)
+}
+
+func (h *Handler) listTaskFeedComments(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	h.listComments(ctx, w, r, 0, id)
+}
+
+// listComments lists a page of the comments on a task or feed entry, read
+// from the pageToken and pageSize query parameters
+func (h *Handler) listComments(ctx context.Context, w http.ResponseWriter, r *http.Request, taskID int32, taskFeedID int32) {
+	var pageToken, pageSize int64
+
+	query := r.URL.Query()
+
+	if t := query.Get("pageToken"); t != "" {
+		var err error
+		if pageToken, err = strconv.ParseInt(t, 10, 32); err != nil {
+			http.Error(w, "Invalid pageToken", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if s := query.Get("pageSize"); s != "" {
+		var err error
+		if pageSize, err = strconv.ParseInt(s, 10, 32); err != nil {
+			http.Error(w, "Invalid pageSize", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := h.service.ListComments(ctx, taskID, taskFeedID, int32(pageToken), int(pageSize))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := commentPage{
+		Comments:      make([]comment, 0, len(page.Comments)),
+		Reactions:     make([]reactionCount, 0, len(page.Reactions)),
+		NextPageToken: page.NextPageToken,
+	}
+
+	for _, c := range page.Comments {
+		resp.Comments = append(resp.Comments, commentFromDB(c))
+	}
+
+	for _, rc := range page.Reactions {
+		resp.Reactions = append(resp.Reactions, reactionCount{CommentID: rc.CommentID, Emoji: rc.Emoji, Count: rc.Count})
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) editComment(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	var req commentRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	c, err := h.service.EditComment(ctx, id, req.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, commentFromDB(c))
+}
+
+func (h *Handler) deleteComment(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteComment(ctx, id); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeEmpty(w)
+}
+
+func (h *Handler) listCommentRevisions(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	revisions, err := h.service.ListCommentRevisions(ctx, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := struct {
+		Revisions []commentRevision `json:"revisions"`
+	}{Revisions: make([]commentRevision, 0, len(revisions))}
+
+	for _, rev := range revisions {
+		resp.Revisions = append(resp.Revisions, commentRevision{ID: rev.ID, CommentID: rev.CommentID, Body: rev.Body, CreatedAt: rev.CreatedAt})
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) addReaction(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	var req reactionRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if err := h.service.AddReaction(ctx, id, req.Emoji); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeEmpty(w)
+}
+
+// removeReaction takes the emoji from the query, as DELETE requests have no
+// body
+func (h *Handler) removeReaction(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.service.RemoveReaction(ctx, id, r.URL.Query().Get("emoji")); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeEmpty(w)
+}
+
+func (h *Handler) listMentions(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	mentions, err := h.service.ListMentions(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := struct {
+		Mentions []mention `json:"mentions"`
+	}{Mentions: make([]mention, 0, len(mentions))}
+
+	for _, m := range mentions {
+		resp.Mentions = append(resp.Mentions, mention{CommentID: m.CommentID, UserID: m.UserID, CreatedAt: m.CreatedAt})
+	}
+
+	writeJSON(w, resp)
+}
@@ -0,0 +1,127 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/server"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var commentedAt = time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+
+func (fakeService) CreateComment(ctx context.Context, comment db.Comment) (db.Comment, error) {
+	comment.ID = 7
+	comment.AuthorID = 2
+	comment.CreatedAt = commentedAt
+
+	return comment, nil
+}
+
+func (fakeService) ListComments(ctx context.Context, taskID int32, taskFeedID int32, pageToken int32, pageSize int) (server.CommentPage, error) {
+	return server.CommentPage{
+		Comments:      []db.Comment{{ID: pageToken + 1, TaskID: taskID, TaskFeedID: taskFeedID, AuthorID: 2, Body: "Done?", CreatedAt: commentedAt}},
+		Reactions:     []db.ReactionCount{{CommentID: pageToken + 1, Emoji: "👍", Count: int32(pageSize)}},
+		NextPageToken: pageToken + 1,
+	}, nil
+}
+
+func (fakeService) EditComment(ctx context.Context, commentID int32, body string) (db.Comment, error) {
+	return db.Comment{ID: commentID, Body: body, CreatedAt: commentedAt, EditedAt: &commentedAt}, nil
+}
+
+func (fakeService) DeleteComment(ctx context.Context, commentID int32) error {
+	return status.Error(codes.PermissionDenied, "Only the author can delete a comment")
+}
+
+func (fakeService) ListCommentRevisions(ctx context.Context, commentID int32) ([]db.CommentRevision, error) {
+	return []db.CommentRevision{{ID: 1, CommentID: commentID, Body: "Dnoe?", CreatedAt: commentedAt}}, nil
+}
+
+func (fakeService) AddReaction(ctx context.Context, commentID int32, emoji string) error {
+	return nil
+}
+
+func (fakeService) RemoveReaction(ctx context.Context, commentID int32, emoji string) error {
+	if emoji != "👍" {
+		return status.Error(codes.NotFound, "record not found")
+	}
+
+	return nil
+}
+
+func (fakeService) ListMentions(ctx context.Context) ([]db.Mention, error) {
+	return []db.Mention{{CommentID: 7, UserID: 2, CreatedAt: commentedAt}}, nil
+}
+
+func TestComments(t *testing.T) {
+	mux := newMux(t, NewHandler(fakeService{}, fakeAuth{}, nil))
+
+	t.Run("it should create a comment", func(t *testing.T) {
+		w := call(mux, http.MethodPost, "/v1alpha1/comments", "child", `{"taskFeedId":5,"body":"Done!"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"id":7,"taskId":0,"taskFeedId":5,"parentId":0,"authorId":2,"body":"Done!","createdAt":"2021-03-01T09:00:00Z","editedAt":null,"deletedAt":null}`, w.Body.String())
+	})
+
+	t.Run("it should list a page of comments with their reactions", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/tasks-feed/5/comments?pageToken=3&pageSize=10", "child", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{
+			"comments":[{"id":4,"taskId":0,"taskFeedId":5,"parentId":0,"authorId":2,"body":"Done?","createdAt":"2021-03-01T09:00:00Z","editedAt":null,"deletedAt":null}],
+			"reactions":[{"commentId":4,"emoji":"👍","count":10}],
+			"nextPageToken":4
+		}`, w.Body.String())
+	})
+
+	t.Run("it should list the comments on a task", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/tasks/3/comments", "child", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"taskId":3,"taskFeedId":0`)
+	})
+
+	t.Run("it should refuse an invalid page token", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, call(mux, http.MethodGet, "/v1alpha1/tasks/3/comments?pageToken=x", "child", "").Code)
+	})
+
+	t.Run("it should edit a comment", func(t *testing.T) {
+		w := call(mux, http.MethodPatch, "/v1alpha1/comments/7", "child", `{"body":"Done."}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"body":"Done.","createdAt":"2021-03-01T09:00:00Z","editedAt":"2021-03-01T09:00:00Z"`)
+	})
+
+	t.Run("it should map service errors to HTTP statuses", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, call(mux, http.MethodDelete, "/v1alpha1/comments/7", "child", "").Code)
+	})
+
+	t.Run("it should list revisions", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/comments/7/revisions", "child", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"revisions":[{"id":1,"commentId":7,"body":"Dnoe?","createdAt":"2021-03-01T09:00:00Z"}]}`, w.Body.String())
+	})
+
+	t.Run("it should add and remove reactions", func(t *testing.T) {
+		w := call(mux, http.MethodPost, "/v1alpha1/comments/7/reactions", "child", `{"emoji":"👍"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{}`, w.Body.String())
+
+		assert.Equal(t, http.StatusOK, call(mux, http.MethodDelete, "/v1alpha1/comments/7/reactions?emoji=%F0%9F%91%8D", "child", "").Code)
+		assert.Equal(t, http.StatusNotFound, call(mux, http.MethodDelete, "/v1alpha1/comments/7/reactions?emoji=x", "child", "").Code)
+	})
+
+	t.Run("it should list mentions", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/mentions", "child", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"mentions":[{"commentId":7,"userId":2,"createdAt":"2021-03-01T09:00:00Z"}]}`, w.Body.String())
+	})
+}
@@ -0,0 +1,107 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+)
+
+// DeadlineService sets task deadlines and reports what was late
+type DeadlineService interface {
+	SetTaskDeadline(ctx context.Context, taskID int32, dueIn time.Duration, latePenaltyPercent int32, missedPenaltyPoints int32) (db.Task, error)
+	ListOverdue(ctx context.Context) ([]db.TaskFeed, error)
+	ListPointsAdjustments(ctx context.Context, userID int32) ([]db.PointsAdjustment, error)
+}
+
+func (h *Handler) deadlineRoutes() []route {
+	return []route{
+		{http.MethodPut, "/v1alpha1/tasks/{id}/deadline", h.setTaskDeadline},
+		{http.MethodGet, "/v1alpha1/tasks-feed/overdue", h.listOverdue},
+		{http.MethodGet, "/v1alpha1/users/{userId}/points-adjustments", h.listPointsAdjustments},
+	}
+}
+
+type deadlineRequest struct {
+	DueInSeconds        int32 `json:"dueInSeconds"`
+	LatePenaltyPercent  int32 `json:"latePenaltyPercent"`
+	MissedPenaltyPoints int32 `json:"missedPenaltyPoints"`
+}
+
+type pointsAdjustment struct {
+	ID         int32     `json:"id"`
+	UserID     int32     `json:"userId"`
+	TaskFeedID int32     `json:"taskFeedId"`
+	Points     int32     `json:"points"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func (h *Handler) setTaskDeadline(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	var req deadlineRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	t, err := h.service.SetTaskDeadline(ctx, id, time.Duration(req.DueInSeconds)*time.Second, req.LatePenaltyPercent, req.MissedPenaltyPoints)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, taskFromDB(t))
+}
+
+func (h *Handler) listOverdue(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	overdue, err := h.service.ListOverdue(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := struct {
+		TasksFeed []taskFeed `json:"tasksFeed"`
+	}{TasksFeed: make([]taskFeed, 0, len(overdue))}
+
+	for _, tf := range overdue {
+		resp.TasksFeed = append(resp.TasksFeed, taskFeedFromDB(tf))
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) listPointsAdjustments(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	userID, ok := pathID(w, pathParams, "userId")
+	if !ok {
+		return
+	}
+
+	adjustments, err := h.service.ListPointsAdjustments(ctx, userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := struct {
+		Adjustments []pointsAdjustment `json:"adjustments"`
+	}{Adjustments: make([]pointsAdjustment, 0, len(adjustments))}
+
+	for _, a := range adjustments {
+		resp.Adjustments = append(resp.Adjustments, pointsAdjustment{
+			ID:         a.ID,
+			UserID:     a.UserID,
+			TaskFeedID: a.TaskFeedID,
+			Points:     a.Points,
+			Reason:     a.Reason,
+			CreatedAt:  a.CreatedAt,
+		})
+	}
+
+	writeJSON(w, resp)
+}
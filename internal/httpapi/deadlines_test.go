@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (fakeService) SetTaskDeadline(ctx context.Context, taskID int32, dueIn time.Duration, latePenaltyPercent int32, missedPenaltyPoints int32) (db.Task, error) {
+	if latePenaltyPercent > 100 {
+		return db.Task{}, status.Error(codes.InvalidArgument, "Late penalty must be between 0 and 100 percent")
+	}
+
+	return db.Task{ID: taskID, DueIn: dueIn, LatePenaltyPercent: latePenaltyPercent, MissedPenaltyPoints: missedPenaltyPoints}, nil
+}
+
+func (fakeService) ListOverdue(ctx context.Context) ([]db.TaskFeed, error) {
+	p, _ := auth.PrincipalFromContext(ctx)
+	if !p.IsParent() {
+		return nil, status.Error(codes.PermissionDenied, "Only parents can list overdue tasks")
+	}
+
+	dueAt := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	return []db.TaskFeed{{ID: 5, AssigneeID: 2, TaskID: 3, Points: 10, DueAt: &dueAt, IsOverdue: true}}, nil
+}
+
+func (fakeService) ListPointsAdjustments(ctx context.Context, userID int32) ([]db.PointsAdjustment, error) {
+	return []db.PointsAdjustment{{ID: 1, UserID: userID, TaskFeedID: 5, Points: -3, Reason: "Missed Dishes", CreatedAt: time.Date(2021, 3, 2, 9, 0, 0, 0, time.UTC)}}, nil
+}
+
+func TestDeadlines(t *testing.T) {
+	mux := newMux(t, NewHandler(fakeService{}, fakeAuth{}, nil))
+
+	t.Run("it should set a task's deadline and penalties", func(t *testing.T) {
+		w := call(mux, http.MethodPut, "/v1alpha1/tasks/3/deadline", "parent", `{"dueInSeconds":86400,"latePenaltyPercent":50,"missedPenaltyPoints":3}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"dueInSeconds":86400,"latePenaltyPercent":50,"missedPenaltyPoints":3`)
+	})
+
+	t.Run("it should refuse an invalid penalty", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, call(mux, http.MethodPut, "/v1alpha1/tasks/3/deadline", "parent", `{"latePenaltyPercent":150}`).Code)
+	})
+
+	t.Run("it should list overdue entries", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/tasks-feed/overdue", "parent", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"tasksFeed":[{"id":5,"assigneeId":2,"taskId":3,"isComplete":false,"isApproved":false,"completedAt":null,"points":10,"dueAt":"2021-03-01T09:00:00Z","isOverdue":true,"isMissed":false}]}`, w.Body.String())
+	})
+
+	t.Run("it should only list overdue entries for parents", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, call(mux, http.MethodGet, "/v1alpha1/tasks-feed/overdue", "child", "").Code)
+	})
+
+	t.Run("it should list points adjustments", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/users/2/points-adjustments", "child", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"adjustments":[{"id":1,"userId":2,"taskFeedId":5,"points":-3,"reason":"Missed Dishes","createdAt":"2021-03-02T09:00:00Z"}]}`, w.Body.String())
+	})
+}
@@ -0,0 +1,142 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/chorerewards/backend/internal/db"
+)
+
+// GoalService manages savings goals
+type GoalService interface {
+	CreateGoal(ctx context.Context, goal db.Goal) (db.Goal, error)
+	ListGoals(ctx context.Context, userID int32) ([]db.Goal, error)
+	AllocateToGoal(ctx context.Context, goalID int32, points int32) (db.Goal, error)
+	ContributeToGoal(ctx context.Context, goalID int32, points int32) (db.Goal, error)
+	RedeemGoal(ctx context.Context, goalID int32) (db.Goal, error)
+}
+
+func (h *Handler) goalRoutes() []route {
+	return []route{
+		{http.MethodPost, "/v1alpha1/goals", h.createGoal},
+		{http.MethodGet, "/v1alpha1/users/{userId}/goals", h.listGoals},
+		{http.MethodPost, "/v1alpha1/goals/{id}/allocate", h.allocateToGoal},
+		{http.MethodPost, "/v1alpha1/goals/{id}/contribute", h.contributeToGoal},
+		{http.MethodPost, "/v1alpha1/goals/{id}/redeem", h.redeemGoal},
+	}
+}
+
+type goal struct {
+	ID              int32  `json:"id"`
+	UserID          int32  `json:"userId"`
+	RewardID        int32  `json:"rewardId"`
+	Name            string `json:"name"`
+	TargetPoints    int32  `json:"targetPoints"`
+	AllocatedPoints int32  `json:"allocatedPoints"`
+	// Progress is the percentage of the target allocated
+	Progress   int32 `json:"progress"`
+	IsRedeemed bool  `json:"isRedeemed"`
+}
+
+func goalFromDB(g db.Goal) goal {
+	return goal{
+		ID:              g.ID,
+		UserID:          g.UserID,
+		RewardID:        g.RewardID,
+		Name:            g.Name,
+		TargetPoints:    g.TargetPoints,
+		AllocatedPoints: g.AllocatedPoints,
+		Progress:        g.Progress(),
+		IsRedeemed:      g.IsRedeemed,
+	}
+}
+
+type pointsRequest struct {
+	Points int32 `json:"points"`
+}
+
+func (h *Handler) createGoal(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var req goal
+	if !decode(w, r, &req) {
+		return
+	}
+
+	g, err := h.service.CreateGoal(ctx, db.Goal{
+		RewardID:     req.RewardID,
+		Name:         req.Name,
+		TargetPoints: req.TargetPoints,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, goalFromDB(g))
+}
+
+func (h *Handler) listGoals(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	userID, ok := pathID(w, pathParams, "userId")
+	if !ok {
+		return
+	}
+
+	goals, err := h.service.ListGoals(ctx, userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := struct {
+		Goals []goal `json:"goals"`
+	}{Goals: make([]goal, 0, len(goals))}
+
+	for _, g := range goals {
+		resp.Goals = append(resp.Goals, goalFromDB(g))
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) allocateToGoal(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	h.changeGoalPoints(ctx, w, r, pathParams, h.service.AllocateToGoal)
+}
+
+func (h *Handler) contributeToGoal(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	h.changeGoalPoints(ctx, w, r, pathParams, h.service.ContributeToGoal)
+}
+
+// changeGoalPoints adds the points in the request to a goal with change
+func (h *Handler) changeGoalPoints(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string, change func(context.Context, int32, int32) (db.Goal, error)) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	var req pointsRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	g, err := change(ctx, id, req.Points)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, goalFromDB(g))
+}
+
+func (h *Handler) redeemGoal(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	g, err := h.service.RedeemGoal(ctx, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, goalFromDB(g))
+}
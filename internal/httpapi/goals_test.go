@@ -0,0 +1,73 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (fakeService) CreateGoal(ctx context.Context, goal db.Goal) (db.Goal, error) {
+	p, _ := auth.PrincipalFromContext(ctx)
+
+	goal.ID = 1
+	goal.UserID = p.UserID
+
+	return goal, nil
+}
+
+func (fakeService) ListGoals(ctx context.Context, userID int32) ([]db.Goal, error) {
+	return []db.Goal{{ID: 1, UserID: userID, Name: "Bike", TargetPoints: 100, AllocatedPoints: 25}}, nil
+}
+
+func (fakeService) AllocateToGoal(ctx context.Context, goalID int32, points int32) (db.Goal, error) {
+	return db.Goal{ID: goalID, TargetPoints: 100, AllocatedPoints: points}, nil
+}
+
+func (fakeService) ContributeToGoal(ctx context.Context, goalID int32, points int32) (db.Goal, error) {
+	p, _ := auth.PrincipalFromContext(ctx)
+	if !p.IsParent() {
+		return db.Goal{}, status.Error(codes.PermissionDenied, "Only parents can contribute to goals")
+	}
+
+	return db.Goal{ID: goalID, TargetPoints: 100, AllocatedPoints: points}, nil
+}
+
+func (fakeService) RedeemGoal(ctx context.Context, goalID int32) (db.Goal, error) {
+	return db.Goal{}, status.Error(codes.FailedPrecondition, "goal has not reached its target")
+}
+
+func TestGoals(t *testing.T) {
+	mux := newMux(t, NewHandler(fakeService{}, fakeAuth{}, nil))
+
+	t.Run("it should create a goal for the caller", func(t *testing.T) {
+		w := call(mux, http.MethodPost, "/v1alpha1/goals", "child", `{"name":"Bike","targetPoints":100,"userId":9}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"id":1,"userId":2,"rewardId":0,"name":"Bike","targetPoints":100,"allocatedPoints":0,"progress":0,"isRedeemed":false}`, w.Body.String())
+	})
+
+	t.Run("it should list a user's goals with their progress", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/users/2/goals", "child", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"goals":[{"id":1,"userId":2,"rewardId":0,"name":"Bike","targetPoints":100,"allocatedPoints":25,"progress":25,"isRedeemed":false}]}`, w.Body.String())
+	})
+
+	t.Run("it should allocate points to a goal", func(t *testing.T) {
+		w := call(mux, http.MethodPost, "/v1alpha1/goals/3/allocate", "child", `{"points":50}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"allocatedPoints":50`)
+	})
+
+	t.Run("it should map service errors to HTTP statuses", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, call(mux, http.MethodPost, "/v1alpha1/goals/3/contribute", "child", `{"points":5}`).Code)
+		assert.Equal(t, http.StatusBadRequest, call(mux, http.MethodPost, "/v1alpha1/goals/3/redeem", "child", "").Code)
+	})
+}
@@ -0,0 +1,164 @@
+// Package httpapi serves the methods of the server which aren't part of the
+// gRPC API as JSON routes on the HTTP proxy. Routes act as the caller of their
+// bearer token, like those generated by grpc-gateway, and are limited by
+// client address as gRPC calls are by the interceptor.
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chorerewards/backend/internal/interceptors"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/status"
+)
+
+// maxRequestSize limits request bodies, which only hold a few fields
+const maxRequestSize = 64 << 10
+
+// Service is the part of the server served by the handler
+type Service interface {
+	GoalService
+	ClaimService
+	RotationService
+	DeadlineService
+	CommentService
+	NotificationService
+	WebhookService
+	AccountService
+	HouseholdService
+}
+
+// Authenticator authenticates the bearer token of a request, returning a
+// context carrying its principal
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (context.Context, error)
+}
+
+// Limiter limits the rate of requests from each client address
+type Limiter interface {
+	Allow(key string) bool
+}
+
+// Handler serves the routes alongside the routes generated by grpc-gateway
+type Handler struct {
+	service Service
+	auth    Authenticator
+	limiter Limiter
+}
+
+func NewHandler(service Service, auth Authenticator, limiter Limiter) *Handler {
+	return &Handler{
+		service: service,
+		auth:    auth,
+		limiter: limiter,
+	}
+}
+
+// handlerFunc handles a request with the context of its caller
+type handlerFunc func(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string)
+
+type route struct {
+	method  string
+	path    string
+	handler handlerFunc
+}
+
+// Register adds the routes to the gateway mux
+func (h *Handler) Register(mux *runtime.ServeMux) error {
+	var routes []route
+	routes = append(routes, h.goalRoutes()...)
+	routes = append(routes, h.claimRoutes()...)
+	routes = append(routes, h.rotationRoutes()...)
+	routes = append(routes, h.deadlineRoutes()...)
+	routes = append(routes, h.commentRoutes()...)
+	routes = append(routes, h.notificationRoutes()...)
+	routes = append(routes, h.webhookRoutes()...)
+	routes = append(routes, h.accountRoutes()...)
+
+	// Public routes can be called without a token
+	var public []route
+	public = append(public, h.publicAccountRoutes()...)
+	public = append(public, h.publicHouseholdRoutes()...)
+
+	for _, rt := range routes {
+		if err := mux.HandlePath(rt.method, rt.path, h.serve(rt, true)); err != nil {
+			return err
+		}
+	}
+
+	for _, rt := range public {
+		if err := mux.HandlePath(rt.method, rt.path, h.serve(rt, false)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serve limits the requests to a route, and authenticates them unless the
+// route is public, before handling them
+func (h *Handler) serve(rt route, authenticate bool) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		ctx := interceptors.HTTPContext(r)
+
+		if h.limiter != nil && !h.limiter.Allow(interceptors.ClientAddress(ctx)) {
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		if authenticate {
+			var err error
+			if ctx, err = h.auth.Authenticate(ctx, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		rt.handler(ctx, w, r, pathParams)
+	}
+}
+
+// decode reads a JSON request body into v, or writes an error and returns
+// false
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(v); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+// pathID parses an ID in the path, or writes an error and returns false
+func pathID(w http.ResponseWriter, pathParams map[string]string, name string) (int32, bool) {
+	id, err := strconv.ParseInt(pathParams[name], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid "+name, http.StatusBadRequest)
+		return 0, false
+	}
+
+	return int32(id), true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeEmpty responds to a call which returns nothing, as the gateway does
+// for methods returning Empty
+func writeEmpty(w http.ResponseWriter) {
+	writeJSON(w, struct{}{})
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+
+	http.Error(w, s.Message(), runtime.HTTPStatusFromCode(s.Code()))
+}
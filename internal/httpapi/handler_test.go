@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeAuth struct{}
+
+func (fakeAuth) Authenticate(ctx context.Context, token string) (context.Context, error) {
+	switch token {
+	case "parent":
+		return auth.ContextWithPrincipal(ctx, auth.Principal{UserID: 1, HouseholdID: 1, Roles: []string{auth.RoleParent}}), nil
+	case "child":
+		return auth.ContextWithPrincipal(ctx, auth.Principal{UserID: 2, HouseholdID: 1}), nil
+	}
+
+	return nil, status.Error(codes.Unauthenticated, "Invalid token")
+}
+
+// fakeService implements Service, with the methods for each group of routes
+// in that group's tests
+type fakeService struct{}
+
+type denyLimiter struct{}
+
+func (denyLimiter) Allow(key string) bool { return false }
+
+func newMux(t *testing.T, h *Handler) *runtime.ServeMux {
+	mux := runtime.NewServeMux()
+	assert.NoError(t, h.Register(mux))
+
+	return mux
+}
+
+func call(mux http.Handler, method string, path string, token string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	mux := newMux(t, NewHandler(fakeService{}, fakeAuth{}, nil))
+
+	t.Run("it should refuse requests without a token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, call(mux, http.MethodGet, "/v1alpha1/users/2/goals", "", "").Code)
+	})
+
+	t.Run("it should refuse invalid IDs", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, call(mux, http.MethodGet, "/v1alpha1/users/x/goals", "child", "").Code)
+	})
+
+	t.Run("it should refuse invalid bodies", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, call(mux, http.MethodPost, "/v1alpha1/goals", "child", "{").Code)
+	})
+
+	t.Run("it should not write responses to caches", func(t *testing.T) {
+		assert.Equal(t, "no-store", call(mux, http.MethodGet, "/v1alpha1/users/2/goals", "child", "").Header().Get("Cache-Control"))
+	})
+
+	t.Run("it should limit requests", func(t *testing.T) {
+		limited := newMux(t, NewHandler(fakeService{}, fakeAuth{}, denyLimiter{}))
+
+		assert.Equal(t, http.StatusTooManyRequests, call(limited, http.MethodGet, "/v1alpha1/users/2/goals", "child", "").Code)
+	})
+}
@@ -0,0 +1,65 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/chorerewards/backend/internal/db"
+)
+
+// HouseholdService signs up new households
+type HouseholdService interface {
+	SignUp(ctx context.Context, householdName string, user db.User, password string) (db.User, error)
+}
+
+// publicHouseholdRoutes are used by those who don't have an account yet
+func (h *Handler) publicHouseholdRoutes() []route {
+	return []route{
+		{http.MethodPost, "/v1alpha1/signup", h.signUp},
+	}
+}
+
+type signUpRequest struct {
+	Household string `json:"household"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	Avatar    string `json:"avatar"`
+}
+
+type user struct {
+	ID          int32  `json:"id"`
+	HouseholdID int32  `json:"householdId"`
+	Username    string `json:"username"`
+	Email       string `json:"email"`
+	IsAdmin     bool   `json:"isAdmin"`
+	IsParent    bool   `json:"isParent"`
+	Avatar      string `json:"avatar"`
+}
+
+func (h *Handler) signUp(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var req signUpRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	u, err := h.service.SignUp(ctx, req.Household, db.User{
+		Username: req.Username,
+		Email:    req.Email,
+		Avatar:   req.Avatar,
+	}, req.Password)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, user{
+		ID:          u.ID,
+		HouseholdID: u.HouseholdID,
+		Username:    u.Username,
+		Email:       u.Email,
+		IsAdmin:     u.IsAdmin,
+		IsParent:    u.IsParent,
+		Avatar:      u.Avatar,
+	})
+}
@@ -0,0 +1,44 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (fakeService) SignUp(ctx context.Context, householdName string, user db.User, password string) (db.User, error) {
+	if householdName == "" {
+		return db.User{}, status.Error(codes.InvalidArgument, "Household name cannot be empty")
+	}
+
+	user.ID = 3
+	user.HouseholdID = 2
+	user.IsAdmin = true
+	user.IsParent = true
+
+	return user, nil
+}
+
+func TestHouseholds(t *testing.T) {
+	mux := newMux(t, NewHandler(fakeService{}, fakeAuth{}, nil))
+
+	t.Run("it should sign up a household without a token", func(t *testing.T) {
+		w := call(mux, http.MethodPost, "/v1alpha1/signup", "", `{"household":"Smiths","username":"parent","email":"parent@example.com","password":"password"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"id":3,"householdId":2,"username":"parent","email":"parent@example.com","isAdmin":true,"isParent":true,"avatar":""}`, w.Body.String())
+
+		assert.Equal(t, http.StatusBadRequest, call(mux, http.MethodPost, "/v1alpha1/signup", "", `{"username":"parent","password":"password"}`).Code)
+	})
+
+	t.Run("it should limit sign ups", func(t *testing.T) {
+		limited := newMux(t, NewHandler(fakeService{}, fakeAuth{}, denyLimiter{}))
+
+		assert.Equal(t, http.StatusTooManyRequests, call(limited, http.MethodPost, "/v1alpha1/signup", "", `{"household":"Smiths"}`).Code)
+	})
+}
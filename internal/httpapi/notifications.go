@@ -0,0 +1,126 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/chorerewards/backend/internal/db"
+)
+
+// NotificationService completes and approves feed entries, which notifies
+// the household, and manages how the caller is notified
+type NotificationService interface {
+	CompleteTaskFeed(ctx context.Context, taskFeedID int32) (db.TaskFeed, error)
+	ApproveTaskFeed(ctx context.Context, taskFeedID int32) (db.TaskFeed, error)
+	SetNotificationPreference(ctx context.Context, preference db.NotificationPreference) (db.NotificationPreference, error)
+	ListNotificationPreferences(ctx context.Context) ([]db.NotificationPreference, error)
+	SetQuietHours(ctx context.Context, settings db.NotificationSettings) (db.NotificationSettings, error)
+}
+
+func (h *Handler) notificationRoutes() []route {
+	return []route{
+		{http.MethodPost, "/v1alpha1/tasks-feed/{id}/complete", h.completeTaskFeed},
+		{http.MethodPost, "/v1alpha1/tasks-feed/{id}/approve", h.approveTaskFeed},
+		{http.MethodGet, "/v1alpha1/notifications/preferences", h.listNotificationPreferences},
+		{http.MethodPut, "/v1alpha1/notifications/preferences/{channel}", h.setNotificationPreference},
+		{http.MethodPut, "/v1alpha1/notifications/quiet-hours", h.setQuietHours},
+	}
+}
+
+type notificationPreference struct {
+	Channel string `json:"channel"`
+	Address string `json:"address"`
+	Enabled bool   `json:"enabled"`
+}
+
+type quietHours struct {
+	// QuietHoursStart and QuietHoursEnd are minutes after midnight in
+	// TimeZone
+	QuietHoursStart int32  `json:"quietHoursStart"`
+	QuietHoursEnd   int32  `json:"quietHoursEnd"`
+	TimeZone        string `json:"timeZone"`
+}
+
+func (h *Handler) completeTaskFeed(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	h.updateTaskFeed(ctx, w, pathParams, h.service.CompleteTaskFeed)
+}
+
+func (h *Handler) approveTaskFeed(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	h.updateTaskFeed(ctx, w, pathParams, h.service.ApproveTaskFeed)
+}
+
+// updateTaskFeed applies update to the feed entry in the path
+func (h *Handler) updateTaskFeed(ctx context.Context, w http.ResponseWriter, pathParams map[string]string, update func(context.Context, int32) (db.TaskFeed, error)) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	tf, err := update(ctx, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, taskFeedFromDB(tf))
+}
+
+func (h *Handler) listNotificationPreferences(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	preferences, err := h.service.ListNotificationPreferences(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := struct {
+		Preferences []notificationPreference `json:"preferences"`
+	}{Preferences: make([]notificationPreference, 0, len(preferences))}
+
+	for _, p := range preferences {
+		resp.Preferences = append(resp.Preferences, notificationPreference{Channel: p.Channel, Address: p.Address, Enabled: p.Enabled})
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) setNotificationPreference(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var req notificationPreference
+	if !decode(w, r, &req) {
+		return
+	}
+
+	p, err := h.service.SetNotificationPreference(ctx, db.NotificationPreference{
+		Channel: pathParams["channel"],
+		Address: req.Address,
+		Enabled: req.Enabled,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, notificationPreference{Channel: p.Channel, Address: p.Address, Enabled: p.Enabled})
+}
+
+func (h *Handler) setQuietHours(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var req quietHours
+	if !decode(w, r, &req) {
+		return
+	}
+
+	settings, err := h.service.SetQuietHours(ctx, db.NotificationSettings{
+		QuietHoursStart: req.QuietHoursStart,
+		QuietHoursEnd:   req.QuietHoursEnd,
+		TimeZone:        req.TimeZone,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, quietHours{
+		QuietHoursStart: settings.QuietHoursStart,
+		QuietHoursEnd:   settings.QuietHoursEnd,
+		TimeZone:        settings.TimeZone,
+	})
+}
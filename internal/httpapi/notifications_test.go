@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (fakeService) CompleteTaskFeed(ctx context.Context, taskFeedID int32) (db.TaskFeed, error) {
+	return db.TaskFeed{ID: taskFeedID, AssigneeID: 2, TaskID: 3, IsComplete: true, Points: 10}, nil
+}
+
+func (fakeService) ApproveTaskFeed(ctx context.Context, taskFeedID int32) (db.TaskFeed, error) {
+	p, _ := auth.PrincipalFromContext(ctx)
+	if !p.IsParent() {
+		return db.TaskFeed{}, status.Error(codes.PermissionDenied, "Only parents can approve tasks")
+	}
+
+	return db.TaskFeed{ID: taskFeedID, IsComplete: true, IsApproved: true}, nil
+}
+
+func (fakeService) SetNotificationPreference(ctx context.Context, preference db.NotificationPreference) (db.NotificationPreference, error) {
+	if preference.Channel != "email" {
+		return db.NotificationPreference{}, status.Errorf(codes.InvalidArgument, "Unknown channel %q", preference.Channel)
+	}
+
+	return preference, nil
+}
+
+func (fakeService) ListNotificationPreferences(ctx context.Context) ([]db.NotificationPreference, error) {
+	return []db.NotificationPreference{{Channel: "email", Address: "child@example.com", Enabled: true}}, nil
+}
+
+func (fakeService) SetQuietHours(ctx context.Context, settings db.NotificationSettings) (db.NotificationSettings, error) {
+	if settings.TimeZone == "" {
+		settings.TimeZone = "UTC"
+	}
+
+	return settings, nil
+}
+
+func TestNotifications(t *testing.T) {
+	mux := newMux(t, NewHandler(fakeService{}, fakeAuth{}, nil))
+
+	t.Run("it should complete a feed entry", func(t *testing.T) {
+		w := call(mux, http.MethodPost, "/v1alpha1/tasks-feed/5/complete", "child", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"id":5,"assigneeId":2,"taskId":3,"isComplete":true`)
+	})
+
+	t.Run("it should only let parents approve feed entries", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, call(mux, http.MethodPost, "/v1alpha1/tasks-feed/5/approve", "child", "").Code)
+		assert.Equal(t, http.StatusOK, call(mux, http.MethodPost, "/v1alpha1/tasks-feed/5/approve", "parent", "").Code)
+	})
+
+	t.Run("it should set a preference for the channel in the path", func(t *testing.T) {
+		w := call(mux, http.MethodPut, "/v1alpha1/notifications/preferences/email", "child", `{"address":"child@example.com","enabled":true}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"channel":"email","address":"child@example.com","enabled":true}`, w.Body.String())
+
+		assert.Equal(t, http.StatusBadRequest, call(mux, http.MethodPut, "/v1alpha1/notifications/preferences/pigeon", "child", `{}`).Code)
+	})
+
+	t.Run("it should list preferences", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/notifications/preferences", "child", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"preferences":[{"channel":"email","address":"child@example.com","enabled":true}]}`, w.Body.String())
+	})
+
+	t.Run("it should set quiet hours", func(t *testing.T) {
+		w := call(mux, http.MethodPut, "/v1alpha1/notifications/quiet-hours", "child", `{"quietHoursStart":1260,"quietHoursEnd":420}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"quietHoursStart":1260,"quietHoursEnd":420,"timeZone":"UTC"}`, w.Body.String())
+	})
+}
@@ -0,0 +1,131 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+)
+
+// defaultUpcomingCount is how many upcoming occurrences of a rotation are
+// listed when the request doesn't say
+const defaultUpcomingCount = 5
+
+// RotationService shares repeating tasks between members of a household
+type RotationService interface {
+	CreateRotation(ctx context.Context, rotation db.Rotation) (db.Rotation, error)
+	SwapRotationParticipants(ctx context.Context, rotationID int32, firstUserID int32, secondUserID int32) (db.Rotation, error)
+	ListUpcomingRotation(ctx context.Context, rotationID int32, count int) ([]db.RotationOccurrence, error)
+}
+
+func (h *Handler) rotationRoutes() []route {
+	return []route{
+		{http.MethodPost, "/v1alpha1/rotations", h.createRotation},
+		{http.MethodPost, "/v1alpha1/rotations/{id}/swap", h.swapRotationParticipants},
+		{http.MethodGet, "/v1alpha1/rotations/{id}/upcoming", h.listUpcomingRotation},
+	}
+}
+
+type rotation struct {
+	ID             int32   `json:"id"`
+	TaskID         int32   `json:"taskId"`
+	ParticipantIDs []int32 `json:"participantIds"`
+	CadenceSeconds int32   `json:"cadenceSeconds"`
+	// NextRunAt is when the next occurrence is added to the feed. When
+	// creating a rotation it defaults to now.
+	NextRunAt time.Time `json:"nextRunAt"`
+}
+
+func rotationFromDB(r db.Rotation) rotation {
+	return rotation{
+		ID:             r.ID,
+		TaskID:         r.TaskID,
+		ParticipantIDs: r.ParticipantIDs,
+		CadenceSeconds: int32(r.Cadence / time.Second),
+		NextRunAt:      r.NextRunAt,
+	}
+}
+
+type swapRequest struct {
+	FirstUserID  int32 `json:"firstUserId"`
+	SecondUserID int32 `json:"secondUserId"`
+}
+
+type occurrence struct {
+	UserID int32     `json:"userId"`
+	At     time.Time `json:"at"`
+}
+
+func (h *Handler) createRotation(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var req rotation
+	if !decode(w, r, &req) {
+		return
+	}
+
+	rot, err := h.service.CreateRotation(ctx, db.Rotation{
+		TaskID:         req.TaskID,
+		ParticipantIDs: req.ParticipantIDs,
+		Cadence:        time.Duration(req.CadenceSeconds) * time.Second,
+		NextRunAt:      req.NextRunAt,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, rotationFromDB(rot))
+}
+
+func (h *Handler) swapRotationParticipants(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	var req swapRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	rot, err := h.service.SwapRotationParticipants(ctx, id, req.FirstUserID, req.SecondUserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, rotationFromDB(rot))
+}
+
+func (h *Handler) listUpcomingRotation(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	count := defaultUpcomingCount
+	if c := r.URL.Query().Get("count"); c != "" {
+		var err error
+		if count, err = strconv.Atoi(c); err != nil {
+			http.Error(w, "Invalid count", http.StatusBadRequest)
+			return
+		}
+	}
+
+	occurrences, err := h.service.ListUpcomingRotation(ctx, id, count)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := struct {
+		Occurrences []occurrence `json:"occurrences"`
+	}{Occurrences: make([]occurrence, 0, len(occurrences))}
+
+	for _, o := range occurrences {
+		resp.Occurrences = append(resp.Occurrences, occurrence{UserID: o.UserID, At: o.At})
+	}
+
+	writeJSON(w, resp)
+}
@@ -0,0 +1,75 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (fakeService) CreateRotation(ctx context.Context, rotation db.Rotation) (db.Rotation, error) {
+	rotation.ID = 1
+
+	return rotation, nil
+}
+
+func (fakeService) SwapRotationParticipants(ctx context.Context, rotationID int32, firstUserID int32, secondUserID int32) (db.Rotation, error) {
+	return db.Rotation{ID: rotationID, ParticipantIDs: []int32{secondUserID, firstUserID}}, nil
+}
+
+func (fakeService) ListUpcomingRotation(ctx context.Context, rotationID int32, count int) ([]db.RotationOccurrence, error) {
+	if count > 52 {
+		return nil, status.Error(codes.InvalidArgument, "Count must be between 1 and 52")
+	}
+
+	at := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	occurrences := make([]db.RotationOccurrence, 0, count)
+	for i := 0; i < count; i++ {
+		occurrences = append(occurrences, db.RotationOccurrence{UserID: int32(2 + i%2), At: at.Add(time.Duration(i) * 24 * time.Hour)})
+	}
+
+	return occurrences, nil
+}
+
+func TestRotations(t *testing.T) {
+	mux := newMux(t, NewHandler(fakeService{}, fakeAuth{}, nil))
+
+	t.Run("it should create a rotation", func(t *testing.T) {
+		w := call(mux, http.MethodPost, "/v1alpha1/rotations", "parent", `{"taskId":3,"participantIds":[2,3],"cadenceSeconds":86400,"nextRunAt":"2021-03-01T09:00:00Z"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"id":1,"taskId":3,"participantIds":[2,3],"cadenceSeconds":86400,"nextRunAt":"2021-03-01T09:00:00Z"}`, w.Body.String())
+	})
+
+	t.Run("it should swap participants", func(t *testing.T) {
+		w := call(mux, http.MethodPost, "/v1alpha1/rotations/1/swap", "child", `{"firstUserId":2,"secondUserId":3}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"participantIds":[3,2]`)
+	})
+
+	t.Run("it should list upcoming assignments", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/rotations/1/upcoming?count=2", "child", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"occurrences":[{"userId":2,"at":"2021-03-01T09:00:00Z"},{"userId":3,"at":"2021-03-02T09:00:00Z"}]}`, w.Body.String())
+	})
+
+	t.Run("it should list five upcoming assignments by default", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/rotations/1/upcoming", "child", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "2021-03-05T09:00:00Z")
+	})
+
+	t.Run("it should refuse invalid counts", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, call(mux, http.MethodGet, "/v1alpha1/rotations/1/upcoming?count=x", "child", "").Code)
+		assert.Equal(t, http.StatusBadRequest, call(mux, http.MethodGet, "/v1alpha1/rotations/1/upcoming?count=53", "child", "").Code)
+	})
+}
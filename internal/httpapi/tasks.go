@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+)
+
+// task matches the JSON of Task on the gateway, with the fields which aren't
+// in the gRPC API added
+type task struct {
+	ID                   int32      `json:"id"`
+	CategoryID           int32      `json:"categoryId"`
+	AssigneeID           int32      `json:"assigneeId"`
+	Name                 string     `json:"name"`
+	Description          string     `json:"description"`
+	Points               int32      `json:"points"`
+	IsRepeatable         bool       `json:"isRepeatable"`
+	IsOpen               bool       `json:"isOpen"`
+	ClaimDurationSeconds int32      `json:"claimDurationSeconds"`
+	ClaimBonusPoints     int32      `json:"claimBonusPoints"`
+	ClaimedByID          int32      `json:"claimedById"`
+	ClaimExpiresAt       *time.Time `json:"claimExpiresAt"`
+	DueInSeconds         int32      `json:"dueInSeconds"`
+	LatePenaltyPercent   int32      `json:"latePenaltyPercent"`
+	MissedPenaltyPoints  int32      `json:"missedPenaltyPoints"`
+}
+
+func taskFromDB(t db.Task) task {
+	return task{
+		ID:                   t.ID,
+		CategoryID:           t.CategoryID,
+		AssigneeID:           t.AssigneeID,
+		Name:                 t.Name,
+		Description:          t.Description,
+		Points:               t.Points,
+		IsRepeatable:         t.IsRepeatable,
+		IsOpen:               t.IsOpen,
+		ClaimDurationSeconds: int32(t.ClaimDuration / time.Second),
+		ClaimBonusPoints:     t.ClaimBonusPoints,
+		ClaimedByID:          t.ClaimedByID,
+		ClaimExpiresAt:       t.ClaimExpiresAt,
+		DueInSeconds:         int32(t.DueIn / time.Second),
+		LatePenaltyPercent:   t.LatePenaltyPercent,
+		MissedPenaltyPoints:  t.MissedPenaltyPoints,
+	}
+}
+
+func tasksFromDB(tasks []db.Task) []task {
+	resp := make([]task, 0, len(tasks))
+	for _, t := range tasks {
+		resp = append(resp, taskFromDB(t))
+	}
+
+	return resp
+}
+
+// taskFeed matches the JSON of TaskFeed on the gateway, with the fields which
+// aren't in the gRPC API added
+type taskFeed struct {
+	ID          int32      `json:"id"`
+	AssigneeID  int32      `json:"assigneeId"`
+	TaskID      int32      `json:"taskId"`
+	IsComplete  bool       `json:"isComplete"`
+	IsApproved  bool       `json:"isApproved"`
+	CompletedAt *time.Time `json:"completedAt"`
+	Points      int32      `json:"points"`
+	DueAt       *time.Time `json:"dueAt"`
+	IsOverdue   bool       `json:"isOverdue"`
+	IsMissed    bool       `json:"isMissed"`
+}
+
+func taskFeedFromDB(tf db.TaskFeed) taskFeed {
+	return taskFeed{
+		ID:          tf.ID,
+		AssigneeID:  tf.AssigneeID,
+		TaskID:      tf.TaskID,
+		IsComplete:  tf.IsComplete,
+		IsApproved:  tf.IsApproved,
+		CompletedAt: tf.CompletedAt,
+		Points:      tf.Points,
+		DueAt:       tf.DueAt,
+		IsOverdue:   tf.IsOverdue,
+		IsMissed:    tf.IsMissed,
+	}
+}
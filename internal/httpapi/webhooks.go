@@ -0,0 +1,211 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/server"
+)
+
+// WebhookService manages a household's webhook subscriptions and deliveries
+type WebhookService interface {
+	CreateWebhookSubscription(ctx context.Context, subscription db.WebhookSubscription) (db.WebhookSubscription, error)
+	ListWebhookSubscriptions(ctx context.Context) ([]db.WebhookSubscription, error)
+	DeleteWebhookSubscription(ctx context.Context, subscriptionID int32) error
+	ListWebhookDeliveries(ctx context.Context, subscriptionID int32) ([]db.WebhookDelivery, error)
+	ListDeadWebhookDeliveries(ctx context.Context) ([]db.WebhookDelivery, error)
+	ReplayWebhookDelivery(ctx context.Context, deliveryID int32) (db.WebhookDelivery, error)
+	TestWebhookSubscription(ctx context.Context, subscriptionID int32) (server.TestWebhookResult, error)
+}
+
+func (h *Handler) webhookRoutes() []route {
+	return []route{
+		{http.MethodPost, "/v1alpha1/webhooks", h.createWebhookSubscription},
+		{http.MethodGet, "/v1alpha1/webhooks", h.listWebhookSubscriptions},
+		{http.MethodDelete, "/v1alpha1/webhooks/{id}", h.deleteWebhookSubscription},
+		{http.MethodPost, "/v1alpha1/webhooks/{id}/test", h.testWebhookSubscription},
+		{http.MethodGet, "/v1alpha1/webhooks/{id}/deliveries", h.listWebhookDeliveries},
+		{http.MethodGet, "/v1alpha1/webhook-deliveries/dead", h.listDeadWebhookDeliveries},
+		{http.MethodPost, "/v1alpha1/webhook-deliveries/{id}/replay", h.replayWebhookDelivery},
+	}
+}
+
+type webhookSubscription struct {
+	ID  int32  `json:"id"`
+	URL string `json:"url"`
+	// Secret is only returned when the subscription is created
+	Secret     string    `json:"secret,omitempty"`
+	EventTypes []string  `json:"eventTypes"`
+	IsActive   bool      `json:"isActive"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func webhookSubscriptionFromDB(s db.WebhookSubscription) webhookSubscription {
+	eventTypes := s.EventTypes
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+
+	return webhookSubscription{
+		ID:         s.ID,
+		URL:        s.URL,
+		Secret:     s.Secret,
+		EventTypes: eventTypes,
+		IsActive:   s.IsActive,
+		CreatedAt:  s.CreatedAt,
+	}
+}
+
+type webhookDelivery struct {
+	ID             int32           `json:"id"`
+	SubscriptionID int32           `json:"subscriptionId"`
+	EventType      string          `json:"eventType"`
+	Payload        json.RawMessage `json:"payload"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt"`
+	DeliveredAt    *time.Time      `json:"deliveredAt"`
+	IsDead         bool            `json:"isDead"`
+	LastError      string          `json:"lastError"`
+	ResponseStatus int32           `json:"responseStatus"`
+	ReplayOf       int32           `json:"replayOf"`
+	CreatedAt      time.Time       `json:"createdAt"`
+}
+
+func webhookDeliveryFromDB(d db.WebhookDelivery) webhookDelivery {
+	return webhookDelivery{
+		ID:             d.ID,
+		SubscriptionID: d.SubscriptionID,
+		EventType:      d.EventType,
+		Payload:        d.Payload,
+		Attempts:       d.Attempts,
+		NextAttemptAt:  d.NextAttemptAt,
+		DeliveredAt:    d.DeliveredAt,
+		IsDead:         d.IsDead,
+		LastError:      d.LastError,
+		ResponseStatus: d.ResponseStatus,
+		ReplayOf:       d.ReplayOf,
+		CreatedAt:      d.CreatedAt,
+	}
+}
+
+type testWebhookResult struct {
+	ResponseStatus int    `json:"responseStatus"`
+	Error          string `json:"error"`
+}
+
+func (h *Handler) createWebhookSubscription(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var req webhookSubscription
+	if !decode(w, r, &req) {
+		return
+	}
+
+	sub, err := h.service.CreateWebhookSubscription(ctx, db.WebhookSubscription{
+		URL:        req.URL,
+		EventTypes: req.EventTypes,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, webhookSubscriptionFromDB(sub))
+}
+
+func (h *Handler) listWebhookSubscriptions(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	subs, err := h.service.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := struct {
+		Subscriptions []webhookSubscription `json:"subscriptions"`
+	}{Subscriptions: make([]webhookSubscription, 0, len(subs))}
+
+	for _, s := range subs {
+		resp.Subscriptions = append(resp.Subscriptions, webhookSubscriptionFromDB(s))
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) deleteWebhookSubscription(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteWebhookSubscription(ctx, id); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeEmpty(w)
+}
+
+func (h *Handler) testWebhookSubscription(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	result, err := h.service.TestWebhookSubscription(ctx, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, testWebhookResult{ResponseStatus: result.ResponseStatus, Error: result.Error})
+}
+
+func (h *Handler) listWebhookDeliveries(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.service.ListWebhookDeliveries(ctx, id)
+	writeDeliveries(w, deliveries, err)
+}
+
+func (h *Handler) listDeadWebhookDeliveries(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	deliveries, err := h.service.ListDeadWebhookDeliveries(ctx)
+	writeDeliveries(w, deliveries, err)
+}
+
+// writeDeliveries responds with a list of deliveries, or the error listing
+// them
+func writeDeliveries(w http.ResponseWriter, deliveries []db.WebhookDelivery, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := struct {
+		Deliveries []webhookDelivery `json:"deliveries"`
+	}{Deliveries: make([]webhookDelivery, 0, len(deliveries))}
+
+	for _, d := range deliveries {
+		resp.Deliveries = append(resp.Deliveries, webhookDeliveryFromDB(d))
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) replayWebhookDelivery(ctx context.Context, w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	id, ok := pathID(w, pathParams, "id")
+	if !ok {
+		return
+	}
+
+	replay, err := h.service.ReplayWebhookDelivery(ctx, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, webhookDeliveryFromDB(replay))
+}
@@ -0,0 +1,100 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/server"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var subscribedAt = time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+
+func (fakeService) CreateWebhookSubscription(ctx context.Context, subscription db.WebhookSubscription) (db.WebhookSubscription, error) {
+	subscription.ID = 1
+	subscription.Secret = "secret"
+	subscription.IsActive = true
+	subscription.CreatedAt = subscribedAt
+
+	return subscription, nil
+}
+
+func (fakeService) ListWebhookSubscriptions(ctx context.Context) ([]db.WebhookSubscription, error) {
+	return []db.WebhookSubscription{{ID: 1, URL: "https://example.com/hook", IsActive: true, CreatedAt: subscribedAt}}, nil
+}
+
+func (fakeService) DeleteWebhookSubscription(ctx context.Context, subscriptionID int32) error {
+	return status.Error(codes.NotFound, "record not found")
+}
+
+func (fakeService) ListWebhookDeliveries(ctx context.Context, subscriptionID int32) ([]db.WebhookDelivery, error) {
+	return []db.WebhookDelivery{{ID: 2, SubscriptionID: subscriptionID, EventType: "feed.completed", Payload: json.RawMessage(`{"id":5}`), NextAttemptAt: subscribedAt, CreatedAt: subscribedAt}}, nil
+}
+
+func (fakeService) ListDeadWebhookDeliveries(ctx context.Context) ([]db.WebhookDelivery, error) {
+	return []db.WebhookDelivery{}, nil
+}
+
+func (fakeService) ReplayWebhookDelivery(ctx context.Context, deliveryID int32) (db.WebhookDelivery, error) {
+	return db.WebhookDelivery{ID: 3, SubscriptionID: 1, EventType: "feed.completed", Payload: json.RawMessage(`{"id":5}`), ReplayOf: deliveryID}, nil
+}
+
+func (fakeService) TestWebhookSubscription(ctx context.Context, subscriptionID int32) (server.TestWebhookResult, error) {
+	return server.TestWebhookResult{ResponseStatus: 500, Error: "unexpected status 500"}, nil
+}
+
+func TestWebhooks(t *testing.T) {
+	mux := newMux(t, NewHandler(fakeService{}, fakeAuth{}, nil))
+
+	t.Run("it should return the secret of a new subscription", func(t *testing.T) {
+		w := call(mux, http.MethodPost, "/v1alpha1/webhooks", "parent", `{"url":"https://example.com/hook","eventTypes":["feed.completed"]}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"id":1,"url":"https://example.com/hook","secret":"secret","eventTypes":["feed.completed"],"isActive":true,"createdAt":"2021-03-01T09:00:00Z"}`, w.Body.String())
+	})
+
+	t.Run("it should list subscriptions without their secrets", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/webhooks", "parent", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"subscriptions":[{"id":1,"url":"https://example.com/hook","eventTypes":[],"isActive":true,"createdAt":"2021-03-01T09:00:00Z"}]}`, w.Body.String())
+	})
+
+	t.Run("it should map service errors to HTTP statuses", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, call(mux, http.MethodDelete, "/v1alpha1/webhooks/9", "parent", "").Code)
+	})
+
+	t.Run("it should report the result of a test", func(t *testing.T) {
+		w := call(mux, http.MethodPost, "/v1alpha1/webhooks/1/test", "parent", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"responseStatus":500,"error":"unexpected status 500"}`, w.Body.String())
+	})
+
+	t.Run("it should list deliveries with their payload", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/webhooks/1/deliveries", "parent", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"eventType":"feed.completed","payload":{"id":5}`)
+	})
+
+	t.Run("it should list dead deliveries", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/webhook-deliveries/dead", "parent", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"deliveries":[]}`, w.Body.String())
+	})
+
+	t.Run("it should replay a delivery", func(t *testing.T) {
+		w := call(mux, http.MethodPost, "/v1alpha1/webhook-deliveries/2/replay", "parent", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"replayOf":2`)
+	})
+}
@@ -0,0 +1,259 @@
+// Package interceptors provides the gRPC interceptors that run around every
+// call, each available for both unary and streaming methods
+package interceptors
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader is the metadata key carrying the request ID, both on the
+// incoming request and on the response
+const RequestIDHeader = "x-request-id"
+
+// maxRequestIDLength limits request IDs supplied by clients
+const maxRequestIDLength = 64
+
+// WrappedStream overrides the context of a stream, for stream interceptors
+// which add values to the context
+type WrappedStream struct {
+	grpc.ServerStream
+	WrappedContext context.Context
+}
+
+func (s WrappedStream) Context() context.Context {
+	return s.WrappedContext
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID of the current request
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
+
+// requestID uses the request ID supplied by the client, such as one set by a
+// load balancer, or generates a new one
+func requestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDHeader); len(ids) == 1 && ids[0] != "" && len(ids[0]) <= maxRequestIDLength {
+			return ids[0]
+		}
+	}
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// RequestID adds a request ID to the context of unary calls and returns it in
+// the response headers
+func RequestID(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	id := requestID(ctx)
+
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+
+	return handler(context.WithValue(ctx, requestIDKey{}, id), req)
+}
+
+// StreamRequestID adds a request ID to the context of streaming calls and
+// returns it in the response headers
+func StreamRequestID(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	id := requestID(ss.Context())
+
+	_ = ss.SetHeader(metadata.Pairs(RequestIDHeader, id))
+
+	return handler(srv, WrappedStream{ServerStream: ss, WrappedContext: context.WithValue(ss.Context(), requestIDKey{}, id)})
+}
+
+// recovered converts a panic into an Internal error, logging the stack
+func recovered(ctx context.Context, method string, r interface{}) error {
+	log.WithFields(log.Fields{
+		"method":    method,
+		"requestID": RequestIDFromContext(ctx),
+		"panic":     r,
+		"stack":     string(debug.Stack()),
+	}).Error("Recovered from panic")
+
+	return status.Error(codes.Internal, "internal error")
+}
+
+// Recovery stops a panic in a unary handler from crashing the server
+func Recovery(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			resp, err = nil, recovered(ctx, info.FullMethod, r)
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+// StreamRecovery stops a panic in a streaming handler from crashing the server
+func StreamRecovery(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(ss.Context(), info.FullMethod, r)
+		}
+	}()
+
+	return handler(srv, ss)
+}
+
+// ClientAddress returns the address of the client. Calls from the in-process
+// HTTP gateway have no peer and calls through a local proxy come from a
+// loopback address, so for those the client address forwarded by the proxy is
+// used instead.
+func ClientAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return forwardedAddress(ctx)
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if forwarded := forwardedAddress(ctx); forwarded != "" {
+			return forwarded
+		}
+	}
+
+	return host
+}
+
+// HTTPContext describes a request served directly on the HTTP proxy the way
+// gRPC metadata would, so that handlers outside the gateway see the same
+// client address and user agent as calls through it
+func HTTPContext(r *http.Request) context.Context {
+	md := metadata.Pairs("user-agent", r.UserAgent())
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		md.Set("x-forwarded-for", forwarded...)
+	}
+
+	return withPeer(metadata.NewIncomingContext(r.Context(), md), r)
+}
+
+// HTTPPeer gives requests to the HTTP proxy the peer they were received
+// from, as the gRPC server does for its calls. Without one, ClientAddress
+// would fall back to X-Forwarded-For, which the client can set itself.
+func HTTPPeer(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(withPeer(r.Context(), r)))
+	})
+}
+
+func withPeer(ctx context.Context, r *http.Request) context.Context {
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	}
+
+	return ctx
+}
+
+// forwardedAddress returns the right-most address in X-Forwarded-For which
+// isn't a loopback address. Each proxy appends the address it received the
+// request from, so entries to the left of those added by local proxies were
+// sent by the client and can't be trusted.
+func forwardedAddress(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	forwarded := md.Get("x-forwarded-for")
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addresses := strings.Split(forwarded[i], ",")
+
+		for j := len(addresses) - 1; j >= 0; j-- {
+			address := strings.TrimSpace(addresses[j])
+
+			if ip := net.ParseIP(address); ip != nil && !ip.IsLoopback() {
+				return address
+			}
+		}
+	}
+
+	return ""
+}
+
+// ChainUnary combines interceptors into one which runs them in order, for
+// calls that don't go through a grpc.Server
+func ChainUnary(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+
+		return next(ctx, req)
+	}
+}
+
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+
+	entry := log.WithFields(log.Fields{
+		"method":     method,
+		"code":       code.String(),
+		"durationMs": time.Since(start).Milliseconds(),
+		"requestID":  RequestIDFromContext(ctx),
+		"peer":       ClientAddress(ctx),
+	})
+
+	switch code {
+	case codes.OK:
+		entry.Info("Request handled")
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+		entry.WithError(err).Error("Request failed")
+	default:
+		entry.WithError(err).Warn("Request failed")
+	}
+}
+
+// Logging writes an access log entry for each unary call
+func Logging(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+
+	resp, err := handler(ctx, req)
+
+	logCall(ctx, info.FullMethod, start, err)
+
+	return resp, err
+}
+
+// StreamLogging writes an access log entry for each streaming call once it
+// has finished
+func StreamLogging(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+
+	err := handler(srv, ss)
+
+	logCall(ss.Context(), info.FullMethod, start, err)
+
+	return err
+}
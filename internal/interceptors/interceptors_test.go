@@ -0,0 +1,233 @@
+package interceptors
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type testClock struct {
+	time time.Time
+}
+
+func (t *testClock) Now() time.Time {
+	return t.time
+}
+
+type testStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *testStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+var unaryInfo = &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+var streamInfo = &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}
+
+func TestRecovery(t *testing.T) {
+	t.Run("it should turn a unary panic into an internal error", func(t *testing.T) {
+		_, err := Recovery(context.Background(), nil, unaryInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+			panic("boom")
+		})
+
+		assert.Equal(t, codes.Internal, status.Code(err))
+	})
+
+	t.Run("it should turn a stream panic into an internal error", func(t *testing.T) {
+		err := StreamRecovery(nil, &testStream{ctx: context.Background()}, streamInfo, func(srv interface{}, ss grpc.ServerStream) error {
+			panic("boom")
+		})
+
+		assert.Equal(t, codes.Internal, status.Code(err))
+	})
+
+	t.Run("it should pass through errors from the handler", func(t *testing.T) {
+		_, err := Recovery(context.Background(), nil, unaryInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.NotFound, "missing")
+		})
+
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
+
+func TestStreamRequestID(t *testing.T) {
+	t.Run("it should use the request ID supplied by the client", func(t *testing.T) {
+		ss := &testStream{ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDHeader, "abc"))}
+
+		var id string
+		err := StreamRequestID(nil, ss, streamInfo, func(srv interface{}, ss grpc.ServerStream) error {
+			id = RequestIDFromContext(ss.Context())
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "abc", id)
+		assert.Equal(t, []string{"abc"}, ss.header.Get(RequestIDHeader))
+	})
+
+	t.Run("it should generate a request ID when there isn't one", func(t *testing.T) {
+		ss := &testStream{ctx: context.Background()}
+
+		var id string
+		err := StreamRequestID(nil, ss, streamInfo, func(srv interface{}, ss grpc.ServerStream) error {
+			id = RequestIDFromContext(ss.Context())
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Len(t, id, 32)
+	})
+}
+
+func TestClientAddress(t *testing.T) {
+	withPeer := func(addr string, md metadata.MD) context.Context {
+		host, port, _ := net.SplitHostPort(addr)
+		tcp := &net.TCPAddr{IP: net.ParseIP(host)}
+		tcp.Port, _ = net.LookupPort("tcp", port)
+
+		return peer.NewContext(metadata.NewIncomingContext(context.Background(), md), &peer.Peer{Addr: tcp})
+	}
+
+	t.Run("it should use the peer address", func(t *testing.T) {
+		ctx := withPeer("203.0.113.5:1234", metadata.Pairs("x-forwarded-for", "198.51.100.1"))
+
+		assert.Equal(t, "203.0.113.5", ClientAddress(ctx))
+	})
+
+	t.Run("it should trust the forwarded address from the local proxy", func(t *testing.T) {
+		ctx := withPeer("127.0.0.1:1234", metadata.Pairs("x-forwarded-for", "198.51.100.1"))
+
+		assert.Equal(t, "198.51.100.1", ClientAddress(ctx))
+	})
+
+	t.Run("it should ignore addresses the client forwarded itself", func(t *testing.T) {
+		ctx := withPeer("127.0.0.1:1234", metadata.Pairs("x-forwarded-for", "203.0.113.9, 198.51.100.1, 127.0.0.1"))
+
+		assert.Equal(t, "198.51.100.1", ClientAddress(ctx))
+	})
+
+	t.Run("it should use the forwarded address for in-process calls", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-forwarded-for", "198.51.100.1"))
+
+		assert.Equal(t, "198.51.100.1", ClientAddress(ctx))
+	})
+}
+
+func TestHTTPPeer(t *testing.T) {
+	var address string
+
+	h := HTTPPeer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// As the gateway does, forward the header as metadata
+		ctx := metadata.NewIncomingContext(r.Context(), metadata.Pairs("x-forwarded-for", r.Header.Get("X-Forwarded-For")))
+		address = ClientAddress(ctx)
+	}))
+
+	t.Run("it should use the address the request was received from", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/v1alpha1/tasks", nil)
+		r.RemoteAddr = "203.0.113.5:1234"
+		r.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+		h.ServeHTTP(httptest.NewRecorder(), r)
+
+		assert.Equal(t, "203.0.113.5", address)
+	})
+
+	t.Run("it should trust the forwarded address from the local proxy", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/v1alpha1/tasks", nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+		h.ServeHTTP(httptest.NewRecorder(), r)
+
+		assert.Equal(t, "198.51.100.1", address)
+	})
+}
+
+func TestChainUnary(t *testing.T) {
+	var calls []string
+
+	record := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			calls = append(calls, name+" "+info.FullMethod)
+			return handler(ctx, req)
+		}
+	}
+
+	chain := ChainUnary(record("first"), record("second"))
+
+	resp, err := chain(context.Background(), "req", &grpc.UnaryServerInfo{FullMethod: "/test.Service/Call"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls = append(calls, "handler")
+		return "resp", nil
+	})
+
+	t.Run("it should run the interceptors in order before the handler", func(t *testing.T) {
+		assert.NoError(t, err)
+		assert.Equal(t, "resp", resp)
+		assert.Equal(t, []string{"first /test.Service/Call", "second /test.Service/Call", "handler"}, calls)
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	clock := &testClock{time: time.Date(2021, 6, 7, 12, 0, 0, 0, time.UTC)}
+
+	l := NewRateLimiter(1, 2)
+	l.clock = clock
+
+	t.Run("it should allow bursts", func(t *testing.T) {
+		assert.True(t, l.Allow("a"))
+		assert.True(t, l.Allow("a"))
+		assert.False(t, l.Allow("a"))
+	})
+
+	t.Run("it should limit each client separately", func(t *testing.T) {
+		assert.True(t, l.Allow("b"))
+	})
+
+	t.Run("it should refill over time", func(t *testing.T) {
+		clock.time = clock.time.Add(time.Second)
+
+		assert.True(t, l.Allow("a"))
+		assert.False(t, l.Allow("a"))
+	})
+
+	t.Run("it should forget clients with full buckets", func(t *testing.T) {
+		clock.time = clock.time.Add(time.Minute * 2)
+		l.Allow("c")
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		assert.NotContains(t, l.buckets, "a")
+		assert.NotContains(t, l.buckets, "b")
+	})
+
+	t.Run("it should apply a new limit", func(t *testing.T) {
+		l.SetLimit(1, 1)
+
+		assert.True(t, l.Allow("d"))
+		assert.False(t, l.Allow("d"))
+	})
+
+	t.Run("it should allow every call when the rate is 0", func(t *testing.T) {
+		l.SetLimit(0, 0)
+
+		assert.True(t, l.Allow("d"))
+	})
+}
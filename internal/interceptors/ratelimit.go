@@ -0,0 +1,127 @@
+package interceptors
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/chorerewards/backend/internal/clock"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter limits the rate of calls from each client address using a token
+// bucket per client
+type RateLimiter struct {
+	rate  float64
+	burst float64
+	clock clock.Clock
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// NewRateLimiter allows each client an average of rate calls per second, with
+// bursts of up to burst calls. A rate of 0 allows every call.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		clock:   clock.Real{},
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// SetLimit changes the rate and burst allowed to each client. Buckets keep
+// their tokens, up to the new burst.
+func (l *RateLimiter) SetLimit(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = rate
+	l.burst = float64(burst)
+}
+
+// Allow takes a token from the client's bucket, reporting whether one was
+// available
+func (l *RateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return true
+	}
+
+	now := l.clock.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// sweep forgets clients whose buckets have refilled, so that the map doesn't
+// grow with every address ever seen
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+
+	l.swept = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *RateLimiter) check(ctx context.Context) error {
+	if !l.Allow(ClientAddress(ctx)) {
+		return status.Error(codes.ResourceExhausted, "Too many requests")
+	}
+
+	return nil
+}
+
+// Unary rejects unary calls from clients over their rate limit
+func (l *RateLimiter) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := l.check(ctx); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// Stream rejects streaming calls from clients over their rate limit
+func (l *RateLimiter) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := l.check(ss.Context()); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
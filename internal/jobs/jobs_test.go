@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvery(t *testing.T) {
+	t.Run("it should keep running after an error until cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		calls := 0
+		done := make(chan struct{})
+
+		go func() {
+			Every(ctx, "test", time.Millisecond, func(context.Context) error {
+				calls++
+				if calls == 3 {
+					cancel()
+				}
+
+				return errors.New("failed")
+			})
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("job did not stop after the context was cancelled")
+		}
+
+		assert.Equal(t, 3, calls)
+	})
+}
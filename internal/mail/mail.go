@@ -0,0 +1,111 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chorerewards/backend/internal/notify"
+	"github.com/pkg/errors"
+)
+
+// Message is an email sent to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends account emails, such as verification and password reset links.
+// Unlike notifications these are sent immediately rather than queued, as the
+// user is waiting for them.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer sends mail through the notification SMTP driver
+type SMTPMailer struct {
+	driver *notify.SMTPDriver
+}
+
+var _ Mailer = (*SMTPMailer)(nil)
+
+func NewSMTPMailer(driver *notify.SMTPDriver) *SMTPMailer {
+	return &SMTPMailer{driver: driver}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	return m.driver.Send(ctx, notify.Message{
+		Address: msg.To,
+		Subject: msg.Subject,
+		Body:    msg.Body,
+	})
+}
+
+// FileMailer writes each message to a file in a directory instead of sending
+// it, for development without an SMTP server
+type FileMailer struct {
+	dir string
+	mu  sync.Mutex
+	seq int
+}
+
+var _ Mailer = (*FileMailer)(nil)
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.Wrap(err, "unable to create mail directory")
+	}
+
+	return &FileMailer{dir: dir}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	m.seq++
+	name := fmt.Sprintf("%d-%d.eml", time.Now().UnixNano(), m.seq)
+	m.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "To: %s\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\n", msg.Subject)
+	b.WriteString("\n")
+	b.WriteString(msg.Body)
+	b.WriteString("\n")
+
+	if err := ioutil.WriteFile(filepath.Join(m.dir, name), []byte(b.String()), 0o600); err != nil {
+		return errors.Wrap(err, "unable to write mail")
+	}
+
+	return nil
+}
+
+// MemoryMailer keeps sent messages in memory so tests can inspect them
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+var _ Mailer = (*MemoryMailer)(nil)
+
+func (m *MemoryMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+
+	return nil
+}
+
+// Messages returns the messages sent so far
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
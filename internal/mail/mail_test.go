@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := NewFileMailer(dir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, m.Send(context.Background(), Message{To: "parent@example.com", Subject: "Hello", Body: "Body"}))
+	assert.NoError(t, m.Send(context.Background(), Message{To: "child@example.com", Subject: "Hi", Body: "Body"}))
+
+	t.Run("it should write a file per message", func(t *testing.T) {
+		files, err := ioutil.ReadDir(dir)
+		assert.NoError(t, err)
+		assert.Len(t, files, 2)
+	})
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := &MemoryMailer{}
+
+	assert.NoError(t, m.Send(context.Background(), Message{To: "parent@example.com", Subject: "Hello"}))
+
+	t.Run("it should record sent messages", func(t *testing.T) {
+		assert.Equal(t, []Message{{To: "parent@example.com", Subject: "Hello"}}, m.Messages())
+	})
+}
@@ -0,0 +1,388 @@
+// Package mfa serves two-factor authentication on the HTTP proxy. The steps
+// of signing in are taken before the user has a token, so they are authorised
+// by the challenge Login returned instead. Managing an enrolment afterwards,
+// and the household's policy, needs a token.
+package mfa
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/interceptors"
+	"github.com/chorerewards/backend/internal/server"
+	chorerewardsv1alpha1 "github.com/chorerewards/proto/chorerewards/v1alpha1"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/status"
+)
+
+// maxRequestSize limits request bodies, which only hold a challenge and code
+const maxRequestSize = 4 << 10
+
+// Service completes sign ins refused by Login until two-factor
+// authentication is done, and manages the caller's enrolment
+type Service interface {
+	LoginMFA(ctx context.Context, challenge string, code string) (*chorerewardsv1alpha1.LoginResponse, error)
+	EnrolTOTPWithChallenge(ctx context.Context, challenge string) (auth.TOTPEnrolment, error)
+	CompleteMFAEnrolment(ctx context.Context, challenge string, code string) (*chorerewardsv1alpha1.LoginResponse, []string, error)
+
+	GetMFAStatus(ctx context.Context) (server.MFAStatus, error)
+	EnrolTOTP(ctx context.Context) (auth.TOTPEnrolment, error)
+	ConfirmTOTP(ctx context.Context, code string) ([]string, error)
+	RegenerateRecoveryCodes(ctx context.Context, code string) ([]string, error)
+	DisableTOTP(ctx context.Context, code string) error
+	ResetUserMFA(ctx context.Context, userID int32) error
+	SetRequireParentMFA(ctx context.Context, required bool) (db.HouseholdSettings, error)
+}
+
+// Authenticator authenticates the bearer token of a request, returning a
+// context carrying its principal
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (context.Context, error)
+}
+
+// Limiter limits the rate of requests from each client address
+type Limiter interface {
+	Allow(key string) bool
+}
+
+// Handler serves the MFA routes alongside the routes generated by
+// grpc-gateway
+type Handler struct {
+	service Service
+	auth    Authenticator
+	limiter Limiter
+}
+
+func NewHandler(service Service, auth Authenticator, limiter Limiter) *Handler {
+	return &Handler{
+		service: service,
+		auth:    auth,
+		limiter: limiter,
+	}
+}
+
+// Register adds the MFA routes to the gateway mux
+func (h *Handler) Register(mux *runtime.ServeMux) error {
+	if err := mux.HandlePath(http.MethodPost, "/v1alpha1/login/mfa", h.login); err != nil {
+		return err
+	}
+
+	if err := mux.HandlePath(http.MethodPost, "/v1alpha1/login/mfa/enrolment", h.enrol); err != nil {
+		return err
+	}
+
+	if err := mux.HandlePath(http.MethodPost, "/v1alpha1/login/mfa/enrolment/complete", h.completeEnrolment); err != nil {
+		return err
+	}
+
+	// The routes below act as the caller of their bearer token
+	routes := []struct {
+		method  string
+		path    string
+		handler runtime.HandlerFunc
+	}{
+		{http.MethodGet, "/v1alpha1/account/mfa", h.status},
+		{http.MethodPost, "/v1alpha1/account/mfa/totp", h.enrolTOTP},
+		{http.MethodPost, "/v1alpha1/account/mfa/totp/confirm", h.confirmTOTP},
+		{http.MethodPost, "/v1alpha1/account/mfa/totp/disable", h.disableTOTP},
+		{http.MethodPost, "/v1alpha1/account/mfa/recovery-codes", h.regenerateRecoveryCodes},
+		{http.MethodPost, "/v1alpha1/users/{userId}/mfa/reset", h.resetUser},
+		{http.MethodPut, "/v1alpha1/household/mfa", h.setRequireParentMFA},
+	}
+
+	for _, rt := range routes {
+		if err := mux.HandlePath(rt.method, rt.path, rt.handler); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type request struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// loginResponse matches the JSON of LoginResponse on the gateway, with the
+// recovery codes added when an enrolment is completed
+type loginResponse struct {
+	Token         string   `json:"token"`
+	IsAdmin       bool     `json:"isAdmin"`
+	IsParent      bool     `json:"isParent"`
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+type enrolmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// accountRequest is the body of the routes called with a token
+type accountRequest struct {
+	Code     string `json:"code"`
+	Required bool   `json:"required"`
+}
+
+type statusResponse struct {
+	Enabled                bool  `json:"enabled"`
+	Required               bool  `json:"required"`
+	RecoveryCodesRemaining int32 `json:"recoveryCodesRemaining"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type settingsResponse struct {
+	HouseholdID      int32 `json:"householdId"`
+	RequireParentMFA bool  `json:"requireParentMfa"`
+}
+
+// decode reads the request body, returning the context to call the service
+// with, or writes an error and returns false
+func (h *Handler) decode(w http.ResponseWriter, r *http.Request) (context.Context, request, bool) {
+	var req request
+
+	ctx := interceptors.HTTPContext(r)
+
+	if h.limiter != nil && !h.limiter.Allow(interceptors.ClientAddress(ctx)) {
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return nil, req, false
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, req, false
+	}
+
+	if req.Challenge == "" {
+		http.Error(w, "A challenge is required", http.StatusBadRequest)
+		return nil, req, false
+	}
+
+	return ctx, req, true
+}
+
+func (h *Handler) login(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	ctx, req, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+
+	resp, err := h.service.LoginMFA(ctx, req.Challenge, req.Code)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, loginResponse{
+		Token:    resp.GetToken(),
+		IsAdmin:  resp.GetIsAdmin(),
+		IsParent: resp.GetIsParent(),
+	})
+}
+
+func (h *Handler) enrol(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	ctx, req, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+
+	enrolment, err := h.service.EnrolTOTPWithChallenge(ctx, req.Challenge)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, enrolmentResponse{
+		Secret: enrolment.Secret,
+		URI:    enrolment.URI,
+	})
+}
+
+func (h *Handler) completeEnrolment(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	ctx, req, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+
+	resp, recoveryCodes, err := h.service.CompleteMFAEnrolment(ctx, req.Challenge, req.Code)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, loginResponse{
+		Token:         resp.GetToken(),
+		IsAdmin:       resp.GetIsAdmin(),
+		IsParent:      resp.GetIsParent(),
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// authenticate limits the request and authenticates its bearer token,
+// returning the context to call the service with, or writes an error and
+// returns false. A body, when given, is read into req.
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request, req *accountRequest) (context.Context, bool) {
+	ctx := interceptors.HTTPContext(r)
+
+	if h.limiter != nil && !h.limiter.Allow(interceptors.ClientAddress(ctx)) {
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return nil, false
+	}
+
+	ctx, err := h.auth.Authenticate(ctx, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		writeError(w, err)
+		return nil, false
+	}
+
+	if req != nil {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	return ctx, true
+}
+
+func (h *Handler) status(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	ctx, ok := h.authenticate(w, r, nil)
+	if !ok {
+		return
+	}
+
+	s, err := h.service.GetMFAStatus(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, statusResponse{
+		Enabled:                s.Enabled,
+		Required:               s.Required,
+		RecoveryCodesRemaining: s.RecoveryCodesRemaining,
+	})
+}
+
+func (h *Handler) enrolTOTP(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	ctx, ok := h.authenticate(w, r, nil)
+	if !ok {
+		return
+	}
+
+	enrolment, err := h.service.EnrolTOTP(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, enrolmentResponse{
+		Secret: enrolment.Secret,
+		URI:    enrolment.URI,
+	})
+}
+
+func (h *Handler) confirmTOTP(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	h.recoveryCodes(w, r, h.service.ConfirmTOTP)
+}
+
+func (h *Handler) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	h.recoveryCodes(w, r, h.service.RegenerateRecoveryCodes)
+}
+
+// recoveryCodes checks the code in the request with issue, responding with
+// the recovery codes it returns
+func (h *Handler) recoveryCodes(w http.ResponseWriter, r *http.Request, issue func(context.Context, string) ([]string, error)) {
+	var req accountRequest
+
+	ctx, ok := h.authenticate(w, r, &req)
+	if !ok {
+		return
+	}
+
+	codes, err := issue(ctx, req.Code)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (h *Handler) disableTOTP(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var req accountRequest
+
+	ctx, ok := h.authenticate(w, r, &req)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DisableTOTP(ctx, req.Code); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, struct{}{})
+}
+
+func (h *Handler) resetUser(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	ctx, ok := h.authenticate(w, r, nil)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseInt(pathParams["userId"], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid userId", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.ResetUserMFA(ctx, int32(userID)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, struct{}{})
+}
+
+func (h *Handler) setRequireParentMFA(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var req accountRequest
+
+	ctx, ok := h.authenticate(w, r, &req)
+	if !ok {
+		return
+	}
+
+	settings, err := h.service.SetRequireParentMFA(ctx, req.Required)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, settingsResponse{
+		HouseholdID:      settings.HouseholdID,
+		RequireParentMFA: settings.RequireParentMFA,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	// Tokens and recovery codes must not be kept by caches
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+
+	http.Error(w, s.Message(), runtime.HTTPStatusFromCode(s.Code()))
+}
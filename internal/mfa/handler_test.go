@@ -0,0 +1,229 @@
+package mfa
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/interceptors"
+	"github.com/chorerewards/backend/internal/server"
+	chorerewardsv1alpha1 "github.com/chorerewards/proto/chorerewards/v1alpha1"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeService struct {
+	clientAddress string
+}
+
+func (f *fakeService) LoginMFA(ctx context.Context, challenge string, code string) (*chorerewardsv1alpha1.LoginResponse, error) {
+	f.clientAddress = interceptors.ClientAddress(ctx)
+
+	if challenge != "challenge" || code != "123456" {
+		return nil, status.Error(codes.PermissionDenied, "Incorrect code")
+	}
+
+	return &chorerewardsv1alpha1.LoginResponse{Token: "token", IsParent: true}, nil
+}
+
+func (f *fakeService) EnrolTOTPWithChallenge(ctx context.Context, challenge string) (auth.TOTPEnrolment, error) {
+	return auth.TOTPEnrolment{Secret: "secret", URI: "otpauth://totp/x"}, nil
+}
+
+func (f *fakeService) CompleteMFAEnrolment(ctx context.Context, challenge string, code string) (*chorerewardsv1alpha1.LoginResponse, []string, error) {
+	return &chorerewardsv1alpha1.LoginResponse{Token: "token", IsParent: true}, []string{"a", "b"}, nil
+}
+
+func (f *fakeService) GetMFAStatus(ctx context.Context) (server.MFAStatus, error) {
+	return server.MFAStatus{Enabled: true, RecoveryCodesRemaining: 8}, nil
+}
+
+func (f *fakeService) EnrolTOTP(ctx context.Context) (auth.TOTPEnrolment, error) {
+	return auth.TOTPEnrolment{Secret: "secret", URI: "otpauth://totp/x"}, nil
+}
+
+func (f *fakeService) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	if code != "123456" {
+		return nil, status.Error(codes.PermissionDenied, "Incorrect code")
+	}
+
+	return []string{"a", "b"}, nil
+}
+
+func (f *fakeService) RegenerateRecoveryCodes(ctx context.Context, code string) ([]string, error) {
+	return f.ConfirmTOTP(ctx, code)
+}
+
+func (f *fakeService) DisableTOTP(ctx context.Context, code string) error {
+	_, err := f.ConfirmTOTP(ctx, code)
+	return err
+}
+
+func (f *fakeService) ResetUserMFA(ctx context.Context, userID int32) error {
+	p, _ := auth.PrincipalFromContext(ctx)
+	if !p.HasRole(auth.RoleAdmin) {
+		return status.Error(codes.PermissionDenied, "Only admins can reset two-factor authentication")
+	}
+
+	return nil
+}
+
+func (f *fakeService) SetRequireParentMFA(ctx context.Context, required bool) (db.HouseholdSettings, error) {
+	p, _ := auth.PrincipalFromContext(ctx)
+
+	return db.HouseholdSettings{HouseholdID: p.HouseholdID, RequireParentMFA: required}, nil
+}
+
+// fakeAuth signs in "admin" as an admin parent and "parent" as a parent
+type fakeAuth struct{}
+
+func (fakeAuth) Authenticate(ctx context.Context, token string) (context.Context, error) {
+	switch token {
+	case "admin":
+		return auth.ContextWithPrincipal(ctx, auth.Principal{UserID: 1, HouseholdID: 1, Roles: []string{auth.RoleParent, auth.RoleAdmin}}), nil
+	case "parent":
+		return auth.ContextWithPrincipal(ctx, auth.Principal{UserID: 2, HouseholdID: 1, Roles: []string{auth.RoleParent}}), nil
+	default:
+		return nil, status.Error(codes.Unauthenticated, "Invalid token")
+	}
+}
+
+type denyLimiter struct{}
+
+func (denyLimiter) Allow(key string) bool { return false }
+
+func newMux(t *testing.T, h *Handler) *runtime.ServeMux {
+	mux := runtime.NewServeMux()
+	assert.NoError(t, h.Register(mux))
+
+	return mux
+}
+
+func post(mux http.Handler, path string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.RemoteAddr = "203.0.113.7:5000"
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	return w
+}
+
+// call makes a request with a bearer token
+func call(mux http.Handler, method string, path string, token string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	service := &fakeService{}
+	mux := newMux(t, NewHandler(service, nil, nil))
+
+	t.Run("it should sign in with a correct code", func(t *testing.T) {
+		w := post(mux, "/v1alpha1/login/mfa", `{"challenge":"challenge","code":"123456"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		assert.JSONEq(t, `{"token":"token","isAdmin":false,"isParent":true}`, w.Body.String())
+	})
+
+	t.Run("it should pass the client address to the service", func(t *testing.T) {
+		assert.Equal(t, "203.0.113.7", service.clientAddress)
+	})
+
+	t.Run("it should map service errors to HTTP statuses", func(t *testing.T) {
+		w := post(mux, "/v1alpha1/login/mfa", `{"challenge":"challenge","code":"000000"}`)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("it should require a challenge", func(t *testing.T) {
+		w := post(mux, "/v1alpha1/login/mfa", `{"code":"123456"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("it should start an enrolment", func(t *testing.T) {
+		w := post(mux, "/v1alpha1/login/mfa/enrolment", `{"challenge":"challenge"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"secret":"secret","uri":"otpauth://totp/x"}`, w.Body.String())
+	})
+
+	t.Run("it should return recovery codes when an enrolment is completed", func(t *testing.T) {
+		w := post(mux, "/v1alpha1/login/mfa/enrolment/complete", `{"challenge":"challenge","code":"123456"}`)
+
+		var resp loginResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "token", resp.Token)
+		assert.Equal(t, []string{"a", "b"}, resp.RecoveryCodes)
+	})
+
+	t.Run("it should reject clients over their rate limit", func(t *testing.T) {
+		w := post(newMux(t, NewHandler(service, nil, denyLimiter{})), "/v1alpha1/login/mfa", `{"challenge":"challenge","code":"123456"}`)
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+}
+
+func TestAccountHandler(t *testing.T) {
+	service := &fakeService{}
+	mux := newMux(t, NewHandler(service, fakeAuth{}, nil))
+
+	t.Run("it should require a token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, call(mux, http.MethodGet, "/v1alpha1/account/mfa", "", "").Code)
+	})
+
+	t.Run("it should return the caller's status", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/account/mfa", "parent", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"enabled":true,"required":false,"recoveryCodesRemaining":8}`, w.Body.String())
+	})
+
+	t.Run("it should enrol and confirm an authenticator app", func(t *testing.T) {
+		w := call(mux, http.MethodPost, "/v1alpha1/account/mfa/totp", "parent", "")
+		assert.JSONEq(t, `{"secret":"secret","uri":"otpauth://totp/x"}`, w.Body.String())
+
+		w = call(mux, http.MethodPost, "/v1alpha1/account/mfa/totp/confirm", "parent", `{"code":"123456"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		assert.JSONEq(t, `{"recoveryCodes":["a","b"]}`, w.Body.String())
+
+		assert.Equal(t, http.StatusForbidden, call(mux, http.MethodPost, "/v1alpha1/account/mfa/totp/confirm", "parent", `{"code":"000000"}`).Code)
+	})
+
+	t.Run("it should regenerate recovery codes and disable with a code", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, call(mux, http.MethodPost, "/v1alpha1/account/mfa/recovery-codes", "parent", `{"code":"123456"}`).Code)
+		assert.Equal(t, http.StatusOK, call(mux, http.MethodPost, "/v1alpha1/account/mfa/totp/disable", "parent", `{"code":"123456"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, call(mux, http.MethodPost, "/v1alpha1/account/mfa/totp/disable", "parent", `not json`).Code)
+	})
+
+	t.Run("it should let admins reset a user and set the household policy", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, call(mux, http.MethodPost, "/v1alpha1/users/2/mfa/reset", "admin", "").Code)
+		assert.Equal(t, http.StatusForbidden, call(mux, http.MethodPost, "/v1alpha1/users/1/mfa/reset", "parent", "").Code)
+
+		w := call(mux, http.MethodPut, "/v1alpha1/household/mfa", "admin", `{"required":true}`)
+		assert.JSONEq(t, `{"householdId":1,"requireParentMfa":true}`, w.Body.String())
+	})
+
+	t.Run("it should limit authenticated routes", func(t *testing.T) {
+		limited := newMux(t, NewHandler(service, fakeAuth{}, denyLimiter{}))
+
+		assert.Equal(t, http.StatusTooManyRequests, call(limited, http.MethodGet, "/v1alpha1/account/mfa", "parent", "").Code)
+	})
+}
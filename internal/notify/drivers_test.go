@@ -0,0 +1,249 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chorerewards/backend/internal/egress"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/hkdf"
+)
+
+// fakeSMTPServer accepts a single message and returns its data
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	received := make(chan string, 1)
+
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		write := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+
+		write("220 localhost ESMTP")
+
+		var data strings.Builder
+		inData := false
+
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					write("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				write("250 localhost")
+			case cmd == "DATA":
+				inData = true
+				write("354 Go ahead")
+			case cmd == "QUIT":
+				write("221 Bye")
+				return
+			default:
+				write("250 OK")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func TestSMTPDriver(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+
+	host, port, err := net.SplitHostPort(addr)
+	assert.NoError(t, err)
+
+	p, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	d := NewSMTPDriver(host, p, "", "", "chores@example.com", time.Second*5)
+
+	err = d.Send(context.Background(), Message{
+		Address: "parent@example.com",
+		Subject: "Chore completed\r\nBcc: attacker@example.com",
+		Body:    "Sam cleaned their room",
+	})
+	assert.NoError(t, err)
+
+	select {
+	case msg := <-received:
+		assert.Contains(t, msg, "To: parent@example.com\r\n")
+		assert.Contains(t, msg, "Subject: Chore completedBcc: attacker@example.com\r\n")
+		assert.NotContains(t, msg, "\r\nBcc:")
+		assert.Contains(t, msg, "Sam cleaned their room")
+	case <-time.After(time.Second * 5):
+		t.Fatal("no message received")
+	}
+}
+
+func TestWebhookDriver(t *testing.T) {
+	t.Run("it should POST the notification as JSON", func(t *testing.T) {
+		var payload webhookPayload
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		}))
+		defer srv.Close()
+
+		d := NewWebhookDriver(time.Second)
+		d.client = srv.Client()
+
+		assert.NoError(t, d.Send(context.Background(), Message{ID: 1, UserID: 2, EventType: "feed.completed", Address: srv.URL}))
+		assert.Equal(t, webhookPayload{ID: 1, UserID: 2, EventType: "feed.completed"}, payload)
+	})
+
+	t.Run("it should error on a non-2xx response", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer srv.Close()
+
+		d := NewWebhookDriver(time.Second)
+		d.client = srv.Client()
+
+		assert.EqualError(t, d.Send(context.Background(), Message{Address: srv.URL}), "unexpected response status 502 Bad Gateway")
+	})
+
+	t.Run("it should refuse to deliver to internal addresses", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("request should not have been sent")
+		}))
+		defer srv.Close()
+
+		d := NewWebhookDriver(time.Second)
+
+		err := d.Send(context.Background(), Message{Address: srv.URL})
+		assert.True(t, errors.Is(err, egress.ErrForbiddenAddress), err)
+	})
+}
+
+// decrypt reverses encrypt using the user agent's private key, as a browser would
+func decrypt(t *testing.T, uaPrivate []byte, uaPublic []byte, authSecret []byte, body []byte) []byte {
+	curve := elliptic.P256()
+
+	salt := body[:16]
+	idLen := int(body[20])
+	asPublic := body[21 : 21+idLen]
+	ciphertext := body[21+idLen:]
+
+	asX, asY := elliptic.Unmarshal(curve, asPublic)
+	sharedX, _ := curve.ScalarMult(asX, asY, uaPrivate)
+	shared := make([]byte, 32)
+	sharedX.FillBytes(shared)
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+
+	ikm, err := expand(hkdf.Extract(sha256.New, shared, authSecret), keyInfo, 32)
+	assert.NoError(t, err)
+
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+
+	cek, err := expand(prk, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	assert.NoError(t, err)
+
+	nonce, err := expand(prk, []byte("Content-Encoding: nonce\x00"), 12)
+	assert.NoError(t, err)
+
+	block, err := aes.NewCipher(cek)
+	assert.NoError(t, err)
+
+	gcm, err := cipher.NewGCM(block)
+	assert.NoError(t, err)
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	assert.NoError(t, err)
+
+	// Strip the last record delimiter
+	return plaintext[:len(plaintext)-1]
+}
+
+func TestWebPushDriver(t *testing.T) {
+	curve := elliptic.P256()
+
+	uaPrivate, uaX, uaY, err := elliptic.GenerateKey(curve, rand.Reader)
+	assert.NoError(t, err)
+	uaPublic := elliptic.Marshal(curve, uaX, uaY)
+
+	authSecret := make([]byte, 16)
+	_, err = rand.Read(authSecret)
+	assert.NoError(t, err)
+
+	vapidPrivate, _, _, err := elliptic.GenerateKey(curve, rand.Reader)
+	assert.NoError(t, err)
+
+	var (
+		body          []byte
+		authorization string
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "aes128gcm", r.Header.Get("Content-Encoding"))
+		authorization = r.Header.Get("Authorization")
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	d, err := NewWebPushDriver(base64.RawURLEncoding.EncodeToString(vapidPrivate), "mailto:admin@example.com", time.Second)
+	assert.NoError(t, err)
+	d.client = srv.Client()
+
+	sub := Subscription{Endpoint: srv.URL + "/push/abc"}
+	sub.Keys.P256dh = base64.RawURLEncoding.EncodeToString(uaPublic)
+	sub.Keys.Auth = base64.RawURLEncoding.EncodeToString(authSecret)
+
+	address, err := json.Marshal(sub)
+	assert.NoError(t, err)
+
+	assert.NoError(t, d.Send(context.Background(), Message{Subject: "Chore approved", Body: "You earned 10 points", EventType: "feed.approved", Address: string(address)}))
+
+	t.Run("it should identify itself with VAPID", func(t *testing.T) {
+		assert.True(t, strings.HasPrefix(authorization, "vapid t="))
+		assert.True(t, strings.HasSuffix(authorization, ", k="+d.vapidPub))
+	})
+
+	t.Run("it should encrypt the payload for the subscription", func(t *testing.T) {
+		var payload webPushPayload
+		assert.NoError(t, json.Unmarshal(decrypt(t, uaPrivate, uaPublic, authSecret, body), &payload))
+
+		assert.Equal(t, webPushPayload{Title: "Chore approved", Body: "You earned 10 points", EventType: "feed.approved"}, payload)
+	})
+}
@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SMTPDriver delivers notifications by email
+type SMTPDriver struct {
+	host    string
+	addr    string
+	from    string
+	auth    smtp.Auth
+	timeout time.Duration
+}
+
+var _ Driver = (*SMTPDriver)(nil)
+
+// NewSMTPDriver creates a driver sending through the SMTP server at host:port.
+// Authentication is only attempted when a username is given. Sending gives up
+// after the timeout, or earlier if the context has a sooner deadline.
+func NewSMTPDriver(host string, port int, username string, password string, from string, timeout time.Duration) *SMTPDriver {
+	d := &SMTPDriver{
+		host:    host,
+		addr:    net.JoinHostPort(host, strconv.Itoa(port)),
+		from:    from,
+		timeout: timeout,
+	}
+
+	if username != "" {
+		d.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return d
+}
+
+// headerValue strips line breaks so user supplied values can't inject headers
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+func (d *SMTPDriver) Send(ctx context.Context, msg Message) error {
+	to := headerValue(msg.Address)
+	if to == "" {
+		return errors.New("no email address")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", headerValue(d.from))
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerValue(msg.Subject)))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	buf.WriteString("\r\n")
+
+	if err := d.send(ctx, to, buf.Bytes()); err != nil {
+		return errors.Wrap(err, "unable to send email")
+	}
+
+	return nil
+}
+
+// send is smtp.SendMail, except that it honours the context and timeout
+func (d *SMTPDriver) send(ctx context.Context, to string, msg []byte) error {
+	deadline := time.Now().Add(d.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+
+	dialer := &net.Dialer{Deadline: deadline}
+
+	conn, err := dialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return err
+	}
+
+	// The deadline covers the whole conversation, not just the dial
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, d.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: d.host}); err != nil {
+			return err
+		}
+	}
+
+	if d.auth != nil {
+		if err := c.Auth(d.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(d.from); err != nil {
+		return err
+	}
+
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,146 @@
+package notify
+
+import (
+	"context"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/outbox"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Channels that notifications can be delivered through
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelWebPush = "webpush"
+)
+
+// Message is a single notification to be delivered to an address on one channel
+type Message struct {
+	ID        int32
+	UserID    int32
+	EventType string
+	Subject   string
+	Body      string
+	// Address is channel specific: an email address, a webhook URL or a
+	// JSON encoded Web Push subscription
+	Address string
+}
+
+// Driver delivers messages over a channel
+type Driver interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Pending is a message waiting in the outbox
+type Pending struct {
+	Message
+	Channel    string
+	Attempts   int32
+	QuietHours QuietHours
+}
+
+// newPending converts a notification claimed from the outbox
+func newPending(n db.PendingNotification) Pending {
+	return Pending{
+		Message: Message{
+			ID:        n.ID,
+			UserID:    n.UserID,
+			EventType: n.EventType,
+			Subject:   n.Subject,
+			Body:      n.Body,
+			Address:   n.Address,
+		},
+		Channel:  n.Channel,
+		Attempts: n.Attempts,
+		QuietHours: QuietHours{
+			Start:    n.QuietHoursStart,
+			End:      n.QuietHoursEnd,
+			Location: n.Location,
+		},
+	}
+}
+
+// Outbox is where messages are queued, transactionally alongside the events
+// which caused them, until they are delivered
+type Outbox interface {
+	// ClaimNotifications returns up to limit due messages, hiding them from
+	// other dispatchers for the lease duration
+	ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]db.PendingNotification, error)
+	MarkNotificationDelivered(ctx context.Context, id int32) error
+	// RetryNotification records a failed attempt, scheduling the next one. A
+	// zero next time means the message will not be retried.
+	RetryNotification(ctx context.Context, id int32, lastError string, next time.Time) error
+	// DeferNotification postpones a message without counting an attempt
+	DeferNotification(ctx context.Context, id int32, until time.Time) error
+}
+
+var _ Outbox = (*db.Manager)(nil)
+
+// queue delivers the outbox through the driver of each message's channel
+type queue struct {
+	outbox  Outbox
+	drivers map[string]Driver
+}
+
+var _ outbox.Deferrer = (*queue)(nil)
+
+// NewDispatcher creates a dispatcher delivering messages from the outbox
+// through the driver of their channel
+func NewDispatcher(o Outbox, drivers map[string]Driver) *outbox.Dispatcher {
+	return outbox.NewDispatcher("notification", &queue{outbox: o, drivers: drivers}, outbox.Policy{
+		BatchSize:   100,
+		Lease:       time.Minute * 5,
+		BaseBackoff: time.Second * 30,
+		MaxBackoff:  time.Hour,
+		MaxAttempts: 8,
+	})
+}
+
+func (q *queue) Claim(ctx context.Context, limit int, lease time.Duration) ([]outbox.Entry, error) {
+	pending, err := q.outbox.ClaimNotifications(ctx, limit, lease)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]outbox.Entry, len(pending))
+	for i, n := range pending {
+		entries[i] = outbox.Entry{
+			ID:       n.ID,
+			Attempts: n.Attempts,
+			Value:    newPending(n),
+			Fields:   log.Fields{"channel": n.Channel},
+		}
+	}
+
+	return entries, nil
+}
+
+func (q *queue) Send(ctx context.Context, e outbox.Entry) error {
+	p := e.Value.(Pending)
+
+	driver, ok := q.drivers[p.Channel]
+	if !ok {
+		return outbox.Permanent(errors.Errorf("no driver configured for channel %s", p.Channel))
+	}
+
+	return driver.Send(ctx, p.Message)
+}
+
+func (q *queue) MarkDelivered(ctx context.Context, e outbox.Entry) error {
+	return q.outbox.MarkNotificationDelivered(ctx, e.ID)
+}
+
+func (q *queue) Retry(ctx context.Context, e outbox.Entry, lastError string, next time.Time) error {
+	return q.outbox.RetryNotification(ctx, e.ID, lastError, next)
+}
+
+func (q *queue) DeferUntil(e outbox.Entry, now time.Time) (time.Time, bool) {
+	return e.Value.(Pending).QuietHours.Until(now)
+}
+
+func (q *queue) Defer(ctx context.Context, e outbox.Entry, until time.Time) error {
+	return q.outbox.DeferNotification(ctx, e.ID, until)
+}
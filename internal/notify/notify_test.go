@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chorerewards/backend/internal/clock"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeOutbox struct {
+	pending   []db.PendingNotification
+	delivered []int32
+	retried   map[int32]time.Time
+	deferred  map[int32]time.Time
+}
+
+func (f *fakeOutbox) ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]db.PendingNotification, error) {
+	return f.pending, nil
+}
+
+func (f *fakeOutbox) MarkNotificationDelivered(ctx context.Context, id int32) error {
+	f.delivered = append(f.delivered, id)
+	return nil
+}
+
+func (f *fakeOutbox) RetryNotification(ctx context.Context, id int32, lastError string, next time.Time) error {
+	f.retried[id] = next
+	return nil
+}
+
+func (f *fakeOutbox) DeferNotification(ctx context.Context, id int32, until time.Time) error {
+	f.deferred[id] = until
+	return nil
+}
+
+type fakeDriver struct {
+	err  error
+	sent []Message
+}
+
+func (f *fakeDriver) Send(ctx context.Context, msg Message) error {
+	f.sent = append(f.sent, msg)
+	return f.err
+}
+
+func TestDispatcherRun(t *testing.T) {
+	now := time.Date(2021, 6, 7, 12, 0, 0, 0, time.UTC)
+
+	outbox := &fakeOutbox{
+		pending: []db.PendingNotification{
+			{ID: 1, Channel: ChannelEmail},
+			{ID: 2, Channel: ChannelWebhook, Attempts: 2},
+			{ID: 3, Channel: ChannelWebhook, Attempts: 7},
+			{ID: 4, Channel: ChannelEmail, QuietHoursStart: 11 * 60, QuietHoursEnd: 13 * 60},
+			{ID: 5, Channel: ChannelWebPush},
+		},
+		retried:  make(map[int32]time.Time),
+		deferred: make(map[int32]time.Time),
+	}
+
+	email := &fakeDriver{}
+	webhook := &fakeDriver{err: errors.New("connection refused")}
+
+	d := NewDispatcher(outbox, map[string]Driver{ChannelEmail: email, ChannelWebhook: webhook})
+	d.Clock = clock.Fixed(now)
+
+	assert.NoError(t, d.Run(context.Background()))
+
+	t.Run("it should mark successful deliveries as delivered", func(t *testing.T) {
+		assert.Equal(t, []int32{1}, outbox.delivered)
+		assert.Len(t, email.sent, 1)
+	})
+
+	t.Run("it should retry failed deliveries with backoff", func(t *testing.T) {
+		assert.Equal(t, now.Add(time.Minute*2), outbox.retried[2])
+	})
+
+	t.Run("it should give up after the maximum attempts", func(t *testing.T) {
+		assert.True(t, outbox.retried[3].IsZero())
+	})
+
+	t.Run("it should defer messages until quiet hours end", func(t *testing.T) {
+		assert.Equal(t, time.Date(2021, 6, 7, 13, 0, 0, 0, time.UTC), outbox.deferred[4])
+	})
+
+	t.Run("it should not retry messages for channels without a driver", func(t *testing.T) {
+		next, ok := outbox.retried[5]
+		assert.True(t, ok)
+		assert.True(t, next.IsZero())
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcher(nil, nil)
+
+	assert.Equal(t, time.Second*30, d.Backoff(1))
+	assert.Equal(t, time.Minute, d.Backoff(2))
+	assert.Equal(t, time.Minute*4, d.Backoff(4))
+	assert.Equal(t, time.Hour, d.Backoff(20))
+}
+
+func TestQuietHours(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	assert.NoError(t, err)
+
+	overnight := QuietHours{Start: 21 * 60, End: 7 * 60, Location: london}
+
+	t.Run("it should not be quiet when start and end are equal", func(t *testing.T) {
+		_, quiet := QuietHours{}.Until(time.Now())
+		assert.False(t, quiet)
+	})
+
+	t.Run("it should be quiet before midnight when the period wraps", func(t *testing.T) {
+		until, quiet := overnight.Until(time.Date(2021, 6, 7, 22, 30, 0, 0, london))
+
+		assert.True(t, quiet)
+		assert.Equal(t, time.Date(2021, 6, 8, 7, 0, 0, 0, london), until)
+	})
+
+	t.Run("it should be quiet after midnight when the period wraps", func(t *testing.T) {
+		until, quiet := overnight.Until(time.Date(2021, 6, 8, 5, 0, 0, 0, london))
+
+		assert.True(t, quiet)
+		assert.Equal(t, time.Date(2021, 6, 8, 7, 0, 0, 0, london), until)
+	})
+
+	t.Run("it should not be quiet during the day", func(t *testing.T) {
+		_, quiet := overnight.Until(time.Date(2021, 6, 8, 12, 0, 0, 0, london))
+		assert.False(t, quiet)
+	})
+
+	t.Run("it should use the quiet hours' time zone", func(t *testing.T) {
+		_, quiet := overnight.Until(time.Date(2021, 6, 8, 6, 30, 0, 0, time.UTC))
+		assert.False(t, quiet)
+	})
+}
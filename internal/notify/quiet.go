@@ -0,0 +1,45 @@
+package notify
+
+import "time"
+
+// QuietHours is a daily period during which notifications are held back. Start
+// and End are minutes after midnight in Location, and the period may wrap past
+// midnight. Equal Start and End means there are no quiet hours.
+type QuietHours struct {
+	Start    int32
+	End      int32
+	Location *time.Location
+}
+
+// Until reports whether t falls within the quiet hours and, if so, when they end
+func (q QuietHours) Until(t time.Time) (time.Time, bool) {
+	if q.Start == q.End {
+		return time.Time{}, false
+	}
+
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	local := t.In(loc)
+	minute := int32(local.Hour()*60 + local.Minute())
+
+	var quiet bool
+	if q.Start < q.End {
+		quiet = minute >= q.Start && minute < q.End
+	} else {
+		quiet = minute >= q.Start || minute < q.End
+	}
+
+	if !quiet {
+		return time.Time{}, false
+	}
+
+	end := time.Date(local.Year(), local.Month(), local.Day(), int(q.End/60), int(q.End%60), 0, 0, loc)
+	if !end.After(local) {
+		end = end.AddDate(0, 0, 1)
+	}
+
+	return end, true
+}
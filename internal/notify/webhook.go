@@ -0,0 +1,77 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/chorerewards/backend/internal/egress"
+	"github.com/pkg/errors"
+)
+
+// WebhookDriver delivers notifications by POSTing them as JSON to a URL
+type WebhookDriver struct {
+	client *http.Client
+}
+
+var _ Driver = (*WebhookDriver)(nil)
+
+// NewWebhookDriver creates a driver which refuses to deliver to internal
+// addresses, since webhook URLs are chosen by users
+func NewWebhookDriver(timeout time.Duration) *WebhookDriver {
+	return &WebhookDriver{
+		client: egress.NewClient(timeout),
+	}
+}
+
+type webhookPayload struct {
+	ID        int32  `json:"id"`
+	UserID    int32  `json:"user_id"`
+	EventType string `json:"event_type"`
+	Subject   string `json:"subject"`
+	Body      string `json:"body"`
+}
+
+func (d *WebhookDriver) Send(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(webhookPayload{
+		ID:        msg.ID,
+		UserID:    msg.UserID,
+		EventType: msg.EventType,
+		Subject:   msg.Subject,
+		Body:      msg.Body,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to encode webhook payload")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.Address, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "unable to create webhook request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	return do(d.client, req)
+}
+
+// do sends a request, treating any non-2xx response as an error
+func do(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/chorerewards/backend/internal/clock"
+	"github.com/chorerewards/backend/internal/egress"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+// recordSize is the aes128gcm record size advertised in the payload header.
+// Notifications are always sent as a single record.
+const recordSize = 4096
+
+// Subscription is a Web Push subscription as produced by PushManager.subscribe()
+// in the browser
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// WebPushDriver delivers notifications to browsers using the Web Push
+// protocol (RFC 8030), with payloads encrypted as described in RFC 8291 and
+// VAPID (RFC 8292) identifying the application server
+type WebPushDriver struct {
+	client     *http.Client
+	vapidKey   *ecdsa.PrivateKey
+	vapidPub   string
+	subscriber string
+	ttl        time.Duration
+	clock      clock.Clock
+}
+
+var _ Driver = (*WebPushDriver)(nil)
+
+// NewWebPushDriver creates a driver signing requests with the VAPID private
+// key, given as the base64url encoded 32 byte P-256 scalar produced by common
+// web-push tooling. subscriber is a mailto: or https: contact for the push
+// service operator.
+func NewWebPushDriver(vapidPrivateKey string, subscriber string, timeout time.Duration) (*WebPushDriver, error) {
+	d, err := base64.RawURLEncoding.DecodeString(vapidPrivateKey)
+	if err != nil || len(d) != 32 {
+		return nil, errors.New("VAPID private key must be a base64url encoded 32 byte P-256 key")
+	}
+
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	key.PublicKey.Curve = elliptic.P256()
+	key.PublicKey.X, key.PublicKey.Y = key.PublicKey.Curve.ScalarBaseMult(d)
+
+	return &WebPushDriver{
+		client:     egress.NewClient(timeout),
+		vapidKey:   key,
+		vapidPub:   base64.RawURLEncoding.EncodeToString(elliptic.Marshal(elliptic.P256(), key.X, key.Y)),
+		subscriber: subscriber,
+		ttl:        time.Hour * 24,
+		clock:      clock.Real{},
+	}, nil
+}
+
+type webPushPayload struct {
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	EventType string `json:"event_type"`
+}
+
+func (d *WebPushDriver) Send(ctx context.Context, msg Message) error {
+	var sub Subscription
+	if err := json.Unmarshal([]byte(msg.Address), &sub); err != nil {
+		return errors.Wrap(err, "invalid push subscription")
+	}
+
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return errors.New("invalid push subscription endpoint")
+	}
+
+	payload, err := json.Marshal(webPushPayload{Title: msg.Subject, Body: msg.Body, EventType: msg.EventType})
+	if err != nil {
+		return errors.Wrap(err, "unable to encode push payload")
+	}
+
+	body, err := encrypt(sub, payload)
+	if err != nil {
+		return err
+	}
+
+	token, err := d.vapidToken(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "unable to create push request")
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.FormatInt(int64(d.ttl/time.Second), 10))
+	req.Header.Set("Authorization", "vapid t="+token+", k="+d.vapidPub)
+
+	return do(d.client, req)
+}
+
+// vapidToken creates the JWT identifying this server to the push service
+func (d *WebPushDriver) vapidToken(audience string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": audience,
+		"exp": d.clock.Now().Add(time.Hour * 12).Unix(),
+		"sub": d.subscriber,
+	})
+
+	signed, err := token.SignedString(d.vapidKey)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to sign VAPID token")
+	}
+
+	return signed, nil
+}
+
+// encrypt encrypts a payload for a subscription using the aes128gcm content
+// encoding (RFC 8188) with keys derived as described in RFC 8291
+func encrypt(sub Subscription, plaintext []byte) ([]byte, error) {
+	uaPublic, err := base64.RawURLEncoding.DecodeString(sub.Keys.P256dh)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid subscription key")
+	}
+
+	authSecret, err := base64.RawURLEncoding.DecodeString(sub.Keys.Auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid subscription auth secret")
+	}
+
+	curve := elliptic.P256()
+
+	uaX, uaY := elliptic.Unmarshal(curve, uaPublic)
+	if uaX == nil {
+		return nil, errors.New("invalid subscription key")
+	}
+
+	asPrivate, asX, asY, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate key")
+	}
+
+	asPublic := elliptic.Marshal(curve, asX, asY)
+
+	sharedX, _ := curve.ScalarMult(uaX, uaY, asPrivate)
+	shared := make([]byte, 32)
+	sharedX.FillBytes(shared)
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "unable to generate salt")
+	}
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+
+	ikm, err := expand(hkdf.Extract(sha256.New, shared, authSecret), keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+
+	cek, err := expand(prk, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := expand(prk, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create cipher")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create cipher")
+	}
+
+	// A 0x02 delimiter marks the last (and only) record
+	record := append(append([]byte{}, plaintext...), 0x02)
+	if len(record)+gcm.Overhead() > recordSize {
+		return nil, errors.New("push payload is too large")
+	}
+
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = append(header, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[16:20], recordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+func expand(prk []byte, info []byte, length int) ([]byte, error) {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, info), out); err != nil {
+		return nil, errors.Wrap(err, "unable to derive key")
+	}
+
+	return out, nil
+}
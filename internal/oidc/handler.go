@@ -0,0 +1,277 @@
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/interceptors"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	stateCookie = "chorerewards_oidc"
+	cookiePath  = "/v1alpha1/oidc/"
+	// stateTTL is how long the user has to sign in with the provider
+	stateTTL = time.Minute * 10
+)
+
+// Service signs in the user with an identity verified by a provider, and
+// manages the identities linked to the caller
+type Service interface {
+	// LoginWithIdentity returns a ChoreRewards token for the user linked to
+	// the identity, or an auth.MFAChallengeError if they need to complete
+	// two-factor authentication first
+	LoginWithIdentity(ctx context.Context, identity Identity) (string, error)
+	ListIdentities(ctx context.Context) ([]db.UserIdentity, error)
+	UnlinkIdentity(ctx context.Context, provider string) error
+}
+
+// Authenticator authenticates the bearer token of a request, returning a
+// context carrying its principal
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (context.Context, error)
+}
+
+// Handler serves the sign in flow on the HTTP proxy. The state, nonce and
+// PKCE verifier of a sign in are kept in a signed cookie so that the callback
+// can be handled by any instance.
+type Handler struct {
+	service    Service
+	auth       Authenticator
+	providers  map[string]*Provider
+	baseURL    string
+	successURL string
+	stateKey   []byte
+}
+
+// NewHandler creates a handler for the providers. baseURL is the public URL
+// of the HTTP proxy, which the providers redirect back to, and successURL is
+// the web app page that receives the token, or an error, in its fragment.
+func NewHandler(service Service, auth Authenticator, providers []*Provider, baseURL string, successURL string, stateKey string) *Handler {
+	h := &Handler{
+		service:    service,
+		auth:       auth,
+		providers:  make(map[string]*Provider, len(providers)),
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		successURL: successURL,
+		stateKey:   []byte(stateKey),
+	}
+
+	for _, p := range providers {
+		h.providers[p.Name()] = p
+	}
+
+	return h
+}
+
+// Register adds the sign in and identity routes to the gateway mux
+func (h *Handler) Register(mux *runtime.ServeMux) error {
+	if err := mux.HandlePath(http.MethodGet, "/v1alpha1/oidc/{provider}/login", h.login); err != nil {
+		return err
+	}
+
+	if err := mux.HandlePath(http.MethodGet, "/v1alpha1/oidc/{provider}/callback", h.callback); err != nil {
+		return err
+	}
+
+	// The caller's linked identities are managed with their token
+	if err := mux.HandlePath(http.MethodGet, "/v1alpha1/oidc/identities", h.listIdentities); err != nil {
+		return err
+	}
+
+	return mux.HandlePath(http.MethodDelete, "/v1alpha1/oidc/identities/{provider}", h.unlinkIdentity)
+}
+
+func (h *Handler) redirectURL(provider string) string {
+	return h.baseURL + cookiePath + url.PathEscape(provider) + "/callback"
+}
+
+type loginState struct {
+	Provider  string `json:"p"`
+	State     string `json:"s"`
+	Nonce     string `json:"n"`
+	Verifier  string `json:"v"`
+	ExpiresAt int64  `json:"e"`
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "unable to generate random value")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (h *Handler) sign(payload string) string {
+	m := hmac.New(sha256.New, h.stateKey)
+	m.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+func (h *Handler) encodeState(s loginState) (string, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to encode state")
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	return payload + "." + h.sign(payload), nil
+}
+
+func (h *Handler) decodeState(value string) (loginState, error) {
+	var s loginState
+
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(h.sign(parts[0]))) {
+		return s, errors.New("invalid state cookie")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return s, errors.New("invalid state cookie")
+	}
+
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, errors.New("invalid state cookie")
+	}
+
+	if time.Now().Unix() > s.ExpiresAt {
+		return s, errors.New("sign in has expired")
+	}
+
+	return s, nil
+}
+
+func (h *Handler) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    value,
+		Path:     cookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.baseURL, "https://"),
+		// Lax so that the cookie is sent on the redirect back from the
+		// provider
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *Handler) login(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	provider, ok := h.providers[pathParams["provider"]]
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	s := loginState{Provider: provider.Name(), ExpiresAt: time.Now().Add(stateTTL).Unix()}
+
+	var err error
+	for _, v := range []*string{&s.State, &s.Nonce, &s.Verifier} {
+		if *v, err = randomString(); err != nil {
+			http.Error(w, "Unable to start sign in", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	challenge := sha256.Sum256([]byte(s.Verifier))
+
+	authURL, err := provider.AuthCodeURL(
+		r.Context(), h.redirectURL(provider.Name()), s.State, s.Nonce,
+		base64.RawURLEncoding.EncodeToString(challenge[:]),
+	)
+	if err != nil {
+		log.WithError(err).WithField("provider", provider.Name()).Error("Unable to start OIDC sign in")
+		http.Error(w, "Unable to contact provider", http.StatusBadGateway)
+		return
+	}
+
+	cookie, err := h.encodeState(s)
+	if err != nil {
+		http.Error(w, "Unable to start sign in", http.StatusInternalServerError)
+		return
+	}
+
+	h.setCookie(w, cookie, int(stateTTL/time.Second))
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// finish sends the user back to the web app with the result in the fragment,
+// which browsers don't send on to servers. The result is a token, an error, or
+// an MFA challenge for the app to complete.
+func (h *Handler) finish(w http.ResponseWriter, r *http.Request, result url.Values) {
+	h.setCookie(w, "", -1)
+
+	http.Redirect(w, r, h.successURL+"#"+result.Encode(), http.StatusFound)
+}
+
+func (h *Handler) callback(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	provider, ok := h.providers[pathParams["provider"]]
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	logger := log.WithField("provider", provider.Name())
+
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil {
+		http.Error(w, "Sign in was not started", http.StatusBadRequest)
+		return
+	}
+
+	s, err := h.decodeState(cookie.Value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+
+	if s.Provider != provider.Name() || !hmac.Equal([]byte(q.Get("state")), []byte(s.State)) {
+		http.Error(w, "State does not match", http.StatusBadRequest)
+		return
+	}
+
+	if e := q.Get("error"); e != "" {
+		logger.WithField("error", e).Info("OIDC sign in was refused")
+		h.finish(w, r, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), h.redirectURL(provider.Name()), q.Get("code"), s.Verifier, s.Nonce)
+	if err != nil {
+		logger.WithError(err).Warn("OIDC code exchange failed")
+		h.finish(w, r, url.Values{"error": {"login_failed"}})
+		return
+	}
+
+	token, err := h.service.LoginWithIdentity(interceptors.HTTPContext(r), identity)
+	if reason, challenge, ok := auth.MFAChallengeFromError(err); ok {
+		h.finish(w, r, url.Values{"mfa": {reason}, "challenge": {challenge}})
+		return
+	}
+
+	if err != nil {
+		logger.WithError(err).Info("OIDC identity could not be signed in")
+		h.finish(w, r, url.Values{"error": {"account_not_linked"}})
+		return
+	}
+
+	h.finish(w, r, url.Values{"token": {token}})
+}
@@ -0,0 +1,87 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chorerewards/backend/internal/interceptors"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/status"
+)
+
+type identity struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// authenticate authenticates the bearer token of a request, or writes an
+// error and returns false
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) (context.Context, bool) {
+	ctx, err := h.auth.Authenticate(interceptors.HTTPContext(r), strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		writeError(w, err)
+		return nil, false
+	}
+
+	return ctx, true
+}
+
+func (h *Handler) listIdentities(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	ctx, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	identities, err := h.service.ListIdentities(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := struct {
+		Identities []identity `json:"identities"`
+	}{Identities: make([]identity, 0, len(identities))}
+
+	// The subject is the provider's identifier for the user, which the app
+	// has no use for
+	for _, i := range identities {
+		resp.Identities = append(resp.Identities, identity{
+			Provider:  i.Provider,
+			Email:     i.Email,
+			CreatedAt: i.CreatedAt,
+		})
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) unlinkIdentity(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	ctx, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.UnlinkIdentity(ctx, pathParams["provider"]); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, struct{}{})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+
+	http.Error(w, s.Message(), runtime.HTTPStatusFromCode(s.Code()))
+}
@@ -0,0 +1,76 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (f *fakeService) ListIdentities(ctx context.Context) ([]db.UserIdentity, error) {
+	p, _ := auth.PrincipalFromContext(ctx)
+
+	return []db.UserIdentity{{UserID: p.UserID, Provider: "mock", Subject: "user-1", Email: "parent@example.com", CreatedAt: time.Unix(0, 0).UTC()}}, nil
+}
+
+func (f *fakeService) UnlinkIdentity(ctx context.Context, provider string) error {
+	if provider != "mock" {
+		return status.Error(codes.NotFound, "record not found")
+	}
+
+	return nil
+}
+
+type fakeAuth struct{}
+
+func (fakeAuth) Authenticate(ctx context.Context, token string) (context.Context, error) {
+	if token != "parent" {
+		return nil, status.Error(codes.Unauthenticated, "Invalid token")
+	}
+
+	return auth.ContextWithPrincipal(ctx, auth.Principal{UserID: 1, HouseholdID: 1}), nil
+}
+
+func TestIdentities(t *testing.T) {
+	mux := runtime.NewServeMux()
+
+	h := NewHandler(&fakeService{}, fakeAuth{}, nil, "https://api.example.com", "https://app.example.com/login", "state-key-which-is-long-enough-to-use")
+	assert.NoError(t, h.Register(mux))
+
+	call := func(method string, path string, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("it should list the caller's identities without their subjects", func(t *testing.T) {
+		w := call(http.MethodGet, "/v1alpha1/oidc/identities", "parent")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"identities":[{"provider":"mock","email":"parent@example.com","createdAt":"1970-01-01T00:00:00Z"}]}`, w.Body.String())
+	})
+
+	t.Run("it should unlink an identity", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, call(http.MethodDelete, "/v1alpha1/oidc/identities/mock", "parent").Code)
+		assert.Equal(t, http.StatusNotFound, call(http.MethodDelete, "/v1alpha1/oidc/identities/other", "parent").Code)
+	})
+
+	t.Run("it should require a token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/v1alpha1/oidc/identities", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, call(http.MethodDelete, "/v1alpha1/oidc/identities/mock", "").Code)
+	})
+}
@@ -0,0 +1,218 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockProvider is a minimal OpenID Connect provider which signs in a single
+// user without asking
+type mockProvider struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	subject  string
+	// codes maps issued codes to the nonce and PKCE challenge of the request
+	codes map[string][2]string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	m := &mockProvider{key: key, clientID: "chorerewards", subject: "user-1", codes: make(map[string][2]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "mock",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		m.codes["code-1"] = [2]string{q.Get("nonce"), q.Get("code_challenge")}
+
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {"code-1"}, "state": {q.Get("state")}}.Encode()
+
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+
+		code, ok := m.codes[r.PostForm.Get("code")]
+		challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+
+		if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != code[1] {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": m.idToken(t, code[0])})
+	})
+
+	m.Server = httptest.NewServer(mux)
+
+	return m
+}
+
+func (m *mockProvider) idToken(t *testing.T, nonce string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            m.URL,
+		"aud":            m.clientID,
+		"sub":            m.subject,
+		"nonce":          nonce,
+		"email":          "parent@example.com",
+		"email_verified": true,
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+	})
+	token.Header["kid"] = "mock"
+
+	signed, err := token.SignedString(m.key)
+	assert.NoError(t, err)
+
+	return signed
+}
+
+type fakeService struct {
+	identity Identity
+	err      error
+}
+
+func (f *fakeService) LoginWithIdentity(ctx context.Context, identity Identity) (string, error) {
+	f.identity = identity
+	return "chorerewards-token", f.err
+}
+
+func TestLoginFlow(t *testing.T) {
+	idp := newMockProvider(t)
+	defer idp.Close()
+
+	service := &fakeService{}
+
+	mux := runtime.NewServeMux()
+	proxy := httptest.NewServer(mux)
+	defer proxy.Close()
+
+	provider := NewProvider(ProviderConfig{Name: "mock", Issuer: idp.URL, ClientID: idp.clientID, ClientSecret: "secret"}, http.DefaultClient)
+
+	h := NewHandler(service, nil, []*Provider{provider}, proxy.URL, "https://app.example.com/login", "state-key-which-is-long-enough-to-use")
+	assert.NoError(t, h.Register(mux))
+
+	jar, _ := cookiejar.New(nil)
+
+	var final *url.URL
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Host == "app.example.com" {
+				final = req.URL
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+
+	resp, err := client.Get(proxy.URL + "/v1alpha1/oidc/mock/login")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	t.Run("it should return the token to the app", func(t *testing.T) {
+		if assert.NotNil(t, final) {
+			fragment, _ := url.ParseQuery(final.Fragment)
+			assert.Equal(t, "chorerewards-token", fragment.Get("token"))
+		}
+	})
+
+	t.Run("it should sign in with the verified identity", func(t *testing.T) {
+		assert.Equal(t, Identity{Provider: "mock", Subject: "user-1", Email: "parent@example.com", EmailVerified: true}, service.identity)
+	})
+
+	t.Run("it should reject a callback without the state cookie", func(t *testing.T) {
+		resp, err := http.Get(proxy.URL + "/v1alpha1/oidc/mock/callback?code=code-1&state=abc")
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("it should report identities which can't sign in", func(t *testing.T) {
+		service.err = errors.New("not linked")
+		final = nil
+
+		resp, err := client.Get(proxy.URL + "/v1alpha1/oidc/mock/login")
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		if assert.NotNil(t, final) {
+			fragment, _ := url.ParseQuery(final.Fragment)
+			assert.Equal(t, "account_not_linked", fragment.Get("error"))
+			assert.Empty(t, fragment.Get("token"))
+		}
+	})
+}
+
+func TestVerify(t *testing.T) {
+	idp := newMockProvider(t)
+	defer idp.Close()
+
+	provider := NewProvider(ProviderConfig{Name: "mock", Issuer: idp.URL, ClientID: idp.clientID}, http.DefaultClient)
+
+	t.Run("it should accept a token for this client", func(t *testing.T) {
+		_, err := provider.Verify(context.Background(), idp.idToken(t, "nonce"), "nonce")
+		assert.NoError(t, err)
+	})
+
+	t.Run("it should reject a token with another nonce", func(t *testing.T) {
+		_, err := provider.Verify(context.Background(), idp.idToken(t, "nonce"), "other")
+		assert.Error(t, err)
+	})
+
+	t.Run("it should reject a token for another client", func(t *testing.T) {
+		other := NewProvider(ProviderConfig{Name: "mock", Issuer: idp.URL, ClientID: "someone-else"}, http.DefaultClient)
+
+		_, err := other.Verify(context.Background(), idp.idToken(t, "nonce"), "nonce")
+		assert.Error(t, err)
+	})
+
+	t.Run("it should reject a token signed by another key", func(t *testing.T) {
+		other := newMockProvider(t)
+		defer other.Close()
+		other.URL = idp.URL
+
+		_, err := provider.Verify(context.Background(), other.idToken(t, "nonce"), "nonce")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,382 @@
+// Package oidc signs users in with an external OpenID Connect provider, using
+// the authorization code flow with PKCE
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// jwksRefreshInterval limits how often the provider's keys are fetched when a
+// token signed by an unknown key is seen
+const jwksRefreshInterval = time.Minute
+
+// ProviderConfig configures a provider
+type ProviderConfig struct {
+	// Name identifies the provider in URLs and linked identities
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Scopes requested in addition to openid
+	Scopes []string
+}
+
+// Identity is a user as identified by a provider
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect provider whose endpoints are found through
+// discovery
+type Provider struct {
+	config ProviderConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+func NewProvider(config ProviderConfig, client *http.Client) *Provider {
+	return &Provider{
+		config: config,
+		client: client,
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return errors.Wrap(err, "unable to create request")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(v), "unable to decode response")
+}
+
+// discover fetches the provider metadata, caching it once it has been fetched
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	d := &discovery{}
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", d); err != nil {
+		return nil, errors.Wrap(err, "unable to discover provider")
+	}
+
+	// The issuer must match exactly, see OpenID Connect Discovery 4.3
+	if d.Issuer != p.config.Issuer {
+		return nil, errors.Errorf("provider issuer %q does not match %q", d.Issuer, p.config.Issuer)
+	}
+
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("provider metadata is missing endpoints")
+	}
+
+	p.discovery = d
+
+	return d, nil
+}
+
+// AuthCodeURL returns the URL to send the user to in order to sign in
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURL string, state string, nonce string, codeChallenge string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid authorization endpoint")
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", redirectURL)
+	q.Set("scope", strings.Join(append([]string{"openid"}, p.config.Scopes...), " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+// Exchange swaps an authorization code for the user's identity, verifying the
+// ID token issued with it
+func (p *Provider) Exchange(ctx context.Context, redirectURL string, code string, codeVerifier string, nonce string) (Identity, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "unable to create token request")
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "token request failed")
+	}
+	defer resp.Body.Close()
+
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return Identity{}, errors.Wrap(err, "unable to decode token response")
+	}
+
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		return Identity{}, errors.Errorf("token request failed: %s %s", resp.Status, tr.Error)
+	}
+
+	return p.Verify(ctx, tr.IDToken, nonce)
+}
+
+// Verify checks an ID token was issued by the provider for this client, and
+// for the sign in with the given nonce
+func (p *Provider) Verify(ctx context.Context, idToken string, nonce string) (Identity, error) {
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(idToken, claims, func(tkn *jwt.Token) (interface{}, error) {
+		kid, _ := tkn.Header["kid"].(string)
+
+		key, err := p.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only accept the algorithm matching the key, so that a public key
+		// can't be used as an HMAC secret
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if tkn.Method != jwt.SigningMethodRS256 {
+				return nil, errors.New("unexpected signing method")
+			}
+		case *ecdsa.PublicKey:
+			if tkn.Method != jwt.SigningMethodES256 {
+				return nil, errors.New("unexpected signing method")
+			}
+		}
+
+		return key, nil
+	})
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "invalid ID token")
+	}
+
+	if !claims.VerifyIssuer(p.config.Issuer, true) {
+		return Identity{}, errors.New("ID token has the wrong issuer")
+	}
+
+	if !hasAudience(claims["aud"], p.config.ClientID) {
+		return Identity{}, errors.New("ID token was not issued to this client")
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return Identity{}, errors.New("ID token has no expiry")
+	}
+
+	if n, _ := claims["nonce"].(string); n == "" || n != nonce {
+		return Identity{}, errors.New("ID token nonce does not match")
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return Identity{}, errors.New("ID token has no subject")
+	}
+
+	email, _ := claims["email"].(string)
+	emailVerified, _ := claims["email_verified"].(bool)
+
+	return Identity{
+		Provider:      p.config.Name,
+		Subject:       sub,
+		Email:         email,
+		EmailVerified: emailVerified,
+	}, nil
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// key returns the provider's signing key with the given ID, fetching the
+// provider's keys again if it isn't known, as the provider may have rotated
+// its keys
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(p.fetchedAt) < jwksRefreshInterval {
+		return nil, errors.New("unknown signing key")
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, errors.Wrap(err, "unable to fetch provider keys")
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		if key, err := k.publicKey(); err == nil {
+			keys[k.KeyID] = key
+		}
+	}
+
+	p.keys = keys
+	p.fetchedAt = time.Now()
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+
+	return key, nil
+}
+
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, errors.Errorf("unsupported curve %q", k.Curve)
+		}
+
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC key")
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, errors.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
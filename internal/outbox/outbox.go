@@ -0,0 +1,148 @@
+// Package outbox delivers messages which were queued in the database alongside
+// the events that caused them, retrying failures with exponential backoff
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/chorerewards/backend/internal/clock"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Entry is a message claimed from an outbox
+type Entry struct {
+	ID int32
+	// Attempts is the number of attempts made before this one
+	Attempts int32
+	// Value is the message itself, as claimed by the queue
+	Value interface{}
+	// Fields are logged along with failed attempts
+	Fields log.Fields
+}
+
+// Queue is an outbox along with the means of delivering its entries
+type Queue interface {
+	// Claim returns up to limit due entries, hiding them from other
+	// dispatchers for the lease duration
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]Entry, error)
+	// Send attempts to deliver an entry
+	Send(ctx context.Context, e Entry) error
+	MarkDelivered(ctx context.Context, e Entry) error
+	// Retry records a failed attempt, scheduling the next one. A zero next
+	// time means the entry will not be retried.
+	Retry(ctx context.Context, e Entry, lastError string, next time.Time) error
+}
+
+// Deferrer is implemented by queues which hold some entries back without
+// attempting them, such as notifications during quiet hours
+type Deferrer interface {
+	// DeferUntil reports whether an entry is to be held back at now and, if
+	// so, until when
+	DeferUntil(e Entry, now time.Time) (time.Time, bool)
+	// Defer postpones an entry without counting an attempt
+	Defer(ctx context.Context, e Entry, until time.Time) error
+}
+
+type permanentError struct {
+	error
+}
+
+func (p permanentError) Unwrap() error {
+	return p.error
+}
+
+// Permanent marks an error from Send as one which retrying won't fix, so the
+// entry is given up on straight away
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Policy is how many entries are claimed at once and how failures are retried
+type Policy struct {
+	BatchSize   int
+	Lease       time.Duration
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	MaxAttempts int32
+}
+
+// Backoff returns the delay before the next attempt after the given number of
+// failed attempts
+func (p Policy) Backoff(attempts int32) time.Duration {
+	backoff := p.BaseBackoff
+	for i := int32(1); i < attempts && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+
+	return backoff
+}
+
+// Dispatcher delivers the entries of a queue
+type Dispatcher struct {
+	Policy
+	Clock clock.Clock
+	name  string
+	queue Queue
+}
+
+// NewDispatcher creates a dispatcher for a queue. The name identifies the
+// queue in logs.
+func NewDispatcher(name string, queue Queue, policy Policy) *Dispatcher {
+	return &Dispatcher{
+		Policy: policy,
+		Clock:  clock.Real{},
+		name:   name,
+		queue:  queue,
+	}
+}
+
+// Run delivers a batch of due entries
+func (d *Dispatcher) Run(ctx context.Context) error {
+	entries, err := d.queue.Claim(ctx, d.BatchSize, d.Lease)
+	if err != nil {
+		return errors.Wrapf(err, "unable to claim %s deliveries", d.name)
+	}
+
+	for _, e := range entries {
+		if err := d.deliver(ctx, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, e Entry) error {
+	now := d.Clock.Now()
+
+	if deferrer, ok := d.queue.(Deferrer); ok {
+		if until, deferred := deferrer.DeferUntil(e, now); deferred {
+			return deferrer.Defer(ctx, e, until)
+		}
+	}
+
+	if err := d.queue.Send(ctx, e); err != nil {
+		attempts := e.Attempts + 1
+
+		var next time.Time
+		if attempts < d.MaxAttempts && !errors.As(err, &permanentError{}) {
+			next = now.Add(d.Backoff(attempts))
+		}
+
+		log.WithFields(e.Fields).WithFields(log.Fields{
+			"outbox":   d.name,
+			"id":       e.ID,
+			"attempts": attempts,
+		}).WithError(err).Warn("Delivery failed")
+
+		return d.queue.Retry(ctx, e, err.Error(), next)
+	}
+
+	return d.queue.MarkDelivered(ctx, e)
+}
@@ -0,0 +1,123 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chorerewards/backend/internal/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeQueue struct {
+	entries   []Entry
+	errors    map[int32]error
+	delivered []int32
+	retried   map[int32]time.Time
+	deferred  map[int32]time.Time
+}
+
+func (f *fakeQueue) Claim(ctx context.Context, limit int, lease time.Duration) ([]Entry, error) {
+	return f.entries, nil
+}
+
+func (f *fakeQueue) Send(ctx context.Context, e Entry) error {
+	return f.errors[e.ID]
+}
+
+func (f *fakeQueue) MarkDelivered(ctx context.Context, e Entry) error {
+	f.delivered = append(f.delivered, e.ID)
+	return nil
+}
+
+func (f *fakeQueue) Retry(ctx context.Context, e Entry, lastError string, next time.Time) error {
+	f.retried[e.ID] = next
+	return nil
+}
+
+// deferringQueue holds back entries whose value is the time to defer them until
+type deferringQueue struct {
+	*fakeQueue
+}
+
+func (d deferringQueue) DeferUntil(e Entry, now time.Time) (time.Time, bool) {
+	until, ok := e.Value.(time.Time)
+	return until, ok
+}
+
+func (d deferringQueue) Defer(ctx context.Context, e Entry, until time.Time) error {
+	d.deferred[e.ID] = until
+	return nil
+}
+
+var policy = Policy{
+	BatchSize:   10,
+	Lease:       time.Minute,
+	BaseBackoff: time.Second * 30,
+	MaxBackoff:  time.Hour,
+	MaxAttempts: 8,
+}
+
+func TestBackoff(t *testing.T) {
+	t.Run("it should double the delay after each attempt", func(t *testing.T) {
+		assert.Equal(t, time.Second*30, policy.Backoff(1))
+		assert.Equal(t, time.Minute, policy.Backoff(2))
+		assert.Equal(t, time.Minute*4, policy.Backoff(4))
+	})
+
+	t.Run("it should cap the delay", func(t *testing.T) {
+		assert.Equal(t, time.Hour, policy.Backoff(20))
+	})
+}
+
+func TestDispatcherRun(t *testing.T) {
+	now := time.Date(2021, 6, 7, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+
+	queue := &fakeQueue{
+		entries: []Entry{
+			{ID: 1},
+			{ID: 2, Attempts: 2},
+			{ID: 3, Attempts: 7},
+			{ID: 4},
+			{ID: 5, Value: later},
+		},
+		errors: map[int32]error{
+			2: errors.New("connection refused"),
+			3: errors.New("connection refused"),
+			4: Permanent(errors.New("no driver")),
+		},
+		retried:  make(map[int32]time.Time),
+		deferred: make(map[int32]time.Time),
+	}
+
+	d := NewDispatcher("test", deferringQueue{queue}, policy)
+	d.Clock = clock.Fixed(now)
+
+	assert.NoError(t, d.Run(context.Background()))
+
+	t.Run("it should mark successful deliveries as delivered", func(t *testing.T) {
+		assert.Equal(t, []int32{1}, queue.delivered)
+	})
+
+	t.Run("it should retry failed deliveries with backoff", func(t *testing.T) {
+		assert.Equal(t, now.Add(time.Minute*2), queue.retried[2])
+	})
+
+	t.Run("it should give up after the maximum attempts", func(t *testing.T) {
+		next, ok := queue.retried[3]
+		assert.True(t, ok)
+		assert.True(t, next.IsZero())
+	})
+
+	t.Run("it should not retry permanent failures", func(t *testing.T) {
+		next, ok := queue.retried[4]
+		assert.True(t, ok)
+		assert.True(t, next.IsZero())
+	})
+
+	t.Run("it should defer entries the queue holds back", func(t *testing.T) {
+		assert.Equal(t, later, queue.deferred[5])
+	})
+}
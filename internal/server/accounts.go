@@ -0,0 +1,295 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/mail"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	emailVerificationTTL = time.Hour * 24
+	passwordResetTTL     = time.Hour
+	minPasswordLength    = 8
+	// passwordResetTimeout limits how long sending a password reset email in
+	// the background may take
+	passwordResetTimeout = time.Minute
+	// passwordResetQueueSize bounds how many password resets can wait to be
+	// sent. Requests beyond it are dropped.
+	passwordResetQueueSize = 100
+	// passwordResetInterval is how often a password reset can be sent to each
+	// address
+	passwordResetInterval = time.Minute
+)
+
+// passwordResets queues the addresses to send password resets to, allowing
+// one for each address every passwordResetInterval
+type passwordResets struct {
+	queue chan string
+
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+func newPasswordResets() *passwordResets {
+	return &passwordResets{
+		queue: make(chan string, passwordResetQueueSize),
+		sent:  make(map[string]time.Time),
+	}
+}
+
+// add queues a password reset for an address, reporting whether it was
+// queued
+func (r *passwordResets) add(email string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for address, at := range r.sent {
+		if now.Sub(at) >= passwordResetInterval {
+			delete(r.sent, address)
+		}
+	}
+
+	if _, ok := r.sent[email]; ok {
+		return false
+	}
+
+	select {
+	case r.queue <- email:
+		r.sent[email] = now
+		return true
+	default:
+		return false
+	}
+}
+
+// accountLink builds the link to the web app page handling a one-time token
+func (s *Server) accountLink(page string, token string) string {
+	return s.appURL + "/" + page + "?" + url.Values{"token": {token}}.Encode()
+}
+
+// SendEmailVerification emails the caller a link to verify their address
+func (s *Server) SendEmailVerification(ctx context.Context) error {
+	p, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.dbManager.GetUserByID(ctx, p.UserID)
+	if err != nil {
+		return statusError(err)
+	}
+
+	return s.sendEmailVerification(ctx, user)
+}
+
+// sendEmailVerification emails a user a link to verify their address
+func (s *Server) sendEmailVerification(ctx context.Context, user db.User) error {
+	token, err := issueEmailVerification(ctx, s.dbManager, user)
+	if err != nil {
+		return statusError(err)
+	}
+
+	return s.mailEmailVerification(ctx, user, token)
+}
+
+// issueEmailVerification stores a new email verification token for a user,
+// returning the token to send them. The store may be a transaction, so that
+// the token is only kept if the user is.
+func issueEmailVerification(ctx context.Context, store db.Store, user db.User) (string, error) {
+	if user.Email == "" {
+		return "", status.Error(codes.FailedPrecondition, "User has no email address")
+	}
+
+	if user.EmailVerified {
+		return "", status.Error(codes.FailedPrecondition, "Email address is already verified")
+	}
+
+	token, hash, err := auth.NewOneTimeToken()
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+
+	if err := store.CreateUserToken(ctx, user.ID, db.TokenPurposeVerifyEmail, hash, time.Now().Add(emailVerificationTTL)); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// mailEmailVerification emails a user the link for a verification token
+func (s *Server) mailEmailVerification(ctx context.Context, user db.User, token string) error {
+	err := s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease verify your email address by following this link:\n\n%s\n\nThe link expires in 24 hours.",
+			user.Username, s.accountLink("verify-email", token),
+		),
+	})
+	if err != nil {
+		return status.Error(codes.Internal, errors.Wrap(err, "unable to send email").Error())
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the address a verification token was sent to as verified
+func (s *Server) VerifyEmail(ctx context.Context, token string) error {
+	if _, err := s.dbManager.VerifyEmail(ctx, auth.HashOneTimeToken(token)); err != nil {
+		return statusError(err)
+	}
+
+	return nil
+}
+
+// RequestPasswordReset emails a password reset link to the users with the
+// given email address. It succeeds straight away whether or not the address
+// belongs to anyone, queueing the email to be sent in the background so that
+// neither the result nor how long the call takes reveals who has an account.
+// Requests for an address which was sent a reset recently, or when the queue
+// is full, are dropped.
+func (s *Server) RequestPasswordReset(ctx context.Context, email string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil
+	}
+
+	if !s.resets.add(email, time.Now()) {
+		log.Info("Password reset dropped")
+	}
+
+	return nil
+}
+
+// SendPasswordResets sends the queued password resets until the context is
+// cancelled
+func (s *Server) SendPasswordResets(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case email := <-s.resets.queue:
+			sendCtx, cancel := context.WithTimeout(ctx, passwordResetTimeout)
+			if err := s.sendPasswordReset(sendCtx, email); err != nil {
+				log.WithError(err).Warn("Unable to send password reset")
+			}
+			cancel()
+		}
+	}
+}
+
+// sendPasswordReset emails a password reset link to each active user with
+// the given email address, if there are any
+func (s *Server) sendPasswordReset(ctx context.Context, email string) error {
+	users, err := s.dbManager.ListUsersByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if !user.IsActive {
+			continue
+		}
+
+		token, hash, err := auth.NewOneTimeToken()
+		if err != nil {
+			return err
+		}
+
+		if err := s.dbManager.CreateUserToken(ctx, user.ID, db.TokenPurposeResetPassword, hash, time.Now().Add(passwordResetTTL)); err != nil {
+			return err
+		}
+
+		err = s.mailer.Send(ctx, mail.Message{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf(
+				"Hi %s,\n\nSomeone asked to reset your password. To choose a new one, follow this link:\n\n%s\n\n"+
+					"The link expires in an hour. If you didn't ask for this you can ignore this email.",
+				user.Username, s.accountLink("reset-password", token),
+			),
+		})
+		if err != nil {
+			return errors.Wrap(err, "unable to send email")
+		}
+	}
+
+	return nil
+}
+
+func hashNewPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", status.Errorf(codes.InvalidArgument, "Password must be at least %d characters", minPasswordLength)
+	}
+
+	hash, err := auth.HashPassword([]byte(password))
+	if err != nil {
+		return "", status.Error(codes.Internal, "unable to hash password")
+	}
+
+	return string(hash), nil
+}
+
+// ResetPassword sets a new password using a password reset token. Everyone
+// signed in as the user is signed out.
+func (s *Server) ResetPassword(ctx context.Context, token string, password string) error {
+	hash, err := hashNewPassword(password)
+	if err != nil {
+		return err
+	}
+
+	userID, err := s.dbManager.ResetPassword(ctx, auth.HashOneTimeToken(token), hash)
+	if err != nil {
+		return statusError(err)
+	}
+
+	s.sessions.InvalidateUser(userID)
+
+	return nil
+}
+
+// ChangePassword sets a new password for the caller, who must know their
+// current one. Everyone signed in as the user is signed out.
+func (s *Server) ChangePassword(ctx context.Context, currentPassword string, password string) error {
+	p, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.dbManager.GetUserByID(ctx, p.UserID)
+	if err != nil {
+		return statusError(err)
+	}
+
+	if !auth.PasswordMatches([]byte(user.Password), []byte(currentPassword)) {
+		return status.Error(codes.PermissionDenied, "incorrect password")
+	}
+
+	hash, err := hashNewPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.dbManager.SetPassword(ctx, user.ID, hash); err != nil {
+		return statusError(err)
+	}
+
+	s.sessions.InvalidateUser(user.ID)
+
+	log.WithFields(log.Fields{
+		"id": user.ID,
+	}).Info("Password changed")
+
+	return nil
+}
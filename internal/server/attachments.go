@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/chorerewards/backend/internal/attachments"
+	"github.com/chorerewards/backend/internal/blob"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UploadAttachment attaches a photo to a feed entry, generating a thumbnail
+// alongside it. Only the assignee of the entry or a parent in the same
+// household may upload.
+func (s *Server) UploadAttachment(ctx context.Context, username string, taskFeedID int32, content []byte) (db.Attachment, error) {
+	if s.blobStore == nil {
+		return db.Attachment{}, status.Error(codes.Unimplemented, "Attachments are not enabled")
+	}
+
+	if !s.runtimeConfig.FeatureEnabled(featureAttachmentUploads) {
+		return db.Attachment{}, status.Error(codes.Unavailable, "Attachment uploads are turned off")
+	}
+
+	user, err := s.dbManager.GetUser(ctx, username)
+	if err != nil {
+		return db.Attachment{}, statusError(err)
+	}
+
+	taskFeed, err := s.dbManager.GetTaskFeed(ctx, taskFeedID)
+	if err != nil {
+		return db.Attachment{}, statusError(err)
+	}
+
+	assignee, err := s.dbManager.GetUserByID(ctx, taskFeed.AssigneeID)
+	if err != nil {
+		return db.Attachment{}, statusError(err)
+	}
+
+	if assignee.HouseholdID != user.HouseholdID || (assignee.ID != user.ID && !user.IsParent) {
+		return db.Attachment{}, status.Error(codes.PermissionDenied, "Only the assignee or a parent can attach files to this task")
+	}
+
+	contentType, err := attachments.DetectContentType(content)
+	if err != nil {
+		return db.Attachment{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	thumbnail, err := attachments.Thumbnail(content)
+	if err != nil {
+		return db.Attachment{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	suffix := make([]byte, 16)
+	if _, err := rand.Read(suffix); err != nil {
+		return db.Attachment{}, errors.Wrap(err, "unable to generate blob key")
+	}
+
+	key := fmt.Sprintf("%d/%d/%s", user.HouseholdID, taskFeedID, hex.EncodeToString(suffix))
+
+	a := db.Attachment{
+		TaskFeedID:   taskFeedID,
+		HouseholdID:  user.HouseholdID,
+		UploaderID:   user.ID,
+		ContentType:  contentType,
+		Size:         int64(len(content)),
+		BlobKey:      key,
+		ThumbnailKey: key + "-thumbnail",
+	}
+
+	if err := s.blobStore.Put(ctx, a.BlobKey, bytes.NewReader(content)); err != nil {
+		return db.Attachment{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := s.blobStore.Put(ctx, a.ThumbnailKey, bytes.NewReader(thumbnail)); err != nil {
+		s.deleteBlobs(ctx, a.BlobKey)
+		return db.Attachment{}, status.Error(codes.Internal, err.Error())
+	}
+
+	attachment, err := s.dbManager.CreateAttachment(ctx, a)
+	if err != nil {
+		s.deleteBlobs(ctx, a.BlobKey, a.ThumbnailKey)
+		return db.Attachment{}, statusError(err)
+	}
+
+	return attachment, nil
+}
+
+func (s *Server) deleteBlobs(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		if err := s.blobStore.Delete(ctx, key); err != nil {
+			log.WithFields(log.Fields{"key": key}).WithError(err).Warn("Unable to clean up blob")
+		}
+	}
+}
+
+// OpenAttachment returns the content of an attachment, or of its thumbnail.
+// Attachments outside of householdID are reported as not found.
+func (s *Server) OpenAttachment(ctx context.Context, id int32, householdID int32, thumbnail bool) (io.ReadCloser, db.Attachment, error) {
+	if s.blobStore == nil {
+		return nil, db.Attachment{}, status.Error(codes.Unimplemented, "Attachments are not enabled")
+	}
+
+	a, err := s.dbManager.GetAttachment(ctx, id)
+	if err != nil {
+		return nil, db.Attachment{}, statusError(err)
+	}
+
+	if a.HouseholdID != householdID {
+		return nil, db.Attachment{}, status.Error(codes.NotFound, "record not found")
+	}
+
+	key := a.BlobKey
+	if thumbnail {
+		key = a.ThumbnailKey
+		a.ContentType = "image/jpeg"
+	}
+
+	r, err := s.blobStore.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotExist) {
+			return nil, db.Attachment{}, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, db.Attachment{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return r, a, nil
+}
+
+// ListAttachments lists the attachments of a feed entry in the caller's
+// household
+func (s *Server) ListAttachments(ctx context.Context, taskFeedID int32) ([]db.Attachment, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	taskFeed, err := s.dbManager.GetTaskFeed(ctx, taskFeedID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	// Entries in other households are reported as missing so their IDs can't
+	// be probed
+	if _, err := s.householdMember(ctx, taskFeed.AssigneeID, p.HouseholdID); err != nil {
+		return nil, status.Error(codes.NotFound, "record not found")
+	}
+
+	a, err := s.dbManager.ListAttachments(ctx, taskFeedID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return a, nil
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ListOpenTasks lists the unclaimed open tasks in the caller's household
+func (s *Server) ListOpenTasks(ctx context.Context) ([]db.Task, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := s.dbManager.ListOpenTasks(ctx, p.HouseholdID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return tasks, nil
+}
+
+// SetTaskOpen lets a parent open a task in their household for any child to
+// claim, or close it again. Tasks are only open when a parent opens them, as
+// tasks created without an assignee were never claimable before.
+func (s *Server) SetTaskOpen(ctx context.Context, taskID int32, open bool, claimDuration time.Duration, claimBonusPoints int32) (db.Task, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.Task{}, err
+	}
+
+	if !p.IsParent() {
+		return db.Task{}, status.Error(codes.PermissionDenied, "Only parents can open tasks")
+	}
+
+	if claimDuration < 0 || claimBonusPoints < 0 {
+		return db.Task{}, status.Error(codes.InvalidArgument, "Claim duration and bonus cannot be negative")
+	}
+
+	task, err := s.dbManager.SetTaskClaims(ctx, taskID, p.HouseholdID, open, claimDuration, claimBonusPoints)
+	if err != nil {
+		return db.Task{}, statusError(err)
+	}
+
+	return task, nil
+}
+
+// ClaimTask claims an open task for the calling child. Only the first child to
+// claim a task succeeds; later claims fail with codes.FailedPrecondition.
+func (s *Server) ClaimTask(ctx context.Context, taskID int32) (db.Task, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.Task{}, err
+	}
+
+	task, err := s.dbManager.ClaimTask(ctx, taskID, p.UserID)
+	if err != nil {
+		return db.Task{}, statusError(err)
+	}
+
+	return task, nil
+}
+
+// ReleaseExpiredClaims reopens open tasks whose claim expired before they were
+// completed. It is intended to be run periodically in the background.
+func (s *Server) ReleaseExpiredClaims(ctx context.Context) error {
+	if _, err := s.dbManager.ReleaseExpiredClaims(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
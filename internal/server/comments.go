@@ -0,0 +1,343 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/chorerewards/backend/internal/db"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxCommentLength      = 2000
+	defaultCommentsPage   = 50
+	maxCommentsPage       = 200
+	maxEmojiLength        = 8
+	mentionsInboxPageSize = 50
+)
+
+// CommentPage is a page of comments along with their reactions
+type CommentPage struct {
+	Comments  []db.Comment
+	Reactions []db.ReactionCount
+	// NextPageToken is passed to ListComments to fetch the following page.
+	// It is zero when there are no more comments.
+	NextPageToken int32
+}
+
+// householdMember returns the user if they belong to the household
+func (s *Server) householdMember(ctx context.Context, userID int32, householdID int32) (db.User, error) {
+	return householdMember(ctx, s.dbManager, userID, householdID)
+}
+
+// householdMember returns an active user of the household from store, which
+// may be a transaction
+func householdMember(ctx context.Context, store db.Store, userID int32, householdID int32) (db.User, error) {
+	user, err := store.GetUserByID(ctx, userID)
+	if err != nil {
+		return db.User{}, statusError(err)
+	}
+
+	if user.HouseholdID != householdID || !user.IsActive {
+		return db.User{}, status.Error(codes.PermissionDenied, "Not a member of this household")
+	}
+
+	return user, nil
+}
+
+// commentTargetHousehold returns the household a task or feed entry belongs to
+func (s *Server) commentTargetHousehold(ctx context.Context, taskID int32, taskFeedID int32) (int32, error) {
+	if (taskID == 0) == (taskFeedID == 0) {
+		return 0, status.Error(codes.InvalidArgument, "Specify either a Task OR a Task Feed entry")
+	}
+
+	if taskID != 0 {
+		task, err := s.dbManager.GetTaskByID(ctx, taskID)
+		if err != nil {
+			return 0, statusError(err)
+		}
+
+		return task.HouseholdID, nil
+	}
+
+	taskFeed, err := s.dbManager.GetTaskFeed(ctx, taskFeedID)
+	if err != nil {
+		return 0, statusError(err)
+	}
+
+	assignee, err := s.dbManager.GetUserByID(ctx, taskFeed.AssigneeID)
+	if err != nil {
+		return 0, statusError(err)
+	}
+
+	return assignee.HouseholdID, nil
+}
+
+func validateCommentBody(body string) error {
+	if strings.TrimSpace(body) == "" {
+		return status.Error(codes.InvalidArgument, "Comment cannot be empty")
+	}
+
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		return status.Errorf(codes.InvalidArgument, "Comments are limited to %d characters", maxCommentLength)
+	}
+
+	return nil
+}
+
+// CreateComment comments on a task or feed entry, or replies to an existing
+// comment. Users mentioned with @username are notified.
+func (s *Server) CreateComment(ctx context.Context, comment db.Comment) (db.Comment, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.Comment{}, err
+	}
+
+	if err := validateCommentBody(comment.Body); err != nil {
+		return db.Comment{}, err
+	}
+
+	householdID, err := s.commentTargetHousehold(ctx, comment.TaskID, comment.TaskFeedID)
+	if err != nil {
+		return db.Comment{}, err
+	}
+
+	if _, err := s.householdMember(ctx, p.UserID, householdID); err != nil {
+		return db.Comment{}, err
+	}
+
+	if comment.ParentID != 0 {
+		parent, err := s.dbManager.GetComment(ctx, comment.ParentID)
+		if err != nil {
+			return db.Comment{}, statusError(err)
+		}
+
+		if parent.TaskID != comment.TaskID || parent.TaskFeedID != comment.TaskFeedID {
+			return db.Comment{}, status.Error(codes.InvalidArgument, "Replies must be on the same task as the comment they reply to")
+		}
+	}
+
+	comment.HouseholdID = householdID
+	comment.AuthorID = p.UserID
+
+	c, err := s.dbManager.CreateComment(ctx, comment)
+	if err != nil {
+		return db.Comment{}, statusError(err)
+	}
+
+	return c, nil
+}
+
+// ListComments lists the comments on a task or feed entry, oldest first
+func (s *Server) ListComments(ctx context.Context, taskID int32, taskFeedID int32, pageToken int32, pageSize int) (CommentPage, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return CommentPage{}, err
+	}
+
+	householdID, err := s.commentTargetHousehold(ctx, taskID, taskFeedID)
+	if err != nil {
+		return CommentPage{}, err
+	}
+
+	if _, err := s.householdMember(ctx, p.UserID, householdID); err != nil {
+		return CommentPage{}, err
+	}
+
+	if pageSize <= 0 {
+		pageSize = defaultCommentsPage
+	}
+
+	if pageSize > maxCommentsPage {
+		pageSize = maxCommentsPage
+	}
+
+	// Fetch one extra comment to find out whether there is another page
+	comments, err := s.dbManager.ListComments(ctx, taskID, taskFeedID, pageToken, pageSize+1)
+	if err != nil {
+		return CommentPage{}, statusError(err)
+	}
+
+	page := CommentPage{Comments: comments}
+
+	if len(comments) > pageSize {
+		page.Comments = comments[:pageSize]
+		page.NextPageToken = page.Comments[pageSize-1].ID
+	}
+
+	ids := make([]int32, len(page.Comments))
+	for i, c := range page.Comments {
+		ids[i] = c.ID
+	}
+
+	page.Reactions, err = s.dbManager.ListReactions(ctx, ids)
+	if err != nil {
+		return CommentPage{}, statusError(err)
+	}
+
+	return page, nil
+}
+
+// EditComment changes the body of a comment. Only the author can edit a
+// comment, and the previous body is kept in its history.
+func (s *Server) EditComment(ctx context.Context, commentID int32, body string) (db.Comment, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.Comment{}, err
+	}
+
+	if err := validateCommentBody(body); err != nil {
+		return db.Comment{}, err
+	}
+
+	comment, err := s.dbManager.GetComment(ctx, commentID)
+	if err != nil {
+		return db.Comment{}, statusError(err)
+	}
+
+	if comment.AuthorID != p.UserID {
+		return db.Comment{}, status.Error(codes.PermissionDenied, "Only the author can edit a comment")
+	}
+
+	c, err := s.dbManager.UpdateComment(ctx, commentID, body)
+	if err != nil {
+		return db.Comment{}, statusError(err)
+	}
+
+	return c, nil
+}
+
+// DeleteComment deletes a comment. Authors can delete their own comments and
+// parents can delete any comment in their household.
+func (s *Server) DeleteComment(ctx context.Context, commentID int32) error {
+	p, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+
+	comment, err := s.dbManager.GetComment(ctx, commentID)
+	if err != nil {
+		return statusError(err)
+	}
+
+	user, err := s.householdMember(ctx, p.UserID, comment.HouseholdID)
+	if err != nil {
+		return err
+	}
+
+	if comment.AuthorID != p.UserID && !user.IsParent {
+		return status.Error(codes.PermissionDenied, "Only the author or a parent can delete a comment")
+	}
+
+	if _, err := s.dbManager.UpdateComment(ctx, commentID, ""); err != nil {
+		return statusError(err)
+	}
+
+	return nil
+}
+
+// ListCommentRevisions returns the edit history of a comment
+func (s *Server) ListCommentRevisions(ctx context.Context, commentID int32) ([]db.CommentRevision, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	comment, err := s.dbManager.GetComment(ctx, commentID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	if _, err := s.householdMember(ctx, p.UserID, comment.HouseholdID); err != nil {
+		return nil, err
+	}
+
+	revisions, err := s.dbManager.ListCommentRevisions(ctx, commentID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return revisions, nil
+}
+
+func validateEmoji(emoji string) error {
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxEmojiLength {
+		return status.Error(codes.InvalidArgument, "Invalid emoji")
+	}
+
+	for _, r := range emoji {
+		if r < utf8.RuneSelf || unicode.IsSpace(r) {
+			return status.Error(codes.InvalidArgument, "Invalid emoji")
+		}
+	}
+
+	return nil
+}
+
+// AddReaction reacts to a comment with an emoji
+func (s *Server) AddReaction(ctx context.Context, commentID int32, emoji string) error {
+	p, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := validateEmoji(emoji); err != nil {
+		return err
+	}
+
+	comment, err := s.dbManager.GetComment(ctx, commentID)
+	if err != nil {
+		return statusError(err)
+	}
+
+	if _, err := s.householdMember(ctx, p.UserID, comment.HouseholdID); err != nil {
+		return err
+	}
+
+	if err := s.dbManager.AddReaction(ctx, commentID, p.UserID, emoji); err != nil {
+		return statusError(err)
+	}
+
+	return nil
+}
+
+// RemoveReaction removes the caller's own reaction from a comment
+func (s *Server) RemoveReaction(ctx context.Context, commentID int32, emoji string) error {
+	p, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+
+	comment, err := s.dbManager.GetComment(ctx, commentID)
+	if err != nil {
+		return statusError(err)
+	}
+
+	if _, err := s.householdMember(ctx, p.UserID, comment.HouseholdID); err != nil {
+		return err
+	}
+
+	if err := s.dbManager.RemoveReaction(ctx, commentID, p.UserID, emoji); err != nil {
+		return statusError(err)
+	}
+
+	return nil
+}
+
+// ListMentions returns the comments the caller was most recently mentioned in
+func (s *Server) ListMentions(ctx context.Context) ([]db.Mention, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mentions, err := s.dbManager.ListMentions(ctx, p.UserID, mentionsInboxPageSize)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return mentions, nil
+}
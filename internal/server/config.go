@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// featureAttachmentUploads turns attachment uploads on and off without a
+// restart
+const featureAttachmentUploads = "attachmentUploads"
+
+// GetEffectiveConfig reports the config in effect, including settings changed
+// since the server started. Only admins can see it.
+func (s *Server) GetEffectiveConfig(ctx context.Context) (config.Snapshot, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return config.Snapshot{}, err
+	}
+
+	if !p.HasRole(auth.RoleAdmin) {
+		return config.Snapshot{}, status.Error(codes.PermissionDenied, "Only admins can see the server config")
+	}
+
+	snapshot, err := s.runtimeConfig.Snapshot()
+	if err != nil {
+		return config.Snapshot{}, status.Error(codes.Internal, err.Error())
+	}
+
+	return snapshot, nil
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+
+	"github.com/chorerewards/backend/internal/db"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CreateGoal creates a new savings goal for the caller
+func (s *Server) CreateGoal(ctx context.Context, goal db.Goal) (db.Goal, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.Goal{}, err
+	}
+
+	if goal.Name == "" {
+		return db.Goal{}, status.Error(codes.InvalidArgument, "Name cannot be empty")
+	}
+
+	if goal.TargetPoints <= 0 {
+		return db.Goal{}, status.Error(codes.InvalidArgument, "Target points must be positive")
+	}
+
+	goal.UserID = p.UserID
+
+	g, err := s.dbManager.CreateGoal(ctx, goal)
+	if err != nil {
+		return db.Goal{}, statusError(err)
+	}
+
+	return g, nil
+}
+
+// ListGoals lists the savings goals of a user, including those already
+// redeemed. Users can list their own goals, and parents those of anyone in
+// their household.
+func (s *Server) ListGoals(ctx context.Context, userID int32) ([]db.Goal, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID != p.UserID {
+		if !p.IsParent() {
+			return nil, status.Error(codes.PermissionDenied, "Only parents can see the goals of others")
+		}
+
+		if _, err := s.householdMember(ctx, userID, p.HouseholdID); err != nil {
+			return nil, err
+		}
+	}
+
+	goals, err := s.dbManager.ListGoals(ctx, userID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return goals, nil
+}
+
+// AllocateToGoal reserves some of the caller's available points for one of
+// their goals. A negative amount releases points back to the available balance.
+func (s *Server) AllocateToGoal(ctx context.Context, goalID int32, points int32) (db.Goal, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.Goal{}, err
+	}
+
+	if points == 0 {
+		return db.Goal{}, status.Error(codes.InvalidArgument, "Points cannot be zero")
+	}
+
+	g, err := s.dbManager.AllocateToGoal(ctx, goalID, p.UserID, points)
+	if err != nil {
+		return db.Goal{}, statusError(err)
+	}
+
+	return g, nil
+}
+
+// ContributeToGoal allows a parent to add matching points to the goal of a
+// child in their household
+func (s *Server) ContributeToGoal(ctx context.Context, goalID int32, points int32) (db.Goal, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.Goal{}, err
+	}
+
+	if !p.IsParent() {
+		return db.Goal{}, status.Error(codes.PermissionDenied, "Only parents can contribute to goals")
+	}
+
+	if points <= 0 {
+		return db.Goal{}, status.Error(codes.InvalidArgument, "Points must be positive")
+	}
+
+	goal, err := s.dbManager.GetGoal(ctx, goalID)
+	if err != nil {
+		return db.Goal{}, statusError(err)
+	}
+
+	// Goals in other households are reported as missing so their IDs can't
+	// be probed
+	if _, err := s.householdMember(ctx, goal.UserID, p.HouseholdID); err != nil {
+		return db.Goal{}, status.Error(codes.NotFound, "record not found")
+	}
+
+	g, err := s.dbManager.ContributeToGoal(ctx, goalID, points)
+	if err != nil {
+		return db.Goal{}, statusError(err)
+	}
+
+	return g, nil
+}
+
+// RedeemGoal spends the points of one of the caller's goals which has reached
+// its target
+func (s *Server) RedeemGoal(ctx context.Context, goalID int32) (db.Goal, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.Goal{}, err
+	}
+
+	g, err := s.dbManager.RedeemGoal(ctx, goalID, p.UserID)
+	if err != nil {
+		return db.Goal{}, statusError(err)
+	}
+
+	return g, nil
+}
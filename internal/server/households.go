@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/chorerewards/backend/internal/db"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SignUp creates a household with the user as its first parent and admin.
+// Anyone can sign up, so it's the only way to create a user without being a
+// parent of the household they join.
+func (s *Server) SignUp(ctx context.Context, householdName string, user db.User, password string) (db.User, error) {
+	householdName = strings.TrimSpace(householdName)
+	if householdName == "" {
+		return db.User{}, status.Error(codes.InvalidArgument, "Household name cannot be empty")
+	}
+
+	if strings.TrimSpace(user.Username) == "" {
+		return db.User{}, status.Error(codes.InvalidArgument, "Username cannot be empty")
+	}
+
+	pwdHash, err := hashNewPassword(password)
+	if err != nil {
+		return db.User{}, err
+	}
+
+	var (
+		created db.User
+		token   string
+	)
+
+	err = s.dbManager.WithTx(ctx, func(tx db.Store) error {
+		household, err := tx.CreateHousehold(ctx, householdName)
+		if err != nil {
+			return err
+		}
+
+		// Parents sign in with their password, so there's no pin
+		created, err = tx.CreateUser(ctx, db.User{
+			Username:    user.Username,
+			Email:       user.Email,
+			HouseholdID: household.ID,
+			IsAdmin:     true,
+			IsParent:    true,
+			Avatar:      user.Avatar,
+			Password:    pwdHash,
+			IsActive:    true,
+		})
+		if err != nil {
+			return err
+		}
+
+		token = ""
+		if created.Email != "" {
+			token, err = issueEmailVerification(ctx, tx, created)
+		}
+
+		return err
+	})
+	if err != nil {
+		return db.User{}, statusError(err)
+	}
+
+	if token != "" {
+		if err := s.mailEmailVerification(ctx, created, token); err != nil {
+			log.WithError(err).WithField("id", created.ID).Warn("Unable to send email verification")
+		}
+	}
+
+	return created, nil
+}
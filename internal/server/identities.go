@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/oidc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LoginWithIdentity signs in the parent linked to an identity verified by an
+// OpenID Connect provider, returning a token as Login does. An identity that
+// isn't linked yet is linked to the only parent with the same email address,
+// as long as both the provider and ChoreRewards have verified the address.
+// Parents using two-factor authentication get a challenge, as from Login.
+func (s *Server) LoginWithIdentity(ctx context.Context, identity oidc.Identity) (string, error) {
+	user, err := s.dbManager.GetUserByIdentity(ctx, identity.Provider, identity.Subject)
+	if err != nil {
+		if !isNotFound(err) {
+			return "", statusError(err)
+		}
+
+		if user, err = s.linkIdentityByEmail(ctx, identity); err != nil {
+			return "", err
+		}
+	}
+
+	if !user.IsActive || !user.IsParent {
+		return "", status.Error(codes.PermissionDenied, "Only parents can sign in with an identity provider")
+	}
+
+	// Two-factor authentication applies to every way of signing in
+	resp, err := s.loginResponse(ctx, user)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.GetToken(), nil
+}
+
+func (s *Server) linkIdentityByEmail(ctx context.Context, identity oidc.Identity) (db.User, error) {
+	notLinked := status.Error(codes.FailedPrecondition, "No account is linked to this identity")
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return db.User{}, notLinked
+	}
+
+	users, err := s.dbManager.ListUsersByEmail(ctx, identity.Email)
+	if err != nil {
+		return db.User{}, statusError(err)
+	}
+
+	// Addresses can be shared, so the identity is only linked when exactly
+	// one parent has verified it
+	var user db.User
+	parents := 0
+	for _, u := range users {
+		if u.EmailVerified && u.IsParent && u.IsActive {
+			user = u
+			parents++
+		}
+	}
+
+	if parents != 1 {
+		return db.User{}, notLinked
+	}
+
+	_, err = s.dbManager.LinkIdentity(ctx, db.UserIdentity{
+		UserID:   user.ID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	})
+	if err != nil {
+		return db.User{}, statusError(err)
+	}
+
+	return user, nil
+}
+
+// ListIdentities lists the identity providers the caller can sign in with
+func (s *Server) ListIdentities(ctx context.Context) ([]db.UserIdentity, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := s.dbManager.ListIdentities(ctx, p.UserID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return identities, nil
+}
+
+// UnlinkIdentity stops the caller signing in with an identity provider
+func (s *Server) UnlinkIdentity(ctx context.Context, provider string) error {
+	p, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.dbManager.UnlinkIdentity(ctx, p.UserID, provider); err != nil {
+		return statusError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+
+	chorerewardsv1alpha1 "github.com/chorerewards/proto/chorerewards/v1alpha1"
+	"google.golang.org/grpc"
+)
+
+// inProcess serves the HTTP gateway directly rather than over a connection
+// to the gRPC server. Calls made this way don't pass through the gRPC
+// server, so they are run through the same interceptors here.
+type inProcess struct {
+	server      chorerewardsv1alpha1.ChoreRewardsServiceServer
+	interceptor grpc.UnaryServerInterceptor
+}
+
+// InProcess wraps the server for registration with the gateway using
+// RegisterChoreRewardsServiceHandlerServer, calling interceptor for every call
+// as the gRPC server does
+func (s *Server) InProcess(interceptor grpc.UnaryServerInterceptor) chorerewardsv1alpha1.ChoreRewardsServiceServer {
+	return inProcess{
+		server:      s,
+		interceptor: interceptor,
+	}
+}
+
+func (p inProcess) call(ctx context.Context, method string, req interface{}, handler grpc.UnaryHandler) (interface{}, error) {
+	return p.interceptor(ctx, req, &grpc.UnaryServerInfo{Server: p.server, FullMethod: servicePrefix + method}, handler)
+}
+
+func (p inProcess) CreateCategory(ctx context.Context, req *chorerewardsv1alpha1.CreateCategoryRequest) (*chorerewardsv1alpha1.CreateCategoryResponse, error) {
+	resp, err := p.call(ctx, "CreateCategory", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return p.server.CreateCategory(ctx, req.(*chorerewardsv1alpha1.CreateCategoryRequest))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*chorerewardsv1alpha1.CreateCategoryResponse), nil
+}
+
+func (p inProcess) ListCategories(ctx context.Context, req *chorerewardsv1alpha1.ListCategoriesRequest) (*chorerewardsv1alpha1.ListCategoriesResponse, error) {
+	resp, err := p.call(ctx, "ListCategories", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return p.server.ListCategories(ctx, req.(*chorerewardsv1alpha1.ListCategoriesRequest))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*chorerewardsv1alpha1.ListCategoriesResponse), nil
+}
+
+func (p inProcess) CreateTask(ctx context.Context, req *chorerewardsv1alpha1.CreateTaskRequest) (*chorerewardsv1alpha1.CreateTaskResponse, error) {
+	resp, err := p.call(ctx, "CreateTask", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return p.server.CreateTask(ctx, req.(*chorerewardsv1alpha1.CreateTaskRequest))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*chorerewardsv1alpha1.CreateTaskResponse), nil
+}
+
+func (p inProcess) ListTasks(ctx context.Context, req *chorerewardsv1alpha1.ListTasksRequest) (*chorerewardsv1alpha1.ListTasksResponse, error) {
+	resp, err := p.call(ctx, "ListTasks", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return p.server.ListTasks(ctx, req.(*chorerewardsv1alpha1.ListTasksRequest))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*chorerewardsv1alpha1.ListTasksResponse), nil
+}
+
+func (p inProcess) AddTaskToFeed(ctx context.Context, req *chorerewardsv1alpha1.AddTaskToFeedRequest) (*chorerewardsv1alpha1.AddTaskToFeedResponse, error) {
+	resp, err := p.call(ctx, "AddTaskToFeed", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return p.server.AddTaskToFeed(ctx, req.(*chorerewardsv1alpha1.AddTaskToFeedRequest))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*chorerewardsv1alpha1.AddTaskToFeedResponse), nil
+}
+
+func (p inProcess) ListTasksFeed(ctx context.Context, req *chorerewardsv1alpha1.ListTasksFeedRequest) (*chorerewardsv1alpha1.ListTasksFeedResponse, error) {
+	resp, err := p.call(ctx, "ListTasksFeed", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return p.server.ListTasksFeed(ctx, req.(*chorerewardsv1alpha1.ListTasksFeedRequest))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*chorerewardsv1alpha1.ListTasksFeedResponse), nil
+}
+
+func (p inProcess) CreateUser(ctx context.Context, req *chorerewardsv1alpha1.CreateUserRequest) (*chorerewardsv1alpha1.CreateUserResponse, error) {
+	resp, err := p.call(ctx, "CreateUser", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return p.server.CreateUser(ctx, req.(*chorerewardsv1alpha1.CreateUserRequest))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*chorerewardsv1alpha1.CreateUserResponse), nil
+}
+
+func (p inProcess) ListUsers(ctx context.Context, req *chorerewardsv1alpha1.ListUsersRequest) (*chorerewardsv1alpha1.ListUsersResponse, error) {
+	resp, err := p.call(ctx, "ListUsers", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return p.server.ListUsers(ctx, req.(*chorerewardsv1alpha1.ListUsersRequest))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*chorerewardsv1alpha1.ListUsersResponse), nil
+}
+
+func (p inProcess) Login(ctx context.Context, req *chorerewardsv1alpha1.LoginRequest) (*chorerewardsv1alpha1.LoginResponse, error) {
+	resp, err := p.call(ctx, "Login", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return p.server.Login(ctx, req.(*chorerewardsv1alpha1.LoginRequest))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*chorerewardsv1alpha1.LoginResponse), nil
+}
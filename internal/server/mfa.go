@@ -0,0 +1,428 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	chorerewardsv1alpha1 "github.com/chorerewards/proto/chorerewards/v1alpha1"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// totpIssuer names the account in authenticator apps
+	totpIssuer        = "ChoreRewards"
+	recoveryCodeCount = 10
+	// After maxMFAAttempts wrong codes in a row the second step of signing in
+	// is locked for mfaLockout, on top of the request rate limit
+	maxMFAAttempts = 5
+	mfaLockout     = time.Minute * 15
+)
+
+// MFAStatus describes a user's two-factor authentication
+type MFAStatus struct {
+	Enabled                bool
+	Required               bool
+	RecoveryCodesRemaining int32
+}
+
+// loginResponse completes signing in a user whose password or PIN has been
+// checked. Users with two-factor authentication get a challenge to complete
+// with LoginMFA instead of a token, and parents who must use it but haven't
+// enrolled get one to complete with EnrolTOTP and ConfirmTOTP.
+func (s *Server) loginResponse(ctx context.Context, user db.User) (*chorerewardsv1alpha1.LoginResponse, error) {
+	m, err := s.dbManager.GetMFA(ctx, user.ID)
+	if err != nil && !isNotFound(err) {
+		return nil, statusError(err)
+	}
+
+	if m.Enabled {
+		return nil, s.mfaChallenge(user.ID, auth.ChallengeMFA, auth.ReasonMFARequired)
+	}
+
+	required, err := s.mfaRequired(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if required {
+		return nil, s.mfaChallenge(user.ID, auth.ChallengeMFAEnrolment, auth.ReasonMFAEnrolmentRequired)
+	}
+
+	return s.issueToken(ctx, user)
+}
+
+func (s *Server) mfaChallenge(userID int32, purpose string, reason string) error {
+	challenge, err := s.tokenManager.CreateChallenge(userID, purpose)
+	if err != nil {
+		return status.Error(codes.Internal, "Unable to create challenge")
+	}
+
+	return auth.MFAChallengeError(reason, challenge)
+}
+
+// issueToken signs the user in on the device making the request
+func (s *Server) issueToken(ctx context.Context, user db.User) (*chorerewardsv1alpha1.LoginResponse, error) {
+	session, err := s.newSession(ctx, user.ID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	token, err := s.tokenManager.CreateToken(auth.Principal{
+		UserID:      user.ID,
+		Username:    user.Username,
+		HouseholdID: user.HouseholdID,
+		Roles:       auth.Roles(user.IsAdmin, user.IsParent),
+		SessionID:   session.ID,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create Token")
+	}
+
+	return &chorerewardsv1alpha1.LoginResponse{
+		Token:    token,
+		IsAdmin:  user.IsAdmin,
+		IsParent: user.IsParent,
+	}, nil
+}
+
+// mfaRequired returns whether the user's household requires them to use
+// two-factor authentication
+func (s *Server) mfaRequired(ctx context.Context, user db.User) (bool, error) {
+	if !user.IsParent && !user.IsAdmin {
+		return false, nil
+	}
+
+	settings, err := s.dbManager.GetHouseholdSettings(ctx, user.HouseholdID)
+	if err != nil {
+		return false, statusError(err)
+	}
+
+	return settings.RequireParentMFA, nil
+}
+
+// challengeUser returns the active user a challenge was issued to
+func (s *Server) challengeUser(ctx context.Context, challenge string, purpose string) (db.User, error) {
+	userID, err := s.tokenManager.VerifyChallenge(challenge, purpose)
+	if err != nil {
+		return db.User{}, status.Error(codes.Unauthenticated, "Invalid or expired challenge")
+	}
+
+	user, err := s.dbManager.GetUserByID(ctx, userID)
+	if err != nil {
+		return db.User{}, statusError(err)
+	}
+
+	if !user.IsActive {
+		return db.User{}, status.Error(codes.Unauthenticated, "Invalid or expired challenge")
+	}
+
+	return user, nil
+}
+
+// checkMFACode accepts a TOTP code, or a recovery code in its place, for a
+// user with two-factor authentication enabled
+func (s *Server) checkMFACode(ctx context.Context, userID int32, code string) error {
+	m, err := s.dbManager.GetMFA(ctx, userID)
+	if err != nil {
+		if isNotFound(err) {
+			return status.Error(codes.FailedPrecondition, "Two-factor authentication is not enabled")
+		}
+		return statusError(err)
+	}
+
+	if !m.Enabled {
+		return status.Error(codes.FailedPrecondition, "Two-factor authentication is not enabled")
+	}
+
+	if m.Locked(time.Now()) {
+		return status.Error(codes.ResourceExhausted, "Too many incorrect codes, try again later")
+	}
+
+	if step, ok := auth.ValidateTOTP(m.Secret, code, time.Now(), m.LastStep); ok {
+		if err := s.dbManager.UseTOTPStep(ctx, userID, step); err != nil {
+			return status.Error(codes.PermissionDenied, "Incorrect code")
+		}
+		return nil
+	}
+
+	if err := s.dbManager.UseRecoveryCode(ctx, userID, auth.HashRecoveryCode(code)); err == nil {
+		return nil
+	}
+
+	if err := s.dbManager.RecordMFAFailure(ctx, userID, maxMFAAttempts, mfaLockout); err != nil {
+		log.WithError(err).WithField("userID", userID).Error("Unable to record failed MFA attempt")
+	}
+
+	return status.Error(codes.PermissionDenied, "Incorrect code")
+}
+
+// LoginMFA completes signing in with the challenge returned by Login and a
+// code from the user's authenticator app or one of their recovery codes
+func (s *Server) LoginMFA(ctx context.Context, challenge string, code string) (*chorerewardsv1alpha1.LoginResponse, error) {
+	user, err := s.challengeUser(ctx, challenge, auth.ChallengeMFA)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkMFACode(ctx, user.ID, code); err != nil {
+		return nil, err
+	}
+
+	return s.issueToken(ctx, user)
+}
+
+// GetMFAStatus returns whether the caller has two-factor authentication enabled
+func (s *Server) GetMFAStatus(ctx context.Context) (MFAStatus, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return MFAStatus{}, err
+	}
+
+	user, err := s.dbManager.GetUserByID(ctx, p.UserID)
+	if err != nil {
+		return MFAStatus{}, statusError(err)
+	}
+
+	m, err := s.dbManager.GetMFA(ctx, user.ID)
+	if err != nil && !isNotFound(err) {
+		return MFAStatus{}, statusError(err)
+	}
+
+	required, err := s.mfaRequired(ctx, user)
+	if err != nil {
+		return MFAStatus{}, err
+	}
+
+	return MFAStatus{
+		Enabled:                m.Enabled,
+		Required:               required,
+		RecoveryCodesRemaining: m.RecoveryCodesRemaining,
+	}, nil
+}
+
+// EnrolTOTP starts enrolling an authenticator app for the caller, who must be
+// a parent or admin. The enrolment takes effect once confirmed with
+// ConfirmTOTP.
+func (s *Server) EnrolTOTP(ctx context.Context) (auth.TOTPEnrolment, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return auth.TOTPEnrolment{}, err
+	}
+
+	user, err := s.dbManager.GetUserByID(ctx, p.UserID)
+	if err != nil {
+		return auth.TOTPEnrolment{}, statusError(err)
+	}
+
+	return s.enrolTOTP(ctx, user)
+}
+
+func (s *Server) enrolTOTP(ctx context.Context, user db.User) (auth.TOTPEnrolment, error) {
+	if !user.IsParent && !user.IsAdmin {
+		return auth.TOTPEnrolment{}, status.Error(codes.FailedPrecondition, "Only parents and admins can enable two-factor authentication")
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return auth.TOTPEnrolment{}, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := s.dbManager.StartMFAEnrolment(ctx, user.ID, secret); err != nil {
+		return auth.TOTPEnrolment{}, statusError(err)
+	}
+
+	return auth.TOTPEnrolment{
+		Secret: secret,
+		URI:    auth.TOTPProvisioningURI(totpIssuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication once the caller has entered a
+// code from their newly enrolled app, returning their recovery codes. They are
+// only shown this once.
+func (s *Server) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.confirmTOTP(ctx, p.UserID, code)
+}
+
+func (s *Server) confirmTOTP(ctx context.Context, userID int32, code string) ([]string, error) {
+	m, err := s.dbManager.GetMFA(ctx, userID)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, status.Error(codes.FailedPrecondition, "No pending two-factor enrolment")
+		}
+		return nil, statusError(err)
+	}
+
+	if m.Enabled {
+		return nil, status.Error(codes.FailedPrecondition, "Two-factor authentication is already enabled")
+	}
+
+	step, ok := auth.ValidateTOTP(m.Secret, code, time.Now(), m.LastStep)
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "Incorrect code")
+	}
+
+	recoveryCodes, hashes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := s.dbManager.EnableMFA(ctx, userID, step, hashes); err != nil {
+		return nil, statusError(err)
+	}
+
+	return recoveryCodes, nil
+}
+
+// EnrolTOTPWithChallenge starts enrolling an authenticator app for a parent
+// who was refused a token by Login until they do
+func (s *Server) EnrolTOTPWithChallenge(ctx context.Context, challenge string) (auth.TOTPEnrolment, error) {
+	user, err := s.challengeUser(ctx, challenge, auth.ChallengeMFAEnrolment)
+	if err != nil {
+		return auth.TOTPEnrolment{}, err
+	}
+
+	return s.enrolTOTP(ctx, user)
+}
+
+// CompleteMFAEnrolment confirms an enrolment started with
+// EnrolTOTPWithChallenge and signs the user in, returning their token along
+// with their recovery codes
+func (s *Server) CompleteMFAEnrolment(ctx context.Context, challenge string, code string) (*chorerewardsv1alpha1.LoginResponse, []string, error) {
+	user, err := s.challengeUser(ctx, challenge, auth.ChallengeMFAEnrolment)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recoveryCodes, err := s.confirmTOTP(ctx, user.ID, code)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := s.issueToken(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp, recoveryCodes, nil
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes, for when they
+// have used or lost them. A current code is needed so that a stolen token
+// alone can't be used to get new codes.
+func (s *Server) RegenerateRecoveryCodes(ctx context.Context, code string) ([]string, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkMFACode(ctx, p.UserID, code); err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, hashes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := s.dbManager.ReplaceRecoveryCodes(ctx, p.UserID, hashes); err != nil {
+		return nil, statusError(err)
+	}
+
+	return recoveryCodes, nil
+}
+
+// DisableTOTP turns off two-factor authentication for the caller, unless
+// their household requires it
+func (s *Server) DisableTOTP(ctx context.Context, code string) error {
+	p, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.dbManager.GetUserByID(ctx, p.UserID)
+	if err != nil {
+		return statusError(err)
+	}
+
+	required, err := s.mfaRequired(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	if required {
+		return status.Error(codes.FailedPrecondition, "Your household requires two-factor authentication")
+	}
+
+	if err := s.checkMFACode(ctx, user.ID, code); err != nil {
+		return err
+	}
+
+	if err := s.dbManager.DisableMFA(ctx, user.ID); err != nil {
+		return statusError(err)
+	}
+
+	return nil
+}
+
+// ResetUserMFA removes the two-factor authentication of a user in the
+// caller's household who has lost both their app and recovery codes. Only
+// admins can reset it. If the household requires it, the user enrols again
+// the next time they sign in.
+func (s *Server) ResetUserMFA(ctx context.Context, userID int32) error {
+	p, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !p.HasRole(auth.RoleAdmin) {
+		return status.Error(codes.PermissionDenied, "Only admins can reset two-factor authentication")
+	}
+
+	user, err := s.dbManager.GetUserByID(ctx, userID)
+	if err != nil {
+		return statusError(err)
+	}
+
+	if user.HouseholdID != p.HouseholdID {
+		return status.Error(codes.NotFound, "record not found")
+	}
+
+	if err := s.dbManager.DisableMFA(ctx, userID); err != nil {
+		return statusError(err)
+	}
+
+	log.WithFields(log.Fields{"userID": userID, "resetBy": p.UserID}).Info("MFA reset by admin")
+
+	return nil
+}
+
+// SetRequireParentMFA sets whether every parent in the caller's household must
+// use two-factor authentication. Only admins can change it.
+func (s *Server) SetRequireParentMFA(ctx context.Context, required bool) (db.HouseholdSettings, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.HouseholdSettings{}, err
+	}
+
+	if !p.HasRole(auth.RoleAdmin) {
+		return db.HouseholdSettings{}, status.Error(codes.PermissionDenied, "Only admins can change household settings")
+	}
+
+	settings, err := s.dbManager.SetRequireParentMFA(ctx, p.HouseholdID, required)
+	if err != nil {
+		return db.HouseholdSettings{}, statusError(err)
+	}
+
+	return settings, nil
+}
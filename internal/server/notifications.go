@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/notify"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const minutesInDay = 24 * 60
+
+// CompleteTaskFeed marks one of the caller's feed entries as complete,
+// notifying their parents
+func (s *Server) CompleteTaskFeed(ctx context.Context, taskFeedID int32) (db.TaskFeed, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.TaskFeed{}, err
+	}
+
+	tf, err := s.dbManager.CompleteTaskFeed(ctx, taskFeedID, p.UserID)
+	if err != nil {
+		return db.TaskFeed{}, statusError(err)
+	}
+
+	return tf, nil
+}
+
+// ApproveTaskFeed approves a completed feed entry in the caller's household,
+// crediting the assignee with its points
+func (s *Server) ApproveTaskFeed(ctx context.Context, taskFeedID int32) (db.TaskFeed, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.TaskFeed{}, err
+	}
+
+	if !p.IsParent() {
+		return db.TaskFeed{}, status.Error(codes.PermissionDenied, "Only parents can approve tasks")
+	}
+
+	var tf db.TaskFeed
+
+	// The entry is checked and approved, and its points credited, in one
+	// transaction so that it can't change household in between
+	err = s.dbManager.WithTx(ctx, func(tx db.Store) error {
+		taskFeed, err := tx.GetTaskFeed(ctx, taskFeedID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := householdMember(ctx, tx, taskFeed.AssigneeID, p.HouseholdID); err != nil {
+			return err
+		}
+
+		tf, err = tx.ApproveTaskFeed(ctx, taskFeedID)
+
+		return err
+	})
+	if err != nil {
+		return db.TaskFeed{}, statusError(err)
+	}
+
+	return tf, nil
+}
+
+func validateAddress(channel string, address string) error {
+	switch channel {
+	case notify.ChannelEmail:
+		if _, err := mail.ParseAddress(address); err != nil {
+			return status.Error(codes.InvalidArgument, "Invalid email address")
+		}
+	case notify.ChannelWebhook:
+		u, err := url.Parse(address)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return status.Error(codes.InvalidArgument, "Invalid webhook URL")
+		}
+	case notify.ChannelWebPush:
+		var sub notify.Subscription
+		if err := json.Unmarshal([]byte(address), &sub); err != nil || sub.Endpoint == "" || sub.Keys.P256dh == "" || sub.Keys.Auth == "" {
+			return status.Error(codes.InvalidArgument, "Invalid push subscription")
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "Unknown channel %q", channel)
+	}
+
+	return nil
+}
+
+// SetNotificationPreference enables or disables a notification channel for the
+// caller, along with the address to deliver to
+func (s *Server) SetNotificationPreference(ctx context.Context, preference db.NotificationPreference) (db.NotificationPreference, error) {
+	caller, err := principal(ctx)
+	if err != nil {
+		return db.NotificationPreference{}, err
+	}
+
+	preference.UserID = caller.UserID
+
+	if err := validateAddress(preference.Channel, preference.Address); err != nil {
+		return db.NotificationPreference{}, err
+	}
+
+	p, err := s.dbManager.SetNotificationPreference(ctx, preference)
+	if err != nil {
+		return db.NotificationPreference{}, statusError(err)
+	}
+
+	return p, nil
+}
+
+// ListNotificationPreferences lists the channels configured for the caller
+func (s *Server) ListNotificationPreferences(ctx context.Context) ([]db.NotificationPreference, error) {
+	caller, err := principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := s.dbManager.ListNotificationPreferences(ctx, caller.UserID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return p, nil
+}
+
+// SetQuietHours sets the period each day during which the caller's
+// notifications are held back
+func (s *Server) SetQuietHours(ctx context.Context, settings db.NotificationSettings) (db.NotificationSettings, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.NotificationSettings{}, err
+	}
+
+	settings.UserID = p.UserID
+
+	if settings.QuietHoursStart < 0 || settings.QuietHoursStart >= minutesInDay || settings.QuietHoursEnd < 0 || settings.QuietHoursEnd >= minutesInDay {
+		return db.NotificationSettings{}, status.Error(codes.InvalidArgument, "Quiet hours must be minutes after midnight")
+	}
+
+	if settings.TimeZone == "" {
+		settings.TimeZone = "UTC"
+	}
+
+	if _, err := time.LoadLocation(settings.TimeZone); err != nil {
+		return db.NotificationSettings{}, status.Error(codes.InvalidArgument, "Unknown time zone")
+	}
+
+	n, err := s.dbManager.SetNotificationSettings(ctx, settings)
+	if err != nil {
+		return db.NotificationSettings{}, statusError(err)
+	}
+
+	return n, nil
+}
+
+// DispatchNotifications delivers queued notifications. It is intended to be
+// run periodically in the background.
+func (s *Server) DispatchNotifications(ctx context.Context) error {
+	return s.dispatcher.Run(ctx)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SetTaskDeadline lets a parent set how long after being added to the feed a
+// task in their household is due, and the penalties for completing it late or
+// not at all. A dueIn of zero removes the deadline.
+func (s *Server) SetTaskDeadline(ctx context.Context, taskID int32, dueIn time.Duration, latePenaltyPercent int32, missedPenaltyPoints int32) (db.Task, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.Task{}, err
+	}
+
+	if !p.IsParent() {
+		return db.Task{}, status.Error(codes.PermissionDenied, "Only parents can set deadlines")
+	}
+
+	if dueIn < 0 || missedPenaltyPoints < 0 {
+		return db.Task{}, status.Error(codes.InvalidArgument, "Deadline and missed penalty cannot be negative")
+	}
+
+	if latePenaltyPercent < 0 || latePenaltyPercent > 100 {
+		return db.Task{}, status.Error(codes.InvalidArgument, "Late penalty must be between 0 and 100 percent")
+	}
+
+	task, err := s.dbManager.SetTaskDeadline(ctx, taskID, p.HouseholdID, dueIn, latePenaltyPercent, missedPenaltyPoints)
+	if err != nil {
+		return db.Task{}, statusError(err)
+	}
+
+	return task, nil
+}
+
+// ListOverdue lists the overdue chores in the calling parent's household
+func (s *Server) ListOverdue(ctx context.Context) ([]db.TaskFeed, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.IsParent() {
+		return nil, status.Error(codes.PermissionDenied, "Only parents can list overdue tasks")
+	}
+
+	overdue, err := s.dbManager.ListOverdue(ctx, p.HouseholdID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return overdue, nil
+}
+
+// ListPointsAdjustments lists the penalties and other adjustments applied to a
+// user's points, most recent first. Users can list their own, and parents
+// those of anyone in their household.
+func (s *Server) ListPointsAdjustments(ctx context.Context, userID int32) ([]db.PointsAdjustment, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID != p.UserID {
+		if !p.IsParent() {
+			return nil, status.Error(codes.PermissionDenied, "Only parents can see the points of others")
+		}
+
+		if _, err := s.householdMember(ctx, userID, p.HouseholdID); err != nil {
+			return nil, err
+		}
+	}
+
+	adjustments, err := s.dbManager.ListPointsAdjustments(ctx, userID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return adjustments, nil
+}
+
+// MarkOverdue flags chores that have passed their deadline, or been missed,
+// and applies their penalties. It is intended to be run periodically in the
+// background.
+func (s *Server) MarkOverdue(ctx context.Context) error {
+	if _, err := s.dbManager.MarkOverdue(ctx, s.missedAfter); err != nil {
+		return err
+	}
+
+	return nil
+}
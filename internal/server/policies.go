@@ -0,0 +1,31 @@
+package server
+
+import (
+	"github.com/chorerewards/backend/internal/auth"
+)
+
+const servicePrefix = "/chorerewards.v1alpha1.ChoreRewardsService/"
+
+// Policies returns the access policy of every method served by the gRPC
+// server, including the health and reflection services registered alongside
+// ours. Any method missing from here is refused.
+func Policies() auth.Policies {
+	return auth.Policies{
+		servicePrefix + "CreateCategory": auth.RequireRoles(auth.RoleParent),
+		servicePrefix + "ListCategories": auth.Authenticated(),
+		servicePrefix + "CreateTask":     auth.RequireRoles(auth.RoleParent),
+		servicePrefix + "ListTasks":      auth.Authenticated(),
+		servicePrefix + "AddTaskToFeed":  auth.Authenticated(),
+		servicePrefix + "ListTasksFeed":  auth.Authenticated(),
+		servicePrefix + "CreateUser":     auth.RequireRoles(auth.RoleParent),
+		servicePrefix + "ListUsers":      auth.Authenticated(),
+		servicePrefix + "Login":          auth.Public(),
+
+		"/grpc.health.v1.Health/Check": auth.Public(),
+		"/grpc.health.v1.Health/Watch": auth.Public(),
+
+		// Reflection only describes the API, which is published in
+		// chorerewards/proto anyway
+		"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo": auth.Public(),
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CreateRotation lets a parent share a task in their household between
+// several members of it. The first occurrence is added to the feed at
+// rotation.NextRunAt, or immediately if it isn't set.
+func (s *Server) CreateRotation(ctx context.Context, rotation db.Rotation) (db.Rotation, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.Rotation{}, err
+	}
+
+	if !p.IsParent() {
+		return db.Rotation{}, status.Error(codes.PermissionDenied, "Only parents can create rotations")
+	}
+
+	if len(rotation.ParticipantIDs) == 0 {
+		return db.Rotation{}, status.Error(codes.InvalidArgument, "A rotation needs at least one participant")
+	}
+
+	if rotation.Cadence < time.Hour {
+		return db.Rotation{}, status.Error(codes.InvalidArgument, "Cadence must be at least an hour")
+	}
+
+	seen := make(map[int32]bool, len(rotation.ParticipantIDs))
+	for _, id := range rotation.ParticipantIDs {
+		if seen[id] {
+			return db.Rotation{}, status.Error(codes.InvalidArgument, "Participants must be unique")
+		}
+		seen[id] = true
+
+		if _, err := s.householdMember(ctx, id, p.HouseholdID); err != nil {
+			return db.Rotation{}, err
+		}
+	}
+
+	task, err := s.dbManager.GetTaskByID(ctx, rotation.TaskID)
+	if err != nil {
+		return db.Rotation{}, statusError(err)
+	}
+
+	if task.HouseholdID != p.HouseholdID {
+		return db.Rotation{}, status.Error(codes.NotFound, "record not found")
+	}
+
+	if rotation.NextRunAt.IsZero() {
+		rotation.NextRunAt = time.Now()
+	}
+
+	r, err := s.dbManager.CreateRotation(ctx, rotation)
+	if err != nil {
+		return db.Rotation{}, statusError(err)
+	}
+
+	return r, nil
+}
+
+// householdRotation returns a rotation if its task is in the caller's
+// household. Rotations in other households are reported as missing.
+func (s *Server) householdRotation(ctx context.Context, p auth.Principal, rotationID int32) (db.Rotation, error) {
+	r, err := s.dbManager.GetRotation(ctx, rotationID)
+	if err != nil {
+		return db.Rotation{}, statusError(err)
+	}
+
+	task, err := s.dbManager.GetTaskByID(ctx, r.TaskID)
+	if err != nil {
+		return db.Rotation{}, statusError(err)
+	}
+
+	if task.HouseholdID != p.HouseholdID {
+		return db.Rotation{}, status.Error(codes.NotFound, "record not found")
+	}
+
+	return r, nil
+}
+
+// SwapRotationParticipants swaps the turns of two participants in a rotation.
+// Parents can swap anyone; children can only trade their own turn.
+func (s *Server) SwapRotationParticipants(ctx context.Context, rotationID int32, firstUserID int32, secondUserID int32) (db.Rotation, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return db.Rotation{}, err
+	}
+
+	if !p.IsParent() && p.UserID != firstUserID && p.UserID != secondUserID {
+		return db.Rotation{}, status.Error(codes.PermissionDenied, "Children can only swap their own turn")
+	}
+
+	if _, err := s.householdRotation(ctx, p, rotationID); err != nil {
+		return db.Rotation{}, err
+	}
+
+	r, err := s.dbManager.SwapRotationParticipants(ctx, rotationID, firstUserID, secondUserID)
+	if err != nil {
+		return db.Rotation{}, statusError(err)
+	}
+
+	return r, nil
+}
+
+// ListUpcomingRotation returns who is due to do the next count occurrences of
+// a rotation in the caller's household, skipping users who are inactive
+func (s *Server) ListUpcomingRotation(ctx context.Context, rotationID int32, count int) ([]db.RotationOccurrence, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if count <= 0 || count > 52 {
+		return nil, status.Error(codes.InvalidArgument, "Count must be between 1 and 52")
+	}
+
+	r, err := s.householdRotation(ctx, p, rotationID)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := s.dbManager.ActiveUsers(ctx, r.ParticipantIDs)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return r.Upcoming(count, active), nil
+}
+
+// RunDueRotations adds due rotation occurrences to the feed. It is intended to
+// be run periodically in the background.
+func (s *Server) RunDueRotations(ctx context.Context) error {
+	if _, err := s.dbManager.RunDueRotations(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -2,55 +2,189 @@ package server
 
 import (
 	"context"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/blob"
+	"github.com/chorerewards/backend/internal/config"
 	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/mail"
+	"github.com/chorerewards/backend/internal/notify"
+	"github.com/chorerewards/backend/internal/outbox"
+	"github.com/chorerewards/backend/internal/webhooks"
 	chorerewardsv1alpha1 "github.com/chorerewards/proto/chorerewards/v1alpha1"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-var errNotFound *db.ErrNotFound
+// isNotFound reports whether err is a db.ErrNotFound. The target is local, as
+// errors.As writes to it and requests are handled concurrently.
+func isNotFound(err error) bool {
+	var notFound *db.ErrNotFound
+	return errors.As(err, &notFound)
+}
+
+// isFailedPrecondition reports whether err is a db.ErrFailedPrecondition
+func isFailedPrecondition(err error) bool {
+	var failedPrecondition *db.ErrFailedPrecondition
+	return errors.As(err, &failedPrecondition)
+}
+
+// statusError converts errors returned by the db package into gRPC status
+// errors. Status errors, such as those returned from a WithTx callback, are
+// returned unchanged.
+func statusError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case isNotFound(err):
+		return status.Error(codes.NotFound, err.Error())
+	case isFailedPrecondition(err):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
 
 type TokenManager interface {
-	CreateToken(username string) (string, error)
+	CreateToken(p auth.Principal) (string, error)
+	TokenTTL() time.Duration
+	CreateChallenge(userID int32, purpose string) (string, error)
+	VerifyChallenge(token string, purpose string) (int32, error)
+}
+
+// principal returns the authenticated caller of a request
+func principal(ctx context.Context) (auth.Principal, error) {
+	p, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return auth.Principal{}, status.Error(codes.Unauthenticated, "Request is not authenticated")
+	}
+
+	return p, nil
+}
+
+// householdPrincipal returns the caller, who must belong to a household.
+// Services authenticated by a client certificate have none, so they can't
+// create records which would belong to no household.
+func householdPrincipal(ctx context.Context) (auth.Principal, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	if p.HouseholdID == 0 {
+		return auth.Principal{}, status.Error(codes.FailedPrecondition, "Caller does not belong to a household")
+	}
+
+	return p, nil
 }
 
 // Server is the implementation of the chorerewardsv1alpha1.ChoreRewardsServiceServer
 type Server struct {
-	dbManager    *db.Manager
-	tokenManager TokenManager
+	dbManager     db.Store
+	tokenManager  TokenManager
+	blobStore     blob.Store
+	dispatcher    *outbox.Dispatcher
+	webhooks      *webhooks.Dispatcher
+	mailer        mail.Mailer
+	appURL        string
+	resets        *passwordResets
+	sessions      *auth.SessionCache
+	runtimeConfig *config.Manager
+	missedAfter   time.Duration
 }
 
 type Config struct {
-	DBHost     string
-	DBPort     int
-	DBUsername string
-	DBPassword string
-	DBName     string
+	DB db.Config
+
+	// BlobStore holds attachment uploads
+	BlobStore blob.Store
+
+	// NotificationDrivers deliver notifications, keyed by channel
+	NotificationDrivers map[string]notify.Driver
+
+	// WebhookTimeout limits how long a household's webhook receiver may take
+	// to respond
+	WebhookTimeout time.Duration
+
+	// Mailer sends account emails such as verification and password reset links
+	Mailer mail.Mailer
+
+	// AppURL is the base URL of the web app, used in links sent by email
+	AppURL string
+
+	// SessionCacheTTL is how long the result of checking a session is reused,
+	// and so how long a session signed out on another instance may still be
+	// used here
+	SessionCacheTTL time.Duration
+
+	// RuntimeConfig holds the config in effect, which changes when the config
+	// file is reloaded
+	RuntimeConfig *config.Manager
+
+	// MissedAfter is how long after its deadline an incomplete feed entry is
+	// treated as missed
+	MissedAfter time.Duration
+}
+
+// timestampOrNil converts an optional time into its protobuf representation
+func timestampOrNil(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+
+	return timestamppb.New(*t)
+}
+
+// callerKey identifies the user making a request, so that their reads go to
+// the primary database after they write
+func callerKey(ctx context.Context) string {
+	p, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	return strconv.Itoa(int(p.UserID))
 }
 
 func New(c Config, tokenManager TokenManager) (*Server, error) {
-	dbManager, err := db.New(db.Config{
-		Host:     c.DBHost,
-		Port:     c.DBPort,
-		Username: c.DBUsername,
-		Password: c.DBPassword,
-		Database: c.DBName,
-	})
+	c.DB.CallerKey = callerKey
+
+	dbManager, err := db.New(c.DB)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Server{
-		dbManager:    dbManager,
-		tokenManager: tokenManager,
+		dbManager:     dbManager,
+		tokenManager:  tokenManager,
+		blobStore:     c.BlobStore,
+		dispatcher:    notify.NewDispatcher(dbManager, c.NotificationDrivers),
+		webhooks:      webhooks.NewDispatcher(dbManager, c.WebhookTimeout),
+		mailer:        c.Mailer,
+		appURL:        strings.TrimSuffix(c.AppURL, "/"),
+		resets:        newPasswordResets(),
+		sessions:      auth.NewSessionCache(dbManager, c.SessionCacheTTL),
+		runtimeConfig: c.RuntimeConfig,
+		missedAfter:   c.MissedAfter,
 	}, nil
 }
 
 func (s *Server) CreateCategory(ctx context.Context, req *chorerewardsv1alpha1.CreateCategoryRequest) (*chorerewardsv1alpha1.CreateCategoryResponse, error) {
+	caller, err := householdPrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	category, err := s.dbManager.CreateCategory(ctx, db.Category{
+		HouseholdID: caller.HouseholdID,
 		Color:       req.GetCategory().GetColor(),
 		Name:        req.GetCategory().GetName(),
 		Description: req.GetCategory().GetDescription(),
@@ -70,7 +204,12 @@ func (s *Server) CreateCategory(ctx context.Context, req *chorerewardsv1alpha1.C
 }
 
 func (s *Server) ListCategories(ctx context.Context, req *chorerewardsv1alpha1.ListCategoriesRequest) (*chorerewardsv1alpha1.ListCategoriesResponse, error) {
-	categories, err := s.dbManager.ListCategories(ctx)
+	caller, err := householdPrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := s.dbManager.ListCategories(ctx, caller.HouseholdID)
 	if err != nil {
 		return nil, err
 	}
@@ -91,9 +230,31 @@ func (s *Server) ListCategories(ctx context.Context, req *chorerewardsv1alpha1.L
 }
 
 func (s *Server) CreateTask(ctx context.Context, req *chorerewardsv1alpha1.CreateTaskRequest) (*chorerewardsv1alpha1.CreateTaskResponse, error) {
+	caller, err := householdPrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The category and assignee must belong to the caller's household too
+	category, err := s.dbManager.GetCategoryByID(ctx, req.GetTask().GetCategoryId())
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	if category.HouseholdID != caller.HouseholdID {
+		return nil, status.Error(codes.NotFound, "record not found")
+	}
+
+	if req.GetTask().GetAssigneeId() != 0 {
+		if _, err := householdMember(ctx, s.dbManager, req.GetTask().GetAssigneeId(), caller.HouseholdID); err != nil {
+			return nil, err
+		}
+	}
+
 	task, err := s.dbManager.CreateTask(ctx, db.Task{
 		CategoryID:   req.GetTask().GetCategoryId(),
 		AssigneeID:   req.GetTask().GetAssigneeId(),
+		HouseholdID:  caller.HouseholdID,
 		Name:         req.GetTask().GetName(),
 		Description:  req.GetTask().GetDescription(),
 		Points:       req.GetTask().GetPoints(),
@@ -117,7 +278,12 @@ func (s *Server) CreateTask(ctx context.Context, req *chorerewardsv1alpha1.Creat
 }
 
 func (s *Server) ListTasks(ctx context.Context, req *chorerewardsv1alpha1.ListTasksRequest) (*chorerewardsv1alpha1.ListTasksResponse, error) {
-	tasks, err := s.dbManager.ListTasks(ctx)
+	caller, err := householdPrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := s.dbManager.ListTasks(ctx, caller.HouseholdID)
 	if err != nil {
 		return nil, err
 	}
@@ -141,31 +307,62 @@ func (s *Server) ListTasks(ctx context.Context, req *chorerewardsv1alpha1.ListTa
 }
 
 func (s *Server) AddTaskToFeed(ctx context.Context, req *chorerewardsv1alpha1.AddTaskToFeedRequest) (*chorerewardsv1alpha1.AddTaskToFeedResponse, error) {
-	taskFeed, err := s.dbManager.CreateTaskFeed(ctx, db.TaskFeed{
-		AssigneeID: req.GetTaskFeed().GetAssigneeId(),
-		TaskID:     req.GetTaskFeed().GetTaskId(),
-		IsComplete: req.GetTaskFeed().GetIsComplete(),
-		IsApproved: req.GetTaskFeed().GetIsApproved(),
-		Points:     req.GetTaskFeed().GetPoints(),
-	})
+	caller, err := householdPrincipal(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	var taskFeed db.TaskFeed
+
+	// Both the task and the assignee must belong to the caller's household
+	err = s.dbManager.WithTx(ctx, func(tx db.Store) error {
+		task, err := tx.GetTaskByID(ctx, req.GetTaskFeed().GetTaskId())
+		if err != nil {
+			return statusError(err)
+		}
+
+		if task.HouseholdID != caller.HouseholdID {
+			return status.Error(codes.NotFound, "record not found")
+		}
+
+		if _, err := householdMember(ctx, tx, req.GetTaskFeed().GetAssigneeId(), caller.HouseholdID); err != nil {
+			return err
+		}
+
+		taskFeed, err = tx.CreateTaskFeed(ctx, db.TaskFeed{
+			AssigneeID: req.GetTaskFeed().GetAssigneeId(),
+			TaskID:     task.ID,
+			IsComplete: req.GetTaskFeed().GetIsComplete(),
+			IsApproved: req.GetTaskFeed().GetIsApproved(),
+			Points:     req.GetTaskFeed().GetPoints(),
+		})
+
+		return err
+	})
+	if err != nil {
+		return nil, statusError(err)
+	}
+
 	return &chorerewardsv1alpha1.AddTaskToFeedResponse{
 		TaskFeed: &chorerewardsv1alpha1.TaskFeed{
-			Id:         taskFeed.ID,
-			TaskId:     taskFeed.TaskID,
-			IsComplete: taskFeed.IsComplete,
-			IsApproved: taskFeed.IsApproved,
-			Points:     taskFeed.Points,
-			AssigneeId: taskFeed.AssigneeID,
+			Id:          taskFeed.ID,
+			TaskId:      taskFeed.TaskID,
+			IsComplete:  taskFeed.IsComplete,
+			IsApproved:  taskFeed.IsApproved,
+			CompletedAt: timestampOrNil(taskFeed.CompletedAt),
+			Points:      taskFeed.Points,
+			AssigneeId:  taskFeed.AssigneeID,
 		},
 	}, nil
 }
 
 func (s *Server) ListTasksFeed(ctx context.Context, req *chorerewardsv1alpha1.ListTasksFeedRequest) (*chorerewardsv1alpha1.ListTasksFeedResponse, error) {
-	tasksFeed, err := s.dbManager.ListTasksFeed(ctx)
+	caller, err := householdPrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tasksFeed, err := s.dbManager.ListTasksFeed(ctx, caller.HouseholdID)
 	if err != nil {
 		return nil, err
 	}
@@ -173,12 +370,13 @@ func (s *Server) ListTasksFeed(ctx context.Context, req *chorerewardsv1alpha1.Li
 	tf := make([]*chorerewardsv1alpha1.TaskFeed, len(tasksFeed))
 	for i, tfeed := range tasksFeed {
 		tf[i] = &chorerewardsv1alpha1.TaskFeed{
-			Id:         tfeed.ID,
-			TaskId:     tfeed.TaskID,
-			IsComplete: tfeed.IsComplete,
-			IsApproved: tfeed.IsApproved,
-			Points:     tfeed.Points,
-			AssigneeId: tfeed.AssigneeID,
+			Id:          tfeed.ID,
+			TaskId:      tfeed.TaskID,
+			IsComplete:  tfeed.IsComplete,
+			IsApproved:  tfeed.IsApproved,
+			CompletedAt: timestampOrNil(tfeed.CompletedAt),
+			Points:      tfeed.Points,
+			AssigneeId:  tfeed.AssigneeID,
 		}
 	}
 
@@ -188,6 +386,11 @@ func (s *Server) ListTasksFeed(ctx context.Context, req *chorerewardsv1alpha1.Li
 }
 
 func (s *Server) CreateUser(ctx context.Context, req *chorerewardsv1alpha1.CreateUserRequest) (*chorerewardsv1alpha1.CreateUserResponse, error) {
+	caller, err := householdPrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	pwdHash, err := auth.HashPassword([]byte(req.GetUser().GetPassword()))
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to hash password")
@@ -198,18 +401,47 @@ func (s *Server) CreateUser(ctx context.Context, req *chorerewardsv1alpha1.Creat
 		return nil, errors.Wrap(err, "unable to hash pin")
 	}
 
-	user, err := s.dbManager.CreateUser(ctx, db.User{
-		Username: req.GetUser().GetUsername(),
-		Email:    req.GetUser().GetEmail(),
-		IsAdmin:  req.GetUser().GetIsAdmin(),
-		IsParent: req.GetUser().GetIsParent(),
-		Avatar:   req.GetUser().GetAvatar(),
-		Password: string(pwdHash),
-		Pin:      string(pinHash),
-		IsActive: true,
+	var (
+		user  db.User
+		token string
+	)
+
+	// The user and their verification token are created together, and the
+	// email is only sent once both are committed
+	err = s.dbManager.WithTx(ctx, func(tx db.Store) error {
+		var err error
+
+		// New users join the household of whoever creates them
+		user, err = tx.CreateUser(ctx, db.User{
+			Username:    req.GetUser().GetUsername(),
+			Email:       req.GetUser().GetEmail(),
+			HouseholdID: caller.HouseholdID,
+			IsAdmin:     req.GetUser().GetIsAdmin(),
+			IsParent:    req.GetUser().GetIsParent(),
+			Avatar:      req.GetUser().GetAvatar(),
+			Password:    string(pwdHash),
+			Pin:         string(pinHash),
+			IsActive:    true,
+		})
+		if err != nil {
+			return err
+		}
+
+		token = ""
+		if user.Email != "" {
+			token, err = issueEmailVerification(ctx, tx, user)
+		}
+
+		return err
 	})
 	if err != nil {
-		return nil, err
+		return nil, statusError(err)
+	}
+
+	if token != "" {
+		if err := s.mailEmailVerification(ctx, user, token); err != nil {
+			log.WithError(err).WithField("id", user.ID).Warn("Unable to send email verification")
+		}
 	}
 
 	return &chorerewardsv1alpha1.CreateUserResponse{
@@ -227,7 +459,12 @@ func (s *Server) CreateUser(ctx context.Context, req *chorerewardsv1alpha1.Creat
 }
 
 func (s *Server) ListUsers(ctx context.Context, req *chorerewardsv1alpha1.ListUsersRequest) (*chorerewardsv1alpha1.ListUsersResponse, error) {
-	users, err := s.dbManager.ListUsers(ctx)
+	caller, err := householdPrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := s.dbManager.ListUsers(ctx, caller.HouseholdID)
 	if err != nil {
 		return nil, err
 	}
@@ -266,9 +503,7 @@ func (s *Server) Login(ctx context.Context, req *chorerewardsv1alpha1.LoginReque
 
 	user, err := s.dbManager.GetUser(ctx, req.GetUsername())
 	if err != nil {
-		// errors.As is the equivalent of a type assertion
-		// if e, ok := err.(*errNotFound); ok
-		if errors.As(err, &errNotFound) {
+		if isNotFound(err) {
 			return nil, status.Error(codes.Internal, "incorrect username or password")
 		}
 		return nil, status.Error(codes.Internal, err.Error())
@@ -286,14 +521,5 @@ func (s *Server) Login(ctx context.Context, req *chorerewardsv1alpha1.LoginReque
 		return nil, status.Error(codes.PermissionDenied, "incorrect username or password")
 	}
 
-	token, err := s.tokenManager.CreateToken(req.GetUsername())
-	if err != nil {
-		return nil, errors.Wrap(err, "Unable to create Token")
-	}
-
-	return &chorerewardsv1alpha1.LoginResponse{
-		Token:    token,
-		IsAdmin:  user.IsAdmin,
-		IsParent: user.IsParent,
-	}, nil
+	return s.loginResponse(ctx, user)
 }
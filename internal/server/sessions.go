@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/interceptors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// maxDeviceNameLength limits the device name clients send in x-device-name
+const maxDeviceNameLength = 100
+
+// firstMetadata returns the first value of the first key present in the
+// incoming metadata
+func firstMetadata(ctx context.Context, keys ...string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	for _, k := range keys {
+		if v := md.Get(k); len(v) > 0 {
+			return v[0]
+		}
+	}
+
+	return ""
+}
+
+// newSession records a sign in from the device making the request
+func (s *Server) newSession(ctx context.Context, userID int32) (db.Session, error) {
+	id, err := auth.NewSessionID()
+	if err != nil {
+		return db.Session{}, err
+	}
+
+	deviceName := strings.TrimSpace(firstMetadata(ctx, "x-device-name"))
+	if len(deviceName) > maxDeviceNameLength {
+		deviceName = deviceName[:maxDeviceNameLength]
+	}
+
+	return s.dbManager.CreateSession(ctx, db.Session{
+		ID:         id,
+		UserID:     userID,
+		DeviceName: deviceName,
+		// Calls through the HTTP proxy carry the browser's user agent
+		// separately from the proxy's own
+		UserAgent: firstMetadata(ctx, "grpcgateway-user-agent", "user-agent"),
+		IPAddress: interceptors.ClientAddress(ctx),
+		ExpiresAt: time.Now().Add(s.tokenManager.TokenTTL()),
+	})
+}
+
+// Sessions returns the cache the auth interceptor checks sessions with, which
+// is told straight away about sessions signed out through this server
+func (s *Server) Sessions() *auth.SessionCache {
+	return s.sessions
+}
+
+// ListSessions lists the devices the caller is signed in on
+func (s *Server) ListSessions(ctx context.Context) ([]db.Session, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.dbManager.ListSessions(ctx, p.UserID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession signs the caller out on one of their devices
+func (s *Server) RevokeSession(ctx context.Context, sessionID string) error {
+	p, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.dbManager.RevokeSession(ctx, p.UserID, sessionID); err != nil {
+		return statusError(err)
+	}
+
+	s.sessions.Invalidate(sessionID)
+
+	return nil
+}
+
+// RevokeOtherSessions signs the caller out everywhere except the device making
+// the request
+func (s *Server) RevokeOtherSessions(ctx context.Context) error {
+	p, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+
+	ids, err := s.dbManager.RevokeOtherSessions(ctx, p.UserID, p.SessionID)
+	if err != nil {
+		return statusError(err)
+	}
+
+	for _, id := range ids {
+		s.sessions.Invalidate(id)
+	}
+
+	return nil
+}
+
+// Logout signs the caller out of the session they are calling with
+func (s *Server) Logout(ctx context.Context) error {
+	p, err := principal(ctx)
+	if err != nil {
+		return err
+	}
+
+	if p.SessionID == "" {
+		return status.Error(codes.FailedPrecondition, "Token has no session")
+	}
+
+	return s.RevokeSession(ctx, p.SessionID)
+}
+
+// DeleteExpiredSessions removes sessions whose tokens have expired
+func (s *Server) DeleteExpiredSessions(ctx context.Context) error {
+	if _, err := s.dbManager.DeleteExpiredSessions(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
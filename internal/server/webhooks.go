@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/webhooks"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const webhookDeliveriesLimit = 100
+
+// TestWebhookResult is the outcome of test-firing a subscription
+type TestWebhookResult struct {
+	ResponseStatus int
+	Error          string
+}
+
+// webhookParent returns the caller, who must be a parent to manage their
+// household's webhooks
+func webhookParent(ctx context.Context) (auth.Principal, error) {
+	p, err := principal(ctx)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	if !p.IsParent() {
+		return auth.Principal{}, status.Error(codes.PermissionDenied, "Only parents can manage webhooks")
+	}
+
+	return p, nil
+}
+
+// webhookSubscription returns a subscription belonging to the caller's household
+func (s *Server) webhookSubscription(ctx context.Context, subscriptionID int32) (db.WebhookSubscription, error) {
+	parent, err := webhookParent(ctx)
+	if err != nil {
+		return db.WebhookSubscription{}, err
+	}
+
+	sub, err := s.dbManager.GetWebhookSubscription(ctx, subscriptionID)
+	if err != nil {
+		return db.WebhookSubscription{}, statusError(err)
+	}
+
+	// Subscriptions in other households are reported as missing rather than
+	// forbidden so their IDs can't be probed
+	if sub.HouseholdID != parent.HouseholdID {
+		return db.WebhookSubscription{}, status.Error(codes.NotFound, "record not found")
+	}
+
+	return sub, nil
+}
+
+// CreateWebhookSubscription subscribes a URL to events in the caller's
+// household. The returned subscription includes the generated signing secret.
+func (s *Server) CreateWebhookSubscription(ctx context.Context, subscription db.WebhookSubscription) (db.WebhookSubscription, error) {
+	parent, err := webhookParent(ctx)
+	if err != nil {
+		return db.WebhookSubscription{}, err
+	}
+
+	u, err := url.Parse(subscription.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return db.WebhookSubscription{}, status.Error(codes.InvalidArgument, "Invalid webhook URL")
+	}
+
+	for _, eventType := range subscription.EventTypes {
+		if !validEventType(eventType) {
+			return db.WebhookSubscription{}, status.Errorf(codes.InvalidArgument, "Unknown event type %q", eventType)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return db.WebhookSubscription{}, status.Error(codes.Internal, "Unable to generate webhook secret")
+	}
+
+	subscription.HouseholdID = parent.HouseholdID
+	subscription.Secret = hex.EncodeToString(secret)
+
+	sub, err := s.dbManager.CreateWebhookSubscription(ctx, subscription)
+	if err != nil {
+		return db.WebhookSubscription{}, statusError(err)
+	}
+
+	return sub, nil
+}
+
+func validEventType(eventType string) bool {
+	for _, t := range db.WebhookEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ListWebhookSubscriptions lists the subscriptions of the caller's household.
+// Secrets are only returned when a subscription is created, so they are left
+// out.
+func (s *Server) ListWebhookSubscriptions(ctx context.Context) ([]db.WebhookSubscription, error) {
+	parent, err := webhookParent(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subs, err := s.dbManager.ListWebhookSubscriptions(ctx, parent.HouseholdID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+
+	return subs, nil
+}
+
+// DeleteWebhookSubscription stops delivering events to a subscription
+func (s *Server) DeleteWebhookSubscription(ctx context.Context, subscriptionID int32) error {
+	if _, err := s.webhookSubscription(ctx, subscriptionID); err != nil {
+		return err
+	}
+
+	if err := s.dbManager.DeleteWebhookSubscription(ctx, subscriptionID); err != nil {
+		return statusError(err)
+	}
+
+	return nil
+}
+
+// ListWebhookDeliveries lists the most recent deliveries to a subscription
+func (s *Server) ListWebhookDeliveries(ctx context.Context, subscriptionID int32) ([]db.WebhookDelivery, error) {
+	if _, err := s.webhookSubscription(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.dbManager.ListWebhookDeliveries(ctx, subscriptionID, webhookDeliveriesLimit)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return deliveries, nil
+}
+
+// ListDeadWebhookDeliveries lists the deliveries in the caller's household
+// which failed too many times to be retried
+func (s *Server) ListDeadWebhookDeliveries(ctx context.Context) ([]db.WebhookDelivery, error) {
+	parent, err := webhookParent(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.dbManager.ListDeadWebhookDeliveries(ctx, parent.HouseholdID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return deliveries, nil
+}
+
+// ReplayWebhookDelivery queues a past delivery, such as one from the
+// dead-letter list, to be sent again
+func (s *Server) ReplayWebhookDelivery(ctx context.Context, deliveryID int32) (db.WebhookDelivery, error) {
+	delivery, err := s.dbManager.GetWebhookDelivery(ctx, deliveryID)
+	if err != nil {
+		return db.WebhookDelivery{}, statusError(err)
+	}
+
+	if _, err := s.webhookSubscription(ctx, delivery.SubscriptionID); err != nil {
+		return db.WebhookDelivery{}, err
+	}
+
+	replay, err := s.dbManager.ReplayWebhookDelivery(ctx, deliveryID)
+	if err != nil {
+		return db.WebhookDelivery{}, statusError(err)
+	}
+
+	return replay, nil
+}
+
+// TestWebhookSubscription immediately sends a ping event to a subscription so
+// that the receiver can be checked. The ping is not recorded as a delivery.
+func (s *Server) TestWebhookSubscription(ctx context.Context, subscriptionID int32) (TestWebhookResult, error) {
+	sub, err := s.webhookSubscription(ctx, subscriptionID)
+	if err != nil {
+		return TestWebhookResult{}, err
+	}
+
+	data, err := json.Marshal(map[string]int32{"subscription_id": sub.ID})
+	if err != nil {
+		return TestWebhookResult{}, status.Error(codes.Internal, err.Error())
+	}
+
+	responseStatus, err := s.webhooks.Send(ctx, sub.URL, sub.Secret, webhooks.Envelope{
+		Type:      webhooks.EventPing,
+		CreatedAt: s.webhooks.Clock.Now(),
+		Data:      data,
+	})
+
+	result := TestWebhookResult{ResponseStatus: responseStatus}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result, nil
+}
+
+// DispatchWebhooks delivers queued webhook events. It is intended to be run
+// periodically in the background.
+func (s *Server) DispatchWebhooks(ctx context.Context) error {
+	return s.webhooks.Run(ctx)
+}
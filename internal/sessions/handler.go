@@ -0,0 +1,177 @@
+// Package sessions serves the devices a user is signed in on, and signing out
+// of them, on the HTTP proxy
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/interceptors"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/status"
+)
+
+// Service manages the caller's sessions
+type Service interface {
+	ListSessions(ctx context.Context) ([]db.Session, error)
+	RevokeSession(ctx context.Context, sessionID string) error
+	RevokeOtherSessions(ctx context.Context) error
+	Logout(ctx context.Context) error
+}
+
+// Authenticator authenticates the bearer token of a request, returning a
+// context carrying its principal
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (context.Context, error)
+}
+
+// Limiter limits the rate of requests from each client address
+type Limiter interface {
+	Allow(key string) bool
+}
+
+// Handler serves the session routes alongside the routes generated by
+// grpc-gateway
+type Handler struct {
+	service Service
+	auth    Authenticator
+	limiter Limiter
+}
+
+func NewHandler(service Service, auth Authenticator, limiter Limiter) *Handler {
+	return &Handler{
+		service: service,
+		auth:    auth,
+		limiter: limiter,
+	}
+}
+
+// Register adds the session routes to the gateway mux
+func (h *Handler) Register(mux *runtime.ServeMux) error {
+	if err := mux.HandlePath(http.MethodGet, "/v1alpha1/sessions", h.list); err != nil {
+		return err
+	}
+
+	if err := mux.HandlePath(http.MethodDelete, "/v1alpha1/sessions/{id}", h.revoke); err != nil {
+		return err
+	}
+
+	if err := mux.HandlePath(http.MethodPost, "/v1alpha1/sessions/revoke-others", h.revokeOthers); err != nil {
+		return err
+	}
+
+	return mux.HandlePath(http.MethodPost, "/v1alpha1/logout", h.logout)
+}
+
+type session struct {
+	ID         string    `json:"id"`
+	DeviceName string    `json:"deviceName"`
+	UserAgent  string    `json:"userAgent"`
+	IPAddress  string    `json:"ipAddress"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	// Current is set on the session the request was made with
+	Current bool `json:"current"`
+}
+
+// authenticate limits the request and authenticates its bearer token,
+// returning the context to call the service with, or writes an error and
+// returns false
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) (context.Context, bool) {
+	ctx := interceptors.HTTPContext(r)
+
+	if h.limiter != nil && !h.limiter.Allow(interceptors.ClientAddress(ctx)) {
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return nil, false
+	}
+
+	ctx, err := h.auth.Authenticate(ctx, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		writeError(w, err)
+		return nil, false
+	}
+
+	return ctx, true
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	ctx, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.service.ListSessions(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	p, _ := auth.PrincipalFromContext(ctx)
+
+	resp := struct {
+		Sessions []session `json:"sessions"`
+	}{Sessions: make([]session, 0, len(sessions))}
+
+	for _, s := range sessions {
+		resp.Sessions = append(resp.Sessions, session{
+			ID:         s.ID,
+			DeviceName: s.DeviceName,
+			UserAgent:  s.UserAgent,
+			IPAddress:  s.IPAddress,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			ExpiresAt:  s.ExpiresAt,
+			Current:    s.ID == p.SessionID,
+		})
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) revoke(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	h.signOut(w, r, func(ctx context.Context) error {
+		return h.service.RevokeSession(ctx, pathParams["id"])
+	})
+}
+
+func (h *Handler) revokeOthers(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	h.signOut(w, r, h.service.RevokeOtherSessions)
+}
+
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	h.signOut(w, r, h.service.Logout)
+}
+
+// signOut calls a method which signs sessions out, which returns nothing
+func (h *Handler) signOut(w http.ResponseWriter, r *http.Request, fn func(ctx context.Context) error) {
+	ctx, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := fn(ctx); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, struct{}{})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+
+	http.Error(w, s.Message(), runtime.HTTPStatusFromCode(s.Code()))
+}
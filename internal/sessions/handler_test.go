@@ -0,0 +1,122 @@
+package sessions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeService struct {
+	revoked []string
+}
+
+func (f *fakeService) ListSessions(ctx context.Context) ([]db.Session, error) {
+	at := time.Unix(0, 0).UTC()
+
+	return []db.Session{
+		{ID: "phone", UserID: 1, DeviceName: "Phone", CreatedAt: at, LastSeenAt: at, ExpiresAt: at},
+		{ID: "laptop", UserID: 1, DeviceName: "Laptop", CreatedAt: at, LastSeenAt: at, ExpiresAt: at},
+	}, nil
+}
+
+func (f *fakeService) RevokeSession(ctx context.Context, sessionID string) error {
+	if sessionID != "laptop" {
+		return status.Error(codes.NotFound, "record not found")
+	}
+
+	f.revoked = append(f.revoked, sessionID)
+
+	return nil
+}
+
+func (f *fakeService) RevokeOtherSessions(ctx context.Context) error {
+	f.revoked = append(f.revoked, "others")
+	return nil
+}
+
+func (f *fakeService) Logout(ctx context.Context) error {
+	p, _ := auth.PrincipalFromContext(ctx)
+	f.revoked = append(f.revoked, p.SessionID)
+
+	return nil
+}
+
+type fakeAuth struct{}
+
+func (fakeAuth) Authenticate(ctx context.Context, token string) (context.Context, error) {
+	if token != "token" {
+		return nil, status.Error(codes.Unauthenticated, "Invalid token")
+	}
+
+	return auth.ContextWithPrincipal(ctx, auth.Principal{UserID: 1, HouseholdID: 1, SessionID: "phone"}), nil
+}
+
+type denyLimiter struct{}
+
+func (denyLimiter) Allow(key string) bool { return false }
+
+func newMux(t *testing.T, h *Handler) *runtime.ServeMux {
+	mux := runtime.NewServeMux()
+	assert.NoError(t, h.Register(mux))
+
+	return mux
+}
+
+func call(mux http.Handler, method string, path string, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	service := &fakeService{}
+	mux := newMux(t, NewHandler(service, fakeAuth{}, nil))
+
+	t.Run("it should require a token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, call(mux, http.MethodGet, "/v1alpha1/sessions", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, call(mux, http.MethodPost, "/v1alpha1/logout", "").Code)
+	})
+
+	t.Run("it should list sessions and mark the current one", func(t *testing.T) {
+		w := call(mux, http.MethodGet, "/v1alpha1/sessions", "token")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		assert.JSONEq(t, `{"sessions":[
+			{"id":"phone","deviceName":"Phone","userAgent":"","ipAddress":"","createdAt":"1970-01-01T00:00:00Z","lastSeenAt":"1970-01-01T00:00:00Z","expiresAt":"1970-01-01T00:00:00Z","current":true},
+			{"id":"laptop","deviceName":"Laptop","userAgent":"","ipAddress":"","createdAt":"1970-01-01T00:00:00Z","lastSeenAt":"1970-01-01T00:00:00Z","expiresAt":"1970-01-01T00:00:00Z","current":false}
+		]}`, w.Body.String())
+	})
+
+	t.Run("it should sign sessions out", func(t *testing.T) {
+		service.revoked = nil
+
+		assert.Equal(t, http.StatusOK, call(mux, http.MethodDelete, "/v1alpha1/sessions/laptop", "token").Code)
+		assert.Equal(t, http.StatusNotFound, call(mux, http.MethodDelete, "/v1alpha1/sessions/tablet", "token").Code)
+		assert.Equal(t, http.StatusOK, call(mux, http.MethodPost, "/v1alpha1/sessions/revoke-others", "token").Code)
+		assert.Equal(t, http.StatusOK, call(mux, http.MethodPost, "/v1alpha1/logout", "token").Code)
+
+		assert.Equal(t, []string{"laptop", "others", "phone"}, service.revoked)
+	})
+
+	t.Run("it should reject clients over their rate limit", func(t *testing.T) {
+		limited := newMux(t, NewHandler(service, fakeAuth{}, denyLimiter{}))
+
+		assert.Equal(t, http.StatusTooManyRequests, call(limited, http.MethodGet, "/v1alpha1/sessions", "token").Code)
+	})
+}
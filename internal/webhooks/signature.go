@@ -0,0 +1,75 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SignatureHeader carries the signature of a delivery
+const SignatureHeader = "X-ChoreRewards-Signature"
+
+func mac(secret string, timestamp int64, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	m.Write([]byte("."))
+	m.Write(body)
+
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// Sign returns the signature header value for a payload. The timestamp is
+// included in the signed content so receivers can reject replayed requests.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := timestamp.Unix()
+
+	return "t=" + strconv.FormatInt(t, 10) + ",v1=" + mac(secret, t, body)
+}
+
+// Verify checks a signature header against a payload, rejecting signatures
+// made more than tolerance away from now. Receivers can use it to validate
+// deliveries.
+func Verify(secret string, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var (
+		timestamp int64
+		signature string
+	)
+
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "t":
+			t, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return errors.New("invalid signature timestamp")
+			}
+			timestamp = t
+		case "v1":
+			signature = kv[1]
+		}
+	}
+
+	if timestamp == 0 || signature == "" {
+		return errors.New("malformed signature header")
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(mac(secret, timestamp, body))) {
+		return errors.New("signature mismatch")
+	}
+
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > tolerance || age < -tolerance {
+		return errors.New("signature timestamp outside tolerance")
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/egress"
+	"github.com/chorerewards/backend/internal/outbox"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// EventPing is only sent when test-firing a subscription. The other event
+// types are listed in db.WebhookEventTypes.
+const EventPing = "ping"
+
+// Envelope is the JSON body POSTed to subscribers
+type Envelope struct {
+	ID        int32           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Store holds webhook deliveries until they succeed or are dead-lettered
+type Store interface {
+	// ClaimWebhookDeliveries returns up to limit due deliveries, hiding them
+	// from other dispatchers for the lease duration
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]db.PendingWebhookDelivery, error)
+	MarkWebhookDelivered(ctx context.Context, id int32, responseStatus int) error
+	// RetryWebhookDelivery records a failed attempt. A zero next time moves
+	// the delivery to the dead-letter list.
+	RetryWebhookDelivery(ctx context.Context, id int32, lastError string, responseStatus int, next time.Time) error
+}
+
+var _ Store = (*db.Manager)(nil)
+
+// Dispatcher sends webhook deliveries, retrying failures with exponential
+// backoff until they are dead-lettered. Subscribers choose their own URLs, so
+// deliveries to internal addresses are refused.
+type Dispatcher struct {
+	*outbox.Dispatcher
+	client *http.Client
+}
+
+func NewDispatcher(store Store, timeout time.Duration) *Dispatcher {
+	d := &Dispatcher{
+		client: egress.NewClient(timeout),
+	}
+
+	d.Dispatcher = outbox.NewDispatcher("webhook", &queue{store: store, dispatcher: d}, outbox.Policy{
+		BatchSize:   100,
+		Lease:       time.Minute * 5,
+		BaseBackoff: time.Second * 10,
+		MaxBackoff:  time.Hour * 6,
+		MaxAttempts: 10,
+	})
+
+	return d
+}
+
+// delivery is a claimed delivery along with the status of the response to it
+type delivery struct {
+	db.PendingWebhookDelivery
+	responseStatus int
+}
+
+// queue sends the deliveries in the store
+type queue struct {
+	store      Store
+	dispatcher *Dispatcher
+}
+
+func (q *queue) Claim(ctx context.Context, limit int, lease time.Duration) ([]outbox.Entry, error) {
+	pending, err := q.store.ClaimWebhookDeliveries(ctx, limit, lease)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]outbox.Entry, len(pending))
+	for i, p := range pending {
+		entries[i] = outbox.Entry{
+			ID:       p.ID,
+			Attempts: p.Attempts,
+			Value:    &delivery{PendingWebhookDelivery: p},
+			Fields:   log.Fields{"subscriptionID": p.SubscriptionID},
+		}
+	}
+
+	return entries, nil
+}
+
+func (q *queue) Send(ctx context.Context, e outbox.Entry) error {
+	d := e.Value.(*delivery)
+
+	var err error
+	d.responseStatus, err = q.dispatcher.Send(ctx, d.URL, d.Secret, Envelope{
+		ID:        d.ID,
+		Type:      d.EventType,
+		CreatedAt: d.CreatedAt,
+		Data:      d.Payload,
+	})
+
+	return err
+}
+
+func (q *queue) MarkDelivered(ctx context.Context, e outbox.Entry) error {
+	return q.store.MarkWebhookDelivered(ctx, e.ID, e.Value.(*delivery).responseStatus)
+}
+
+func (q *queue) Retry(ctx context.Context, e outbox.Entry, lastError string, next time.Time) error {
+	return q.store.RetryWebhookDelivery(ctx, e.ID, lastError, e.Value.(*delivery).responseStatus, next)
+}
+
+// Send signs and POSTs an envelope to a subscriber, returning the response
+// status. Any non-2xx response is an error.
+func (d *Dispatcher) Send(ctx context.Context, url string, secret string, envelope Envelope) (int, error) {
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to encode webhook")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to create webhook request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ChoreRewards-Webhooks/1.0")
+	req.Header.Set("X-ChoreRewards-Event", envelope.Type)
+	req.Header.Set("X-ChoreRewards-Delivery", strconv.Itoa(int(envelope.ID)))
+	req.Header.Set(SignatureHeader, Sign(secret, d.Clock.Now(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,147 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chorerewards/backend/internal/clock"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/egress"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStore struct {
+	pending   []db.PendingWebhookDelivery
+	delivered map[int32]int
+	retried   map[int32]time.Time
+}
+
+func (f *fakeStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]db.PendingWebhookDelivery, error) {
+	return f.pending, nil
+}
+
+func (f *fakeStore) MarkWebhookDelivered(ctx context.Context, id int32, responseStatus int) error {
+	f.delivered[id] = responseStatus
+	return nil
+}
+
+func (f *fakeStore) RetryWebhookDelivery(ctx context.Context, id int32, lastError string, responseStatus int, next time.Time) error {
+	f.retried[id] = next
+	return nil
+}
+
+func TestSignature(t *testing.T) {
+	now := time.Date(2021, 6, 7, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"type":"task.created"}`)
+
+	header := Sign("secret", now, body)
+
+	t.Run("it should verify a signature it made", func(t *testing.T) {
+		assert.NoError(t, Verify("secret", header, body, now.Add(time.Minute), time.Minute*5))
+	})
+
+	t.Run("it should reject a different secret", func(t *testing.T) {
+		assert.Error(t, Verify("other", header, body, now, time.Minute*5))
+	})
+
+	t.Run("it should reject a modified body", func(t *testing.T) {
+		assert.Error(t, Verify("secret", header, []byte(`{"type":"feed.approved"}`), now, time.Minute*5))
+	})
+
+	t.Run("it should reject an old signature", func(t *testing.T) {
+		assert.Error(t, Verify("secret", header, body, now.Add(time.Hour), time.Minute*5))
+	})
+
+	t.Run("it should reject a malformed header", func(t *testing.T) {
+		assert.Error(t, Verify("secret", "v1=abc", body, now, time.Minute*5))
+	})
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	d := NewDispatcher(&fakeStore{}, time.Second)
+
+	t.Run("it should double the delay after each attempt", func(t *testing.T) {
+		assert.Equal(t, time.Second*10, d.Backoff(1))
+		assert.Equal(t, time.Second*20, d.Backoff(2))
+		assert.Equal(t, time.Second*80, d.Backoff(4))
+	})
+
+	t.Run("it should cap the delay", func(t *testing.T) {
+		assert.Equal(t, time.Hour*6, d.Backoff(20))
+	})
+}
+
+func TestDispatcherRun(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	var received []Envelope
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if err := Verify("secret", r.Header.Get(SignatureHeader), body, now, time.Minute); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var e Envelope
+		_ = json.Unmarshal(body, &e)
+		received = append(received, e)
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	store := &fakeStore{
+		pending: []db.PendingWebhookDelivery{
+			{ID: 1, EventType: db.EventTaskCreated, Payload: json.RawMessage(`{"id":5}`), URL: srv.URL, Secret: "secret"},
+			{ID: 2, EventType: db.EventFeedApproved, Payload: json.RawMessage(`{}`), URL: srv.URL, Secret: "wrong", Attempts: 1},
+			{ID: 3, EventType: db.EventPointsChanged, Payload: json.RawMessage(`{}`), URL: srv.URL, Secret: "wrong", Attempts: 9},
+		},
+		delivered: make(map[int32]int),
+		retried:   make(map[int32]time.Time),
+	}
+
+	d := NewDispatcher(store, time.Second)
+	d.Clock = clock.Fixed(now)
+	d.client = srv.Client()
+
+	assert.NoError(t, d.Run(context.Background()))
+
+	t.Run("it should deliver signed envelopes", func(t *testing.T) {
+		assert.Equal(t, map[int32]int{1: http.StatusNoContent}, store.delivered)
+		assert.Len(t, received, 1)
+		assert.Equal(t, int32(1), received[0].ID)
+		assert.JSONEq(t, `{"id":5}`, string(received[0].Data))
+	})
+
+	t.Run("it should retry failed deliveries with backoff", func(t *testing.T) {
+		assert.Equal(t, now.Add(time.Second*20), store.retried[2])
+	})
+
+	t.Run("it should dead-letter deliveries after the last attempt", func(t *testing.T) {
+		next, ok := store.retried[3]
+		assert.True(t, ok)
+		assert.True(t, next.IsZero())
+	})
+}
+
+func TestDispatcherSend(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should not have been sent")
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(&fakeStore{}, time.Second)
+
+	t.Run("it should refuse to send to internal addresses", func(t *testing.T) {
+		_, err := d.Send(context.Background(), srv.URL, "secret", Envelope{Type: EventPing})
+		assert.True(t, errors.Is(err, egress.ErrForbiddenAddress), err)
+	})
+}
@@ -2,21 +2,43 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
-	"net"
 	"net/http"
 	"os"
-	"time"
+	"strings"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
-	"github.com/rs/cors"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 
+	"github.com/chorerewards/backend/internal/admin"
+	"github.com/chorerewards/backend/internal/attachments"
 	"github.com/chorerewards/backend/internal/auth"
+	"github.com/chorerewards/backend/internal/blob"
+	"github.com/chorerewards/backend/internal/certs"
+	"github.com/chorerewards/backend/internal/config"
+	"github.com/chorerewards/backend/internal/cors"
+	"github.com/chorerewards/backend/internal/db"
+	"github.com/chorerewards/backend/internal/docs"
+	"github.com/chorerewards/backend/internal/httpapi"
+	"github.com/chorerewards/backend/internal/interceptors"
+	"github.com/chorerewards/backend/internal/jobs"
+	"github.com/chorerewards/backend/internal/mail"
+	"github.com/chorerewards/backend/internal/mfa"
+	"github.com/chorerewards/backend/internal/notify"
+	"github.com/chorerewards/backend/internal/oidc"
 	"github.com/chorerewards/backend/internal/server"
+	"github.com/chorerewards/backend/internal/sessions"
 	chorerewardsv1alpha1 "github.com/chorerewards/proto/chorerewards/v1alpha1"
 )
 
@@ -32,124 +54,488 @@ func init() {
 }
 
 func main() {
-	// Config file
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-
-	// Server defaults
-	viper.SetDefault("server.port", 8080)
-	viper.SetDefault("server.httpProxy.enabled", false)
-	viper.SetDefault("server.httpProxy.port", 8443)
-
-	// DB defaults
-	viper.SetDefault("db.host", "localhost")
-	viper.SetDefault("db.port", 5432)
-	viper.SetDefault("db.username", "chorerewards")
-	viper.SetDefault("db.password", "")
-	viper.SetDefault("db.name", "chorerewards")
-
-	// Auth defaults
-	viper.SetDefault("auth.key", "secretkey")
-
-	err := viper.ReadInConfig()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	v := newViper()
+
+	cfg, err := config.Load(v)
 	if err != nil {
-		log.Fatal("unable to read config")
+		var invalid *config.ValidationError
+		if errors.As(err, &invalid) {
+			logInvalidConfig(err)
+			os.Exit(1)
+		}
+
+		log.Fatalf("Unable to read config: %+v", err)
 	}
 
-	var (
-		port             = viper.GetInt("server.port")
-		httpProxyEnabled = viper.GetBool("server.httpProxy.enabled")
-		httpProxyPort    = viper.GetInt("server.httpProxy.port")
+	if err := cfg.Validate(); err != nil {
+		logInvalidConfig(err)
+		os.Exit(1)
+	}
 
-		dbHost     = viper.GetString("db.host")
-		dbPort     = viper.GetInt("db.port")
-		dbUsername = viper.GetString("db.username")
-		dbPassword = viper.GetString("db.password")
-		dbName     = viper.GetString("db.name")
+	// Validate has checked the level
+	level, _ := log.ParseLevel(cfg.Log.Level)
+	log.SetLevel(level)
 
-		authKey = viper.GetString("auth.key")
-	)
+	runtimeConfig := config.NewManager(v, cfg)
 
 	log.WithFields(log.Fields{
-		"Server Port":        port,
-		"HTTP Proxy Enabled": httpProxyEnabled,
-		"HTTP Proxy Port":    httpProxyPort,
-		"Database Name":      dbName,
-		"Database Host":      dbHost,
-		"Database Port":      dbPort,
-		"Database Username":  dbUsername,
+		"Server Port":        cfg.Server.Port,
+		"HTTP Proxy Enabled": cfg.Server.HTTPProxy.Enabled,
+		"Database Name":      cfg.DB.Name,
+		"Database Host":      cfg.DB.Host,
+		"Database Port":      cfg.DB.Port,
+		"Database Username":  cfg.DB.Username,
+		"Config File":        v.ConfigFileUsed(),
 	}).Info("Config Initialised")
 
-	tokenManager := auth.NewTokenManager(authKey)
+	keys, err := newKeySet(cfg.Auth)
+	if err != nil {
+		log.Fatalf("Unable to initialise token signing keys: %+v", err)
+	}
+
+	tokenManager := auth.NewTokenManager(keys, cfg.Auth.Issuer, cfg.Auth.Audience)
+	tokenManager.SetTokenTTL(cfg.Auth.TokenTTL)
+
+	blobStore, err := blob.NewLocalStore(cfg.Attachments.Dir)
+	if err != nil {
+		log.Fatalf("Unable to initialise attachment store: %+v", err)
+	}
+
+	notificationDrivers, err := newNotificationDrivers(cfg.Notifications)
+	if err != nil {
+		log.Fatalf("Unable to initialise notification drivers: %+v", err)
+	}
+
+	mailer, err := newMailer(cfg)
+	if err != nil {
+		log.Fatalf("Unable to initialise mailer: %+v", err)
+	}
+
+	policies := server.Policies()
 
 	server, err := server.New(
-		server.Config{DBHost: dbHost, DBPort: dbPort, DBUsername: dbUsername, DBPassword: dbPassword, DBName: dbName},
+		server.Config{
+			DB:                  newDBConfig(cfg.DB),
+			BlobStore:           blobStore,
+			NotificationDrivers: notificationDrivers,
+			WebhookTimeout:      cfg.Webhooks.Timeout,
+			Mailer:              mailer,
+			AppURL:              cfg.Mail.AppURL,
+			SessionCacheTTL:     cfg.Auth.SessionCacheTTL,
+			RuntimeConfig:       runtimeConfig,
+			MissedAfter:         cfg.Tasks.MissedAfter,
+		},
 		tokenManager,
 	)
 	if err != nil {
 		log.Fatalf("Unable to initialise new Server: %+v", err)
 	}
 
+	go jobs.Every(context.Background(), "release-expired-claims", cfg.Jobs.ReleaseExpiredClaimsInterval, server.ReleaseExpiredClaims)
+	go jobs.Every(context.Background(), "run-due-rotations", cfg.Jobs.RunDueRotationsInterval, server.RunDueRotations)
+	go jobs.Every(context.Background(), "mark-overdue", cfg.Jobs.MarkOverdueInterval, server.MarkOverdue)
+	go jobs.Every(context.Background(), "dispatch-notifications", cfg.Jobs.DispatchNotificationsInterval, server.DispatchNotifications)
+	go jobs.Every(context.Background(), "dispatch-webhooks", cfg.Jobs.DispatchWebhooksInterval, server.DispatchWebhooks)
+	go server.SendPasswordResets(context.Background())
+	go jobs.Every(context.Background(), "delete-expired-sessions", cfg.Jobs.DeleteExpiredSessionsInterval, server.DeleteExpiredSessions)
+
+	// Interceptors run in order, so the request ID is available to everything
+	// after it and the access log records the final status of every call,
+	// including those rejected by the rate limiter or auth
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		interceptors.RequestID,
+		interceptors.Logging,
+		interceptors.Recovery,
+	}
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		interceptors.StreamRequestID,
+		interceptors.StreamLogging,
+		interceptors.StreamRecovery,
+	}
+
+	// The limiter is always installed so that rate limiting can be turned on
+	// by reloading the config
+	limiter := interceptors.NewRateLimiter(cfg.Server.RateLimit.RequestsPerSecond, cfg.Server.RateLimit.Burst)
+
+	unaryInterceptors = append(unaryInterceptors, limiter.Unary)
+	streamInterceptors = append(streamInterceptors, limiter.Stream)
+
+	authorizer := auth.NewAuthorizer(tokenManager, policies, server.Sessions())
+
+	unaryInterceptors = append(unaryInterceptors, authorizer.Unary)
+	streamInterceptors = append(streamInterceptors, authorizer.Stream)
+
 	gServer := grpc.NewServer(
-		grpc.UnaryInterceptor(tokenManager.ValidateAuthInterceptor),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
 	)
 
 	chorerewardsv1alpha1.RegisterChoreRewardsServiceServer(gServer, server)
 
 	reflection.Register(gServer)
+	healthpb.RegisterHealthServer(gServer, health.NewServer())
+
+	// Refuse to start if any method could be called without a policy
+	if err := policies.Validate(gServer.GetServiceInfo()); err != nil {
+		log.Fatalf("Invalid access policies: %+v", err)
+	}
+
+	if v.IsSet("server.httpProxy.port") {
+		log.Warn("server.httpProxy.port is no longer used, the HTTP proxy is served on server.port")
+	}
+
+	var httpHandler http.Handler
+
+	if cfg.Server.HTTPProxy.Enabled {
+		attachmentsHandler := attachments.NewHandler(
+			server,
+			authorizer,
+			attachments.NewSigner(cfg.Attachments.URLKey, cfg.Attachments.URLTTL),
+			cfg.Attachments.MaxSize,
+		)
+
+		oidcHandler := newOIDCHandler(cfg.OIDC, server, authorizer)
+
+		// The MFA routes are served outside the gateway, so
+		// they are limited here rather than by the interceptor
+		mfaHandler := mfa.NewHandler(server, authorizer, limiter)
+
+		adminHandler := admin.NewHandler(server, authorizer)
+
+		sessionsHandler := sessions.NewHandler(server, authorizer, limiter)
+
+		// Methods which aren't in the gRPC API yet are also served outside
+		// the gateway
+		apiHandler := httpapi.NewHandler(server, authorizer, limiter)
+
+		// REST calls are made on the server directly rather than over a
+		// connection to the gRPC server, so they are given the same
+		// interceptors
+		api := server.InProcess(interceptors.ChainUnary(unaryInterceptors...))
+
+		var docsHandler *docs.Handler
+		if cfg.Server.HTTPProxy.Docs {
+			var services []string
+			for name := range gServer.GetServiceInfo() {
+				services = append(services, name)
+			}
 
-	addr := fmt.Sprintf(":%d", port)
+			if docsHandler, err = docs.NewHandler(services); err != nil {
+				log.Fatalf("Unable to initialise API docs: %+v", err)
+			}
+		}
+
+		httpHandler, err = httpProxyHandler(runtimeConfig, gServer, api, attachmentsHandler, oidcHandler, mfaHandler, adminHandler, sessionsHandler, apiHandler, docsHandler, keys)
+		if err != nil {
+			log.Fatalf("Unable to initialise HTTP proxy: %+v", err)
+		}
+	}
+
+	var tlsConfig *tls.Config
+
+	if cfg.Server.TLS.Enabled {
+		if tlsConfig, err = newTLSConfig(cfg.Server.TLS, authorizer); err != nil {
+			log.Fatalf("Unable to initialise TLS: %+v", err)
+		}
+	}
+
+	runtimeConfig.OnReload(func(c *config.Config) {
+		level, _ := log.ParseLevel(c.Log.Level)
+		log.SetLevel(level)
+
+		limiter.SetLimit(c.Server.RateLimit.RequestsPerSecond, c.Server.RateLimit.Burst)
+		tokenManager.SetTokenTTL(c.Auth.TokenTTL)
+	})
+
+	if v.ConfigFileUsed() != "" {
+		runtimeConfig.Watch()
+	}
+
+	serve(cfg.Server.Port, gServer, httpHandler, tlsConfig)
+}
+
+// newViper returns a viper reading config.yaml from the working directory
+func newViper() *viper.Viper {
+	v := viper.New()
+	v.SetConfigName("config")
+	v.SetConfigType("yaml")
+	v.AddConfigPath(".")
+
+	return v
+}
+
+// logInvalidConfig logs every problem found with the config
+func logInvalidConfig(err error) {
+	var invalid *config.ValidationError
+	if errors.As(err, &invalid) {
+		log.WithField("problems", invalid.Problems).Error("Invalid config")
+		return
+	}
+
+	log.WithError(err).Error("Invalid config")
+}
 
-	if httpProxyEnabled {
-		go httpProxyServer(httpProxyPort, addr)
+// runCommand runs a subcommand instead of the server. The only one is
+// config print, which prints the config with environment overrides applied,
+// and with secrets replaced when --redacted is given.
+func runCommand(args []string) int {
+	if len(args) < 2 || args[0] != "config" || args[1] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: chorerewards [config print [--redacted]]")
+		return 2
 	}
 
-	listener, err := net.Listen("tcp", addr)
+	flags := flag.NewFlagSet("config print", flag.ContinueOnError)
+	redacted := flags.Bool("redacted", false, "replace secrets with REDACTED")
+
+	if err := flags.Parse(args[2:]); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load(newViper())
 	if err != nil {
-		log.Fatal(err, "Failed to create listener")
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
 	}
 
-	log.WithFields(log.Fields{
-		"port": port,
-	}).Info("Starting grpc server")
+	if err := cfg.Print(os.Stdout, *redacted); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	var invalid *config.ValidationError
+	if err := cfg.Validate(); errors.As(err, &invalid) {
+		fmt.Fprintln(os.Stderr, "Invalid config:")
+		for _, problem := range invalid.Problems {
+			fmt.Fprintf(os.Stderr, "  %s\n", problem)
+		}
+
+		return 1
+	}
+
+	return 0
+}
+
+// newDBConfig converts the db settings into the database connection config
+func newDBConfig(cfg config.DB) db.Config {
+	c := db.Config{
+		DSN:               cfg.DSN,
+		Host:              cfg.Host,
+		Port:              cfg.Port,
+		Username:          cfg.Username,
+		Password:          cfg.Password,
+		Database:          cfg.Name,
+		SSLMode:           cfg.SSLMode,
+		SSLRootCert:       cfg.SSLRootCert,
+		SSLCert:           cfg.SSLCert,
+		SSLKey:            cfg.SSLKey,
+		ApplicationName:   cfg.ApplicationName,
+		StatementTimeout:  cfg.StatementTimeout,
+		ConnectTimeout:    cfg.ConnectTimeout,
+		MinConns:          cfg.Pool.MinConns,
+		MaxConns:          cfg.Pool.MaxConns,
+		MaxConnLifetime:   cfg.Pool.MaxConnLifetime,
+		MaxConnIdleTime:   cfg.Pool.MaxConnIdleTime,
+		HealthCheckPeriod: cfg.Pool.HealthCheckPeriod,
+		StartupTimeout:    cfg.StartupTimeout,
+	}
 
-	if err := gServer.Serve(listener); err != nil {
-		log.Fatal(err, "Failed to start server")
+	if cfg.Replica.Enabled() {
+		c.Replica = &db.Config{
+			DSN:         cfg.Replica.DSN,
+			Host:        cfg.Replica.Host,
+			Port:        cfg.Replica.Port,
+			Username:    cfg.Replica.Username,
+			Password:    cfg.Replica.Password,
+			Database:    cfg.Replica.Name,
+			SSLMode:     cfg.Replica.SSLMode,
+			SSLRootCert: cfg.Replica.SSLRootCert,
+		}
+		c.ReadYourWritesWindow = cfg.Replica.ReadYourWritesWindow
 	}
+
+	return c
 }
 
-// httpProxyServer starts a new http server listening on the specified port, proxying
-// requests to the provided grpc service
-func httpProxyServer(port int, grpcAddr string) {
-	ctx := context.Background()
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+// newNotificationDrivers creates a driver for each notification channel that
+// has been configured
+func newNotificationDrivers(cfg config.Notifications) (map[string]notify.Driver, error) {
+	drivers := make(map[string]notify.Driver)
+
+	if cfg.SMTP.Host != "" {
+		drivers[notify.ChannelEmail] = newSMTPDriver(cfg)
+	}
+
+	if cfg.Webhook.Enabled {
+		drivers[notify.ChannelWebhook] = notify.NewWebhookDriver(cfg.Timeout)
+	}
+
+	if cfg.WebPush.VAPIDPrivateKey != "" {
+		d, err := notify.NewWebPushDriver(cfg.WebPush.VAPIDPrivateKey, cfg.WebPush.Subscriber, cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		drivers[notify.ChannelWebPush] = d
+	}
 
-	// Register gRPC server endpoint
-	mux := runtime.NewServeMux()
-	opts := []grpc.DialOption{grpc.WithInsecure()}
-	if err := chorerewardsv1alpha1.RegisterChoreRewardsServiceHandlerFromEndpoint(ctx, mux, grpcAddr, opts); err != nil {
-		log.Fatal(err, "Failed to register http handler")
+	channels := make([]string, 0, len(drivers))
+	for channel := range drivers {
+		channels = append(channels, channel)
 	}
 
-	// Create a handler for our multiplexer.
-	h := Handler(mux)
+	log.WithFields(log.Fields{
+		"channels": channels,
+	}).Info("Notification drivers initialised")
+
+	return drivers, nil
+}
+
+// isGRPC reports whether a request is a gRPC call, rather than a REST or
+// gRPC-Web request
+func isGRPC(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
 
-	c := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedHeaders: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
-		MaxAge:         int(time.Hour * 24),
+	return r.ProtoMajor == 2 && (contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+"))
+}
+
+// serve listens on port, sending gRPC calls to the gRPC server and everything
+// else to httpHandler, if the HTTP proxy is enabled. Without TLS, HTTP/2 is
+// accepted in cleartext (h2c) so that gRPC clients can connect.
+func serve(port int, gServer *grpc.Server, httpHandler http.Handler, tlsConfig *tls.Config) {
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isGRPC(r) {
+			gServer.ServeHTTP(w, r)
+			return
+		}
+
+		if httpHandler == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		httpHandler.ServeHTTP(w, r)
 	})
 
-	h = c.Handler(h)
+	if tlsConfig == nil {
+		h = h2c.NewHandler(h, &http2.Server{})
+	}
+
+	httpServer := &http.Server{
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   h,
+		TLSConfig: tlsConfig,
+	}
 
 	log.WithFields(log.Fields{
 		"port": port,
-	}).Info("Starting http proxy server")
+		"tls":  tlsConfig != nil,
+	}).Info("Starting server")
+
+	if tlsConfig != nil {
+		// The certificate comes from the TLS config, which reloads it
+		log.Fatal(httpServer.ListenAndServeTLS("", ""), "Failed to start server")
+	}
+
+	log.Fatal(httpServer.ListenAndServe(), "Failed to start server")
+}
+
+// httpProxyHandler serves the REST API through the gateway, gRPC-Web for
+// browsers, the API docs, the methods outside the gRPC API, and the
+// attachment, sign in, session, admin and key endpoints
+func httpProxyHandler(runtimeConfig *config.Manager, gServer *grpc.Server, api chorerewardsv1alpha1.ChoreRewardsServiceServer, attachmentsHandler *attachments.Handler, oidcHandler *oidc.Handler, mfaHandler *mfa.Handler, adminHandler *admin.Handler, sessionsHandler *sessions.Handler, apiHandler *httpapi.Handler, docsHandler *docs.Handler, keys *auth.KeySet) (http.Handler, error) {
+	mux := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(headerMatcher))
+
+	if err := chorerewardsv1alpha1.RegisterChoreRewardsServiceHandlerServer(context.Background(), mux, api); err != nil {
+		return nil, errors.Wrap(err, "failed to register http handler")
+	}
+
+	if err := attachmentsHandler.Register(mux); err != nil {
+		return nil, errors.Wrap(err, "failed to register attachments handler")
+	}
+
+	if oidcHandler != nil {
+		if err := oidcHandler.Register(mux); err != nil {
+			return nil, errors.Wrap(err, "failed to register OIDC handler")
+		}
+	}
+
+	if err := mfaHandler.Register(mux); err != nil {
+		return nil, errors.Wrap(err, "failed to register MFA handler")
+	}
+
+	if err := adminHandler.Register(mux); err != nil {
+		return nil, errors.Wrap(err, "failed to register admin handler")
+	}
+
+	if err := sessionsHandler.Register(mux); err != nil {
+		return nil, errors.Wrap(err, "failed to register sessions handler")
+	}
+
+	if err := apiHandler.Register(mux); err != nil {
+		return nil, errors.Wrap(err, "failed to register API handler")
+	}
+
+	if docsHandler != nil {
+		if err := docsHandler.Register(mux); err != nil {
+			return nil, errors.Wrap(err, "failed to register docs handler")
+		}
+	}
+
+	if err := mux.HandlePath(http.MethodGet, "/.well-known/jwks.json", keys.JWKSHandler); err != nil {
+		return nil, errors.Wrap(err, "failed to register JWKS handler")
+	}
+
+	// HandleGrpcWebRequest is used rather than ServeHTTP, which would add
+	// its own CORS headers, so that the policy applies to gRPC-Web too
+	grpcWeb := grpcweb.WrapServer(gServer)
+
+	rest := interceptors.HTTPPeer(Handler(mux))
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if grpcWeb.IsGrpcWebRequest(r) {
+			grpcWeb.HandleGrpcWebRequest(w, r)
+			return
+		}
+
+		rest.ServeHTTP(w, r)
+	})
+
+	policy, err := runtimeConfig.Current().CORS.Policy()
+	if err != nil {
+		return nil, err
+	}
+
+	handler, err := cors.NewReloadable(policy, h)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid CORS policy")
+	}
+
+	runtimeConfig.OnReload(func(c *config.Config) {
+		policy, err := c.CORS.Policy()
+		if err == nil {
+			err = handler.Update(policy)
+		}
+
+		if err != nil {
+			log.WithError(err).Error("Unable to apply CORS policy")
+		}
+	})
+
+	return handler, nil
+}
+
+// headerMatcher forwards the headers the gRPC server reads in addition to
+// those forwarded by default
+func headerMatcher(key string) (string, bool) {
+	if http.CanonicalHeaderKey(key) == "X-Device-Name" {
+		return "x-device-name", true
+	}
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), h), "Failed to start http proxy server")
+	return runtime.DefaultHeaderMatcher(key)
 }
 
 func Handler(mux *runtime.ServeMux) http.Handler {
@@ -165,3 +551,98 @@ func Handler(mux *runtime.ServeMux) http.Handler {
 		})
 	}(mux)
 }
+
+// newKeySet loads the token signing keys. Each entry of auth.keys has an id,
+// used as the kid of the tokens it signs, and the file holding the key. The
+// first entry signs new tokens and the others, typically public keys of
+// retired signing keys, are only used to verify tokens. auth.key, when set,
+// is a shared HMAC secret which signs tokens if there are no other keys, and
+// otherwise remains valid for verification while its tokens expire.
+func newKeySet(cfg config.Auth) (*auth.KeySet, error) {
+	keys := auth.NewKeySet()
+
+	for _, k := range cfg.Keys {
+		if err := keys.LoadKeyFile(k.ID, k.File); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Key != "" {
+		if err := keys.AddHMAC(cfg.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// newTLSConfig loads the server certificate, which is reloaded when renewed.
+// Client certificates are requested on the whole port, so clientAuth require
+// also applies to browsers and apps.
+func newTLSConfig(cfg config.TLS, authorizer *auth.Authorizer) (*tls.Config, error) {
+	reloader, err := certs.NewReloader(cfg.CertFile, cfg.KeyFile, cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := reloader.ServerConfig(cfg.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Clients) > 0 {
+		roles := make(auth.ClientRoles, len(cfg.Clients))
+		for _, c := range cfg.Clients {
+			roles[c.Identity] = c.Roles
+		}
+
+		authorizer.TrustClientCertificates(roles)
+	}
+
+	go jobs.Every(context.Background(), "reload-certificates", cfg.ReloadInterval, reloader.ReloadIfChanged)
+
+	return tlsConfig, nil
+}
+
+// newOIDCHandler creates the sign in handler for the providers listed in
+// oidc.providers, or returns nil when there are none
+func newOIDCHandler(cfg config.OIDC, service oidc.Service, authenticator oidc.Authenticator) *oidc.Handler {
+	if len(cfg.Providers) == 0 {
+		return nil
+	}
+
+	client := &http.Client{Timeout: cfg.Timeout}
+
+	providers := make([]*oidc.Provider, 0, len(cfg.Providers))
+	for name, c := range cfg.Providers {
+		if c.Scopes == nil {
+			c.Scopes = []string{"email"}
+		}
+
+		providers = append(providers, oidc.NewProvider(oidc.ProviderConfig{
+			Name:         name,
+			Issuer:       c.Issuer,
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			Scopes:       c.Scopes,
+		}, client))
+	}
+
+	return oidc.NewHandler(service, authenticator, providers, cfg.BaseURL, cfg.SuccessURL, cfg.StateKey)
+}
+
+// newMailer creates the mailer for account emails
+func newMailer(cfg *config.Config) (mail.Mailer, error) {
+	switch cfg.Mail.Driver {
+	case "smtp":
+		return mail.NewSMTPMailer(newSMTPDriver(cfg.Notifications)), nil
+	case "file":
+		return mail.NewFileMailer(cfg.Mail.Dir)
+	default:
+		return nil, errors.Errorf("unknown mail driver %q", cfg.Mail.Driver)
+	}
+}
+
+func newSMTPDriver(cfg config.Notifications) *notify.SMTPDriver {
+	return notify.NewSMTPDriver(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.From, cfg.Timeout)
+}
@@ -0,0 +1,17 @@
+-- Savings goals. Points allocated to a goal stay in users.points, and are
+-- counted in reserved_points so that they can't be spent or allocated twice.
+ALTER TABLE users ADD COLUMN reserved_points integer NOT NULL DEFAULT 0 CHECK (reserved_points >= 0);
+
+CREATE TABLE goals (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users (id),
+    -- The reward the goal is saving for, if any. Rewards are kept by the app,
+    -- so this isn't a foreign key.
+    reward_id integer,
+    name text NOT NULL,
+    target_points integer NOT NULL CHECK (target_points > 0),
+    allocated_points integer NOT NULL DEFAULT 0 CHECK (allocated_points >= 0),
+    is_redeemed boolean NOT NULL DEFAULT false
+);
+
+CREATE INDEX goals_user_id_idx ON goals (user_id);
@@ -0,0 +1,35 @@
+-- Users and tasks belong to a household. Any existing users and tasks are
+-- moved into a single household, as there was only ever one.
+CREATE TABLE households (
+    id serial PRIMARY KEY,
+    name text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+INSERT INTO households (name)
+SELECT 'Household' WHERE EXISTS (SELECT 1 FROM users) OR EXISTS (SELECT 1 FROM tasks);
+
+ALTER TABLE users ADD COLUMN household_id integer REFERENCES households (id);
+UPDATE users SET household_id = (SELECT min(id) FROM households);
+ALTER TABLE users ALTER COLUMN household_id SET NOT NULL;
+CREATE INDEX users_household_id_idx ON users (household_id);
+
+ALTER TABLE tasks ADD COLUMN household_id integer REFERENCES households (id);
+UPDATE tasks SET household_id = (SELECT min(id) FROM households);
+ALTER TABLE tasks ALTER COLUMN household_id SET NOT NULL;
+CREATE INDEX tasks_household_id_idx ON tasks (household_id);
+
+-- Open tasks have no assignee and can be claimed by any child in the
+-- household. claim_feed_id is the feed entry added for the current claim,
+-- which is removed if the claim expires before it's completed.
+ALTER TABLE tasks ALTER COLUMN assignee_id DROP NOT NULL;
+ALTER TABLE tasks
+    ADD COLUMN is_open boolean NOT NULL DEFAULT false,
+    ADD COLUMN claim_duration_seconds integer NOT NULL DEFAULT 0 CHECK (claim_duration_seconds >= 0),
+    ADD COLUMN claim_bonus_points integer NOT NULL DEFAULT 0 CHECK (claim_bonus_points >= 0),
+    ADD COLUMN claimed_by_id integer REFERENCES users (id),
+    ADD COLUMN claim_feed_id integer REFERENCES tasks_feed (id) ON DELETE SET NULL,
+    ADD COLUMN claim_expires_at timestamptz,
+    ADD CONSTRAINT tasks_open_unassigned CHECK (NOT is_open OR assignee_id IS NULL);
+
+CREATE INDEX tasks_claim_expires_at_idx ON tasks (claim_expires_at) WHERE claimed_by_id IS NOT NULL;
@@ -0,0 +1,12 @@
+-- Rotations share a repeating task between participants. position is the
+-- index into participant_ids of whoever is due next.
+CREATE TABLE rotations (
+    id serial PRIMARY KEY,
+    task_id integer NOT NULL REFERENCES tasks (id),
+    participant_ids integer[] NOT NULL CHECK (cardinality(participant_ids) > 0),
+    cadence_seconds integer NOT NULL CHECK (cadence_seconds > 0),
+    position integer NOT NULL DEFAULT 0,
+    next_run_at timestamptz NOT NULL
+);
+
+CREATE INDEX rotations_next_run_at_idx ON rotations (next_run_at);
@@ -0,0 +1,30 @@
+-- Tasks hold how long after a feed entry is added it is due, and the
+-- penalties for completing it late or not at all. Each feed entry gets its
+-- own deadline when it's added.
+ALTER TABLE tasks
+    ADD COLUMN due_in_seconds integer NOT NULL DEFAULT 0 CHECK (due_in_seconds >= 0),
+    ADD COLUMN late_penalty_percent integer NOT NULL DEFAULT 0 CHECK (late_penalty_percent BETWEEN 0 AND 100),
+    ADD COLUMN missed_penalty_points integer NOT NULL DEFAULT 0 CHECK (missed_penalty_points >= 0);
+
+-- Overdue entries can still be completed for the points left after the late
+-- penalty. Missed entries can't be completed and have had their missed
+-- penalty applied.
+ALTER TABLE tasks_feed
+    ADD COLUMN due_at timestamptz,
+    ADD COLUMN is_overdue boolean NOT NULL DEFAULT false,
+    ADD COLUMN is_missed boolean NOT NULL DEFAULT false;
+
+CREATE INDEX tasks_feed_due_at_idx ON tasks_feed (due_at) WHERE NOT is_complete AND NOT is_missed;
+
+-- Changes to users' points made outside of approving tasks, such as missed
+-- penalties
+CREATE TABLE points_adjustments (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users (id),
+    tasks_feed_id integer REFERENCES tasks_feed (id) ON DELETE SET NULL,
+    points integer NOT NULL,
+    reason text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX points_adjustments_user_id_idx ON points_adjustments (user_id, created_at);
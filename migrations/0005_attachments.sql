@@ -0,0 +1,15 @@
+-- Photos attached to feed entries. The content is kept in the blob store
+-- under blob_key and thumbnail_key.
+CREATE TABLE attachments (
+    id serial PRIMARY KEY,
+    tasks_feed_id integer NOT NULL REFERENCES tasks_feed (id) ON DELETE CASCADE,
+    household_id integer NOT NULL REFERENCES households (id),
+    uploader_id integer NOT NULL REFERENCES users (id),
+    content_type text NOT NULL,
+    size bigint NOT NULL,
+    blob_key text NOT NULL,
+    thumbnail_key text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX attachments_tasks_feed_id_idx ON attachments (tasks_feed_id, created_at);
@@ -0,0 +1,45 @@
+-- Comments on a task or a feed entry. Deleted comments are kept with an
+-- empty body so that their replies stay threaded.
+CREATE TABLE comments (
+    id serial PRIMARY KEY,
+    household_id integer NOT NULL REFERENCES households (id),
+    task_id integer REFERENCES tasks (id) ON DELETE CASCADE,
+    tasks_feed_id integer REFERENCES tasks_feed (id) ON DELETE CASCADE,
+    parent_id integer REFERENCES comments (id),
+    author_id integer NOT NULL REFERENCES users (id),
+    body text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    edited_at timestamptz,
+    deleted_at timestamptz,
+    CONSTRAINT comments_one_target CHECK ((task_id IS NULL) <> (tasks_feed_id IS NULL))
+);
+
+CREATE INDEX comments_task_id_idx ON comments (task_id, id);
+CREATE INDEX comments_tasks_feed_id_idx ON comments (tasks_feed_id, id);
+
+-- Previous bodies of edited and deleted comments
+CREATE TABLE comment_revisions (
+    id serial PRIMARY KEY,
+    comment_id integer NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+    body text NOT NULL,
+    created_at timestamptz NOT NULL
+);
+
+CREATE INDEX comment_revisions_comment_id_idx ON comment_revisions (comment_id);
+
+CREATE TABLE comment_reactions (
+    comment_id integer NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES users (id),
+    emoji text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (comment_id, user_id, emoji)
+);
+
+CREATE TABLE comment_mentions (
+    comment_id integer NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES users (id),
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (comment_id, user_id)
+);
+
+CREATE INDEX comment_mentions_user_id_idx ON comment_mentions (user_id, created_at);
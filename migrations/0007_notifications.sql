@@ -0,0 +1,37 @@
+-- The channels each user has enabled, and the address to deliver to on each
+CREATE TABLE notification_preferences (
+    user_id integer NOT NULL REFERENCES users (id),
+    channel text NOT NULL,
+    address text NOT NULL,
+    enabled boolean NOT NULL DEFAULT true,
+    PRIMARY KEY (user_id, channel)
+);
+
+-- Quiet hours are minutes after midnight in time_zone. Users without a row
+-- have none.
+CREATE TABLE notification_settings (
+    user_id integer PRIMARY KEY REFERENCES users (id),
+    quiet_hours_start integer NOT NULL DEFAULT 0 CHECK (quiet_hours_start BETWEEN 0 AND 1439),
+    quiet_hours_end integer NOT NULL DEFAULT 0 CHECK (quiet_hours_end BETWEEN 0 AND 1439),
+    time_zone text NOT NULL DEFAULT 'UTC'
+);
+
+-- Notifications are queued in the same transaction as the event they're
+-- about, and delivered by the dispatcher. is_dead is set once a notification
+-- has failed too many times to retry.
+CREATE TABLE notification_outbox (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users (id),
+    channel text NOT NULL,
+    event_type text NOT NULL,
+    subject text NOT NULL,
+    body text NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    delivered_at timestamptz,
+    is_dead boolean NOT NULL DEFAULT false,
+    last_error text,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX notification_outbox_pending_idx ON notification_outbox (next_attempt_at) WHERE delivered_at IS NULL AND NOT is_dead;
@@ -0,0 +1,35 @@
+-- Subscriptions send a household's events to a URL, signed with secret. An
+-- empty event_types means every event. Deleted subscriptions are kept
+-- inactive so that their deliveries can still be listed.
+CREATE TABLE webhook_subscriptions (
+    id serial PRIMARY KEY,
+    household_id integer NOT NULL REFERENCES households (id),
+    url text NOT NULL,
+    secret text NOT NULL,
+    event_types text[] NOT NULL DEFAULT '{}',
+    is_active boolean NOT NULL DEFAULT true,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX webhook_subscriptions_household_id_idx ON webhook_subscriptions (household_id);
+
+-- Deliveries are queued with the event that caused them and sent by the
+-- dispatcher. Dead deliveries make up the dead-letter list, and replays are
+-- new deliveries pointing at the one they replay.
+CREATE TABLE webhook_deliveries (
+    id serial PRIMARY KEY,
+    subscription_id integer NOT NULL REFERENCES webhook_subscriptions (id),
+    event_type text NOT NULL,
+    payload jsonb NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    delivered_at timestamptz,
+    is_dead boolean NOT NULL DEFAULT false,
+    last_error text,
+    response_status integer,
+    replay_of integer REFERENCES webhook_deliveries (id),
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX webhook_deliveries_subscription_id_idx ON webhook_deliveries (subscription_id, id);
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE delivered_at IS NULL AND NOT is_dead;
@@ -0,0 +1,23 @@
+-- Email addresses aren't unique, as a household may share one. Password
+-- resets are sent to every active user with the address.
+ALTER TABLE users
+    ADD COLUMN email_verified_at timestamptz,
+    -- Set when the password changes, so that older sign ins stop working
+    ADD COLUMN sessions_revoked_at timestamptz;
+
+CREATE INDEX users_email_idx ON users (lower(email)) WHERE email <> '';
+
+-- One-time tokens sent by email, stored as hashes. email is the address the
+-- token was sent to, so a verification link stops working if it's changed.
+CREATE TABLE user_tokens (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users (id),
+    purpose text NOT NULL,
+    token_hash bytea NOT NULL UNIQUE,
+    email text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX user_tokens_user_id_idx ON user_tokens (user_id, purpose) WHERE used_at IS NULL;
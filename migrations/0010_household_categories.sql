@@ -0,0 +1,9 @@
+-- Categories belong to a household, like tasks. Until now there could only
+-- be one household, so existing categories are moved into it.
+INSERT INTO households (name)
+SELECT 'Household' WHERE NOT EXISTS (SELECT 1 FROM households) AND EXISTS (SELECT 1 FROM categories);
+
+ALTER TABLE categories ADD COLUMN household_id integer REFERENCES households (id);
+UPDATE categories SET household_id = (SELECT min(id) FROM households);
+ALTER TABLE categories ALTER COLUMN household_id SET NOT NULL;
+CREATE INDEX categories_household_id_idx ON categories (household_id);
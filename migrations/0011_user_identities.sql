@@ -0,0 +1,12 @@
+-- Accounts with external identity providers linked to users, for signing in
+-- with OpenID Connect. Each provider account links to one user, and each
+-- user has at most one account with each provider.
+CREATE TABLE user_identities (
+    user_id integer NOT NULL REFERENCES users (id),
+    provider text NOT NULL,
+    subject text NOT NULL,
+    email text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, provider),
+    UNIQUE (provider, subject)
+);
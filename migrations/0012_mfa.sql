@@ -0,0 +1,25 @@
+-- TOTP enrolments. An enrolment is pending until enabled_at is set, and
+-- last_step is the time step of the last code used so none is accepted twice.
+CREATE TABLE user_mfa (
+    user_id integer PRIMARY KEY REFERENCES users (id),
+    secret text NOT NULL,
+    enabled_at timestamptz,
+    last_step bigint NOT NULL DEFAULT 0,
+    failed_attempts integer NOT NULL DEFAULT 0,
+    locked_until timestamptz
+);
+
+-- Hashes of the one-time recovery codes of an enrolment
+CREATE TABLE user_recovery_codes (
+    user_id integer NOT NULL REFERENCES users (id),
+    code_hash bytea NOT NULL,
+    used_at timestamptz,
+    PRIMARY KEY (user_id, code_hash)
+);
+
+-- Policies chosen by a household's admins. Households without a row use
+-- the defaults.
+CREATE TABLE household_settings (
+    household_id integer PRIMARY KEY REFERENCES households (id),
+    require_parent_mfa boolean NOT NULL DEFAULT false
+);
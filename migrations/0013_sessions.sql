@@ -0,0 +1,15 @@
+-- Sign ins on each of a user's devices. id is the random session id carried
+-- in the tokens issued for the session.
+CREATE TABLE sessions (
+    id text PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users (id),
+    device_name text NOT NULL,
+    user_agent text NOT NULL,
+    ip_address text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    last_seen_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL,
+    revoked_at timestamptz
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id, expires_at);